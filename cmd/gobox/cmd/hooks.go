@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"gobox/internal/hooks"
+)
+
+var hooksCmd = &cobra.Command{
+	Use:   "hooks",
+	Short: "Manage the git hooks that tag commits with the running task",
+	Long: `The hooks add a "Gobox-Task: <id>" trailer to every commit made while a session
+is running. Tagged commits are credited to their task even when they are made after
+the session ended or while working on another task.`,
+}
+
+var hooksInstallCmd = &cobra.Command{
+	Use:   "install [repo...]",
+	Short: "Install the prepare-commit-msg and post-commit hooks",
+	Long: `install writes the hooks into the given repositories, or the current one. The hooks
+read the sessions from the state file used in the directory install is run in, or the
+one given with --state. Hooks that are already in place keep running before the gobox
+ones.`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		exe, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		st, err := openStores("")
+		if err != nil {
+			return err
+		}
+		command := hooks.ShellQuote(exe) + " hooks run --state " + hooks.ShellQuote(st.path)
+		for _, repo := range reposOrCurrent(args) {
+			dir, err := hooks.Install(repo, command)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Installed gobox hooks in %s\n", dir)
+		}
+		return nil
+	},
+}
+
+var hooksUninstallCmd = &cobra.Command{
+	Use:          "uninstall [repo...]",
+	Short:        "Remove the gobox hooks and restore the hooks they replaced",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		for _, repo := range reposOrCurrent(args) {
+			dir, err := hooks.Uninstall(repo)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Removed gobox hooks from %s\n", dir)
+		}
+		return nil
+	},
+}
+
+// hooksRunCmd is what the installed hooks run.
+var hooksRunCmd = &cobra.Command{
+	Use:          "run <hook> [args...]",
+	Short:        "Run a gobox git hook",
+	Hidden:       true,
+	Args:         cobra.MinimumNArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		st, err := openStores("")
+		if err != nil {
+			return err
+		}
+		states, err := st.state.Load()
+		if err != nil {
+			return err
+		}
+		switch args[0] {
+		case "prepare-commit-msg":
+			if len(args) < 2 {
+				return fmt.Errorf("prepare-commit-msg needs the commit message file")
+			}
+			return hooks.PrepareCommitMsg(states, args[1])
+		case "post-commit":
+			note, err := hooks.PostCommit(states)
+			if err != nil {
+				return err
+			}
+			if note != "" {
+				fmt.Println(note)
+			}
+			return nil
+		default:
+			return fmt.Errorf("unknown hook %q", args[0])
+		}
+	},
+}
+
+func reposOrCurrent(args []string) []string {
+	if len(args) == 0 {
+		return []string{"."}
+	}
+	return args
+}
+
+func init() {
+	hooksCmd.AddCommand(hooksInstallCmd, hooksUninstallCmd, hooksRunCmd)
+	rootCmd.AddCommand(hooksCmd)
+}
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"gobox/internal/history"
+)
+
+// historyFilterFlags are the flags shared by commands that read the session history.
+type historyFilterFlags struct {
+	since string
+	until string
+	file  string
+	task  string
+}
+
+func (f *historyFilterFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.since, "since", "", "only sessions completed on or after this date (YYYY-MM-DD)")
+	cmd.Flags().StringVar(&f.until, "until", "", "only sessions completed on or before this date (YYYY-MM-DD)")
+	cmd.Flags().StringVar(&f.file, "file", "", "only sessions for this markdown file")
+	cmd.Flags().StringVar(&f.task, "task", "", "only sessions for tasks with this ID or containing this text")
+}
+
+// filter turns the flags into a history filter. Dates are inclusive days in local time.
+func (f *historyFilterFlags) filter() (history.Filter, error) {
+	filter := history.Filter{File: f.file, Task: f.task}
+
+	if f.since != "" {
+		since, err := time.ParseInLocation("2006-01-02", f.since, time.Local)
+		if err != nil {
+			return filter, fmt.Errorf("invalid --since date %q, expected YYYY-MM-DD", f.since)
+		}
+		filter.Since = since
+	}
+	if f.until != "" {
+		until, err := time.ParseInLocation("2006-01-02", f.until, time.Local)
+		if err != nil {
+			return filter, fmt.Errorf("invalid --until date %q, expected YYYY-MM-DD", f.until)
+		}
+		filter.Until = until.AddDate(0, 0, 1)
+	}
+
+	return filter, nil
+}
+
+// loadHistory reads the session history and applies the filter flags.
+func loadHistory(f *historyFilterFlags) ([]history.Entry, error) {
+	filter, err := f.filter()
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := openStores("")
+	if err != nil {
+		return nil, err
+	}
+	entries, err := history.Query(st.history, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	return entries, nil
+}
+
+var logFilter historyFilterFlags
+
+// logCmd lists completed sessions from the history log
+var logCmd = &cobra.Command{
+	Use:   "log",
+	Short: "List completed timebox sessions",
+	Long: `log prints the sessions recorded in the history log, oldest first.
+Entries can be filtered by completion date, markdown file and task.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		entries, err := loadHistory(&logFilter)
+		if err != nil {
+			return err
+		}
+
+		if len(entries) == 0 {
+			fmt.Println("No sessions found.")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "COMPLETED\tACTUAL\tPLANNED\tCOMMITS\tFILE\tTASK")
+		for _, e := range entries {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n",
+				e.CompletedAt.Local().Format("2006-01-02 15:04"),
+				e.Actual.Round(time.Second),
+				e.Planned,
+				len(e.Commits),
+				e.File,
+				e.Description,
+			)
+		}
+		return w.Flush()
+	},
+}
+
+func init() {
+	logFilter.register(logCmd)
+	rootCmd.AddCommand(logCmd)
+}
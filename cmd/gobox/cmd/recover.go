@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"gobox/internal/core"
+	"gobox/internal/state"
+)
+
+// recoverEnd ends all stale sessions without asking: "heartbeat", "now" or a time.
+var recoverEnd string
+
+var recoverCmd = &cobra.Command{
+	Use:   "recover [markdown_file]",
+	Short: "End sessions left running by a gobox that was killed",
+	Long: `recover finds sessions that were still running when gobox was killed, or when
+the machine went to sleep or lost power. Their time would otherwise count until they
+are resumed. For each one, choose to end it at its last heartbeat, to keep the time
+until now, or enter when you stopped: a time like 17:30 or how long it ran, like 45m.
+The sessions are paused, so they can be resumed later.
+
+The TUI asks the same when it starts. Use --end to end all of them without asking.`,
+	Args:         cobra.MaximumNArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		taskFile := ""
+		if len(args) == 1 {
+			taskFile = args[0]
+		}
+		st, err := openStores(taskFile)
+		if err != nil {
+			return err
+		}
+		states, err := st.state.Load()
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		stale := state.Stale(states, now)
+		if len(stale) == 0 {
+			fmt.Println("No sessions were left running.")
+			return nil
+		}
+		reader := bufio.NewReader(os.Stdin)
+		for _, i := range stale {
+			s := states[i]
+			fmt.Println(describeStale(s, now))
+			var end time.Time
+			if recoverEnd != "" {
+				if end, err = parseRecoverEnd(recoverEnd, s, now); err != nil {
+					return err
+				}
+			} else if end, err = askRecoverEnd(reader, s, now); err != nil {
+				return err
+			}
+			if err := core.CloseStale(st.state, s, end); err != nil {
+				if errors.Is(err, core.ErrNotStale) {
+					fmt.Println("  Another gobox has dealt with it meanwhile.")
+					continue
+				}
+				return err
+			}
+			s.CloseAt(end)
+			fmt.Printf("  Ended at %s, %s in total.\n", end.Format("Mon Jan 2 15:04"), state.SumSegments(s.Segments).Round(time.Minute))
+		}
+		return nil
+	},
+}
+
+// describeStale tells which session was left running and when it was last seen.
+func describeStale(s state.TimeBoxState, now time.Time) string {
+	description := s.Description
+	if description == "" {
+		description = s.Key()
+	}
+	lastSeen := s.LastSeen()
+	return fmt.Sprintf("%q was left running, last seen %s (%s ago)", description,
+		lastSeen.Format("Mon Jan 2 15:04"), now.Sub(lastSeen).Round(time.Minute))
+}
+
+// parseRecoverEnd returns when the stale session s ended for the answer "heartbeat",
+// "now", a time of day or a duration.
+func parseRecoverEnd(answer string, s state.TimeBoxState, now time.Time) (time.Time, error) {
+	switch answer {
+	case "heartbeat", "h":
+		return s.LastSeen(), nil
+	case "now", "k":
+		return now, nil
+	}
+	return core.ParseEnd(answer, s, now)
+}
+
+// askRecoverEnd asks when the stale session s ended until the answer makes sense. An
+// empty answer ends it at its last heartbeat.
+func askRecoverEnd(reader *bufio.Reader, s state.TimeBoxState, now time.Time) (time.Time, error) {
+	for {
+		fmt.Printf("  End it at the last heartbeat [h], keep the time until now [k], or enter when you stopped (17:30 or 45m): ")
+		answer, err := reader.ReadString('\n')
+		answer = strings.TrimSpace(answer)
+		if err != nil && answer == "" {
+			return time.Time{}, errors.New("no answer, the session is left as it is")
+		}
+		if answer == "" {
+			answer = "h"
+		}
+		end, parseErr := parseRecoverEnd(answer, s, now)
+		if parseErr == nil {
+			return end, nil
+		}
+		if err != nil {
+			return time.Time{}, parseErr
+		}
+		fmt.Println("  " + parseErr.Error())
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(recoverCmd)
+	recoverCmd.Flags().StringVar(&recoverEnd, "end", "", `end all stale sessions without asking: "heartbeat", "now", a time like 17:30 or a duration like 45m`)
+}
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"gobox/internal/report"
+)
+
+var (
+	reportFilter historyFilterFlags
+	reportFormat string
+	reportTop    int
+)
+
+// reportCmd summarizes the session history to show how accurate timebox estimates are
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Summarize time spent and estimate accuracy",
+	Long: `report reads the session history and summarizes planned vs. actual time,
+the overrun ratio and commits per session per day, per week and per file,
+followed by the sessions that overran their timebox the most.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if reportTop < 1 {
+			return fmt.Errorf("--top must be at least 1, got %d", reportTop)
+		}
+		entries, err := loadHistory(&reportFilter)
+		if err != nil {
+			return err
+		}
+		return report.Write(os.Stdout, report.Build(entries, reportTop), reportFormat)
+	},
+}
+
+func init() {
+	reportFilter.register(reportCmd)
+	reportCmd.Flags().StringVarP(&reportFormat, "format", "f", report.FormatTable, "output format: table, markdown, csv or json")
+	reportCmd.Flags().IntVar(&reportTop, "top", 5, "number of worst overruns to list")
+	rootCmd.AddCommand(reportCmd)
+}
@@ -3,13 +3,28 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/spf13/cobra"
 
+	"gobox/internal/backup"
+	"gobox/internal/config"
 	"gobox/internal/core" // For state store initialization
+	"gobox/internal/history"
+	"gobox/internal/sqlstore"
+	"gobox/internal/statefile"
 	"gobox/internal/tui"
 )
 
+const (
+	historyFile = ".gobox_history.jsonl"
+	configFile  = ".gobox.json"
+	backupDir   = ".gobox/backups"
+)
+
+// dryRun shows the changes to the markdown file instead of writing them.
+var dryRun bool
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "gobox [markdown_file]",
@@ -19,15 +34,96 @@ updates the markdown upon completion with a checkmark and Git commits.`,
 	Args: cobra.ExactArgs(1), // Expect exactly one argument: the markdown file path
 	Run: func(cmd *cobra.Command, args []string) {
 		markdownFile := args[0]
-		stateMgr := core.NewFileStateStore(".gobox_state.json")
-		states, _ := stateMgr.Load()
-		if err := tui.Run(markdownFile, stateMgr, states); err != nil {
+		cfg, err := config.Load(rootFile(markdownFile, configFile))
+		if err != nil {
+			fmt.Println("Error loading config:", err)
+			os.Exit(1)
+		}
+		st, err := openStores(markdownFile)
+		if err != nil {
+			fmt.Println("Error opening the state file:", err)
+			os.Exit(1)
+		}
+		states, _ := st.state.Load()
+		if err := tui.Run(markdownFile, st.state, states, st.history, st.backups, cfg, dryRun); err != nil {
 			fmt.Println("Error running TUI:", err)
 			os.Exit(1)
 		}
 	},
 }
 
+// stateOverride is the state file given with --state.
+var stateOverride string
+
+// stores are where the sessions of a repository are kept.
+type stores struct {
+	path    string          // the state file or database
+	state   core.StateStore // unfinished sessions
+	history history.Log     // completed sessions
+	backups *backup.Store   // the task files from before gobox changed them
+}
+
+// isDatabase reports whether the state file is an SQLite database, by its extension.
+func isDatabase(path string) bool {
+	switch filepath.Ext(path) {
+	case ".db", ".sqlite", ".sqlite3":
+		return true
+	}
+	return false
+}
+
+// rootFile returns the file name in the repository root of taskFile, or in the
+// directory of taskFile outside of a repository. An empty taskFile stands for the
+// working directory.
+func rootFile(taskFile, name string) string {
+	path, err := statefile.RootFile(taskFile, name)
+	if err != nil {
+		return name
+	}
+	return path
+}
+
+// openStores returns the stores of the sessions of tasks in taskFile, or in the working
+// directory if it is empty. Sessions left in state files of earlier versions are moved
+// into them. A state file ending in .db or .sqlite is an SQLite database that keeps the
+// history as well; the JSON state and history files are imported into it once. Task
+// files are backed up in the repository root, like the history.
+func openStores(taskFile string) (*stores, error) {
+	path, err := statefile.Path(stateOverride, taskFile)
+	if err != nil {
+		return nil, err
+	}
+	legacy := statefile.LegacyPaths(taskFile)
+	backups := backup.NewStore(rootFile(taskFile, backupDir))
+
+	if isDatabase(path) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, err
+		}
+		db, err := sqlstore.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		imported, err := db.Import(legacy, []string{rootFile(taskFile, historyFile)})
+		if err != nil {
+			return nil, fmt.Errorf("failed to import sessions into %s: %w", path, err)
+		}
+		for _, file := range imported {
+			fmt.Fprintf(os.Stderr, "Imported the sessions in %s into %s\n", file, path)
+		}
+		return &stores{path: path, state: db, history: db, backups: backups}, nil
+	}
+
+	migrated, err := statefile.Migrate(path, legacy...)
+	for _, old := range migrated {
+		fmt.Fprintf(os.Stderr, "Moved the sessions in %s to %s\n", old, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to move sessions to %s: %w", path, err)
+	}
+	return &stores{path: path, state: core.NewFileStateStore(path), history: history.NewFileLog(rootFile(taskFile, historyFile)), backups: backups}, nil
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
@@ -40,4 +136,6 @@ func Execute() {
 func init() {
 	// Any global flags or initializations can go here.
 	// rootCmd.AddCommand(tuiCmd) // Will be added in tui_cmd.go
+	rootCmd.PersistentFlags().StringVar(&stateOverride, "state", "", "state file of the sessions, an SQLite database if it ends in .db (default $"+statefile.EnvVar+" or $XDG_STATE_HOME/gobox/<repo>/"+statefile.Name+")")
+	rootCmd.Flags().BoolVar(&dryRun, "dry-run", false, "show the changes to the markdown file when a task is completed without writing them")
 }
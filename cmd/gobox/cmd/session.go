@@ -0,0 +1,263 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"gobox/internal/config"
+	"gobox/internal/daemon"
+	"gobox/internal/gitutil"
+	"gobox/internal/state"
+	"gobox/internal/statefile"
+	"gobox/internal/statusline"
+)
+
+// socketPath overrides the control socket of the background session
+var socketPath string
+
+func controlSocket() string {
+	if socketPath != "" {
+		return socketPath
+	}
+	return daemon.SocketPath()
+}
+
+// daemonCmd runs a session in the background. It is started by "gobox start".
+var daemonCmd = &cobra.Command{
+	Use:    "daemon",
+	Short:  "Run a background session (started by gobox start)",
+	Hidden: true,
+	Args:   cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// gobox start runs the daemon in the root of the task file, where its files are
+		cfg, err := config.Load(rootFile("", configFile))
+		if err != nil {
+			return err
+		}
+		st, err := openStores("")
+		if err != nil {
+			return err
+		}
+		srv := daemon.NewServer(st.state, st.history)
+		srv.Config = cfg
+		srv.Backups = st.backups
+
+		socket := controlSocket()
+		l, err := daemon.Listen(socket)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(socket)
+
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+		go func() {
+			select {
+			case <-sigCh:
+				srv.Shutdown()
+			case <-srv.Done():
+			}
+		}()
+
+		return srv.Serve(l)
+	},
+}
+
+var startEarly bool
+
+// startPauseOthers pauses sessions running in other gobox processes when starting one.
+var startPauseOthers bool
+
+// startCmd starts a session in the background
+var startCmd = &cobra.Command{
+	Use:   "start <markdown_file> [task]",
+	Short: "Start a timeboxed session in the background",
+	Long: `start runs the timer for a task in a background process, so it keeps running
+when the terminal is closed. The task is picked by ID or by part of its description;
+without one, the next unchecked task with a timebox is started. Use status, pause,
+resume, done and abort to control the session.`,
+	Args:         cobra.RangeArgs(1, 2),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		socket := controlSocket()
+		if daemon.IsRunning(socket) {
+			return errors.New("a session is already running, see gobox status")
+		}
+		req := daemon.Request{Command: daemon.CmdStart, File: absPath(args[0]), Early: startEarly, PauseOthers: startPauseOthers}
+		if len(args) == 2 {
+			req.Task = args[1]
+		}
+		// The daemon can't report a broken config, so check it before starting one
+		if _, err := config.Load(rootFile(req.File, configFile)); err != nil {
+			return err
+		}
+
+		// The daemon finds the config and the history in the root of the task file
+		dir, err := statefile.Root(req.File)
+		if err != nil {
+			return err
+		}
+		// The daemon keeps the sessions with those of the task file's repository
+		st, err := openStores(req.File)
+		if err != nil {
+			return err
+		}
+		// The open segment of a session left running by a killed gobox would count the
+		// time since, so that needs settling first
+		if states, err := st.state.Load(); err == nil {
+			now := time.Now()
+			if stale := state.Stale(states, now); len(stale) > 0 {
+				return fmt.Errorf("%s, run gobox recover first", describeStale(states[stale[0]], now))
+			}
+		}
+		if err := daemon.Spawn(socket, dir, "daemon", "--socket", socket, "--state", st.path); err != nil {
+			return err
+		}
+		return sendAndPrint(req)
+	},
+}
+
+var statusFormat string
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the background session",
+	Long: `status shows the session run by gobox start. With --format it instead reads the
+saved state of any running or paused session, including one in the TUI, for use in a
+shell prompt, tmux or a status bar:
+
+  --format compact                       one line, e.g. "▶ Fix bug 12:34"
+  --format waybar                        Waybar custom module JSON
+  --format i3blocks                      i3blocks JSON
+  --format '{{.Task}} {{clock .Remaining}}'  a Go template over the session info
+
+Templates can use .State, .Task, .TimeBox, .File, .Elapsed, .Remaining, .Percent and
+.Overdue, and the functions clock and truncate.`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if cmd.Flags().Changed("format") {
+			states, err := statusStates()
+			if err != nil {
+				return err
+			}
+			return statusline.Render(os.Stdout, statusline.Current(states, time.Now()), statusFormat)
+		}
+
+		st, err := daemon.Send(controlSocket(), daemon.Request{Command: daemon.CmdStatus})
+		if errors.Is(err, daemon.ErrNotRunning) {
+			fmt.Println("No active session.")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		printStatus(st)
+		return nil
+	},
+}
+
+// statusStates returns the sessions gobox status --format chooses from: those in the
+// state file given with --state or $GOBOX_STATE, else those of all repositories, as the
+// status bar runs outside of the session's repository.
+func statusStates() ([]state.TimeBoxState, error) {
+	if stateOverride == "" && os.Getenv(statefile.EnvVar) == "" {
+		return statefile.LoadAll()
+	}
+	st, err := openStores("")
+	if err != nil {
+		return nil, err
+	}
+	return st.state.Load()
+}
+
+// controlCmd creates a command that sends a single request to the background session.
+func controlCmd(command, short string) *cobra.Command {
+	return &cobra.Command{
+		Use:          command,
+		Short:        short,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return sendAndPrint(daemon.Request{Command: command})
+		},
+	}
+}
+
+func sendAndPrint(req daemon.Request) error {
+	st, err := daemon.Send(controlSocket(), req)
+	if err != nil {
+		return err
+	}
+	printStatus(st)
+	return nil
+}
+
+func printStatus(st *daemon.Status) {
+	fmt.Printf("%s %s (%s)\n", st.Description, st.TimeBox, st.State)
+	fmt.Printf("File:      %s\n", st.File)
+	if len(st.Scope) > 0 {
+		fmt.Printf("Scope:     %s\n", strings.Join(st.Scope, ", "))
+	}
+	fmt.Printf("Elapsed:   %s\n", st.Elapsed.Round(time.Second))
+	if st.IdleSince != nil {
+		fmt.Printf("Idle:      since %s, not counted\n", st.IdleSince.Format("15:04"))
+	}
+	if st.State != daemon.StateDone && st.State != daemon.StateAborted {
+		fmt.Printf("Remaining: %s\n", st.Remaining.Round(time.Second))
+	}
+	if !st.Diff.IsEmpty() {
+		fmt.Printf("Changes:   %s\n", st.Diff)
+	}
+	fmt.Printf("Commits:   %d\n", len(st.Commits))
+	repos := make(map[string]bool)
+	for _, c := range st.Commits {
+		repos[c.Repo] = true
+	}
+	for _, c := range st.Commits {
+		flag := ""
+		if len(c.OutOfScope) > 0 {
+			flag = "⚠ "
+		}
+		if len(repos) > 1 {
+			fmt.Printf("  %s%s: %s\n", flag, c.Repo, c)
+		} else {
+			fmt.Printf("  %s%s\n", flag, c)
+		}
+	}
+	if warnings := gitutil.ScopeWarnings(st.Commits, st.Diff.Uncommitted); len(warnings) > 0 {
+		fmt.Println("Outside the scope:")
+		for _, w := range warnings {
+			fmt.Printf("  %s\n", w)
+		}
+	}
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&socketPath, "socket", "", "control socket of the background session (default $GOBOX_SOCKET or $XDG_RUNTIME_DIR/gobox.sock)")
+	startCmd.Flags().BoolVar(&startEarly, "early", false, "start a time range task before its scheduled start")
+	startCmd.Flags().BoolVar(&startPauseOthers, "pause-others", false, "pause sessions running in other terminals instead of refusing to start")
+	statusCmd.Flags().StringVarP(&statusFormat, "format", "f", statusline.FormatCompact, "compact, waybar, i3blocks or a Go template")
+
+	rootCmd.AddCommand(daemonCmd, startCmd, statusCmd,
+		controlCmd(daemon.CmdPause, "Pause the background session"),
+		controlCmd(daemon.CmdResume, "Resume the background session"),
+		controlCmd(daemon.CmdDone, "Complete the background session's task and check it off"),
+		controlCmd(daemon.CmdAbort, "Stop the background session without completing its task"),
+	)
+}
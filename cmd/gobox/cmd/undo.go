@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"gobox/internal/backup"
+	"gobox/internal/fileutil"
+	"gobox/internal/textdiff"
+)
+
+var undoYes bool
+
+var undoCmd = &cobra.Command{
+	Use:   "undo [file]",
+	Short: "Revert the last change gobox made to a task file",
+	Long: `undo restores a task file to how it was before gobox last changed it, by
+completing a task or giving it an ID. Without a file, the latest change to any task
+file in the repository of the working directory is undone. Running undo again reverts the change before that.
+
+If the file was edited since, undo offers to merge the revert into the edits. Lines
+changed both by gobox and by you are marked as conflicts, as git does.`,
+	Args:         cobra.MaximumNArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file := ""
+		if len(args) == 1 {
+			file = args[0]
+		}
+		// Changes are backed up in the root of the task file's repository, see openStores
+		store := backup.NewStore(rootFile(file, backupDir))
+		snap, err := store.Latest(file)
+		if errors.Is(err, backup.ErrNoSnapshot) {
+			fmt.Println("Nothing to undo.")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		current, version, err := fileutil.ReadFile(snap.File)
+		if err != nil {
+			return err
+		}
+		reverted, merged, conflicts := snap.Revert(current)
+		if merged {
+			fmt.Printf("%s was edited since gobox changed it (%s, %s).\n", snap.File, snap.Operation, snap.Time.Format("2006-01-02 15:04"))
+			fmt.Print(textdiff.Unified(snap.File, snap.File, current, reverted))
+			if conflicts > 0 {
+				fmt.Printf("The merge has %d conflict(s), marked with <<<<<<< and >>>>>>>.\n", conflicts)
+			}
+			if !undoYes && !confirm("Apply the merged undo?") {
+				fmt.Println("Nothing changed.")
+				return nil
+			}
+		}
+
+		if err := fileutil.WriteFileIfUnchanged(snap.File, reverted, 0644, version); err != nil {
+			if errors.Is(err, fileutil.ErrChanged) {
+				return fmt.Errorf("%s changed while undoing, try again", snap.File)
+			}
+			return err
+		}
+		if err := store.Remove(snap); err != nil {
+			return err
+		}
+		fmt.Printf("Undid %s in %s.\n", snap.Operation, snap.File)
+		return nil
+	},
+}
+
+// confirm asks a yes/no question on the terminal, defaulting to no.
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func init() {
+	rootCmd.AddCommand(undoCmd)
+	undoCmd.Flags().BoolVarP(&undoYes, "yes", "y", false, "apply a merged undo without asking")
+}
@@ -0,0 +1,200 @@
+// Package backup keeps snapshots of task files from before gobox rewrote them, so the
+// changes can be undone.
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gobox/internal/fileutil"
+	"gobox/internal/textdiff"
+)
+
+// DefaultKeep is how many snapshots are kept per task file.
+const DefaultKeep = 20
+
+// timeFormat names snapshot files so that they sort in the order they were taken.
+const timeFormat = "20060102T150405.000000000Z"
+
+// ErrNoSnapshot is returned when there is no snapshot to undo.
+var ErrNoSnapshot = errors.New("no changes to undo")
+
+// Snapshot records a change gobox made to a task file.
+type Snapshot struct {
+	File      string    `json:"file"`      // Absolute path of the task file
+	Operation string    `json:"operation"` // What gobox changed, e.g. `complete "Write docs"`
+	Time      time.Time `json:"time"`      // When the change was made
+	Before    string    `json:"before"`    // Content of the file before the change
+	After     string    `json:"after"`     // Content gobox wrote
+
+	path string // where the snapshot is stored
+}
+
+// Store keeps the snapshots of each task file in a directory of its own below Dir,
+// dropping all but the latest Keep.
+type Store struct {
+	Dir  string
+	Keep int
+}
+
+// NewStore returns a store keeping DefaultKeep snapshots per file below dir.
+func NewStore(dir string) *Store {
+	return &Store{Dir: dir, Keep: DefaultKeep}
+}
+
+// fileDir returns the directory the snapshots of file are kept in, named after the file
+// and told apart from files of the same name by a hash of its path.
+func (s *Store) fileDir(file string) string {
+	sum := sha256.Sum256([]byte(file))
+	return filepath.Join(s.Dir, filepath.Base(file)+"-"+hex.EncodeToString(sum[:4]))
+}
+
+// Save records that operation changed file from before to after.
+func (s *Store) Save(file, operation string, before, after []byte) (*Snapshot, error) {
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	snap := &Snapshot{File: file, Operation: operation, Time: time.Now(), Before: string(before), After: string(after)}
+	dir := s.fileDir(file)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	snap.path = filepath.Join(dir, snap.Time.UTC().Format(timeFormat)+".json")
+	if err := fileutil.WriteFile(snap.path, append(data, '\n'), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write backup: %w", err)
+	}
+	return snap, s.prune(dir)
+}
+
+// prune removes all but the latest Keep snapshots in dir.
+func (s *Store) prune(dir string) error {
+	names, err := snapshotNames(dir)
+	if err != nil || s.Keep <= 0 || len(names) <= s.Keep {
+		return err
+	}
+	for _, name := range names[:len(names)-s.Keep] {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshotNames returns the names of the snapshot files in dir, oldest first.
+func snapshotNames(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// List returns the snapshots of file, oldest first. An empty file lists the snapshots
+// of all files, ordered by time.
+func (s *Store) List(file string) ([]Snapshot, error) {
+	var dirs []string
+	if file != "" {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return nil, err
+		}
+		dirs = []string{s.fileDir(abs)}
+	} else {
+		entries, err := os.ReadDir(s.Dir)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.IsDir() {
+				dirs = append(dirs, filepath.Join(s.Dir, e.Name()))
+			}
+		}
+	}
+
+	var snaps []Snapshot
+	for _, dir := range dirs {
+		names, err := snapshotNames(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			snap, err := load(filepath.Join(dir, name))
+			if err != nil {
+				return nil, err
+			}
+			snaps = append(snaps, *snap)
+		}
+	}
+	sort.SliceStable(snaps, func(i, j int) bool { return snaps[i].Time.Before(snaps[j].Time) })
+	return snaps, nil
+}
+
+func load(path string) (*Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var snap Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("invalid backup %s: %w", path, err)
+	}
+	snap.path = path
+	return &snap, nil
+}
+
+// Latest returns the latest snapshot of file, or of any file if file is empty. It
+// returns ErrNoSnapshot if there is none.
+func (s *Store) Latest(file string) (*Snapshot, error) {
+	snaps, err := s.List(file)
+	if err != nil {
+		return nil, err
+	}
+	if len(snaps) == 0 {
+		return nil, ErrNoSnapshot
+	}
+	return &snaps[len(snaps)-1], nil
+}
+
+// Remove deletes the snapshot, once its change has been undone or was never written.
+func (s *Store) Remove(snap *Snapshot) error {
+	if snap.path == "" {
+		return nil
+	}
+	return os.Remove(snap.path)
+}
+
+// Revert returns current, the content of the snapshot's file, with the snapshot's change
+// undone. If the file was edited since gobox changed it, the undo is merged into the
+// edits: merged is true and conflicts counts the places where both changed the same lines,
+// which are marked in the result.
+func (snap *Snapshot) Revert(current []byte) (reverted []byte, merged bool, conflicts int) {
+	if string(current) == snap.After {
+		return []byte(snap.Before), false, 0
+	}
+	reverted, conflicts = textdiff.Merge([]byte(snap.After), current, []byte(snap.Before),
+		"current", "before "+snap.Operation)
+	return reverted, true, conflicts
+}
@@ -0,0 +1,72 @@
+package backup_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"gobox/internal/backup"
+)
+
+func TestSaveListAndPrune(t *testing.T) {
+	dir := t.TempDir()
+	store := backup.NewStore(filepath.Join(dir, "backups"))
+	store.Keep = 2
+	tasks := filepath.Join(dir, "tasks.md")
+	other := filepath.Join(dir, "other", "tasks.md")
+
+	if _, err := store.Latest(tasks); !errors.Is(err, backup.ErrNoSnapshot) {
+		t.Fatalf("Latest() on an empty store = %v, want ErrNoSnapshot", err)
+	}
+	for _, op := range []string{"first", "second", "third"} {
+		if _, err := store.Save(tasks, op, []byte("before "+op), []byte("after "+op)); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+	if _, err := store.Save(other, "other", []byte("a"), []byte("b")); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	snaps, err := store.List(tasks)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(snaps) != 2 || snaps[0].Operation != "second" || snaps[1].Operation != "third" {
+		t.Fatalf("expected the two latest snapshots, got %+v", snaps)
+	}
+	if snaps[1].File != tasks || snaps[1].Before != "before third" || snaps[1].After != "after third" {
+		t.Errorf("unexpected snapshot %+v", snaps[1])
+	}
+
+	// Without a file, the latest change to any file is undone first
+	latest, err := store.Latest("")
+	if err != nil || latest.Operation != "other" {
+		t.Fatalf("Latest(\"\") = %+v, %v, want the snapshot of the other file", latest, err)
+	}
+	if err := store.Remove(latest); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if latest, err = store.Latest(""); err != nil || latest.Operation != "third" {
+		t.Errorf("Latest(\"\") after Remove = %+v, %v, want the third snapshot", latest, err)
+	}
+}
+
+func TestRevert(t *testing.T) {
+	snap := backup.Snapshot{
+		Operation: `complete "Task"`,
+		Before:    "- [ ] Task @1h\n- [ ] Other @1h\n",
+		After:     "- [x] Task @1h\n  * ⏱️ 1h\n- [ ] Other @1h\n",
+	}
+
+	reverted, merged, conflicts := snap.Revert([]byte(snap.After))
+	if string(reverted) != snap.Before || merged || conflicts != 0 {
+		t.Errorf("Revert() of an unedited file = %q, %v, %d", reverted, merged, conflicts)
+	}
+
+	edited := "- [x] Task @1h\n  * ⏱️ 1h\n- [ ] Other @1h\n- [ ] Added later @1h\n"
+	reverted, merged, conflicts = snap.Revert([]byte(edited))
+	want := "- [ ] Task @1h\n- [ ] Other @1h\n- [ ] Added later @1h\n"
+	if string(reverted) != want || !merged || conflicts != 0 {
+		t.Errorf("Revert() of an edited file = %q, %v, %d, want %q", reverted, merged, conflicts, want)
+	}
+}
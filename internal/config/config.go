@@ -0,0 +1,262 @@
+// Package config loads the project configuration, which lives in a JSON file next to
+// the state and history files.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gobox/internal/gitutil"
+	"gobox/internal/parser"
+	"gobox/internal/session"
+)
+
+// Config is the project configuration. Every setting is optional.
+type Config struct {
+	// Commits decides which commits are credited to a session
+	Commits gitutil.Attribution `json:"commits"`
+
+	// Repos lists the repositories whose commits are tracked, relative to the config
+	// file; the repository around the working directory if neither it nor Workspace is set
+	Repos []string `json:"repos,omitempty"`
+
+	// Workspace is a directory whose repositories, found one level deep, are tracked
+	Workspace string `json:"workspace,omitempty"`
+
+	// Scope decides how the scope declared on a task is checked
+	Scope ScopeChecks `json:"scope"`
+
+	// Annotation decides what is written below a task when it is completed
+	Annotation AnnotationConfig `json:"annotation"`
+
+	// Idle decides when a running session is paused for lack of activity
+	Idle IdleConfig `json:"idle"`
+
+	dir string // Directory of the config file
+}
+
+// AnnotationConfig selects the annotation written below completed tasks: a template,
+// a template file relative to the config file, or one of the built-in formats. The
+// emoji format is used if none is set.
+type AnnotationConfig struct {
+	Format       string `json:"format,omitempty"`
+	Template     string `json:"template,omitempty"`
+	TemplateFile string `json:"template_file,omitempty"`
+}
+
+// LoadAnnotation returns the configured annotation.
+func (c Config) LoadAnnotation() (*parser.Annotation, error) {
+	a := c.Annotation
+	switch {
+	case a.Template != "" && a.TemplateFile != "":
+		return nil, errors.New("annotation template and template_file are mutually exclusive")
+	case a.Template != "":
+		return parser.NewAnnotation(a.Template)
+	case a.TemplateFile != "":
+		text, err := os.ReadFile(c.resolve(a.TemplateFile))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read annotation template: %w", err)
+		}
+		return parser.NewAnnotation(string(text))
+	case a.Format != "":
+		return parser.AnnotationFormat(a.Format)
+	default:
+		return parser.DefaultAnnotation, nil
+	}
+}
+
+// IdleSource names what counts as activity while a session runs.
+type IdleSource string
+
+const (
+	// IdleKeys counts keys typed in the TUI.
+	IdleKeys IdleSource = "keys"
+	// IdleFiles counts changes to the files in the working trees of the repositories.
+	IdleFiles IdleSource = "files"
+	// IdleCommand asks the idle command how long the user has been idle.
+	IdleCommand IdleSource = "command"
+)
+
+// IdleConfig decides when a running session is idle, and paused as of its last
+// activity. Sessions are always paused when the machine was suspended.
+type IdleConfig struct {
+	// After is how long without activity makes a session idle, like "15m"; sessions
+	// aren't paused for being idle if it is empty
+	After string `json:"after,omitempty"`
+
+	// Sources is what counts as activity: keys and files if empty, and the command if set
+	Sources []IdleSource `json:"sources,omitempty"`
+
+	// Command prints how long the user has been idle, in milliseconds like xprintidle
+	// or as a duration like "90s"
+	Command string `json:"command,omitempty"`
+}
+
+// Threshold returns how long without activity makes a session idle, zero if sessions
+// aren't paused for being idle.
+func (c IdleConfig) Threshold() time.Duration {
+	d, _ := time.ParseDuration(c.After)
+	return d
+}
+
+// Validate checks the threshold and the sources.
+func (c IdleConfig) Validate() error {
+	if c.After != "" {
+		if d, err := time.ParseDuration(c.After); err != nil || d <= 0 {
+			return fmt.Errorf("invalid idle time %q, use a duration like 15m", c.After)
+		}
+	}
+	for _, source := range c.Sources {
+		switch source {
+		case IdleKeys, IdleFiles:
+		case IdleCommand:
+			if c.Command == "" {
+				return errors.New("the idle source command needs an idle command")
+			}
+		default:
+			return fmt.Errorf("unknown idle source %q, use %s, %s or %s", source, IdleKeys, IdleFiles, IdleCommand)
+		}
+	}
+	return nil
+}
+
+// NewSources returns the configured idle sources, watching the files of repos and
+// counting the keys recorded in keys. A nil keys leaves the keys out, where none are
+// typed.
+func (c IdleConfig) NewSources(repos []gitutil.Repo, keys *session.Activity) []session.IdleSource {
+	names := c.Sources
+	if len(names) == 0 {
+		names = []IdleSource{IdleKeys, IdleFiles}
+		if c.Command != "" {
+			names = append(names, IdleCommand)
+		}
+	}
+
+	var sources []session.IdleSource
+	for _, name := range names {
+		switch {
+		case name == IdleKeys && keys != nil:
+			sources = append(sources, keys)
+		case name == IdleFiles:
+			sources = append(sources, session.RepoActivity{Repos: repos})
+		case name == IdleCommand:
+			sources = append(sources, session.IdleCommand{Command: c.Command})
+		}
+	}
+	return sources
+}
+
+// ScopeChecks decides what is checked against a task's scope. Commits always are.
+type ScopeChecks struct {
+	// Uncommitted also flags files changed in the working trees outside the scope
+	Uncommitted bool `json:"uncommitted,omitempty"`
+}
+
+// UncommittedScope returns the scope to check uncommitted changes against for a task
+// with the given scope, nil if they aren't checked.
+func (c ScopeChecks) UncommittedScope(scope []string) gitutil.Scope {
+	if !c.Uncommitted {
+		return nil
+	}
+	return scope
+}
+
+// Load reads the configuration from path. A missing file gives the default configuration.
+func Load(path string) (Config, error) {
+	cfg := Config{dir: filepath.Dir(path)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	if err := cfg.Commits.Validate(); err != nil {
+		return cfg, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	if _, err := cfg.LoadAnnotation(); err != nil {
+		return cfg, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	if err := cfg.Idle.Validate(); err != nil {
+		return cfg, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// SessionRepos returns the repositories whose commits count towards the tasks in
+// markdownFile. Repositories listed in the file itself take precedence over the ones in
+// the config.
+func (c Config) SessionRepos(markdownFile string) ([]gitutil.Repo, error) {
+	paths, err := parser.ParseRepos(markdownFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		for _, path := range c.Repos {
+			paths = append(paths, c.resolve(path))
+		}
+	}
+
+	var repos []gitutil.Repo
+	for _, path := range paths {
+		repo, err := gitutil.NewRepo(path)
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, repo)
+	}
+	if len(paths) == 0 && c.Workspace != "" {
+		found, err := gitutil.DiscoverRepos(c.resolve(c.Workspace))
+		if err != nil {
+			return nil, fmt.Errorf("failed to discover repositories: %w", err)
+		}
+		repos = append(repos, found...)
+	}
+	if len(repos) == 0 {
+		repo, err := gitutil.NewRepo(".")
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, repo)
+	}
+	return uniqueRepos(repos), nil
+}
+
+// resolve makes a path from the config relative to the config file, expanding a leading ~.
+func (c Config) resolve(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(c.dir, path)
+}
+
+// uniqueRepos drops repositories listed more than once, and makes the names of
+// different repositories in directories with the same name unique, see
+// gitutil.UniqueNames.
+func uniqueRepos(repos []gitutil.Repo) []gitutil.Repo {
+	var unique []gitutil.Repo
+	paths := make(map[string]bool)
+	for _, repo := range repos {
+		if paths[repo.Path] {
+			continue
+		}
+		paths[repo.Path] = true
+		repo.Name = filepath.Base(repo.Path)
+		unique = append(unique, repo)
+	}
+	gitutil.UniqueNames(unique)
+	return unique
+}
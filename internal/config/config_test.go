@@ -0,0 +1,164 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"gobox/internal/config"
+	"gobox/internal/session"
+)
+
+func TestLoadMissingFile(t *testing.T) {
+	cfg, err := config.Load(filepath.Join(t.TempDir(), ".gobox.json"))
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(cfg.Repos) != 0 || cfg.Commits.Branch != "" {
+		t.Errorf("expected the default config, got %+v", cfg)
+	}
+}
+
+func TestLoadRejectsUnknownBranchScope(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".gobox.json")
+	if err := os.WriteFile(path, []byte(`{"commits": {"branch": "mine"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := config.Load(path); err == nil {
+		t.Error("expected an error for an unknown branch scope")
+	}
+}
+
+func TestLoadAnnotationTemplateFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ".gobox.json")
+	if err := os.WriteFile(path, []byte(`{"annotation": {"template_file": "done.tmpl"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := config.Load(path); err == nil {
+		t.Error("expected an error for a missing template file")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "done.tmpl"), []byte("* Done in {{.Actual}}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if _, err := cfg.LoadAnnotation(); err != nil {
+		t.Errorf("LoadAnnotation failed: %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"annotation": {"format": "emoji-free"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := config.Load(path); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestLoadIdle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".gobox.json")
+	for _, invalid := range []string{
+		`{"idle": {"after": "soon"}}`,
+		`{"idle": {"after": "15m", "sources": ["mouse"]}}`,
+		`{"idle": {"after": "15m", "sources": ["command"]}}`,
+	} {
+		if err := os.WriteFile(path, []byte(invalid), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := config.Load(path); err == nil {
+			t.Errorf("expected an error for %s", invalid)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte(`{"idle": {"after": "15m", "command": "xprintidle"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if got := cfg.Idle.Threshold(); got != 15*time.Minute {
+		t.Errorf("expected a threshold of 15m, got %v", got)
+	}
+	// Keys, files and the command count by default; keys only where they are typed
+	if got := cfg.Idle.NewSources(nil, &session.Activity{}); len(got) != 3 {
+		t.Errorf("expected three sources, got %d", len(got))
+	}
+	if got := cfg.Idle.NewSources(nil, nil); len(got) != 2 {
+		t.Errorf("expected two sources without keys, got %d", len(got))
+	}
+}
+
+func TestSessionRepos(t *testing.T) {
+	dir := t.TempDir()
+	for _, repo := range []string{"work/service", "work/lib", "work/notes"} {
+		if err := os.MkdirAll(filepath.Join(dir, repo), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, repo := range []string{"work/service", "work/lib"} {
+		if err := os.Mkdir(filepath.Join(dir, repo, ".git"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(dir, ".gobox.json")
+	if err := os.WriteFile(path, []byte(`{"workspace": "work"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	tasks := filepath.Join(dir, "tasks.md")
+	if err := os.WriteFile(tasks, []byte("- [ ] Task @1h\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	repos, err := cfg.SessionRepos(tasks)
+	if err != nil {
+		t.Fatalf("SessionRepos failed: %v", err)
+	}
+	if len(repos) != 2 || repos[0].Name != "lib" || repos[1].Name != "service" {
+		t.Fatalf("expected the workspace repos lib and service, got %+v", repos)
+	}
+	if repos[0].Path != filepath.Join(dir, "work", "lib") {
+		t.Errorf("unexpected path %s", repos[0].Path)
+	}
+
+	// Repositories listed in the task file win over the config
+	content := "<!-- gobox:repos=work/service -->\n- [ ] Task @1h\n"
+	if err := os.WriteFile(tasks, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	repos, err = cfg.SessionRepos(tasks)
+	if err != nil {
+		t.Fatalf("SessionRepos failed: %v", err)
+	}
+	if len(repos) != 1 || repos[0].Name != "service" {
+		t.Errorf("expected only the service repo, got %+v", repos)
+	}
+	// Repositories in directories with the same name are told apart by their parents
+	if err := os.MkdirAll(filepath.Join(dir, "forks", "lib", ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	content = "<!-- gobox:repos=work/lib,forks/lib,work/service -->\n- [ ] Task @1h\n"
+	if err := os.WriteFile(tasks, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	repos, err = cfg.SessionRepos(tasks)
+	if err != nil {
+		t.Fatalf("SessionRepos failed: %v", err)
+	}
+	var names []string
+	for _, repo := range repos {
+		names = append(names, repo.Name)
+	}
+	if !slices.Equal(names, []string{"work/lib", "forks/lib", "service"}) {
+		t.Errorf("expected the lib repos named after their parents, got %v", names)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"syscall"
@@ -124,14 +126,9 @@ func timerAndGitWatcher(
 
 			mu.Lock()
 			for _, commit := range commits {
-				// Extract commit hash (first 7-8 chars)
-				parts := strings.SplitN(commit, " ", 2)
-				if len(parts) > 0 {
-					hash := parts[0]
-					if _, found := lastPrintedCommitHashes[hash]; !found {
-						printCommit(fmt.Sprintf("New commit: %s", commit))
-						lastPrintedCommitHashes[hash] = struct{}{}
-					}
+				if _, found := lastPrintedCommitHashes[commit.Hash]; !found {
+					printCommit(fmt.Sprintf("New commit: %s", commit))
+					lastPrintedCommitHashes[commit.Hash] = struct{}{}
 				}
 			}
 			mu.Unlock()
@@ -167,25 +164,51 @@ func StartGoBoxWithClockAndStore(markdownFile string, clk clock.Clock, stateMgr
 		return fmt.Errorf("Error parsing time box '%s': %v", nextTask.TimeBox, err)
 	}
 
-	actualDuration, actualEndTime, skip := determineTimer(duration, endTime, nextTask)
+	actualDuration, actualEndTime, skip := determineTimer(duration, endTime, nextTask, clk)
 	if skip {
 		return nil
 	}
 
-	states, _ := stateMgr.Load()
-	taskHash := nextTask.Hash()
+	// Give the task a stable ID on first start, so its state survives edits to the task
+	if nextTask.ID == "" {
+		id := task.NewID()
+		if err := parser.AssignTaskID(markdownFile, nil, *nextTask, id); err == nil {
+			nextTask.ID = id
+		}
+	}
+
+	// Only one session runs at a time, so sessions left running elsewhere are paused
 	now := clk.Now()
-	states, currentState := findOrCreateState(states, taskHash, now)
-	stateMgr.Save(states)
+	var currentState *state.TimeBoxState
+	var paused []string
+	_, err = stateMgr.Update(func(states []state.TimeBoxState) ([]state.TimeBoxState, error) {
+		states, started := findOrCreateState(states, markdownFile, nextTask, now)
+		for _, i := range state.Active(states, started.Key()) {
+			states[i].PauseRunning(now)
+			paused = append(paused, states[i].Description)
+		}
+		// The session writes its segments itself, so it gets its own copy of them
+		currentState = &state.TimeBoxState{}
+		*currentState = *started
+		currentState.Segments = slices.Clone(started.Segments)
+		return states, nil
+	})
+	if err != nil {
+		return fmt.Errorf("Error saving state: %w", err)
+	}
+	for _, description := range paused {
+		fmt.Printf("Paused the running session of '%s'.\n", description)
+	}
 
 	elapsed, timerStartTime := calculateElapsedAndStart(currentState, now)
-	setupSignalHandler(states, stateMgr, taskHash)
+	setupSignalHandler(currentState, stateMgr)
+	stopHeartbeat := keepAlive(stateMgr, currentState.Key(), clk)
 
 	stopChan := make(chan struct{})
 	var wg sync.WaitGroup
 	wg.Add(1)
 
-	timerDuration := getTimerDuration(actualDuration, elapsed, nextTask, stateMgr, states)
+	timerDuration := getTimerDuration(actualDuration, elapsed, nextTask)
 	go timerAndGitWatcher(nextTask.Description, timerDuration, actualEndTime, timerStartTime, stopChan, &wg, clk)
 
 	reader := bufio.NewReader(os.Stdin)
@@ -195,21 +218,34 @@ func StartGoBoxWithClockAndStore(markdownFile string, clk clock.Clock, stateMgr
 	default:
 	}
 	wg.Wait()
+	stopHeartbeat()
 
 	finalEndTime := clk.Now()
-	closeCurrentSegmentIfOpen(currentState, finalEndTime, stateMgr, states)
+	if err := closeCurrentSegmentIfOpen(currentState, finalEndTime, stateMgr); err != nil {
+		fmt.Printf("Warning: Could not save state: %v\n", err)
+	}
 	commitsDuringTask := getCommitsDuringTask(timerStartTime)
+	scope := gitutil.Scope(nextTask.Scope)
+	gitutil.FlagScope(commitsDuringTask, scope)
+	gitutil.LinkCommits(commitsDuringTask, nil)
+	diff := gitutil.SessionDiff(commitsDuringTask, nil, nil, gitutil.Baseline{TaskFile: currentState.File})
 	nextTask.IsChecked = true
-	totalDuration := calculateTotalDuration(currentState, finalEndTime)
-	err = parser.UpdateMarkdown(markdownFile, *nextTask, commitsDuringTask, totalDuration)
-	newStates := stateMgr.RemoveTaskState(states, taskHash)
-	stateMgr.Save(newStates)
-
+	err = parser.UpdateMarkdown(markdownFile, nil, *nextTask, commitsDuringTask, currentState.Segments, diff)
 	if err != nil {
+		// The state keeps the session's time until the task can be completed
 		return fmt.Errorf("Error updating markdown file: %v", err)
 	}
+	if _, err := RemoveState(stateMgr, currentState.Key()); err != nil {
+		return fmt.Errorf("Error removing the completed task's state: %w", err)
+	}
 
 	fmt.Println("\nTask completed and markdown updated!")
+	if warnings := gitutil.ScopeWarnings(commitsDuringTask, diff.Uncommitted); len(warnings) > 0 {
+		fmt.Printf("⚠️  Changes outside the task's scope (%s):\n", strings.Join(scope, ", "))
+		for _, w := range warnings {
+			fmt.Println("  " + w)
+		}
+	}
 	return nil
 }
 
@@ -220,54 +256,81 @@ func StartGoBoxWithClock(markdownFile string, clk clock.Clock) error {
 
 // CompleteTask marks a task as checked, updates the markdown file, and records duration/commits.
 // It sums all segments in the TimeBoxState for total duration.
-func CompleteTask(markdownFile string, t task.Task, tbState state.TimeBoxState, commits []string) error {
+func CompleteTask(markdownFile string, t task.Task, tbState state.TimeBoxState, commits []gitutil.Commit) error {
 	updated := t
 	updated.IsChecked = true
-	var totalDuration time.Duration
-	for _, seg := range tbState.Segments {
-		if seg.End != nil {
-			totalDuration += seg.End.Sub(seg.Start)
-		}
-	}
-	return parser.UpdateMarkdown(markdownFile, updated, commits, totalDuration)
+	return parser.UpdateMarkdown(markdownFile, nil, updated, commits, tbState.Segments, gitutil.Summarize(commits))
 }
 
 // --- Helper Functions ---
 
+// selectNextTask returns the first unchecked task with a timebox. Parents are skipped
+// while they still have open subtasks, so those get worked on first.
 func selectNextTask(tasks []task.Task) *task.Task {
 	for i := range tasks {
-		if !tasks[i].IsChecked && tasks[i].TimeBox != "" {
+		if !tasks[i].IsChecked && tasks[i].TimeBox != "" && !hasOpenSubtasks(&tasks[i]) {
 			return &tasks[i]
 		}
 	}
 	return nil
 }
 
-func determineTimer(duration time.Duration, endTime time.Time, nextTask *task.Task) (time.Duration, time.Time, bool) {
+func hasOpenSubtasks(t *task.Task) bool {
+	for _, child := range t.Children {
+		if !child.IsChecked {
+			return true
+		}
+	}
+	return false
+}
+
+func determineTimer(duration time.Duration, endTime time.Time, nextTask *task.Task, clk clock.Clock) (time.Duration, time.Time, bool) {
 	if duration > 0 {
 		return duration, time.Time{}, false
 	} else if !endTime.IsZero() {
-		if time.Until(endTime) <= 0 {
+		now := clk.Now()
+		startTime, rangeEnd, err := parser.ParseTimeRangeAt(nextTask.TimeBox, now)
+		if err == nil {
+			endTime = rangeEnd
+		}
+		if !endTime.After(now) {
 			fmt.Printf("Task '%s' with timebox '%s' is already past its end time. Skipping.\n", nextTask.Description, nextTask.TimeBox)
 			return 0, time.Time{}, true
 		}
+		if err == nil && now.Before(startTime) {
+			fmt.Printf("Task '%s' is scheduled to start at %s. Skipping.\n", nextTask.Description, startTime.Format("15:04"))
+			return 0, time.Time{}, true
+		}
 		return 0, endTime, false
 	}
 	fmt.Printf("Task '%s' has an invalid or unsupported timebox: %s\n", nextTask.Description, nextTask.TimeBox)
 	return 0, time.Time{}, true
 }
 
-func findOrCreateState(states []state.TimeBoxState, taskHash string, now time.Time) ([]state.TimeBoxState, *state.TimeBoxState) {
-	for i := range states {
-		if states[i].TaskHash == taskHash {
-			if len(states[i].Segments) == 0 || states[i].Segments[len(states[i].Segments)-1].End != nil {
-				states[i].Segments = append(states[i].Segments, state.TimeSegment{Start: now, End: nil})
-			}
-			return states, &states[i]
+// findOrCreateState returns the state of t with an open segment, creating the state if
+// needed. A segment left open by a gobox that was killed is closed at its last heartbeat
+// first, so that the time since doesn't count.
+func findOrCreateState(states []state.TimeBoxState, file string, t *task.Task, now time.Time) ([]state.TimeBoxState, *state.TimeBoxState) {
+	if abs, err := filepath.Abs(file); err == nil {
+		file = abs
+	}
+	taskHash := t.Hash()
+	if i := state.FindState(states, file, t.ID, taskHash, t.Description); i >= 0 {
+		states[i].TaskID = t.ID
+		states[i].TaskHash = taskHash
+		states[i].File = file
+		if states[i].IsStale(now) {
+			states[i].CloseAt(states[i].LastSeen())
+		}
+		if len(states[i].Segments) == 0 || states[i].Segments[len(states[i].Segments)-1].End != nil {
+			states[i].Segments = append(states[i].Segments, state.TimeSegment{Start: now, End: nil})
 		}
+		return states, &states[i]
 	}
 	states = append(states, state.TimeBoxState{
+		TaskID:   t.ID,
 		TaskHash: taskHash,
+		File:     file,
 		Segments: []state.TimeSegment{{Start: now, End: nil}},
 	})
 	return states, &states[len(states)-1]
@@ -291,32 +354,58 @@ func calculateElapsedAndStart(currentState *state.TimeBoxState, now time.Time) (
 	return elapsed, timerStartTime
 }
 
-func setupSignalHandler(states []state.TimeBoxState, stateMgr StateStore, taskHash string) {
+// keepAlive records a heartbeat for the session tracked under key every
+// state.HeartbeatInterval until the returned function is called. The function returns
+// once the last heartbeat is saved, so the state can be written safely after it.
+func keepAlive(stateMgr StateStore, key string, clk clock.Clock) (stop func()) {
+	ticker := clk.NewTicker(state.HeartbeatInterval)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C():
+				// A missed heartbeat is made up for by the next one
+				_, _ = BeatState(stateMgr, key, clk.Now())
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+func setupSignalHandler(currentState *state.TimeBoxState, stateMgr StateStore) {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		sig := <-sigChan
 		fmt.Printf("\nReceived signal: %v. Pausing timebox and saving state...\n", sig)
 		now := time.Now()
-		for i := range states {
-			if states[i].TaskHash == taskHash && len(states[i].Segments) > 0 {
-				lastSeg := &states[i].Segments[len(states[i].Segments)-1]
-				if lastSeg.End == nil {
-					lastSeg.End = &now
-				}
+		if len(currentState.Segments) > 0 {
+			lastSeg := &currentState.Segments[len(currentState.Segments)-1]
+			if lastSeg.End == nil {
+				lastSeg.End = &now
 			}
 		}
-		stateMgr.Save(states)
+		if _, err := SaveState(stateMgr, *currentState); err != nil {
+			fmt.Printf("Error saving state: %v\n", err)
+		}
 		os.Exit(130)
 	}()
 }
 
-func getTimerDuration(actualDuration, elapsed time.Duration, nextTask *task.Task, stateMgr StateStore, states []state.TimeBoxState) time.Duration {
+func getTimerDuration(actualDuration, elapsed time.Duration, nextTask *task.Task) time.Duration {
 	if actualDuration > 0 {
 		if elapsed >= actualDuration {
 			fmt.Println("Task has already used up its allocated timebox. Marking as done.")
 			nextTask.IsChecked = true
-			stateMgr.Save(states)
 			return 0
 		}
 		return actualDuration - elapsed
@@ -324,37 +413,25 @@ func getTimerDuration(actualDuration, elapsed time.Duration, nextTask *task.Task
 	return 0
 }
 
-func closeCurrentSegmentIfOpen(currentState *state.TimeBoxState, finalEndTime time.Time, stateMgr StateStore, states []state.TimeBoxState) {
+// closeCurrentSegmentIfOpen closes the open segment of currentState at finalEndTime and
+// saves it.
+func closeCurrentSegmentIfOpen(currentState *state.TimeBoxState, finalEndTime time.Time, stateMgr StateStore) error {
 	if currentState != nil && len(currentState.Segments) > 0 {
 		lastSeg := &currentState.Segments[len(currentState.Segments)-1]
 		if lastSeg.End == nil {
 			lastSeg.End = &finalEndTime
-			stateMgr.Save(states)
+			_, err := SaveState(stateMgr, *currentState)
+			return err
 		}
 	}
+	return nil
 }
 
-func getCommitsDuringTask(timerStartTime time.Time) []string {
-	allCommits, err := gitutil.GetCommitsSince(timerStartTime)
+func getCommitsDuringTask(timerStartTime time.Time) []gitutil.Commit {
+	commits, err := gitutil.GetCommitsSince(timerStartTime)
 	if err != nil {
 		fmt.Printf("Warning: Could not fetch Git commits: %v\n", err)
-		allCommits = []string{}
-	}
-	var commitsDuringTask []string
-	for _, commitLine := range allCommits {
-		commitsDuringTask = append(commitsDuringTask, commitLine)
-	}
-	return commitsDuringTask
-}
-
-func calculateTotalDuration(currentState *state.TimeBoxState, finalEndTime time.Time) time.Duration {
-	var totalDuration time.Duration
-	for _, seg := range currentState.Segments {
-		if seg.End != nil {
-			totalDuration += seg.End.Sub(seg.Start)
-		} else {
-			totalDuration += finalEndTime.Sub(seg.Start)
-		}
+		return nil
 	}
-	return totalDuration
+	return commits
 }
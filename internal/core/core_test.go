@@ -7,6 +7,8 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"gobox/internal/clock"
 )
 
 // Helper to create a temporary markdown file with given content
@@ -169,7 +171,9 @@ func TestStartGoBox_StatePopulatedDuringActiveSession(t *testing.T) {
 	}()
 
 	// Start GoBox in a goroutine so we can check state while it's running
+	finished := make(chan struct{})
 	go func() {
+		defer close(finished)
 		StartGoBoxWithClockAndStore(tmpFile, nil, memStore)
 	}()
 
@@ -185,6 +189,8 @@ func TestStartGoBox_StatePopulatedDuringActiveSession(t *testing.T) {
 	}
 
 	<-done
+	// The session reads stdin until it ends
+	<-finished
 	os.Stdin = origStdin
 }
 
@@ -206,4 +212,42 @@ func TestStartGoBox_NoTasks(t *testing.T) {
 	}
 }
 
+func TestStartGoBox_TimeRangeGoesByTheClock(t *testing.T) {
+	tmpFile := createTempMarkdownFile(t, "- [ ] Standup @[10:00-10:30]\n")
+	memStore := NewInMemoryStateStore()
+	now := time.Now()
+	clk := clock.NewMockClock(time.Date(now.Year(), now.Month(), now.Day(), 9, 0, 0, 0, now.Location()))
+
+	out, _ := captureOutput(func() {
+		if err := StartGoBoxWithClockAndStore(tmpFile, clk, memStore); err != nil {
+			t.Errorf("StartGoBoxWithClockAndStore failed: %v", err)
+		}
+	})
+	if !strings.Contains(out, "scheduled to start at 10:00") {
+		t.Errorf("expected the task to wait for its range at 9:00, got: %q", out)
+	}
+	if states, _ := memStore.Load(); len(states) != 0 {
+		t.Errorf("expected no session to start, got %+v", states)
+	}
+}
+
 // Additional tests for pause/resume, state file, and error cases can be added here.
+
+func TestStartGoBox_CorruptStateIsAnError(t *testing.T) {
+	tmpFile := createTempMarkdownFile(t, "- [ ] Task @1h\n")
+	stateFile := filepath.Join(filepath.Dir(tmpFile), "state.json")
+	if err := os.WriteFile(stateFile, []byte("{not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var err error
+	captureOutput(func() {
+		err = StartGoBoxWithClockAndStore(tmpFile, nil, NewFileStateStore(stateFile))
+	})
+	if err == nil || !strings.Contains(err.Error(), "Error saving state") {
+		t.Fatalf("expected the state error to be returned, got %v", err)
+	}
+	if data := readFileContent(t, stateFile); data != "{not json" {
+		t.Errorf("the corrupt state file should be left alone, got %q", data)
+	}
+}
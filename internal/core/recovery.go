@@ -0,0 +1,61 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gobox/internal/state"
+)
+
+// ErrNotStale is returned by CloseStale when the session was resumed or closed since
+// it was found stale.
+var ErrNotStale = errors.New("the session is no longer stale")
+
+// CloseStale closes the open segment of the stale session s at end, which ParseEnd
+// helps choosing. Closing it at s.LastSeen() drops the time after the last heartbeat,
+// closing it now keeps all of it. The session is paused, so it can be resumed later.
+func CloseStale(store StateStore, s state.TimeBoxState, end time.Time) error {
+	_, err := store.Update(func(states []state.TimeBoxState) ([]state.TimeBoxState, error) {
+		for i := range states {
+			if states[i].Key() != s.Key() {
+				continue
+			}
+			if !states[i].IsActive() || !states[i].LastSeen().Equal(s.LastSeen()) {
+				return nil, ErrNotStale
+			}
+			states[i].CloseAt(end)
+			return states, nil
+		}
+		return nil, ErrNotStale
+	})
+	return err
+}
+
+// ParseEnd parses when the open segment of the stale session s ended: a time of day
+// like "17:30", on the day the segment started or the day after if that is earlier than
+// its start, or how long the segment lasted, like "45m". It must be between the start
+// of the segment and now.
+func ParseEnd(input string, s state.TimeBoxState, now time.Time) (time.Time, error) {
+	if !s.IsActive() {
+		return time.Time{}, ErrNotStale
+	}
+	start := s.Segments[len(s.Segments)-1].Start
+
+	var end time.Time
+	if d, err := time.ParseDuration(input); err == nil {
+		end = start.Add(d)
+	} else if clock, err := time.ParseInLocation("15:04", input, start.Location()); err == nil {
+		end = time.Date(start.Year(), start.Month(), start.Day(), clock.Hour(), clock.Minute(), 0, 0, start.Location())
+		if end.Before(start) {
+			end = end.AddDate(0, 0, 1)
+		}
+	} else {
+		return time.Time{}, fmt.Errorf("%q is neither a time like 17:30 nor a duration like 45m", input)
+	}
+
+	if end.Before(start) || end.After(now) {
+		return time.Time{}, fmt.Errorf("the end must be between %s and now", start.Format("Jan 2 15:04"))
+	}
+	return end, nil
+}
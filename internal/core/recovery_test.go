@@ -0,0 +1,86 @@
+package core
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gobox/internal/state"
+	"gobox/pkg/task"
+)
+
+func TestParseEnd(t *testing.T) {
+	start := time.Date(2025, 6, 2, 22, 30, 0, 0, time.Local)
+	now := time.Date(2025, 6, 3, 9, 0, 0, 0, time.Local)
+	s := state.TimeBoxState{Segments: []state.TimeSegment{{Start: start}}}
+
+	tests := []struct {
+		input string
+		want  time.Time
+		err   bool
+	}{
+		{input: "45m", want: start.Add(45 * time.Minute)},
+		{input: "23:15", want: time.Date(2025, 6, 2, 23, 15, 0, 0, time.Local)},
+		{input: "01:10", want: time.Date(2025, 6, 3, 1, 10, 0, 0, time.Local)}, // after midnight
+		{input: "12h", err: true},                                              // later than now
+		{input: "-5m", err: true},                                              // before the start
+		{input: "soon", err: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseEnd(tt.input, s, now)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseEnd(%q) = %v, expected an error", tt.input, got)
+			}
+			continue
+		}
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("ParseEnd(%q) = %v, %v, want %v", tt.input, got, err, tt.want)
+		}
+	}
+}
+
+func TestCloseStale(t *testing.T) {
+	now := time.Now()
+	heartbeat := now.Add(-time.Hour)
+	store := NewInMemoryStateStore()
+	store.Save([]state.TimeBoxState{{
+		TaskID:    "killed",
+		Segments:  []state.TimeSegment{{Start: now.Add(-2 * time.Hour)}},
+		Heartbeat: &heartbeat,
+	}})
+	states, _ := store.Load()
+	stale := states[0]
+
+	if err := CloseStale(store, stale, stale.LastSeen()); err != nil {
+		t.Fatalf("CloseStale failed: %v", err)
+	}
+	states, _ = store.Load()
+	if end := states[0].Segments[0].End; end == nil || !end.Equal(heartbeat) || !states[0].IsPaused() {
+		t.Errorf("expected the session paused at its last heartbeat, got %+v", states[0])
+	}
+
+	// Once dealt with, the session is left alone
+	if err := CloseStale(store, stale, now); !errors.Is(err, ErrNotStale) {
+		t.Errorf("expected ErrNotStale, got %v", err)
+	}
+}
+
+func TestFindOrCreateStateClosesStaleSegment(t *testing.T) {
+	now := time.Now()
+	heartbeat := now.Add(-3 * time.Hour)
+	tk := &task.Task{ID: "abc", Description: "Write docs", TimeBox: "@1h"}
+	states := []state.TimeBoxState{{
+		TaskID:    "abc",
+		Segments:  []state.TimeSegment{{Start: now.Add(-4 * time.Hour)}},
+		Heartbeat: &heartbeat,
+	}}
+
+	_, current := findOrCreateState(states, "tasks.md", tk, now)
+	if len(current.Segments) != 2 || !current.Segments[0].End.Equal(heartbeat) || !current.Segments[1].Start.Equal(now) {
+		t.Errorf("expected the stale segment closed at its heartbeat and a new one opened, got %+v", current.Segments)
+	}
+	if elapsed, _ := calculateElapsedAndStart(current, now); elapsed != time.Hour {
+		t.Errorf("expected only the time until the heartbeat to count, got %v", elapsed)
+	}
+}
@@ -2,20 +2,77 @@ package core
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
+	"gobox/internal/fileutil"
 	"gobox/internal/state"
 )
 
 // StateStore abstracts state persistence for testability.
 type StateStore interface {
 	Load() ([]state.TimeBoxState, error)
-	Save([]state.TimeBoxState) error
+	// Update loads the states, passes them to update and saves the states it returns,
+	// keeping other gobox processes from changing the states in between. It returns the
+	// saved states. Nothing is saved if update returns an error, which Update returns.
+	Update(update func([]state.TimeBoxState) ([]state.TimeBoxState, error)) ([]state.TimeBoxState, error)
+	// RemoveTaskState returns states without the state tracked under the given task key (see TimeBoxState.Key).
 	RemoveTaskState([]state.TimeBoxState, string) []state.TimeBoxState
 }
 
-// FileStateStore implements StateStore using a file.
+// ActiveSessionsError is returned when starting a session while other sessions are
+// running.
+type ActiveSessionsError struct {
+	States []state.TimeBoxState // the running sessions
+}
+
+func (e *ActiveSessionsError) Error() string {
+	names := make([]string, len(e.States))
+	for i, s := range e.States {
+		names[i] = s.Key()
+		if s.Description != "" {
+			names[i] = fmt.Sprintf("%q", s.Description)
+		}
+	}
+	return "another session is running: " + strings.Join(names, ", ")
+}
+
+// SaveState saves s in place of the state tracked under the same key, keeping the
+// states other processes saved. It returns all saved states.
+func SaveState(store StateStore, s state.TimeBoxState) ([]state.TimeBoxState, error) {
+	return store.Update(func(states []state.TimeBoxState) ([]state.TimeBoxState, error) {
+		return state.Put(states, s), nil
+	})
+}
+
+// RemoveState removes the state tracked under key, keeping the states other processes
+// saved. It returns the remaining states.
+func RemoveState(store StateStore, key string) ([]state.TimeBoxState, error) {
+	return store.Update(func(states []state.TimeBoxState) ([]state.TimeBoxState, error) {
+		return store.RemoveTaskState(states, key), nil
+	})
+}
+
+// BeatState records a heartbeat at now for the saved state tracked under key, if it is
+// still running. Unlike saving the whole state, this leaves a pause made by another
+// process alone. It returns all saved states.
+func BeatState(store StateStore, key string, now time.Time) ([]state.TimeBoxState, error) {
+	return store.Update(func(states []state.TimeBoxState) ([]state.TimeBoxState, error) {
+		for i := range states {
+			if states[i].Key() == key && states[i].IsActive() {
+				states[i].Beat(now)
+			}
+		}
+		return states, nil
+	})
+}
+
+// FileStateStore implements StateStore using a file. Writes take an advisory lock on
+// File + ".lock", so gobox processes sharing the file take turns.
 type FileStateStore struct {
 	File string
 }
@@ -37,21 +94,56 @@ func (fs *FileStateStore) Load() ([]state.TimeBoxState, error) {
 	return states, nil
 }
 
+// Save writes states to the file, replacing it atomically so that a crash or a
+// concurrent Load never sees it half written. It replaces whatever other processes
+// saved meanwhile; Update keeps their changes.
 func (fs *FileStateStore) Save(states []state.TimeBoxState) error {
-	f, err := os.Create(fs.File)
+	unlock, err := fs.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return fs.save(states)
+}
+
+// Update implements StateStore.
+func (fs *FileStateStore) Update(update func([]state.TimeBoxState) ([]state.TimeBoxState, error)) ([]state.TimeBoxState, error) {
+	unlock, err := fs.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	states, err := fs.Load()
+	if err != nil {
+		return nil, err
+	}
+	if states, err = update(states); err != nil {
+		return nil, err
+	}
+	return states, fs.save(states)
+}
+
+// lock takes the lock on the state file, creating its directory if needed.
+func (fs *FileStateStore) lock() (unlock func() error, err error) {
+	if err := os.MkdirAll(filepath.Dir(fs.File), 0755); err != nil {
+		return nil, err
+	}
+	return fileutil.Lock(fs.File + ".lock")
+}
+
+func (fs *FileStateStore) save(states []state.TimeBoxState) error {
+	data, err := json.MarshalIndent(states, "", "  ")
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	enc := json.NewEncoder(f)
-	enc.SetIndent("", "  ")
-	return enc.Encode(states)
+	return fileutil.WriteFile(fs.File, append(data, '\n'), 0644)
 }
 
-func (fs *FileStateStore) RemoveTaskState(states []state.TimeBoxState, taskHash string) []state.TimeBoxState {
+func (fs *FileStateStore) RemoveTaskState(states []state.TimeBoxState, taskKey string) []state.TimeBoxState {
 	var newStates []state.TimeBoxState
 	for _, s := range states {
-		if s.TaskHash != taskHash {
+		if s.Key() != taskKey {
 			newStates = append(newStates, s)
 		}
 	}
@@ -87,10 +179,25 @@ func (ms *InMemoryStateStore) Save(states []state.TimeBoxState) error {
 	return nil
 }
 
-func (ms *InMemoryStateStore) RemoveTaskState(states []state.TimeBoxState, taskHash string) []state.TimeBoxState {
+// Update implements StateStore.
+func (ms *InMemoryStateStore) Update(update func([]state.TimeBoxState) ([]state.TimeBoxState, error)) ([]state.TimeBoxState, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	cpy := make([]state.TimeBoxState, len(ms.states))
+	copy(cpy, ms.states)
+	states, err := update(cpy)
+	if err != nil {
+		return nil, err
+	}
+	ms.states = make([]state.TimeBoxState, len(states))
+	copy(ms.states, states)
+	return states, nil
+}
+
+func (ms *InMemoryStateStore) RemoveTaskState(states []state.TimeBoxState, taskKey string) []state.TimeBoxState {
 	var newStates []state.TimeBoxState
 	for _, s := range states {
-		if s.TaskHash != taskHash {
+		if s.Key() != taskKey {
 			newStates = append(newStates, s)
 		}
 	}
@@ -1,9 +1,12 @@
 package core
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
@@ -11,7 +14,8 @@ import (
 )
 
 func sampleStates() []state.TimeBoxState {
-	now := time.Now().Truncate(time.Second)
+	// UTC so that states compare equal after a JSON round trip
+	now := time.Now().UTC().Truncate(time.Second)
 	later := now.Add(1 * time.Hour)
 	return []state.TimeBoxState{
 		{
@@ -21,6 +25,7 @@ func sampleStates() []state.TimeBoxState {
 			},
 		},
 		{
+			TaskID:   "id2",
 			TaskHash: "hash2",
 			Segments: []state.TimeSegment{
 				{Start: now, End: nil},
@@ -71,7 +76,7 @@ func TestFileStateStore_Basic(t *testing.T) {
 	}
 
 	// RemoveTaskState
-	remaining := store.RemoveTaskState(loaded, "hash2")
+	remaining := store.RemoveTaskState(loaded, "id2")
 	if len(remaining) != 1 || remaining[0].TaskHash != "hash1" {
 		t.Errorf("RemoveTaskState did not remove the correct task: %+v", remaining)
 	}
@@ -93,3 +98,93 @@ func TestFileStateStore_Basic(t *testing.T) {
 		t.Errorf("Expected state file to exist, but got error: %v", err)
 	}
 }
+
+func TestFileStateStore_SaveKeepsPermissions(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(stateFile, []byte("[]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	store := NewFileStateStore(stateFile)
+	if err := store.Save(sampleStates()); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	info, err := os.Stat(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("permissions = %v, want %v", info.Mode().Perm(), os.FileMode(0600))
+	}
+}
+
+func TestFileStateStore_UpdateKeepsConcurrentChanges(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "gobox", "state.json")
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		// A store per goroutine, as separate processes would have
+		store := NewFileStateStore(stateFile)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprintf("task-%d", i)
+			_, err := store.Update(func(states []state.TimeBoxState) ([]state.TimeBoxState, error) {
+				return append(states, state.TimeBoxState{TaskID: key, TaskHash: key}), nil
+			})
+			if err != nil {
+				t.Errorf("Update failed: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	states, err := NewFileStateStore(stateFile).Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(states) != 10 {
+		t.Errorf("expected the states of all 10 updates, got %d", len(states))
+	}
+}
+
+func TestUpdateErrorSavesNothing(t *testing.T) {
+	store := NewFileStateStore(filepath.Join(t.TempDir(), "state.json"))
+	if err := store.Save(sampleStates()); err != nil {
+		t.Fatal(err)
+	}
+	_, err := store.Update(func(states []state.TimeBoxState) ([]state.TimeBoxState, error) {
+		return nil, &ActiveSessionsError{States: states[1:]}
+	})
+	var active *ActiveSessionsError
+	if !errors.As(err, &active) || len(active.States) != 1 {
+		t.Fatalf("expected the update's error, got %v", err)
+	}
+	if states, _ := store.Load(); len(states) != 2 {
+		t.Errorf("a failed update should leave the states alone, got %+v", states)
+	}
+}
+
+func TestBeatStateLeavesPausedSessionsAlone(t *testing.T) {
+	store := NewInMemoryStateStore()
+	start := time.Now().Add(-time.Hour)
+	pausedAt := start.Add(time.Minute)
+	if err := store.Save([]state.TimeBoxState{
+		{TaskID: "running", Segments: []state.TimeSegment{{Start: start}}},
+		{TaskID: "paused", Segments: []state.TimeSegment{{Start: start, End: &pausedAt}}, PausedAt: &pausedAt},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	for _, key := range []string{"running", "paused"} {
+		if _, err := BeatState(store, key, now); err != nil {
+			t.Fatalf("BeatState failed: %v", err)
+		}
+	}
+	saved, _ := store.Load()
+	if saved[0].Heartbeat == nil || !saved[0].Heartbeat.Equal(now) {
+		t.Errorf("expected a heartbeat for the running session, got %v", saved[0].Heartbeat)
+	}
+	if saved[1].Heartbeat != nil || saved[1].IsActive() {
+		t.Errorf("the paused session should be left alone: %+v", saved[1])
+	}
+}
@@ -0,0 +1,107 @@
+package daemon
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// SocketPath returns the control socket location: $GOBOX_SOCKET if set, otherwise
+// gobox.sock in $XDG_RUNTIME_DIR, falling back to a per-user file in the temp dir.
+func SocketPath() string {
+	if path := os.Getenv("GOBOX_SOCKET"); path != "" {
+		return path
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "gobox.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("gobox-%d.sock", os.Getuid()))
+}
+
+// ErrNotRunning is returned by Send when no daemon listens on the socket.
+var ErrNotRunning = errors.New("no gobox session is running")
+
+// Send sends a request to the daemon listening on socketPath and returns the status
+// it reports.
+func Send(socketPath string, req Request) (*Status, error) {
+	conn, err := net.DialTimeout("unix", socketPath, time.Second)
+	if err != nil {
+		return nil, ErrNotRunning
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	line, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(append(line, '\n')); err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	var resp Response
+	reply, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err == nil {
+		err = json.Unmarshal(reply, &resp)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	return resp.Status, nil
+}
+
+// IsRunning reports whether a daemon listens on socketPath.
+func IsRunning(socketPath string) bool {
+	conn, err := net.DialTimeout("unix", socketPath, time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// Listen creates the control socket. A socket file left behind by a daemon that
+// is no longer running is removed first.
+func Listen(socketPath string) (net.Listener, error) {
+	if IsRunning(socketPath) {
+		return nil, errors.New("a gobox session is already running")
+	}
+	_ = os.Remove(socketPath)
+	return net.Listen("unix", socketPath)
+}
+
+// Spawn starts "<this executable> args..." in dir as a background process detached
+// from the terminal, and waits until it listens on socketPath.
+func Spawn(socketPath, dir string, args ...string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find gobox executable: %w", err)
+	}
+
+	cmd := exec.Command(exe, args...)
+	cmd.Dir = dir
+	detach(cmd)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start daemon: %w", err)
+	}
+	// The daemon outlives us, we only needed to start it
+	_ = cmd.Process.Release()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if IsRunning(socketPath) {
+			return nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return fmt.Errorf("daemon did not start listening on %s", socketPath)
+}
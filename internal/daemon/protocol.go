@@ -0,0 +1,57 @@
+package daemon
+
+import (
+	"time"
+
+	"gobox/internal/gitutil"
+)
+
+// Commands understood by the daemon.
+const (
+	CmdStart  = "start"
+	CmdStatus = "status"
+	CmdPause  = "pause"
+	CmdResume = "resume"
+	CmdDone   = "done"
+	CmdAbort  = "abort"
+)
+
+// Session states reported in Status.State.
+const (
+	StateRunning = "running"
+	StatePaused  = "paused"
+	StateTimeUp  = "time_up" // the timebox is used up, waiting for done or abort
+	StateDone    = "done"
+	StateAborted = "aborted"
+)
+
+// Request is a single command sent to the daemon, encoded as one JSON line.
+type Request struct {
+	Command     string `json:"command"`
+	File        string `json:"file,omitempty"`         // Absolute path of the markdown file (start only)
+	Task        string `json:"task,omitempty"`         // Task ID or description text; empty selects the next task (start only)
+	Early       bool   `json:"early,omitempty"`        // Start a time range task before its scheduled start (start only)
+	PauseOthers bool   `json:"pause_others,omitempty"` // Pause sessions running elsewhere instead of refusing to start (start only)
+}
+
+// Response is the daemon's answer to a Request, encoded as one JSON line.
+type Response struct {
+	Error  string  `json:"error,omitempty"`
+	Status *Status `json:"status,omitempty"`
+}
+
+// Status describes the session run by the daemon.
+type Status struct {
+	State       string              `json:"state"`
+	File        string              `json:"file"`
+	TaskID      string              `json:"task_id"`
+	Description string              `json:"description"`
+	TimeBox     string              `json:"timebox"`
+	Scope       []string            `json:"scope,omitempty"` // Paths the task is meant to change
+	StartedAt   time.Time           `json:"started_at"`
+	Elapsed     time.Duration       `json:"elapsed"`
+	Remaining   time.Duration       `json:"remaining"`
+	Commits     []gitutil.Commit    `json:"commits"`
+	Diff        gitutil.DiffSummary `json:"diff"`                 // Changes made by the commits, and those left uncommitted once done
+	IdleSince   *time.Time          `json:"idle_since,omitempty"` // Last activity of a session paused for being idle or suspended
+}
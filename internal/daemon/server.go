@@ -0,0 +1,595 @@
+package daemon
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gobox/internal/backup"
+	"gobox/internal/config"
+	"gobox/internal/core"
+	"gobox/internal/gitutil"
+	"gobox/internal/gitwatcher"
+	"gobox/internal/history"
+	"gobox/internal/parser"
+	"gobox/internal/session"
+	"gobox/internal/state"
+	"gobox/pkg/task"
+)
+
+// Server runs a single timeboxed session in the background and answers control
+// requests for it. It is done once the session ends, or if the session could not
+// be started at all.
+type Server struct {
+	PollInterval time.Duration // how often the git watcher polls for commits
+	StartTimeout time.Duration // how long Serve waits for a start request before giving up
+	Config       config.Config // which repositories are watched and which commits are credited
+	Backups      *backup.Store // where task files are backed up before they are changed, nil to disable
+
+	stateMgr   core.StateStore
+	historyLog history.Log
+
+	mu       sync.Mutex
+	sess     *activeSession
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+// activeSession is the session currently run by the server.
+type activeSession struct {
+	file    string
+	task    task.Task
+	tbState *state.TimeBoxState
+	runner  *session.SessionRunner
+	watcher *gitwatcher.GitWatcher
+	repos   []gitutil.Repo
+	commits []gitutil.Commit
+	stopCh  chan struct{}
+}
+
+// NewServer creates a server that persists state with stateMgr and records completed
+// sessions in historyLog unless it is nil.
+func NewServer(stateMgr core.StateStore, historyLog history.Log) *Server {
+	return &Server{
+		PollInterval: 5 * time.Second,
+		StartTimeout: 10 * time.Second,
+		stateMgr:     stateMgr,
+		historyLog:   historyLog,
+		done:         make(chan struct{}),
+	}
+}
+
+// Done is closed when the server has no more work to do.
+func (s *Server) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Server) finish() {
+	s.doneOnce.Do(func() { close(s.done) })
+}
+
+// Serve answers requests on l until the server is done. Each connection carries
+// one JSON request line and receives one JSON response line.
+func (s *Server) Serve(l net.Listener) error {
+	go func() {
+		select {
+		case <-s.done:
+		case <-time.After(s.StartTimeout):
+			s.mu.Lock()
+			if s.sess == nil {
+				s.finish()
+			}
+			s.mu.Unlock()
+			<-s.done
+		}
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-s.done:
+				return nil
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	var req Request
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err == nil {
+		err = json.Unmarshal(line, &req)
+	}
+
+	var resp Response
+	if err != nil {
+		resp = Response{Error: fmt.Sprintf("invalid request: %v", err)}
+	} else {
+		resp = s.Handle(req)
+	}
+	_ = json.NewEncoder(conn).Encode(resp)
+}
+
+// Handle executes a single request.
+func (s *Server) Handle(req Request) Response {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var status *Status
+	var err error
+	switch req.Command {
+	case CmdStart:
+		status, err = s.start(req)
+	case CmdStatus:
+		status, err = s.status()
+	case CmdPause:
+		status, err = s.pause()
+	case CmdResume:
+		status, err = s.resume()
+	case CmdDone:
+		status, err = s.complete()
+	case CmdAbort:
+		status, err = s.abort()
+	default:
+		err = fmt.Errorf("unknown command %q", req.Command)
+	}
+
+	if err != nil {
+		return Response{Error: err.Error()}
+	}
+	return Response{Status: status}
+}
+
+// Shutdown aborts the running session, if any, saving the time spent so far.
+func (s *Server) Shutdown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sess != nil {
+		_, _ = s.abort()
+	}
+	s.finish()
+}
+
+var (
+	errNoSession = errors.New("no active session")
+	errTimeUp    = errors.New("time is up, run done or abort")
+)
+
+// timeUp reports whether the runner completed because the timebox ran out.
+func (sess *activeSession) timeUp() bool {
+	sess.runner.Mutex.Lock()
+	defer sess.runner.Mutex.Unlock()
+	return sess.runner.Completed
+}
+
+func (s *Server) start(req Request) (*Status, error) {
+	if s.sess != nil {
+		return nil, fmt.Errorf("a session is already running for %q", s.sess.task.Description)
+	}
+
+	sess, err := s.newSession(req)
+	if err != nil {
+		// Nothing to run, so there is no reason to stay around
+		s.finish()
+		return nil, err
+	}
+	s.sess = sess
+
+	sess.runner.Start()
+	sess.watcher.Start()
+	go s.watch(sess)
+
+	s.save()
+	return s.status()
+}
+
+func (s *Server) newSession(req Request) (*activeSession, error) {
+	if req.File == "" {
+		return nil, errors.New("no markdown file given")
+	}
+	tasks, err := parser.ParseMarkdownFile(req.File)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", req.File, err)
+	}
+	t, err := selectTask(tasks, req.Task)
+	if err != nil {
+		return nil, err
+	}
+
+	duration, endTime, err := parser.ParseTimeBox(t.TimeBox)
+	if err != nil || (duration == 0 && endTime.IsZero()) {
+		return nil, fmt.Errorf("task %q has an invalid or unsupported timebox: %s", t.Description, t.TimeBox)
+	}
+	if duration == 0 {
+		if !time.Now().Before(endTime) {
+			return nil, fmt.Errorf("task %q is already past its end time", t.Description)
+		}
+		if startTime, _, err := parser.ParseTimeRange(t.TimeBox); err == nil && time.Now().Before(startTime) && !req.Early {
+			return nil, fmt.Errorf("task %q is scheduled to start at %s, use --early to start it now", t.Description, startTime.Format("15:04"))
+		}
+	}
+
+	// Give the task a stable ID on first start, so its state survives edits to the task
+	if t.ID == "" {
+		id := task.NewID()
+		if err := parser.AssignTaskID(req.File, s.Backups, t, id); err == nil {
+			t.ID = id
+		}
+	}
+
+	repos, err := s.Config.SessionRepos(req.File)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only one session runs at a time: the others are paused if asked to, or the start
+	// is refused
+	tbState := &state.TimeBoxState{}
+	_, err = s.stateMgr.Update(func(states []state.TimeBoxState) ([]state.TimeBoxState, error) {
+		taskHash := t.Hash()
+		idx := state.FindState(states, req.File, t.ID, taskHash, t.Description)
+		if idx < 0 {
+			states = append(states, state.TimeBoxState{TaskID: t.ID, TaskHash: taskHash})
+			idx = len(states) - 1
+		}
+		states[idx].TaskID = t.ID
+		states[idx].TaskHash = taskHash
+		states[idx].File = req.File
+		states[idx].Description = t.Description
+		states[idx].TimeBox = t.TimeBox
+		gitwatcher.RecordRefs(&states[idx], repos)
+
+		active := state.Active(states, states[idx].Key())
+		if len(active) > 0 && !req.PauseOthers {
+			others := &core.ActiveSessionsError{}
+			for _, i := range active {
+				others.States = append(others.States, states[i])
+			}
+			return nil, others
+		}
+		now := time.Now()
+		for _, i := range active {
+			states[i].PauseRunning(now)
+		}
+		*tbState = states[idx]
+		return states, nil
+	})
+	var others *core.ActiveSessionsError
+	if errors.As(err, &others) {
+		return nil, fmt.Errorf("%w, use --pause-others to pause it", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to save state: %w", err)
+	}
+
+	sess := &activeSession{
+		file:    req.File,
+		task:    t,
+		tbState: tbState,
+		runner:  session.NewSessionRunner(t, tbState, duration, endTime),
+		repos:   repos,
+		stopCh:  make(chan struct{}),
+	}
+	// Commits from earlier sessions of this task still count towards it
+	sess.commits, _ = gitwatcher.CommitsDuring(s.Config.Commits, repos, tbState)
+	gitutil.FlagScope(sess.commits, t.Scope)
+
+	startTime := time.Now()
+	if len(tbState.Segments) > 0 {
+		startTime = tbState.Segments[0].Start
+	}
+	// Keys aren't typed here, so only the other idle sources count
+	sess.runner.IdleAfter = s.Config.Idle.Threshold()
+	sess.runner.IdleSources = s.Config.Idle.NewSources(repos, nil)
+
+	sess.watcher = gitwatcher.NewGitWatcher(startTime, s.PollInterval)
+	sess.watcher.Rules = s.Config.Commits
+	sess.watcher.Repos = repos
+	sess.watcher.Refs = gitwatcher.SessionRefs(tbState)
+	sess.watcher.Task = tbState.Key()
+	sess.watcher.Scope = t.Scope
+	return sess, nil
+}
+
+// watch collects commits and reacts to the timebox running out until the session ends.
+func (s *Server) watch(sess *activeSession) {
+	heartbeat := time.NewTicker(state.HeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case now := <-heartbeat.C:
+			s.mu.Lock()
+			if s.sess == sess {
+				s.beat(now)
+			}
+			s.mu.Unlock()
+		case ev := <-sess.runner.Events():
+			// A session paused for being idle stays paused until resumed, without
+			// the idle time
+			if ev == session.EventCompleted || ev == session.EventIdle {
+				s.mu.Lock()
+				if s.sess == sess {
+					if ev == session.EventIdle {
+						sess.watcher.Pause()
+					}
+					s.save()
+				}
+				s.mu.Unlock()
+			}
+		case commit := <-sess.watcher.Commits():
+			s.mu.Lock()
+			if !containsCommit(sess.commits, commit) {
+				sess.commits = append(sess.commits, commit)
+			}
+			s.mu.Unlock()
+		case <-sess.watcher.Errors():
+			// Not being in a git repository only means there are no commits to show
+		case <-sess.stopCh:
+			return
+		}
+	}
+}
+
+func (s *Server) pause() (*Status, error) {
+	if s.sess == nil {
+		return nil, errNoSession
+	}
+	if s.sess.timeUp() {
+		return nil, errTimeUp
+	}
+	s.sess.runner.Pause()
+	s.sess.watcher.Pause()
+	s.save()
+	return s.status()
+}
+
+func (s *Server) resume() (*Status, error) {
+	if s.sess == nil {
+		return nil, errNoSession
+	}
+	if s.sess.timeUp() {
+		return nil, errTimeUp
+	}
+	s.sess.runner.Resume()
+	_ = s.sess.watcher.Resume()
+	s.save()
+	return s.status()
+}
+
+// complete checks off the task in its markdown file, records the session in the
+// history and removes its state.
+func (s *Server) complete() (*Status, error) {
+	sess := s.sess
+	if sess == nil {
+		return nil, errNoSession
+	}
+
+	now := time.Now()
+	sess.runner.Complete()
+	s.closeSegment(now)
+	s.end()
+
+	commits, _ := gitwatcher.CommitsDuring(s.Config.Commits, sess.repos, sess.tbState)
+	gitutil.FlagScope(commits, sess.task.Scope)
+	gitutil.LinkCommits(commits, sess.repos)
+	sess.commits = commits
+
+	updatedTask := sess.task
+	updatedTask.IsChecked = true
+	diff := gitutil.SessionDiff(commits, sess.repos, s.Config.Scope.UncommittedScope(sess.task.Scope), gitwatcher.SessionBaseline(sess.tbState))
+	annotation, err := s.Config.LoadAnnotation()
+	if err == nil {
+		err = annotation.UpdateMarkdown(sess.file, s.Backups, updatedTask, commits, sess.tbState.Segments, diff)
+	}
+	if err != nil {
+		// Keep the time spent, so the task can be completed later
+		s.saveSession(sess)
+		return nil, fmt.Errorf("failed to update markdown file %s: %w", sess.file, err)
+	}
+
+	status := s.sessionStatus(sess, StateDone, now)
+	status.Diff = diff
+	if s.historyLog != nil {
+		if err := s.historyLog.Append(history.NewEntry(sess.file, updatedTask, sess.tbState.Segments, commits, diff, now)); err != nil {
+			// The state stays the only record of the session
+			s.saveSession(sess)
+			return status, fmt.Errorf("failed to record the session in the history, its state is kept: %w", err)
+		}
+	}
+	if _, err := core.RemoveState(s.stateMgr, sess.tbState.Key()); err != nil {
+		return status, fmt.Errorf("failed to save state: %w", err)
+	}
+	return status, nil
+}
+
+// abort stops the session without completing the task. The time spent so far is
+// kept, so starting the task again continues where it left off.
+func (s *Server) abort() (*Status, error) {
+	sess := s.sess
+	if sess == nil {
+		return nil, errNoSession
+	}
+
+	now := time.Now()
+	sess.runner.Stop()
+	s.closeSegment(now)
+	s.end()
+	s.saveSession(sess)
+	return s.sessionStatus(sess, StateAborted, now), nil
+}
+
+// end stops the background work of the active session and marks the server done.
+func (s *Server) end() {
+	s.sess.watcher.Stop()
+	close(s.sess.stopCh)
+	s.sess = nil
+	s.finish()
+}
+
+// closeSegment closes the open segment of the active session, if any.
+func (s *Server) closeSegment(now time.Time) {
+	runner := s.sess.runner
+	runner.Mutex.Lock()
+	defer runner.Mutex.Unlock()
+
+	segments := s.sess.tbState.Segments
+	if len(segments) > 0 && segments[len(segments)-1].End == nil {
+		segments[len(segments)-1].End = &now
+	}
+}
+
+// beat records that the active session is still running, unless it is paused here or
+// elsewhere, so that it isn't taken for a session left over by a killed gobox.
+func (s *Server) beat(now time.Time) {
+	runner := s.sess.runner
+	runner.Mutex.Lock()
+	running := s.sess.tbState.IsActive()
+	if running {
+		s.sess.tbState.Beat(now)
+	}
+	key := s.sess.tbState.Key()
+	runner.Mutex.Unlock()
+	if running {
+		_, _ = core.BeatState(s.stateMgr, key, now)
+	}
+}
+
+// save persists the state of the active session.
+func (s *Server) save() {
+	s.saveSession(s.sess)
+}
+
+// saveSession persists the state of sess, keeping the states of other sessions as they
+// were saved. The runner lock keeps its segments from changing meanwhile.
+func (s *Server) saveSession(sess *activeSession) {
+	if sess == nil {
+		return
+	}
+	sess.runner.Mutex.Lock()
+	defer sess.runner.Mutex.Unlock()
+	_, _ = core.SaveState(s.stateMgr, *sess.tbState)
+}
+
+func (s *Server) status() (*Status, error) {
+	if s.sess == nil {
+		return nil, errNoSession
+	}
+
+	runner := s.sess.runner
+	runner.Mutex.Lock()
+	paused, completed := runner.Paused, runner.Completed
+	runner.Mutex.Unlock()
+
+	st := StateRunning
+	switch {
+	case completed:
+		st = StateTimeUp
+	case paused:
+		st = StatePaused
+	}
+	return s.sessionStatus(s.sess, st, time.Now()), nil
+}
+
+func (s *Server) sessionStatus(sess *activeSession, st string, now time.Time) *Status {
+	sess.runner.Mutex.Lock()
+	segments := append([]state.TimeSegment(nil), sess.tbState.Segments...)
+	sess.runner.Mutex.Unlock()
+
+	status := &Status{
+		State:       st,
+		File:        sess.file,
+		TaskID:      sess.task.Key(),
+		Description: sess.task.Description,
+		TimeBox:     sess.task.TimeBox,
+		Scope:       sess.task.Scope,
+		Elapsed:     state.Elapsed(segments, now),
+		Commits:     append([]gitutil.Commit(nil), sess.commits...),
+		Diff:        gitutil.Summarize(sess.commits),
+	}
+	if len(segments) > 0 {
+		status.StartedAt = segments[0].Start
+	}
+	if idle := sess.runner.Idle(); idle != nil {
+		status.IdleSince = &idle.Since
+	}
+
+	if sess.runner.Duration > 0 {
+		status.Remaining = sess.runner.Duration - status.Elapsed
+	} else if !sess.runner.EndTime.IsZero() {
+		status.Remaining = sess.runner.EndTime.Sub(now)
+	}
+	if status.Remaining < 0 || st == StateDone || st == StateAborted {
+		status.Remaining = 0
+	}
+	return status
+}
+
+// selectTask finds the task to start. An empty query selects the next unchecked task
+// with a timebox and no open subtasks; otherwise the query is matched against task IDs
+// first and then, case-insensitively, against descriptions of unchecked tasks.
+func selectTask(tasks []task.Task, query string) (task.Task, error) {
+	if query == "" {
+		for _, t := range tasks {
+			if !t.IsChecked && t.TimeBox != "" && !hasOpenSubtasks(t) {
+				return t, nil
+			}
+		}
+		return task.Task{}, errors.New("no unchecked task with a timebox found")
+	}
+
+	for _, t := range tasks {
+		if t.ID == query {
+			if t.IsChecked {
+				return task.Task{}, fmt.Errorf("task %q is already done", t.Description)
+			}
+			return t, nil
+		}
+	}
+
+	var matches []task.Task
+	for _, t := range tasks {
+		if !t.IsChecked && strings.Contains(strings.ToLower(t.Description), strings.ToLower(query)) {
+			matches = append(matches, t)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return task.Task{}, fmt.Errorf("no unchecked task matches %q", query)
+	case 1:
+		return matches[0], nil
+	default:
+		return task.Task{}, fmt.Errorf("%d tasks match %q, be more specific or use the task ID", len(matches), query)
+	}
+}
+
+func hasOpenSubtasks(t task.Task) bool {
+	for _, child := range t.Children {
+		if !child.IsChecked {
+			return true
+		}
+	}
+	return false
+}
+
+func containsCommit(commits []gitutil.Commit, commit gitutil.Commit) bool {
+	return slices.ContainsFunc(commits, func(c gitutil.Commit) bool {
+		return c.Hash == commit.Hash
+	})
+}
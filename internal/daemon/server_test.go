@@ -0,0 +1,261 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gobox/internal/backup"
+	"gobox/internal/core"
+	"gobox/internal/gitutil"
+	"gobox/internal/history"
+	"gobox/internal/state"
+)
+
+// noCommits is a git runner that never reports any commits.
+type noCommits struct{}
+
+func (noCommits) CombinedOutput(ctx context.Context, name string, arg ...string) ([]byte, error) {
+	return nil, nil
+}
+
+func newTestServer(t *testing.T, content string) (*Server, string, *core.InMemoryStateStore, *history.InMemoryLog) {
+	t.Helper()
+	gitutil.SetRunner(noCommits{})
+	gitutil.SetInProcess(false)
+	t.Cleanup(func() {
+		gitutil.SetRunner(gitutil.DefaultRunner{})
+		gitutil.SetInProcess(true)
+	})
+
+	file := filepath.Join(t.TempDir(), "tasks.md")
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store := core.NewInMemoryStateStore()
+	log := history.NewInMemoryLog()
+	srv := NewServer(store, log)
+	srv.PollInterval = time.Hour
+	return srv, file, store, log
+}
+
+func TestServerStartPauseDone(t *testing.T) {
+	srv, file, store, log := newTestServer(t, "- [ ] First @1h\n- [ ] Second @30m\n")
+
+	resp := srv.Handle(Request{Command: CmdStart, File: file, Task: "second"})
+	if resp.Error != "" {
+		t.Fatalf("start failed: %s", resp.Error)
+	}
+	if resp.Status.Description != "Second" || resp.Status.State != StateRunning {
+		t.Fatalf("unexpected status after start: %+v", resp.Status)
+	}
+
+	if resp := srv.Handle(Request{Command: CmdStart, File: file}); resp.Error == "" {
+		t.Error("expected starting a second session to fail")
+	}
+
+	resp = srv.Handle(Request{Command: CmdPause})
+	if resp.Error != "" || resp.Status.State != StatePaused {
+		t.Fatalf("unexpected pause response: %+v", resp)
+	}
+	states, _ := store.Load()
+	if len(states) != 1 || states[0].IsActive() {
+		t.Fatalf("expected one saved state with a closed segment, got %+v", states)
+	}
+
+	resp = srv.Handle(Request{Command: CmdResume})
+	if resp.Error != "" || resp.Status.State != StateRunning {
+		t.Fatalf("unexpected resume response: %+v", resp)
+	}
+
+	resp = srv.Handle(Request{Command: CmdDone})
+	if resp.Error != "" || resp.Status.State != StateDone {
+		t.Fatalf("unexpected done response: %+v", resp)
+	}
+
+	content, _ := os.ReadFile(file)
+	if !strings.Contains(string(content), "- [x] Second @30m") {
+		t.Errorf("expected task to be checked off, got:\n%s", content)
+	}
+	if states, _ := store.Load(); len(states) != 0 {
+		t.Errorf("expected state to be removed, got %+v", states)
+	}
+	entries, _ := log.Entries()
+	if len(entries) != 1 || len(entries[0].Segments) != 2 {
+		t.Errorf("expected one history entry with two segments, got %+v", entries)
+	}
+
+	select {
+	case <-srv.Done():
+	default:
+		t.Error("expected server to be done after the session ended")
+	}
+}
+
+// failingLog is a history log that can't be written.
+type failingLog struct{ history.InMemoryLog }
+
+func (*failingLog) Append(history.Entry) error {
+	return errors.New("disk full")
+}
+
+func TestServerDoneKeepsStateWhenHistoryFails(t *testing.T) {
+	srv, file, store, _ := newTestServer(t, "- [ ] Task @1h\n")
+	srv.historyLog = &failingLog{}
+
+	if resp := srv.Handle(Request{Command: CmdStart, File: file}); resp.Error != "" {
+		t.Fatalf("start failed: %s", resp.Error)
+	}
+	resp := srv.Handle(Request{Command: CmdDone})
+	if !strings.Contains(resp.Error, "disk full") {
+		t.Fatalf("expected the history error, got %+v", resp)
+	}
+	states, _ := store.Load()
+	if len(states) != 1 || states[0].IsActive() || len(states[0].Segments) != 1 {
+		t.Errorf("expected the closed session to be kept, got %+v", states)
+	}
+}
+
+func TestServerBacksUpTheTaskFile(t *testing.T) {
+	srv, file, _, _ := newTestServer(t, "- [ ] Task @1h\n")
+	srv.Backups = backup.NewStore(filepath.Join(t.TempDir(), "backups"))
+
+	if resp := srv.Handle(Request{Command: CmdStart, File: file}); resp.Error != "" {
+		t.Fatalf("start failed: %s", resp.Error)
+	}
+	if resp := srv.Handle(Request{Command: CmdDone}); resp.Error != "" {
+		t.Fatalf("done failed: %s", resp.Error)
+	}
+
+	// Both assigning the ID and completing the task can be undone
+	snaps, err := srv.Backups.List(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snaps) != 2 || snaps[0].Before != "- [ ] Task @1h\n" {
+		t.Errorf("expected two backups starting with the original file, got %+v", snaps)
+	}
+}
+
+func TestServerAbortKeepsTime(t *testing.T) {
+	srv, file, store, log := newTestServer(t, "- [ ] First @1h\n")
+
+	if resp := srv.Handle(Request{Command: CmdStart, File: file}); resp.Error != "" {
+		t.Fatalf("start failed: %s", resp.Error)
+	}
+	resp := srv.Handle(Request{Command: CmdAbort})
+	if resp.Error != "" || resp.Status.State != StateAborted {
+		t.Fatalf("unexpected abort response: %+v", resp)
+	}
+
+	states, _ := store.Load()
+	if len(states) != 1 || states[0].IsActive() {
+		t.Errorf("expected the aborted session's state to be kept closed, got %+v", states)
+	}
+	if entries, _ := log.Entries(); len(entries) != 0 {
+		t.Errorf("expected no history entry for an aborted session, got %d", len(entries))
+	}
+	content, _ := os.ReadFile(file)
+	if strings.Contains(string(content), "[x]") {
+		t.Errorf("expected task to stay unchecked, got:\n%s", content)
+	}
+}
+
+func TestServerStartErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		task    string
+	}{
+		{"no tasks", "- [x] Done @1h\n", ""},
+		{"no match", "- [ ] First @1h\n", "missing"},
+		{"ambiguous", "- [ ] Fix a @1h\n- [ ] Fix b @1h\n", "fix"},
+		{"no timebox", "- [ ] Plain task\n", "plain"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, file, _, _ := newTestServer(t, tt.content)
+			if resp := srv.Handle(Request{Command: CmdStart, File: file, Task: tt.task}); resp.Error == "" {
+				t.Fatalf("expected start to fail, got %+v", resp.Status)
+			}
+			select {
+			case <-srv.Done():
+			default:
+				t.Error("expected server to be done when nothing was started")
+			}
+		})
+	}
+}
+
+func TestServeOverSocket(t *testing.T) {
+	srv, file, _, _ := newTestServer(t, "- [ ] First @1h\n")
+
+	socket := filepath.Join(t.TempDir(), "gobox.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("unix sockets not available: %v", err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(l) }()
+
+	if _, err := Send(socket, Request{Command: CmdStatus}); err == nil {
+		t.Error("expected status without a session to fail")
+	}
+	st, err := Send(socket, Request{Command: CmdStart, File: file})
+	if err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	if st.Description != "First" {
+		t.Errorf("unexpected task %q", st.Description)
+	}
+	if _, err := Send(socket, Request{Command: CmdAbort}); err != nil {
+		t.Fatalf("abort failed: %v", err)
+	}
+
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Serve returned %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected Serve to return after the session ended")
+	}
+	if _, err := Send(socket, Request{Command: CmdStatus}); err != ErrNotRunning {
+		t.Errorf("expected ErrNotRunning after shutdown, got %v", err)
+	}
+}
+
+func TestServerStartWithSessionRunningElsewhere(t *testing.T) {
+	srv, file, store, _ := newTestServer(t, "- [ ] First @1h\n")
+	started := time.Now().Add(-10 * time.Minute)
+	store.Save([]state.TimeBoxState{{
+		TaskID:      "other",
+		TaskHash:    "other",
+		Description: "Other task",
+		Segments:    []state.TimeSegment{{Start: started}},
+	}})
+
+	resp := srv.Handle(Request{Command: CmdStart, File: file})
+	if !strings.Contains(resp.Error, `"Other task"`) || !strings.Contains(resp.Error, "--pause-others") {
+		t.Fatalf("expected the start to be refused, got %+v", resp)
+	}
+
+	srv, _, _, _ = newTestServer(t, "")
+	srv.stateMgr = store
+	resp = srv.Handle(Request{Command: CmdStart, File: file, PauseOthers: true})
+	if resp.Error != "" {
+		t.Fatalf("start failed: %s", resp.Error)
+	}
+	defer srv.Handle(Request{Command: CmdAbort})
+
+	states, _ := store.Load()
+	if len(states) != 2 || states[0].IsActive() || !states[0].IsPaused() || !states[1].IsActive() {
+		t.Errorf("expected the other session paused and the new one running, got %+v", states)
+	}
+}
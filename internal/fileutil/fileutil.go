@@ -0,0 +1,111 @@
+// Package fileutil writes files atomically and detects files changed by someone else
+// between reading and writing them.
+package fileutil
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrChanged is returned by WriteFileIfUnchanged when the file no longer has the
+// version it was read at.
+var ErrChanged = errors.New("file changed since it was read")
+
+// Version identifies the content of a file when it was read.
+type Version struct {
+	ModTime time.Time
+	Size    int64
+	Hash    [sha256.Size]byte
+}
+
+// ReadFile reads the file at path and returns its content and version.
+func ReadFile(path string) ([]byte, Version, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, Version{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, Version{}, err
+	}
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(f); err != nil {
+		return nil, Version{}, err
+	}
+	content := buf.Bytes()
+	return content, Version{ModTime: info.ModTime(), Size: info.Size(), Hash: sha256.Sum256(content)}, nil
+}
+
+// Changed reports whether the file at path differs from version v. A file that was
+// saved again without changes, moving only its modification time, is unchanged.
+func (v Version) Changed(path string) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	if info.Size() != v.Size {
+		return true, nil
+	}
+	if info.ModTime().Equal(v.ModTime) {
+		return false, nil
+	}
+	_, current, err := ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	return current.Hash != v.Hash, nil
+}
+
+// WriteFile writes data to the file at path atomically: it writes a temporary file in
+// the same directory and renames it over path, so readers and crashes never see a
+// partly written file. An existing file keeps its permissions, a new one gets perm.
+// Symbolic links are followed, so the file they point to is replaced.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// WriteFileIfUnchanged is WriteFile for a file that was read at version v. It returns
+// ErrChanged without writing if the file has changed since.
+func WriteFileIfUnchanged(path string, data []byte, perm os.FileMode, v Version) error {
+	changed, err := v.Changed(path)
+	if err != nil {
+		return err
+	}
+	if changed {
+		return ErrChanged
+	}
+	return WriteFile(path, data, perm)
+}
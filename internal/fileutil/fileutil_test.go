@@ -0,0 +1,101 @@
+package fileutil_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gobox/internal/fileutil"
+)
+
+func TestWriteFileKeepsPermissions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.md")
+	if err := os.WriteFile(path, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := fileutil.WriteFile(path, []byte("new"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "new" {
+		t.Errorf("content = %q, want %q", content, "new")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("permissions = %v, want %v", info.Mode().Perm(), os.FileMode(0600))
+	}
+
+	// No temporary files are left behind
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("directory has %d entries, want 1", len(entries))
+	}
+}
+
+func TestWriteFileFollowsSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "real.md")
+	link := filepath.Join(dir, "tasks.md")
+	if err := os.WriteFile(target, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	if err := fileutil.WriteFile(link, []byte("new"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("link was replaced by a regular file")
+	}
+	if content, _ := os.ReadFile(target); string(content) != "new" {
+		t.Errorf("target content = %q, want %q", content, "new")
+	}
+}
+
+func TestWriteFileIfUnchanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.md")
+	if err := os.WriteFile(path, []byte("- [ ] Task @1h\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, version, err := fileutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Saving the same content again only moves the modification time
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := version.Changed(path); err != nil || changed {
+		t.Errorf("Changed() = %v, %v after touching the file, want false", changed, err)
+	}
+
+	// Same size, different content
+	if err := os.WriteFile(path, []byte("- [ ] Task @2h\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := version.Changed(path); err != nil || !changed {
+		t.Errorf("Changed() = %v, %v after editing the file, want true", changed, err)
+	}
+	err = fileutil.WriteFileIfUnchanged(path, []byte("- [x] Task @1h\n"), 0644, version)
+	if !errors.Is(err, fileutil.ErrChanged) {
+		t.Fatalf("WriteFileIfUnchanged() = %v, want ErrChanged", err)
+	}
+	if content, _ := os.ReadFile(path); string(content) != "- [ ] Task @2h\n" {
+		t.Errorf("edited file was overwritten: %q", content)
+	}
+}
@@ -0,0 +1,26 @@
+package fileutil
+
+import "os"
+
+// Lock takes an exclusive advisory lock on the file at path, creating the file if
+// needed, and waits for other processes holding it to release it. Calling the returned
+// function releases the lock. The lock only keeps out processes that take it too, and
+// it is taken on a file of its own: files written with WriteFile are replaced on every
+// write, so a lock on them would not outlive the next write.
+func Lock(path string) (unlock func() error, err error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() error {
+		unlockErr := unlockFile(f)
+		if err := f.Close(); err != nil {
+			return err
+		}
+		return unlockErr
+	}, nil
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package fileutil
+
+import "os"
+
+// lockFile is a no-op on platforms without flock, where concurrent processes are not
+// kept apart.
+func lockFile(f *os.File) error { return nil }
+
+func unlockFile(f *os.File) error { return nil }
@@ -0,0 +1,44 @@
+package gitutil
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// LastModified returns when a file in the working tree of repo was last changed, going
+// by the files that are modified or untracked, and when HEAD last moved, e.g. by a
+// commit. It returns the zero time if neither is known.
+func LastModified(repo Repo) (time.Time, error) {
+	reader := CommandReader{Runner: runner}
+	if repo.Path != "." {
+		reader.Dir = repo.Path
+	}
+	top, err := reader.git("rev-parse", "--show-toplevel")
+	if err != nil {
+		return time.Time{}, err
+	}
+	top = strings.TrimSpace(top)
+	files, err := reader.git("ls-files", "--modified", "--others", "--exclude-standard", "--full-name", ":/")
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var last time.Time
+	seen := func(path string) {
+		if info, err := os.Stat(path); err == nil && info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+	}
+	for _, f := range strings.Split(files, "\n") {
+		// Deleted files are listed as modified too, but have nothing to stat
+		if f = strings.TrimSpace(f); f != "" {
+			seen(filepath.Join(top, filepath.FromSlash(f)))
+		}
+	}
+	if gitDir, _, err := GitDir(top); err == nil {
+		seen(filepath.Join(gitDir, "logs", "HEAD"))
+	}
+	return last, nil
+}
@@ -0,0 +1,59 @@
+package gitutil_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gobox/internal/gitutil"
+)
+
+func TestLastModified(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	run := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, out)
+		}
+	}
+	run("init", "-q")
+	run("config", "user.email", "jane@example.com")
+	run("config", "user.name", "Jane")
+	main := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(main, []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run("add", "main.go")
+	run("commit", "-q", "-m", "Initial commit")
+
+	repo, err := gitutil.NewRepo(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	committed, err := gitutil.LastModified(repo)
+	if err != nil {
+		t.Fatalf("LastModified failed: %v", err)
+	}
+	if committed.IsZero() {
+		t.Fatal("expected the commit to count as a change")
+	}
+
+	// Changing a tracked file counts as of the change
+	edited := committed.Add(time.Hour)
+	if err := os.WriteFile(main, []byte("package main\n\nfunc main() {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(main, edited, edited); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := gitutil.LastModified(repo); err != nil || !got.Equal(edited) {
+		t.Errorf("expected the edit at %v, got %v, %v", edited, got, err)
+	}
+}
@@ -0,0 +1,199 @@
+package gitutil
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// BranchScope selects the branches whose commits are credited to a session.
+type BranchScope string
+
+const (
+	// BranchAny credits commits reachable from HEAD, whichever branch is checked out.
+	BranchAny BranchScope = "any"
+	// BranchCurrent credits only commits on the branch the session started on.
+	BranchCurrent BranchScope = "current"
+	// BranchSinceStart credits only commits added to the session's branch after it
+	// started, leaving out everything that was already reachable from it.
+	BranchSinceStart BranchScope = "since-start"
+)
+
+// DateField selects the commit date compared with the session's time range.
+type DateField string
+
+const (
+	// DateAuthor uses the author date, which survives rebases and cherry-picks.
+	DateAuthor DateField = "author"
+	// DateCommitter uses the committer date, like git log --since.
+	DateCommitter DateField = "committer"
+)
+
+// AnyAuthor in Attribution.Authors credits commits by anyone.
+const AnyAuthor = "*"
+
+// Attribution holds the rules deciding which commits are credited to a session.
+// The zero value credits non-merge commits by anyone on any branch, by author date;
+// Resolve fills in the configured user as the author.
+type Attribution struct {
+	Authors       []string    `json:"authors,omitempty"`        // Author emails; empty means user.email
+	Branch        BranchScope `json:"branch,omitempty"`         // Defaults to BranchAny
+	IncludeMerges bool        `json:"include_merges,omitempty"` // Merge commits are left out unless set
+	Date          DateField   `json:"date,omitempty"`           // Defaults to DateAuthor
+}
+
+// SessionRef records where HEAD was when a session started.
+type SessionRef struct {
+	Branch string // Checked out branch, empty if HEAD was detached
+	Base   string // Commit HEAD pointed to, empty if there were no commits yet
+}
+
+// CurrentRef returns the SessionRef in repo for a session starting now.
+func CurrentRef(repo Repo) (SessionRef, error) {
+	branch, hash, err := repo.Reader().Head()
+	if err != nil {
+		return SessionRef{}, err
+	}
+	return SessionRef{Branch: branch, Base: hash}, nil
+}
+
+// Validate checks the branch scope and date field.
+func (a Attribution) Validate() error {
+	switch a.Branch {
+	case "", BranchAny, BranchCurrent, BranchSinceStart:
+	default:
+		return fmt.Errorf("unknown branch scope %q, use %s, %s or %s", a.Branch, BranchAny, BranchCurrent, BranchSinceStart)
+	}
+	switch a.Date {
+	case "", DateAuthor, DateCommitter:
+	default:
+		return fmt.Errorf("unknown commit date %q, use %s or %s", a.Date, DateAuthor, DateCommitter)
+	}
+	return nil
+}
+
+// Resolve returns the rules for repo with the defaults filled in, looking up its
+// user.email if no authors are configured. Without a configured email commits by
+// anyone are credited.
+func (a Attribution) Resolve(repo Repo) Attribution {
+	if a.Branch == "" {
+		a.Branch = BranchAny
+	}
+	if a.Date == "" {
+		a.Date = DateAuthor
+	}
+	if len(a.Authors) == 0 {
+		if email, err := repo.Reader().UserEmail(); err == nil && email != "" {
+			a.Authors = []string{email}
+		}
+	}
+	return a
+}
+
+// Match reports whether a commit made on or after since is credited to the session of
+// task started at ref. A commit tagged with a TaskTrailer belongs to the tagged task on
+// any branch. Match can't tell whether a commit was reachable before the session
+// started, so BranchSinceStart is checked like BranchCurrent.
+func (a Attribution) Match(c Commit, task string, since time.Time, ref SessionRef) bool {
+	if c.IsMerge() && !a.IncludeMerges || !a.matchesAuthor(c) {
+		return false
+	}
+	if a.date(c).Before(since) {
+		return false
+	}
+	if c.Task != "" {
+		return c.Task == task
+	}
+	if a.Branch == BranchCurrent || a.Branch == BranchSinceStart {
+		return c.Branch == ref.Branch
+	}
+	return true
+}
+
+// Commits returns the commits in repo credited to the session of task started at ref
+// that were made between since and until, newest first. A zero until means up to now.
+// Commits tagged for another task are left out.
+func (a Attribution) Commits(repo Repo, ref SessionRef, task string, since, until time.Time) ([]Commit, error) {
+	return a.commits(repo, ref, task, since, until, nil)
+}
+
+// NewCommits returns the commits in repo credited to the session of task started at ref
+// that were made since the given time, like Commits, leaving out those seen reports as
+// known already before their changes are looked up.
+func (a Attribution) NewCommits(repo Repo, ref SessionRef, task string, since time.Time, seen func(hash string) bool) ([]Commit, error) {
+	return a.commits(repo, ref, task, since, time.Time{}, seen)
+}
+
+func (a Attribution) commits(repo Repo, ref SessionRef, task string, since, until time.Time, seen func(hash string) bool) ([]Commit, error) {
+	opts := LogOptions{Since: since, Until: until}
+	if a.Date != DateCommitter {
+		// Rebased commits are committed after they were written, so the committer
+		// date only bounds the range from below
+		opts.Until = time.Time{}
+	}
+	switch a.Branch {
+	case BranchCurrent:
+		opts.From = ref.Branch
+	case BranchSinceStart:
+		opts.From = ref.Branch
+		opts.Base = ref.Base
+	}
+
+	return a.log(repo, opts, func(c Commit) bool {
+		if c.Task != "" && c.Task != task || seen != nil && seen(c.Hash) {
+			return false
+		}
+		when := a.date(c)
+		return !when.Before(since) && (until.IsZero() || !when.After(until))
+	})
+}
+
+// Tagged returns the commits in repo tagged for task that were committed since the
+// given time, on the checked out branch, newest first. They belong to the task even if
+// they were made outside its sessions, e.g. right after it was completed.
+func (a Attribution) Tagged(repo Repo, task string, since time.Time) ([]Commit, error) {
+	if task == "" {
+		return []Commit{}, nil
+	}
+	return a.log(repo, LogOptions{Since: since}, func(c Commit) bool {
+		return c.Task == task
+	})
+}
+
+// log returns the commits selected by opts that pass the merge and author rules and keep,
+// labelled with the repository's name.
+func (a Attribution) log(repo Repo, opts LogOptions, keep func(Commit) bool) ([]Commit, error) {
+	opts.Keep = func(c Commit) bool {
+		return (!c.IsMerge() || a.IncludeMerges) && a.matchesAuthor(c) && keep(c)
+	}
+	commits, err := repo.Reader().Log(opts)
+	if err != nil {
+		return nil, err
+	}
+	if commits == nil {
+		commits = []Commit{}
+	}
+	for i := range commits {
+		commits[i].Repo = repo.Name
+	}
+	return commits, nil
+}
+
+func (a Attribution) matchesAuthor(c Commit) bool {
+	if len(a.Authors) == 0 {
+		return true
+	}
+	for _, author := range a.Authors {
+		if author == AnyAuthor || strings.EqualFold(author, c.Email) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a Attribution) date(c Commit) time.Time {
+	if a.Date == DateCommitter {
+		return c.CommitterTime
+	}
+	return c.AuthorTime
+}
@@ -0,0 +1,148 @@
+package gitutil_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	"gobox/internal/gitutil"
+)
+
+func TestAttribution(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+
+	base := time.Now().Add(-2 * time.Hour).Truncate(time.Second)
+	commit := func(email, msg string, authored, committed time.Time, parents ...plumbing.Hash) plumbing.Hash {
+		t.Helper()
+		file := filepath.Join(dir, "file.txt")
+		if err := os.WriteFile(file, []byte(msg), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := wt.Add("file.txt"); err != nil {
+			t.Fatal(err)
+		}
+		opts := &git.CommitOptions{
+			Author:    &object.Signature{Name: email, Email: email, When: authored},
+			Committer: &object.Signature{Name: email, Email: email, When: committed},
+		}
+		if len(parents) > 0 {
+			head, err := repo.Head()
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts.Parents = append([]plumbing.Hash{head.Hash()}, parents...)
+		}
+		hash, err := wt.Commit(msg, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+
+	at := func(minutes int) time.Time { return base.Add(time.Duration(minutes) * time.Minute) }
+	initial := commit("jane@example.com", "Initial commit", at(0), at(0))
+	start := at(30)
+	commit("bob@example.com", "Teammate's commit", at(40), at(40))
+	mine := commit("Jane@Example.com", "My commit", at(50), at(50))
+	commit("jane@example.com", "For another task\n\nGobox-Task: other", at(55), at(55))
+	commit("jane@example.com", "Merge", at(60), at(60), initial)
+	commit("jane@example.com", "Rebased commit", at(20), at(70))
+	commit("jane@example.com", "After the session\n\nGobox-Task: task-1", at(100), at(100))
+
+	subjects := func(rules gitutil.Attribution, ref gitutil.SessionRef) []string {
+		t.Helper()
+		commits, err := rules.Commits(gitutil.CurrentRepo, ref, "", start, at(90))
+		if err != nil {
+			t.Fatalf("Commits failed: %v", err)
+		}
+		var subjects []string
+		for _, c := range commits {
+			subjects = append(subjects, c.Subject)
+		}
+		return subjects
+	}
+
+	jane := gitutil.Attribution{Authors: []string{"jane@example.com"}}.Resolve(gitutil.CurrentRepo)
+	ref := gitutil.SessionRef{Branch: "master", Base: initial.String()}
+	tests := []struct {
+		name  string
+		rules gitutil.Attribution
+		ref   gitutil.SessionRef
+		want  []string
+	}{
+		{"author date", jane, ref, []string{"My commit"}},
+		{"committer date", withDate(jane, gitutil.DateCommitter), ref, []string{"Rebased commit", "My commit"}},
+		{"merges", withMerges(jane), ref, []string{"Merge", "My commit"}},
+		{"anyone", gitutil.Attribution{Authors: []string{gitutil.AnyAuthor}}.Resolve(gitutil.CurrentRepo), ref, []string{"My commit", "Teammate's commit"}},
+		{"since start", gitutil.Attribution{Authors: jane.Authors, Branch: gitutil.BranchSinceStart, Date: gitutil.DateCommitter},
+			gitutil.SessionRef{Branch: "master", Base: mine.String()}, []string{"Rebased commit"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := subjects(tt.rules, tt.ref)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("got %q, want %q", got, tt.want)
+				}
+			}
+		})
+	}
+
+	// Commits tagged for the task count whenever they were made
+	tagged, err := jane.Tagged(gitutil.CurrentRepo, "task-1", start)
+	if err != nil {
+		t.Fatalf("Tagged failed: %v", err)
+	}
+	if len(tagged) != 1 || tagged[0].Subject != "After the session" || tagged[0].Task != "task-1" {
+		t.Errorf("expected the tagged commit, got %v", tagged)
+	}
+
+	// The watcher checks single commits against the same rules
+	c, err := gitutil.GetCommit(mine.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !jane.Match(c, "", start, ref) {
+		t.Errorf("expected %q to match", c.Subject)
+	}
+	if jane.Match(c, "", at(55), ref) {
+		t.Errorf("expected %q made before the session to be left out", c.Subject)
+	}
+	onBranch := gitutil.Attribution{Authors: jane.Authors, Branch: gitutil.BranchCurrent}
+	if onBranch.Match(c, "", start, gitutil.SessionRef{Branch: "feature"}) {
+		t.Errorf("expected %q on another branch to be left out", c.Subject)
+	}
+	if !onBranch.Match(tagged[0], "task-1", start, gitutil.SessionRef{Branch: "feature"}) {
+		t.Errorf("expected the commit tagged for the task to match on any branch")
+	}
+	if onBranch.Match(tagged[0], "task-2", start, gitutil.SessionRef{Branch: "master"}) {
+		t.Errorf("expected the commit tagged for another task to be left out")
+	}
+}
+
+func withDate(a gitutil.Attribution, date gitutil.DateField) gitutil.Attribution {
+	a.Date = date
+	return a
+}
+
+func withMerges(a gitutil.Attribution) gitutil.Attribution {
+	a.IncludeMerges = true
+	return a
+}
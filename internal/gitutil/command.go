@@ -0,0 +1,174 @@
+package gitutil
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Field and record separators in the git log output, chosen so they can't clash with commit subjects.
+const (
+	fieldSep  = "\x1f"
+	recordSep = "\x1e"
+)
+
+// logFormat prints one header line per commit, followed by its --numstat lines.
+var logFormat = "--pretty=format:" + recordSep + strings.Join([]string{
+	"%H", "%h", "%an", "%ae", "%aI", "%cI", "%P",
+	"%(trailers:key=" + TaskTrailer + ",valueonly,separator=%x2C)",
+	"%s",
+}, fieldSep)
+
+// CommandReader implements Reader by running the git command through a CommandRunner.
+type CommandReader struct {
+	Runner CommandRunner
+	Dir    string // Directory to run git in; the working directory if empty
+}
+
+func (r CommandReader) Log(opts LogOptions) ([]Commit, error) {
+	args := []string{"log", "--since", opts.Since.Format(time.RFC3339)}
+	if !opts.Until.IsZero() {
+		args = append(args, "--until", opts.Until.Format(time.RFC3339))
+	}
+	args = append(args, "--no-color", "--numstat", logFormat)
+	if opts.From != "" {
+		args = append(args, opts.From)
+	}
+	if opts.Base != "" {
+		if opts.From == "" {
+			args = append(args, "HEAD")
+		}
+		args = append(args, "^"+opts.Base)
+	}
+	if opts.From != "" || opts.Base != "" {
+		args = append(args, "--")
+	}
+
+	output, err := r.git(args...)
+	if err != nil {
+		return nil, err
+	}
+	commits, err := parseLog(output)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Keep != nil {
+		commits = slices.DeleteFunc(commits, func(c Commit) bool { return !opts.Keep(c) })
+	}
+	if len(commits) == 0 {
+		return commits, nil
+	}
+
+	branch := r.branch()
+	for i := range commits {
+		commits[i].Branch = branch
+	}
+	return commits, nil
+}
+
+func (r CommandReader) Commit(hash string) (Commit, error) {
+	output, err := r.git("log", "-1", "--no-color", "--numstat", logFormat, hash, "--")
+	if err != nil {
+		return Commit{}, err
+	}
+	commits, err := parseLog(output)
+	if err != nil {
+		return Commit{}, err
+	}
+	if len(commits) == 0 {
+		return Commit{}, fmt.Errorf("commit %s not found", hash)
+	}
+
+	commits[0].Branch = r.branch()
+	return commits[0], nil
+}
+
+func (r CommandReader) Head() (string, string, error) {
+	hash, err := r.git("rev-parse", "-q", "--verify", "HEAD")
+	if err != nil {
+		// No commits yet
+		hash = ""
+	}
+	return r.branch(), strings.TrimSpace(hash), nil
+}
+
+func (r CommandReader) UserEmail() (string, error) {
+	// git config exits with 1 when the key isn't set
+	email, _ := r.git("config", "user.email")
+	return strings.TrimSpace(email), nil
+}
+
+// branch returns the checked out branch, or "" if HEAD is detached.
+func (r CommandReader) branch() string {
+	branch, _ := r.git("symbolic-ref", "--short", "-q", "HEAD")
+	return strings.TrimSpace(branch)
+}
+
+// git runs a git subcommand and returns its output.
+func (r CommandReader) git(args ...string) (string, error) {
+	gitArgs := args
+	if r.Dir != "" {
+		gitArgs = append([]string{"-C", r.Dir}, args...)
+	}
+	outputBytes, err := r.Runner.CombinedOutput(context.Background(), "git", gitArgs...)
+	output := string(outputBytes)
+	if strings.Contains(strings.ToLower(output), "not a git repository") {
+		return "", fmt.Errorf("not a git repository: %s", strings.TrimSpace(output))
+	}
+	if err != nil {
+		return "", fmt.Errorf("error running git %s: %w, output: %s", args[0], err, output)
+	}
+	return output, nil
+}
+
+// parseLog parses git log output produced with logFormat and --numstat.
+func parseLog(output string) ([]Commit, error) {
+	commits := []Commit{}
+	for _, record := range strings.Split(output, recordSep) {
+		if strings.TrimSpace(record) == "" {
+			continue
+		}
+		lines := strings.Split(record, "\n")
+		fields := strings.Split(lines[0], fieldSep)
+		if len(fields) != 9 {
+			return nil, fmt.Errorf("unexpected git log output: %q", lines[0])
+		}
+
+		c := Commit{
+			Hash:      fields[0],
+			ShortHash: fields[1],
+			Author:    fields[2],
+			Email:     fields[3],
+			Subject:   fields[8],
+			Parents:   strings.Fields(fields[6]),
+		}
+		if tasks := strings.Split(fields[7], ","); tasks[len(tasks)-1] != "" {
+			c.Task = strings.TrimSpace(tasks[len(tasks)-1])
+		}
+		var err error
+		if c.AuthorTime, err = time.Parse(time.RFC3339, fields[4]); err != nil {
+			return nil, fmt.Errorf("invalid author date in git log output: %w", err)
+		}
+		if c.CommitterTime, err = time.Parse(time.RFC3339, fields[5]); err != nil {
+			return nil, fmt.Errorf("invalid committer date in git log output: %w", err)
+		}
+
+		for _, line := range lines[1:] {
+			// --numstat lines are "<added>\t<deleted>\t<path>", with "-" counts for binary files
+			parts := strings.SplitN(line, "\t", 3)
+			if len(parts) != 3 {
+				continue
+			}
+			added, _ := strconv.Atoi(parts[0])
+			deleted, _ := strconv.Atoi(parts[1])
+			c.Insertions += added
+			c.Deletions += deleted
+			c.Files = append(c.Files, parts[2])
+		}
+		commits = append(commits, c)
+	}
+	return commits, nil
+}
@@ -0,0 +1,302 @@
+package gitutil
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// topDirDepth is how many leading path components identify a directory in TopDirs.
+const topDirDepth = 2
+
+// maxTopDirs is how many directories DiffSummary.TopDirs lists.
+const maxTopDirs = 3
+
+// DiffSummary sums up how much changed during a session.
+type DiffSummary struct {
+	Files       []string     `json:"files"`                  // Paths changed, sorted; prefixed with the repository name for several repositories
+	Insertions  int          `json:"insertions"`             // Lines added
+	Deletions   int          `json:"deletions"`              // Lines removed
+	TopDirs     []DirChange  `json:"top_dirs,omitempty"`     // Directories with the most changed files, most first
+	Uncommitted *DiffSummary `json:"uncommitted,omitempty"`  // Changes left in the working trees, nil if they were clean
+	OutOfScope  []string     `json:"out_of_scope,omitempty"` // Uncommitted files outside the scope passed to SessionDiff
+}
+
+// DirChange counts the files changed in a directory.
+type DirChange struct {
+	Dir   string `json:"dir"`
+	Files int    `json:"files"`
+}
+
+// Summarize sums up the changes made by commits. Paths are prefixed with the repository
+// name if the commits come from several repositories.
+func Summarize(commits []Commit) DiffSummary {
+	repos := make(map[string]bool)
+	for _, c := range commits {
+		repos[c.Repo] = true
+	}
+	return summarize(commits, len(repos) > 1)
+}
+
+func summarize(commits []Commit, prefixRepo bool) DiffSummary {
+	var d DiffSummary
+	files := make(map[string]bool)
+	for _, c := range commits {
+		d.Insertions += c.Insertions
+		d.Deletions += c.Deletions
+		for _, f := range c.Files {
+			if prefixRepo {
+				f = c.Repo + "/" + f
+			}
+			files[f] = true
+		}
+	}
+	d.setFiles(files)
+	return d
+}
+
+// IsEmpty reports whether nothing changed, committed or not.
+func (d DiffSummary) IsEmpty() bool {
+	return len(d.Files) == 0 && d.Uncommitted == nil
+}
+
+// String returns the summary in the compact form "+120/-34 in 7 files", followed by
+// the uncommitted changes if there are any.
+func (d DiffSummary) String() string {
+	s := d.stat()
+	if d.Uncommitted != nil {
+		s += ", " + d.Uncommitted.stat() + " uncommitted"
+	}
+	return s
+}
+
+func (d DiffSummary) stat() string {
+	files := "files"
+	if len(d.Files) == 1 {
+		files = "file"
+	}
+	return fmt.Sprintf("+%d/-%d in %d %s", d.Insertions, d.Deletions, len(d.Files), files)
+}
+
+// DirNames returns the names of the TopDirs.
+func (d DiffSummary) DirNames() []string {
+	names := make([]string, len(d.TopDirs))
+	for i, dir := range d.TopDirs {
+		names[i] = dir.Dir
+	}
+	return names
+}
+
+// setFiles sets Files and TopDirs from the set of changed paths.
+func (d *DiffSummary) setFiles(files map[string]bool) {
+	d.Files = make([]string, 0, len(files))
+	dirs := make(map[string]int)
+	for f := range files {
+		d.Files = append(d.Files, f)
+		dirs[topDir(f)]++
+	}
+	sort.Strings(d.Files)
+
+	d.TopDirs = nil
+	for dir, n := range dirs {
+		d.TopDirs = append(d.TopDirs, DirChange{Dir: dir, Files: n})
+	}
+	sort.Slice(d.TopDirs, func(i, j int) bool {
+		if d.TopDirs[i].Files != d.TopDirs[j].Files {
+			return d.TopDirs[i].Files > d.TopDirs[j].Files
+		}
+		return d.TopDirs[i].Dir < d.TopDirs[j].Dir
+	})
+	if len(d.TopDirs) > maxTopDirs {
+		d.TopDirs = d.TopDirs[:maxTopDirs]
+	}
+}
+
+// topDir returns the leading directories of a file path, "." for files at the top.
+func topDir(file string) string {
+	dir := path.Dir(file)
+	if dir == "." {
+		return dir
+	}
+	parts := strings.Split(dir, "/")
+	if len(parts) > topDirDepth {
+		parts = parts[:topDirDepth]
+	}
+	return strings.Join(parts, "/")
+}
+
+// Uncommitted returns the changes in the working tree of repo that are not committed
+// yet, staged or not, including untracked files. It returns nil if the tree is clean.
+func Uncommitted(repo Repo) (*DiffSummary, error) {
+	return uncommitted(repo, "", nil, Baseline{})
+}
+
+// Baseline is what SessionDiff leaves out of the uncommitted changes of a session.
+type Baseline struct {
+	TaskFile string                       // Absolute path of the task file, which gobox changes itself
+	Dirty    map[string]map[string]string // Files changed before the session started by repository path, see DirtyFiles
+}
+
+// dirtyFile is a file with uncommitted changes.
+type dirtyFile struct {
+	added, deleted int
+	fingerprint    string // tells whether the file changed since, see DirtyFiles
+}
+
+// DirtyFiles returns the files with uncommitted changes in the working tree of repo by
+// their path from the top of the repository, each with a fingerprint of its changes: the
+// lines added and removed, or the size and modification time of an untracked file.
+func DirtyFiles(repo Repo) (map[string]string, error) {
+	_, files, err := dirtyFiles(repo)
+	if err != nil {
+		return nil, err
+	}
+	fingerprints := make(map[string]string, len(files))
+	for f, c := range files {
+		fingerprints[f] = c.fingerprint
+	}
+	return fingerprints, nil
+}
+
+// dirtyFiles returns the top of the working tree of repo and its files with uncommitted
+// changes.
+func dirtyFiles(repo Repo) (string, map[string]dirtyFile, error) {
+	reader := CommandReader{Runner: runner}
+	if repo.Path != "." {
+		reader.Dir = repo.Path
+	}
+	top, err := reader.git("rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", nil, err
+	}
+	top = strings.TrimSpace(top)
+
+	// Before the first commit there is no HEAD to compare with
+	base := "HEAD"
+	if _, err := reader.git("rev-parse", "-q", "--verify", "HEAD"); err != nil {
+		base = "--cached"
+	}
+	output, err := reader.git("diff", base, "--numstat", "--no-color", "--no-renames")
+	if err != nil {
+		return "", nil, err
+	}
+	untracked, err := reader.git("ls-files", "--others", "--exclude-standard", "--full-name", ":/")
+	if err != nil {
+		return "", nil, err
+	}
+
+	files := make(map[string]dirtyFile)
+	for _, line := range strings.Split(output, "\n") {
+		// "<added>\t<deleted>\t<path>", with "-" counts for binary files
+		parts := strings.SplitN(line, "\t", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		added, _ := strconv.Atoi(parts[0])
+		deleted, _ := strconv.Atoi(parts[1])
+		files[parts[2]] = dirtyFile{added: added, deleted: deleted, fingerprint: parts[0] + "/" + parts[1]}
+	}
+	for _, f := range strings.Split(untracked, "\n") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		fingerprint := "new"
+		if info, err := os.Stat(filepath.Join(top, filepath.FromSlash(f))); err == nil {
+			fingerprint = fmt.Sprintf("new %d %d", info.Size(), info.ModTime().UnixNano())
+		}
+		files[f] = dirtyFile{fingerprint: fingerprint}
+	}
+	return top, files, nil
+}
+
+// uncommitted is Uncommitted with prefix added to each path, leaving out the task file
+// and the files of base that haven't changed since. Files outside a non-empty scope are
+// listed in OutOfScope.
+func uncommitted(repo Repo, prefix string, scope Scope, base Baseline) (*DiffSummary, error) {
+	top, files, err := dirtyFiles(repo)
+	if err != nil {
+		return nil, err
+	}
+	before := base.Dirty[repo.Path]
+	taskFile := relPath(top, base.TaskFile)
+
+	d := &DiffSummary{}
+	changed := make(map[string]bool)
+	for f, c := range files {
+		if f == taskFile {
+			continue
+		}
+		if fingerprint, ok := before[f]; ok && fingerprint == c.fingerprint {
+			continue
+		}
+		d.Insertions += c.added
+		d.Deletions += c.deleted
+		changed[prefix+f] = true
+		if !scope.Contains(f) {
+			d.OutOfScope = append(d.OutOfScope, prefix+f)
+		}
+	}
+	sort.Strings(d.OutOfScope)
+	if len(changed) == 0 {
+		return nil, nil
+	}
+	d.setFiles(changed)
+	return d, nil
+}
+
+// relPath returns the slash separated path of file from top, or "" if file is empty or
+// not below top.
+func relPath(top, file string) string {
+	if file == "" {
+		return ""
+	}
+	// git reports the top with symlinks resolved
+	if dir, err := filepath.EvalSymlinks(filepath.Dir(file)); err == nil {
+		file = filepath.Join(dir, filepath.Base(file))
+	}
+	rel, err := filepath.Rel(top, file)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+// SessionDiff sums up the changes made by commits and those left uncommitted in repos,
+// except for what base leaves out. Repositories whose working tree can't be read only
+// contribute their commits. Uncommitted files outside a non-empty scope are listed in
+// Uncommitted.OutOfScope.
+func SessionDiff(commits []Commit, repos []Repo, scope Scope, base Baseline) DiffSummary {
+	if len(repos) == 0 {
+		repos = []Repo{CurrentRepo}
+	}
+	multiRepo := len(repos) > 1
+	d := summarize(commits, multiRepo)
+
+	left := &DiffSummary{}
+	files := make(map[string]bool)
+	for _, repo := range repos {
+		prefix := ""
+		if multiRepo {
+			prefix = repo.Name + "/"
+		}
+		u, err := uncommitted(repo, prefix, scope, base)
+		if err != nil || u == nil {
+			continue
+		}
+		left.Insertions += u.Insertions
+		left.Deletions += u.Deletions
+		left.OutOfScope = append(left.OutOfScope, u.OutOfScope...)
+		for _, f := range u.Files {
+			files[f] = true
+		}
+	}
+	if len(files) > 0 {
+		left.setFiles(files)
+		d.Uncommitted = left
+	}
+	return d
+}
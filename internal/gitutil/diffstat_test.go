@@ -0,0 +1,132 @@
+package gitutil_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gobox/internal/gitutil"
+)
+
+func TestSummarize(t *testing.T) {
+	commits := []gitutil.Commit{
+		{Repo: "api", Files: []string{"internal/parser/parser.go", "internal/parser/lexer.go"}, Insertions: 100, Deletions: 30},
+		{Repo: "api", Files: []string{"internal/parser/parser.go", "README.md"}, Insertions: 20, Deletions: 4},
+	}
+	d := gitutil.Summarize(commits)
+	if got := d.String(); got != "+120/-34 in 3 files" {
+		t.Errorf("unexpected summary %q", got)
+	}
+	if got := d.DirNames(); !reflect.DeepEqual(got, []string{"internal/parser", "."}) {
+		t.Errorf("unexpected top dirs %v", got)
+	}
+
+	// Paths are told apart by repository once there are several
+	commits = append(commits, gitutil.Commit{Repo: "web", Files: []string{"README.md"}, Insertions: 1})
+	d = gitutil.Summarize(commits)
+	if !reflect.DeepEqual(d.Files, []string{"api/README.md", "api/internal/parser/lexer.go", "api/internal/parser/parser.go", "web/README.md"}) {
+		t.Errorf("unexpected files %v", d.Files)
+	}
+}
+
+func TestSessionDiffCountsUncommittedChanges(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	run := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, out)
+		}
+	}
+	run("init", "-q")
+	run("config", "user.email", "jane@example.com")
+	run("config", "user.name", "Jane")
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run("add", "main.go")
+	run("commit", "-q", "-m", "Initial commit")
+
+	repo, err := gitutil.NewRepo(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := gitutil.SessionDiff(nil, []gitutil.Repo{repo}, nil, gitutil.Baseline{}); !d.IsEmpty() {
+		t.Fatalf("expected a clean tree, got %s", d)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "new.go"), []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d := gitutil.SessionDiff(nil, []gitutil.Repo{repo}, gitutil.Scope{"main.go"}, gitutil.Baseline{})
+	if got := d.String(); got != "+0/-0 in 0 files, +2/-0 in 2 files uncommitted" {
+		t.Errorf("unexpected summary %q", got)
+	}
+	if !reflect.DeepEqual(d.Uncommitted.OutOfScope, []string{"new.go"}) {
+		t.Errorf("expected new.go to be flagged, got %v", d.Uncommitted.OutOfScope)
+	}
+}
+
+func TestSessionDiffLeavesOutTheTaskFileAndEarlierChanges(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	run := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, out)
+		}
+	}
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run("init", "-q")
+	run("config", "user.email", "jane@example.com")
+	run("config", "user.name", "Jane")
+	write("main.go", "package main\n")
+	write("notes.go", "package main\n")
+	write("tasks.md", "- [ ] Task @1h\n")
+	run("add", ".")
+	run("commit", "-q", "-m", "Initial commit")
+
+	repo, err := gitutil.NewRepo(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// notes.go was being edited before the session started
+	write("notes.go", "package main\n\n// TODO\n")
+	dirty, err := gitutil.DirtyFiles(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := gitutil.Baseline{TaskFile: filepath.Join(dir, "tasks.md"), Dirty: map[string]map[string]string{repo.Path: dirty}}
+
+	write("tasks.md", "- [x] Task @1h <!-- gobox:id=abc123 -->\n")
+	write("main.go", "package main\n\nfunc main() {}\n")
+	d := gitutil.SessionDiff(nil, []gitutil.Repo{repo}, gitutil.Scope{"main.go"}, base)
+	if d.Uncommitted == nil || !reflect.DeepEqual(d.Uncommitted.Files, []string{"main.go"}) || len(d.Uncommitted.OutOfScope) != 0 {
+		t.Fatalf("expected only main.go to be the session's, got %+v", d.Uncommitted)
+	}
+
+	// Once the session changes it too, the earlier edits can't be told apart
+	write("notes.go", "package main\n\n// TODO\n// And this\n")
+	d = gitutil.SessionDiff(nil, []gitutil.Repo{repo}, gitutil.Scope{"main.go"}, base)
+	if !reflect.DeepEqual(d.Uncommitted.OutOfScope, []string{"notes.go"}) {
+		t.Errorf("expected notes.go to be flagged, got %v", d.Uncommitted.OutOfScope)
+	}
+}
@@ -0,0 +1,83 @@
+package gitutil
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// GitDir returns the git directory of the repository containing path, and its common
+// directory, which holds the refs shared by linked worktrees. Both are the same
+// outside of linked worktrees.
+func GitDir(path string) (gitDir, commonDir string, err error) {
+	dir, err := filepath.Abs(path)
+	if err != nil {
+		return "", "", err
+	}
+
+	for {
+		dotGit := filepath.Join(dir, ".git")
+		info, err := os.Stat(dotGit)
+		if err == nil {
+			if info.IsDir() {
+				gitDir = dotGit
+			} else if gitDir, err = readGitFile(dotGit); err != nil {
+				return "", "", err
+			}
+			return gitDir, commonDirOf(gitDir), nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", "", errors.New("not a git repository")
+		}
+		dir = parent
+	}
+}
+
+// readGitFile resolves a ".git" file, as used by worktrees and submodules, which
+// contains "gitdir: <path>".
+func readGitFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	target, ok := strings.CutPrefix(strings.TrimSpace(string(content)), "gitdir:")
+	if !ok {
+		return "", fmt.Errorf("invalid git file %s", path)
+	}
+	target = strings.TrimSpace(target)
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(path), target)
+	}
+	return filepath.Clean(target), nil
+}
+
+// commonDirOf returns the common directory a linked worktree's git directory points to.
+func commonDirOf(gitDir string) string {
+	content, err := os.ReadFile(filepath.Join(gitDir, "commondir"))
+	if err != nil {
+		return gitDir
+	}
+	common := strings.TrimSpace(string(content))
+	if !filepath.IsAbs(common) {
+		common = filepath.Join(gitDir, common)
+	}
+	return filepath.Clean(common)
+}
+
+// HooksDir returns the directory git runs the hooks of the repository containing path
+// from, which core.hooksPath may point elsewhere.
+func HooksDir(path string) (string, error) {
+	output, err := CommandReader{Runner: runner, Dir: path}.git("rev-parse", "--git-path", "hooks")
+	if err != nil {
+		return "", err
+	}
+	dir := strings.TrimSpace(output)
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(path, dir)
+	}
+	return filepath.Abs(dir)
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"os/exec"
-	"strings"
 	"time"
 )
 
@@ -30,59 +29,136 @@ func commandContext(ctx context.Context, name string, arg ...string) *exec.Cmd {
 // We'll use a package-level variable for the runner
 var runner CommandRunner = DefaultRunner{}
 
-// GetCommitsSince fetches git commits since a given time, using the runner.
-func GetCommitsSince(since time.Time) ([]string, error) {
-	// Use --date=iso-strict to ensure consistent date format for parsing
-	outputBytes, err := runner.CombinedOutput(context.Background(), "git", "log", "--oneline", "--since", since.Format(time.RFC3339))
-	if err != nil {
-		if strings.Contains(strings.ToLower(string(outputBytes)), "not a git repository") {
-			return nil, fmt.Errorf("not a git repository: %s", strings.TrimSpace(string(outputBytes)))
-		}
-		return nil, fmt.Errorf("error running git log: %w, output: %s", err, string(outputBytes))
-	}
+// inProcess enables reading the repository in-process before falling back to the git command.
+var inProcess = true
 
-	output := string(outputBytes)
-	if strings.Contains(strings.ToLower(output), "not a git repository") {
-		return nil, fmt.Errorf("not a git repository: %s", strings.TrimSpace(output))
-	}
+// Commit is a single git commit.
+type Commit struct {
+	Hash          string    `json:"hash"`                   // Full commit hash
+	ShortHash     string    `json:"short_hash"`             // Abbreviated hash, as shown by git log --oneline
+	Author        string    `json:"author"`                 // Author name
+	Email         string    `json:"email"`                  // Author email
+	AuthorTime    time.Time `json:"author_time"`            // When the change was originally made
+	CommitterTime time.Time `json:"committer_time"`         // When the commit was created, e.g. after a rebase
+	Subject       string    `json:"subject"`                // First line of the commit message
+	Parents       []string  `json:"parents"`                // Full hashes of the parent commits
+	Branch        string    `json:"branch"`                 // Branch checked out when the commit was read, empty if HEAD is detached
+	Files         []string  `json:"files"`                  // Paths changed by the commit
+	Insertions    int       `json:"insertions"`             // Lines added
+	Deletions     int       `json:"deletions"`              // Lines removed
+	Repo          string    `json:"repo,omitempty"`         // Name of the repository, set when commits are read from several
+	Task          string    `json:"task,omitempty"`         // Task ID from the commit's TaskTrailer, if any
+	OutOfScope    []string  `json:"out_of_scope,omitempty"` // Files changed outside the task's Scope, see FlagScope
+	URL           string    `json:"url,omitempty"`          // Web page of the commit, see LinkCommits
+}
+
+// IsMerge reports whether the commit has more than one parent.
+func (c Commit) IsMerge() bool {
+	return len(c.Parents) > 1
+}
+
+// String returns the commit in git log --oneline form, e.g. "1a2b3c4 Fix parser".
+func (c Commit) String() string {
+	return c.ShortHash + " " + c.Subject
+}
+
+// LogOptions selects commits to read.
+type LogOptions struct {
+	Since time.Time // Only commits committed at or after Since
+	Until time.Time // Only commits committed at or before Until; zero means no upper bound
+	From  string    // Branch or commit to walk back from; HEAD if empty
+	Base  string    // Leave out Base and the commits before it, like git log Base..From
+
+	// Keep, if set, selects the commits to return. It is called before the files a commit
+	// changed are known, so they are only looked up for the commits it keeps.
+	Keep func(Commit) bool
+}
+
+// Reader reads commits from a repository.
+type Reader interface {
+	// Log returns the commits selected by opts, newest first.
+	Log(opts LogOptions) ([]Commit, error)
+
+	// Commit returns the commit with the given full or abbreviated hash.
+	Commit(hash string) (Commit, error)
+
+	// Head returns the checked out branch, empty if HEAD is detached, and the commit
+	// HEAD points to, empty if there are no commits yet.
+	Head() (branch, hash string, err error)
+
+	// UserEmail returns the configured user.email.
+	UserEmail() (string, error)
+}
+
+// reader returns the reader for the repository around the working directory.
+func reader() Reader {
+	return CurrentRepo.Reader()
+}
+
+// fallbackReader uses the git command when the in-process reader fails, e.g. on
+// repository features it doesn't support.
+type fallbackReader struct {
+	primary  Reader
+	fallback Reader
+}
 
-	lines := strings.Split(strings.TrimSpace(output), "\n")
-	var commits []string
-	for _, line := range lines {
-		if line != "" {
-			commits = append(commits, line)
-		}
+func (f fallbackReader) Log(opts LogOptions) ([]Commit, error) {
+	commits, err := f.primary.Log(opts)
+	if err != nil {
+		return f.fallback.Log(opts)
 	}
 	return commits, nil
 }
 
-// For testing, we'll add a function to set a mock runner
-func SetRunner(r CommandRunner) {
-	runner = r
+func (f fallbackReader) Commit(hash string) (Commit, error) {
+	commit, err := f.primary.Commit(hash)
+	if err != nil {
+		return f.fallback.Commit(hash)
+	}
+	return commit, nil
 }
 
-// GetCommitsBetweenTimeRange fetches git commits between a start and end time range.
-func GetCommitsBetweenTimeRange(start, end time.Time) ([]string, error) {
-	outputBytes, err := runner.CombinedOutput(context.Background(), "git", "log", "--oneline", "--since", start.Format(time.RFC3339), "--until", end.Format(time.RFC3339))
+func (f fallbackReader) Head() (string, string, error) {
+	branch, hash, err := f.primary.Head()
 	if err != nil {
-		outputStr := string(outputBytes)
-		if strings.Contains(strings.ToLower(outputStr), "not a git repository") {
-			return nil, fmt.Errorf("not a git repository: %s", strings.TrimSpace(outputStr))
-		}
-		return nil, fmt.Errorf("error running git log: %w, output: %s", err, outputStr)
+		return f.fallback.Head()
 	}
+	return branch, hash, nil
+}
 
-	output := string(outputBytes)
-	if strings.Contains(strings.ToLower(output), "not a git repository") {
-		return nil, fmt.Errorf("not a git repository: %s", strings.TrimSpace(output))
+func (f fallbackReader) UserEmail() (string, error) {
+	email, err := f.primary.UserEmail()
+	if err != nil || email == "" {
+		return f.fallback.UserEmail()
 	}
+	return email, nil
+}
+
+// GetCommit fetches a single commit by hash.
+func GetCommit(hash string) (Commit, error) {
+	return reader().Commit(hash)
+}
 
-	lines := strings.Split(strings.TrimSpace(output), "\n")
-	var commits []string
-	for _, line := range lines {
-		if line != "" {
-			commits = append(commits, line)
-		}
+// GetCommitsSince fetches git commits since a given time.
+func GetCommitsSince(since time.Time) ([]Commit, error) {
+	return reader().Log(LogOptions{Since: since})
+}
+
+// SetRunner replaces the runner used to shell out to git.
+func SetRunner(r CommandRunner) {
+	runner = r
+}
+
+// SetInProcess enables or disables reading repositories in-process. While it is disabled
+// all reads go through the runner, e.g. so that tests only see the runner's output.
+func SetInProcess(enabled bool) {
+	inProcess = enabled
+}
+
+// GetCommitsBetweenTimeRange fetches git commits between a start and end time range.
+func GetCommitsBetweenTimeRange(start, end time.Time) ([]Commit, error) {
+	if end.IsZero() {
+		return nil, fmt.Errorf("no end time given")
 	}
-	return commits, nil
+	return reader().Log(LogOptions{Since: start, Until: end})
 }
@@ -2,9 +2,15 @@ package gitutil_test
 
 import (
 	"context"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
 	"gobox/internal/gitutil"
 )
 
@@ -12,16 +18,29 @@ import (
 type MockRunner struct {
 	output string
 	err    error
+	branch string // output of git symbolic-ref
 }
 
 func (m MockRunner) CombinedOutput(ctx context.Context, name string, arg ...string) ([]byte, error) {
+	if len(arg) > 0 && arg[0] == "symbolic-ref" {
+		return []byte(m.branch + "\n"), nil
+	}
 	return []byte(m.output), m.err
 }
 
+// logRecord builds the git log output for one commit, as produced by the command reader's format.
+func logRecord(hash, subject string, numstat ...string) string {
+	fields := []string{hash, hash[:7], "Jane Doe", "jane@example.com", "2025-06-02T10:00:00+02:00", "2025-06-02T10:05:00+02:00", "0123456789abcdef0123456789abcdef01234567", "", subject}
+	record := "\x1e" + strings.Join(fields, "\x1f")
+	for _, line := range numstat {
+		record += "\n" + line
+	}
+	return record + "\n"
+}
+
 func TestGetCommitsSince(t *testing.T) {
 	now := time.Now()
 	since := now.Add(-time.Hour)
-	sinceStr := since.Format(time.RFC3339)
 
 	tests := []struct {
 		name        string
@@ -29,28 +48,25 @@ func TestGetCommitsSince(t *testing.T) {
 		mockError   error
 		wantCommits []string
 		wantErr     bool
-		checkArgs   []string
 	}{
 		{
-			name:        "successful with commits",
-			mockOutput:  "abcdefg Commit 1\nhijklmn Another commit",
+			name: "successful with commits",
+			mockOutput: logRecord("abcdefg1234567890abcdefg1234567890abcd", "Commit 1", "3\t1\tmain.go", "-\t-\tlogo.png") +
+				"\n" + logRecord("hijklmn1234567890hijklmn1234567890hijk", "Another commit"),
 			wantCommits: []string{"abcdefg Commit 1", "hijklmn Another commit"},
 			wantErr:     false,
-			checkArgs:   []string{"log", "--oneline", "--since", sinceStr},
 		},
 		{
 			name:        "no commits",
 			mockOutput:  "",
 			wantCommits: []string{},
 			wantErr:     false,
-			checkArgs:   []string{"log", "--oneline", "--since", sinceStr},
 		},
 		{
 			name:        "not a git repository",
 			mockOutput:  "fatal: not a git repository (or any of the parent directories): .git",
 			wantErr:     true,
 			wantCommits: nil,
-			checkArgs:   []string{"log", "--oneline", "--since", sinceStr},
 		},
 		{
 			name:        "other git error",
@@ -58,15 +74,16 @@ func TestGetCommitsSince(t *testing.T) {
 			mockError:   &mockExecError{output: "error: something went wrong"},
 			wantErr:     true,
 			wantCommits: nil,
-			checkArgs:   []string{"log", "--oneline", "--since", sinceStr},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			mockRunner := MockRunner{output: tt.mockOutput, err: tt.mockError}
+			mockRunner := MockRunner{output: tt.mockOutput, err: tt.mockError, branch: "main"}
 			gitutil.SetRunner(mockRunner)
+			gitutil.SetInProcess(false)
 			defer gitutil.SetRunner(gitutil.DefaultRunner{}) // Reset after test
+			defer gitutil.SetInProcess(true)
 
 			gotCommits, err := gitutil.GetCommitsSince(since)
 			if (err != nil) != tt.wantErr {
@@ -77,10 +94,128 @@ func TestGetCommitsSince(t *testing.T) {
 				t.Errorf("GetCommitsSince() gotCommits length = %d, wantCommits length = %d", len(gotCommits), len(tt.wantCommits))
 				return
 			}
+			for i, c := range gotCommits {
+				if c.String() != tt.wantCommits[i] {
+					t.Errorf("commit %d = %q, want %q", i, c.String(), tt.wantCommits[i])
+				}
+			}
 		})
 	}
 }
 
+func TestCommandReaderParsesDetails(t *testing.T) {
+	gitutil.SetRunner(MockRunner{
+		output: logRecord("abcdefg1234567890abcdefg1234567890abcd", "Commit 1", "3\t1\tmain.go", "-\t-\tlogo.png", "10\t0\tdocs/README.md"),
+		branch: "feature/x",
+	})
+	gitutil.SetInProcess(false)
+	defer gitutil.SetRunner(gitutil.DefaultRunner{})
+	defer gitutil.SetInProcess(true)
+
+	commits, err := gitutil.GetCommitsBetweenTimeRange(time.Now().Add(-time.Hour), time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(commits) != 1 {
+		t.Fatalf("expected 1 commit, got %d", len(commits))
+	}
+
+	c := commits[0]
+	if c.Hash != "abcdefg1234567890abcdefg1234567890abcd" || c.ShortHash != "abcdefg" {
+		t.Errorf("unexpected hashes %q / %q", c.Hash, c.ShortHash)
+	}
+	if c.Author != "Jane Doe" || c.Email != "jane@example.com" || c.Branch != "feature/x" {
+		t.Errorf("unexpected author or branch: %+v", c)
+	}
+	if c.CommitterTime.Sub(c.AuthorTime) != 5*time.Minute {
+		t.Errorf("unexpected times %v / %v", c.AuthorTime, c.CommitterTime)
+	}
+	if c.Insertions != 13 || c.Deletions != 1 || len(c.Files) != 3 {
+		t.Errorf("unexpected stats: +%d -%d %v", c.Insertions, c.Deletions, c.Files)
+	}
+	if len(c.Parents) != 1 || c.IsMerge() {
+		t.Errorf("unexpected parents: %v", c.Parents)
+	}
+}
+
+func TestRepoReader(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := time.Now().Add(-2 * time.Hour).Truncate(time.Second)
+	commit := func(file, content, msg string, when time.Time) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := wt.Add(file); err != nil {
+			t.Fatal(err)
+		}
+		sig := &object.Signature{Name: "Jane Doe", Email: "jane@example.com", When: when}
+		if _, err := wt.Commit(msg, &git.CommitOptions{Author: sig, Committer: sig}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	commit("a.txt", "one\n", "Initial commit", base)
+	commit("a.txt", "one\ntwo\nthree\n", "Add lines\n\nWith a body.", base.Add(time.Hour))
+	commit("b.txt", "b\n", "Add b", base.Add(90*time.Minute))
+
+	reader, err := gitutil.OpenRepo(filepath.Join(dir, "."))
+	if err != nil {
+		t.Fatalf("OpenRepo failed: %v", err)
+	}
+
+	commits, err := reader.Log(gitutil.LogOptions{Since: base.Add(30 * time.Minute), Until: base.Add(80 * time.Minute)})
+	if err != nil {
+		t.Fatalf("Log failed: %v", err)
+	}
+	if len(commits) != 1 {
+		t.Fatalf("expected 1 commit in range, got %d", len(commits))
+	}
+
+	c := commits[0]
+	if c.Subject != "Add lines" || len(c.Hash) != 40 || c.ShortHash != c.Hash[:7] {
+		t.Errorf("unexpected commit: %+v", c)
+	}
+	if c.Branch != "master" || c.Author != "Jane Doe" || !c.AuthorTime.Equal(base.Add(time.Hour)) {
+		t.Errorf("unexpected author, branch or time: %+v", c)
+	}
+	if c.Insertions != 2 || c.Deletions != 0 || len(c.Files) != 1 || c.Files[0] != "a.txt" {
+		t.Errorf("unexpected stats: +%d -%d %v", c.Insertions, c.Deletions, c.Files)
+	}
+
+	commits, err = reader.Log(gitutil.LogOptions{Since: base.Add(30 * time.Minute)})
+	if err != nil {
+		t.Fatalf("Log failed: %v", err)
+	}
+	if len(commits) != 2 || commits[0].Subject != "Add b" {
+		t.Errorf("expected the 2 newest commits, newest first, got %v", commits)
+	}
+
+	// Keep sees the commits before their changes are looked up
+	var kept []string
+	commits, err = reader.Log(gitutil.LogOptions{Since: base.Add(30 * time.Minute), Keep: func(c gitutil.Commit) bool {
+		if len(c.Files) != 0 {
+			t.Errorf("Keep was given the changes of %s", c)
+		}
+		kept = append(kept, c.Subject)
+		return c.Subject == "Add b"
+	}})
+	if err != nil {
+		t.Fatalf("Log failed: %v", err)
+	}
+	if len(kept) != 2 || len(commits) != 1 || commits[0].Subject != "Add b" || len(commits[0].Files) != 1 {
+		t.Errorf("expected only the kept commit with its changes, got %+v", commits)
+	}
+}
+
 // Mock error to simulate exec command errors
 type mockExecError struct {
 	output string
@@ -0,0 +1,92 @@
+package gitutil
+
+import (
+	"net/url"
+	"strings"
+)
+
+// RemoteURL returns the URL of the repository's origin remote, "" if it has none.
+func RemoteURL(repo Repo) string {
+	reader := CommandReader{Runner: runner}
+	if repo.Path != "." {
+		reader.Dir = repo.Path
+	}
+	output, err := reader.git("config", "--get", "remote.origin.url")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(output)
+}
+
+// WebURL returns the web page of the repository a remote URL points to, such as
+// https://github.com/owner/repo for git@github.com:owner/repo.git. It returns "" for
+// remotes that aren't hosted, e.g. local paths.
+func WebURL(remote string) string {
+	remote = strings.TrimSuffix(strings.TrimSuffix(remote, "/"), ".git")
+
+	// scp-like syntax: [user@]host:path
+	if !strings.Contains(remote, "://") {
+		host, path, ok := strings.Cut(remote, ":")
+		if !ok || strings.Contains(host, "/") {
+			return ""
+		}
+		if _, h, found := strings.Cut(host, "@"); found {
+			host = h
+		}
+		return "https://" + host + "/" + strings.TrimPrefix(path, "/")
+	}
+
+	u, err := url.Parse(remote)
+	if err != nil || u.Hostname() == "" {
+		return ""
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return u.Scheme + "://" + u.Host + u.Path
+	case "ssh", "git":
+		// The web server doesn't listen on the ssh or git port
+		return "https://" + u.Hostname() + u.Path
+	default:
+		return ""
+	}
+}
+
+// CommitURL returns the web page of the commit with the given hash in the repository
+// whose web page is web, following the conventions of GitLab and Bitbucket and
+// GitHub's for everything else.
+func CommitURL(web, hash string) string {
+	switch {
+	case web == "":
+		return ""
+	case strings.Contains(web, "gitlab"):
+		return web + "/-/commit/" + hash
+	case strings.Contains(web, "bitbucket.org"):
+		return web + "/commits/" + hash
+	default:
+		return web + "/commit/" + hash
+	}
+}
+
+// LinkCommits sets the URL of each of commits from the origin remote of the repository
+// it was read from. An empty repos means the current repository.
+func LinkCommits(commits []Commit, repos []Repo) {
+	if len(repos) == 0 {
+		repos = []Repo{CurrentRepo}
+	}
+	webURLs := make(map[string]string)
+	for i := range commits {
+		c := &commits[i]
+		repo := repos[0]
+		for _, r := range repos {
+			if r.Name == c.Repo {
+				repo = r
+			}
+		}
+		web, ok := webURLs[repo.Path]
+		if !ok {
+			web = WebURL(RemoteURL(repo))
+			webURLs[repo.Path] = web
+		}
+		c.URL = CommitURL(web, c.Hash)
+	}
+}
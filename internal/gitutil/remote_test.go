@@ -0,0 +1,32 @@
+package gitutil_test
+
+import (
+	"testing"
+
+	"gobox/internal/gitutil"
+)
+
+func TestWebURL(t *testing.T) {
+	tests := map[string]string{
+		"git@github.com:acme/gobox.git":            "https://github.com/acme/gobox",
+		"https://github.com/acme/gobox.git":        "https://github.com/acme/gobox",
+		"https://user@git.example.com:8443/a/b":    "https://git.example.com:8443/a/b",
+		"ssh://git@gitlab.com:2222/group/sub/proj": "https://gitlab.com/group/sub/proj",
+		"http://localhost:3000/me/repo/":           "http://localhost:3000/me/repo",
+		"/srv/git/repo.git":                        "",
+		"file:///srv/git/repo.git":                 "",
+		"":                                         "",
+	}
+	for remote, want := range tests {
+		if got := gitutil.WebURL(remote); got != want {
+			t.Errorf("WebURL(%q) = %q, want %q", remote, got, want)
+		}
+	}
+
+	if got := gitutil.CommitURL("https://gitlab.com/group/proj", "abc"); got != "https://gitlab.com/group/proj/-/commit/abc" {
+		t.Errorf("unexpected GitLab commit URL %q", got)
+	}
+	if got := gitutil.CommitURL("https://github.com/acme/gobox", "abc"); got != "https://github.com/acme/gobox/commit/abc" {
+		t.Errorf("unexpected GitHub commit URL %q", got)
+	}
+}
@@ -0,0 +1,223 @@
+package gitutil
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// shortHashLength matches the default abbreviation of git log --oneline.
+const shortHashLength = 7
+
+// staleCommits is how many commits older than the start of the range are walked
+// before giving up, to tolerate clock skew between commits like git log does.
+const staleCommits = 5
+
+// RepoReader implements Reader by reading the repository in-process, without
+// starting a git process. It is safe for concurrent use.
+type RepoReader struct {
+	mu   sync.Mutex // go-git repositories are not
+	repo *git.Repository
+}
+
+// OpenRepo opens the repository containing path.
+func OpenRepo(path string) (*RepoReader, error) {
+	repo, err := git.PlainOpenWithOptions(path, &git.PlainOpenOptions{
+		DetectDotGit:          true,
+		EnableDotGitCommonDir: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &RepoReader{repo: repo}, nil
+}
+
+func (r *RepoReader) Log(opts LogOptions) ([]Commit, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	from, err := r.resolve(opts.From)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) && opts.From == "" {
+		// No commits yet
+		return []Commit{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	branch := r.branch()
+
+	// Commits older than Since are never listed, so only the recent part of the
+	// history behind Base has to be known to leave it out.
+	excluded := map[plumbing.Hash]bool{}
+	if opts.Base != "" {
+		base, err := r.resolve(opts.Base)
+		if err != nil {
+			return nil, err
+		}
+		err = r.walk(base, opts.Since, func(c *object.Commit) {
+			excluded[c.Hash] = true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	commits := []Commit{}
+	err = r.walk(from, opts.Since, func(c *object.Commit) {
+		if excluded[c.Hash] {
+			return
+		}
+		if !opts.Until.IsZero() && c.Committer.When.After(opts.Until) {
+			return
+		}
+		commit := newCommit(c, branch)
+		if opts.Keep != nil && !opts.Keep(commit) {
+			return
+		}
+		addStats(&commit, c)
+		commits = append(commits, commit)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return commits, nil
+}
+
+// walk calls fn for the commits reachable from hash that were committed at or after
+// since, newest first.
+func (r *RepoReader) walk(hash plumbing.Hash, since time.Time, fn func(*object.Commit)) error {
+	stale := 0
+	visit := func(c *object.Commit) error {
+		if c.Committer.When.Before(since) {
+			stale++
+			if stale >= staleCommits {
+				return storer.ErrStop
+			}
+			return nil
+		}
+		stale = 0
+		fn(c)
+		return nil
+	}
+
+	iter, err := r.repo.Log(&git.LogOptions{From: hash, Order: git.LogOrderCommitterTime})
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	return iter.ForEach(visit)
+}
+
+// resolve returns the commit a branch name or hash points to, HEAD if rev is empty.
+func (r *RepoReader) resolve(rev string) (plumbing.Hash, error) {
+	if rev == "" {
+		head, err := r.repo.Head()
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		return head.Hash(), nil
+	}
+	hash, err := r.repo.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return *hash, nil
+}
+
+func (r *RepoReader) Commit(hash string) (Commit, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	full, err := r.repo.ResolveRevision(plumbing.Revision(hash))
+	if err != nil {
+		return Commit{}, err
+	}
+	c, err := r.repo.CommitObject(*full)
+	if err != nil {
+		return Commit{}, err
+	}
+	commit := newCommit(c, r.branch())
+	addStats(&commit, c)
+	return commit, nil
+}
+
+func (r *RepoReader) Head() (string, string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	head, err := r.repo.Head()
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return r.unbornBranch(), "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return r.branch(), head.Hash().String(), nil
+}
+
+// unbornBranch returns the branch HEAD points to before the first commit.
+func (r *RepoReader) unbornBranch() string {
+	head, err := r.repo.Storer.Reference(plumbing.HEAD)
+	if err != nil || head.Type() != plumbing.SymbolicReference {
+		return ""
+	}
+	return head.Target().Short()
+}
+
+func (r *RepoReader) UserEmail() (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cfg, err := r.repo.ConfigScoped(config.GlobalScope)
+	if err != nil {
+		return "", err
+	}
+	return cfg.User.Email, nil
+}
+
+// branch returns the checked out branch, or "" if HEAD is detached or unborn.
+func (r *RepoReader) branch() string {
+	head, err := r.repo.Head()
+	if err != nil || !head.Name().IsBranch() {
+		return ""
+	}
+	return head.Name().Short()
+}
+
+// newCommit returns the commit c, without the files it changed, see addStats.
+func newCommit(c *object.Commit, branch string) Commit {
+	hash := c.Hash.String()
+	subject, _, _ := strings.Cut(strings.TrimSpace(c.Message), "\n")
+
+	commit := Commit{
+		Hash:          hash,
+		ShortHash:     hash[:shortHashLength],
+		Author:        c.Author.Name,
+		Email:         c.Author.Email,
+		AuthorTime:    c.Author.When,
+		CommitterTime: c.Committer.When,
+		Subject:       strings.TrimSpace(subject),
+		Branch:        branch,
+		Task:          taskFromMessage(c.Message),
+	}
+	for _, parent := range c.ParentHashes {
+		commit.Parents = append(commit.Parents, parent.String())
+	}
+	return commit
+}
+
+// addStats adds the files c changed and the lines it added and removed to commit. They
+// take diffing c against its first parent, so are only looked up for the commits needed.
+func addStats(commit *Commit, c *object.Commit) {
+	// Failing to compute the stats only loses the counts
+	if stats, err := c.Stats(); err == nil {
+		for _, s := range stats {
+			commit.Files = append(commit.Files, s.Name)
+			commit.Insertions += s.Addition
+			commit.Deletions += s.Deletion
+		}
+	}
+}
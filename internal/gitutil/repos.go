@@ -0,0 +1,177 @@
+package gitutil
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Repo is a repository whose commits are tracked.
+type Repo struct {
+	Name string `json:"name"` // Label shown next to the repository's commits
+	Path string `json:"path"` // A directory in the repository's worktree
+}
+
+// CurrentRepo is the repository around the working directory.
+var CurrentRepo = Repo{Path: "."}
+
+// NewRepo returns the repository at path, named after its directory. The path is made
+// absolute, so the repository can be found from any working directory.
+func NewRepo(path string) (Repo, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return Repo{}, err
+	}
+	return Repo{Name: filepath.Base(abs), Path: abs}, nil
+}
+
+// DiscoverRepos returns the repositories directly inside dir, and dir itself if it is
+// one, sorted by name.
+func DiscoverRepos(dir string) ([]Repo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var repos []Repo
+	if isRepoRoot(dir) {
+		repo, err := NewRepo(dir)
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, repo)
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if !entry.IsDir() || !isRepoRoot(path) {
+			continue
+		}
+		repo, err := NewRepo(path)
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, repo)
+	}
+	UniqueNames(repos)
+	sort.Slice(repos, func(i, j int) bool { return repos[i].Name < repos[j].Name })
+	return repos, nil
+}
+
+// UniqueNames renames repositories in directories with the same name after as many of
+// their parent directories as it takes to tell them apart, e.g. work/gobox and
+// forks/gobox, as commits and diffs are matched to their repository by name.
+func UniqueNames(repos []Repo) {
+	byName := make(map[string][]int)
+	for i, repo := range repos {
+		byName[repo.Name] = append(byName[repo.Name], i)
+	}
+	for _, same := range byName {
+		if len(same) < 2 {
+			continue
+		}
+		depth := 0
+		for _, i := range same {
+			depth = max(depth, len(pathElems(repos[i].Path)))
+		}
+		// The whole paths are different, so some depth tells the repositories apart
+		for n := 2; n <= depth; n++ {
+			names := make(map[string]bool)
+			for _, i := range same {
+				names[lastElems(repos[i].Path, n)] = true
+			}
+			if len(names) == len(same) || n == depth {
+				for _, i := range same {
+					repos[i].Name = lastElems(repos[i].Path, n)
+				}
+				break
+			}
+		}
+	}
+}
+
+// pathElems splits path into its directories.
+func pathElems(path string) []string {
+	var elems []string
+	for _, elem := range strings.Split(filepath.ToSlash(filepath.Clean(path)), "/") {
+		if elem != "" {
+			elems = append(elems, elem)
+		}
+	}
+	return elems
+}
+
+// lastElems returns the last n directories of path, joined with slashes.
+func lastElems(path string, n int) string {
+	elems := pathElems(path)
+	return strings.Join(elems[max(0, len(elems)-n):], "/")
+}
+
+// FindRoot returns the top-level directory of the worktree dir is in, or "" if it isn't
+// in one. It only looks at the file system, so git doesn't need to be installed.
+func FindRoot(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		if isRepoRoot(dir) {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// isRepoRoot reports whether dir is the top-level directory of a worktree.
+func isRepoRoot(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, ".git"))
+	return err == nil
+}
+
+// Reader returns the reader for the repository: the in-process reader, falling back to
+// the git command if the repository can't be opened.
+func (r Repo) Reader() Reader {
+	commandReader := CommandReader{Runner: runner}
+	if r.Path != "." {
+		commandReader.Dir = r.Path
+	}
+	if !inProcess {
+		return commandReader
+	}
+	repo, err := openCached(r.Path)
+	if err != nil {
+		return commandReader
+	}
+	return fallbackReader{primary: repo, fallback: commandReader}
+}
+
+// openRepos keeps the repositories opened by Reader, by absolute path, as opening one
+// reads its config, refs and pack indexes.
+var openRepos = struct {
+	sync.Mutex
+	repos map[string]*RepoReader
+}{repos: make(map[string]*RepoReader)}
+
+// openCached opens the repository containing path once, see OpenRepo. Repositories that
+// can't be opened are tried again next time, they may be created meanwhile.
+func openCached(path string) (*RepoReader, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	openRepos.Lock()
+	defer openRepos.Unlock()
+	if repo, ok := openRepos.repos[abs]; ok {
+		return repo, nil
+	}
+	repo, err := OpenRepo(abs)
+	if err != nil {
+		return nil, err
+	}
+	openRepos.repos[abs] = repo
+	return repo, nil
+}
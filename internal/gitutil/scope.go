@@ -0,0 +1,91 @@
+package gitutil
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Scope lists the paths a task is meant to change, as glob patterns relative to the
+// repository root. "*" and "?" match within a path component, "**" matches any number
+// of components, and a pattern without wildcards matches a file or everything below a
+// directory. An empty Scope contains every path.
+type Scope []string
+
+// Contains reports whether file is in the scope.
+func (s Scope) Contains(file string) bool {
+	if len(s) == 0 {
+		return true
+	}
+	for _, pattern := range s {
+		if matchGlob(pattern, file) {
+			return true
+		}
+	}
+	return false
+}
+
+// Outside returns the files that are not in the scope, nil if there are none.
+func (s Scope) Outside(files []string) []string {
+	var outside []string
+	for _, f := range files {
+		if !s.Contains(f) {
+			outside = append(outside, f)
+		}
+	}
+	return outside
+}
+
+// FlagScope sets OutOfScope on each of commits to the files it changed outside scope.
+func FlagScope(commits []Commit, scope Scope) {
+	for i := range commits {
+		commits[i].OutOfScope = scope.Outside(commits[i].Files)
+	}
+}
+
+// ScopeWarnings describes the commits and uncommitted changes that went outside the
+// session's scope, one line each. It returns nil if everything stayed in scope.
+func ScopeWarnings(commits []Commit, uncommitted *DiffSummary) []string {
+	var warnings []string
+	for _, c := range commits {
+		if len(c.OutOfScope) > 0 {
+			warnings = append(warnings, fmt.Sprintf("%s %s: %s", c.ShortHash, c.Subject, strings.Join(c.OutOfScope, ", ")))
+		}
+	}
+	if uncommitted != nil && len(uncommitted.OutOfScope) > 0 {
+		warnings = append(warnings, "uncommitted: "+strings.Join(uncommitted.OutOfScope, ", "))
+	}
+	return warnings
+}
+
+// matchGlob reports whether file matches the scope pattern.
+func matchGlob(pattern, file string) bool {
+	pattern = strings.TrimPrefix(pattern, "./")
+	pattern = strings.TrimSuffix(pattern, "/")
+	if !strings.ContainsAny(pattern, "*?[") {
+		return file == pattern || strings.HasPrefix(file, pattern+"/")
+	}
+	return matchParts(strings.Split(pattern, "/"), strings.Split(file, "/"))
+}
+
+// matchParts matches path components against pattern components.
+func matchParts(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(parts); i++ {
+				if matchParts(pattern[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
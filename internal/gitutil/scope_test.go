@@ -0,0 +1,49 @@
+package gitutil_test
+
+import (
+	"reflect"
+	"testing"
+
+	"gobox/internal/gitutil"
+)
+
+func TestScopeContains(t *testing.T) {
+	tests := []struct {
+		scope gitutil.Scope
+		file  string
+		want  bool
+	}{
+		{nil, "anything.go", true},
+		{gitutil.Scope{"internal/parser/**"}, "internal/parser/parser.go", true},
+		{gitutil.Scope{"internal/parser/**"}, "internal/parser/testdata/a.md", true},
+		{gitutil.Scope{"internal/parser/**"}, "internal/tui/view.go", false},
+		{gitutil.Scope{"internal/parser"}, "internal/parser/parser.go", true},
+		{gitutil.Scope{"internal/parser/"}, "internal/parserx/parser.go", false},
+		{gitutil.Scope{"**/*_test.go"}, "internal/tui/tui_test.go", true},
+		{gitutil.Scope{"**/*_test.go"}, "main_test.go", true},
+		{gitutil.Scope{"docs/*.md"}, "docs/guide/intro.md", false},
+		{gitutil.Scope{"./README.md", "docs/*.md"}, "README.md", true},
+	}
+	for _, tt := range tests {
+		if got := tt.scope.Contains(tt.file); got != tt.want {
+			t.Errorf("%v.Contains(%q) = %v, want %v", tt.scope, tt.file, got, tt.want)
+		}
+	}
+}
+
+func TestFlagScope(t *testing.T) {
+	commits := []gitutil.Commit{
+		{ShortHash: "abc1234", Subject: "Fix the lexer", Files: []string{"internal/parser/lexer.go"}},
+		{ShortHash: "def5678", Subject: "Tweak the view", Files: []string{"internal/parser/parser.go", "internal/tui/view.go"}},
+	}
+	gitutil.FlagScope(commits, gitutil.Scope{"internal/parser/**"})
+	if commits[0].OutOfScope != nil || !reflect.DeepEqual(commits[1].OutOfScope, []string{"internal/tui/view.go"}) {
+		t.Fatalf("unexpected flags %v, %v", commits[0].OutOfScope, commits[1].OutOfScope)
+	}
+
+	warnings := gitutil.ScopeWarnings(commits, &gitutil.DiffSummary{OutOfScope: []string{"go.mod"}})
+	want := []string{"def5678 Tweak the view: internal/tui/view.go", "uncommitted: go.mod"}
+	if !reflect.DeepEqual(warnings, want) {
+		t.Errorf("unexpected warnings %q", warnings)
+	}
+}
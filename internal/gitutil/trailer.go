@@ -0,0 +1,25 @@
+package gitutil
+
+import "strings"
+
+// TaskTrailer is the commit message trailer naming the task a commit was made for,
+// added by the hooks gobox installs.
+const TaskTrailer = "Gobox-Task"
+
+// taskFromMessage returns the value of the last TaskTrailer in the trailer block at the
+// end of a commit message, or "" if there is none.
+func taskFromMessage(message string) string {
+	paragraphs := strings.Split(strings.TrimSpace(message), "\n\n")
+	if len(paragraphs) < 2 {
+		// The subject alone is never a trailer block
+		return ""
+	}
+	task := ""
+	for _, line := range strings.Split(paragraphs[len(paragraphs)-1], "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(key), TaskTrailer) {
+			task = strings.TrimSpace(value)
+		}
+	}
+	return task
+}
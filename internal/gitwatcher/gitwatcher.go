@@ -1,24 +1,58 @@
 package gitwatcher
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
+
 	"gobox/internal/gitutil"
+	"gobox/internal/state"
 )
 
-// GitWatcher polls for new git commits since a start time and emits them via a channel.
+// FallbackPollInterval is how often the watcher still polls while it is notified of ref
+// changes, to catch commits the notifications miss, e.g. on network filesystems.
+const FallbackPollInterval = time.Minute
+
+// debounceDelay groups the burst of file changes a single git command makes.
+const debounceDelay = 100 * time.Millisecond
+
+// GitWatcher reports new git commits made since a start time via a channel. It is
+// notified when HEAD or a branch changes and reads only the new reflog entries,
+// polling every PollInterval if a repository can't be watched. Only commits
+// credited to the session by Rules are reported, flagged if they go outside Scope.
 type GitWatcher struct {
 	StartTime    time.Time
 	PollInterval time.Duration
+	Rules        gitutil.Attribution           // Set before Start
+	Repos        []gitutil.Repo                // Repositories to watch; the current one if empty
+	Refs         map[string]gitutil.SessionRef // Where HEAD was when the session started, by repository path
+	Task         string                        // Key of the session's task, see gitutil.TaskTrailer
+	Scope        gitutil.Scope                 // Paths the task is meant to change; commits touching others are flagged
 
 	mu         sync.Mutex
+	paused     bool
+	pausedAt   time.Time // when Pause was called, zero while running
+	repos      []*repoWatch
 	lastHashes map[string]struct{}
 	stopCh     chan struct{}
-	commitsCh  chan string
+	running    sync.WaitGroup // the watching goroutines
+	commitsCh  chan gitutil.Commit
 	errorCh    chan error
 }
 
+// repoWatch is a repository being watched, with the rules resolved for it.
+type repoWatch struct {
+	repo    gitutil.Repo
+	rules   gitutil.Attribution
+	ref     gitutil.SessionRef
+	resumed chan struct{} // asks for a scan after the watcher is resumed
+}
+
 // NewGitWatcher creates a new GitWatcher.
 func NewGitWatcher(startTime time.Time, pollInterval time.Duration) *GitWatcher {
 	return &GitWatcher{
@@ -26,56 +60,398 @@ func NewGitWatcher(startTime time.Time, pollInterval time.Duration) *GitWatcher
 		PollInterval: pollInterval,
 		lastHashes:   make(map[string]struct{}),
 		stopCh:       make(chan struct{}),
-		commitsCh:    make(chan string, 10),
+		commitsCh:    make(chan gitutil.Commit, 10),
 		errorCh:      make(chan error, 2),
 	}
 }
 
-// Start begins polling for new commits in a background goroutine.
+// Start begins watching for new commits, with a background goroutine per repository.
 func (gw *GitWatcher) Start() {
+	repos := gw.Repos
+	if len(repos) == 0 {
+		repos = []gitutil.Repo{gitutil.CurrentRepo}
+	}
+
+	gw.mu.Lock()
+	for _, repo := range repos {
+		gw.repos = append(gw.repos, &repoWatch{
+			repo:    repo,
+			rules:   gw.Rules.Resolve(repo),
+			ref:     gw.Refs[repo.Path],
+			resumed: make(chan struct{}, 1),
+		})
+	}
+	watches := gw.repos
+	gw.mu.Unlock()
+
+	for _, rw := range watches {
+		gw.watch(rw)
+	}
+}
+
+// watch watches a single repository in a background goroutine.
+func (gw *GitWatcher) watch(rw *repoWatch) {
+	changes, reflog, closeWatch := watchRefs(rw.repo.Path)
+
+	interval := gw.PollInterval
+	if changes != nil && interval < FallbackPollInterval {
+		interval = FallbackPollInterval
+	}
+
+	gw.running.Add(1)
 	go func() {
-		ticker := time.NewTicker(gw.PollInterval)
+		defer gw.running.Done()
+		defer closeWatch()
+		ticker := time.NewTicker(interval)
 		defer ticker.Stop()
+
+		var debounce <-chan time.Time
 		for {
 			select {
 			case <-gw.stopCh:
 				return
 			case <-ticker.C:
-				commits, err := gitutil.GetCommitsSince(gw.StartTime)
-				if err != nil {
-					gw.errorCh <- err
-					continue
+				gw.poll(rw)
+			case <-rw.resumed:
+				gw.poll(rw)
+			case <-changes:
+				if debounce == nil {
+					debounce = time.After(debounceDelay)
+				}
+			case <-debounce:
+				debounce = nil
+				gw.refsChanged(rw, reflog)
+			}
+		}
+	}()
+}
+
+// watchRefs watches HEAD, the branches, packed-refs and the HEAD reflog of the
+// repository at path. The returned channel receives a value whenever one of them
+// changes; it is nil if the repository can't be watched, in which case the watcher
+// relies on polling.
+func watchRefs(path string) (<-chan struct{}, *reflogReader, func()) {
+	noop := func() {}
+	gitDir, commonDir, err := gitutil.GitDir(path)
+	if err != nil {
+		return nil, nil, noop
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, nil, noop
+	}
+
+	// HEAD and logs/HEAD live in the worktree's git directory, branches and packed-refs
+	// in the common directory. Git replaces files by renaming lock files over them, so
+	// the directories are watched rather than the files.
+	headsDir := filepath.Join(commonDir, "refs", "heads")
+	dirs := []string{gitDir, filepath.Join(gitDir, "logs"), commonDir}
+	_ = filepath.WalkDir(headsDir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	for _, dir := range dirs {
+		if err := watcher.Add(dir); err != nil && dir == gitDir {
+			watcher.Close()
+			return nil, nil, noop
+		}
+	}
+
+	relevant := func(path string) bool {
+		switch filepath.Base(path) {
+		case "HEAD", "packed-refs":
+			return true
+		}
+		return strings.HasPrefix(path, headsDir+string(filepath.Separator)) && !strings.HasSuffix(path, ".lock")
+	}
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		for {
+			select {
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
 				}
-				gw.mu.Lock()
-				for _, commit := range commits {
-					hash := ""
-					if len(commit) > 8 {
-						hash = commit[:8]
-					} else {
-						hash = commit
+				// Branch names with slashes create new directories under refs/heads
+				if ev.Has(fsnotify.Create) && strings.HasPrefix(ev.Name, headsDir) {
+					if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+						_ = watcher.Add(ev.Name)
 					}
-					if _, seen := gw.lastHashes[hash]; !seen {
-						gw.commitsCh <- commit
-						gw.lastHashes[hash] = struct{}{}
+				}
+				if relevant(ev.Name) {
+					select {
+					case changes <- struct{}{}:
+					default:
 					}
 				}
-				gw.mu.Unlock()
+			case _, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
 			}
 		}
 	}()
+
+	reflog := newReflogReader(filepath.Join(gitDir, "logs", "HEAD"))
+	return changes, reflog, func() { watcher.Close() }
+}
+
+// refsChanged looks up the commits recorded in the new reflog entries. Ref changes that
+// don't move HEAD, or a missing reflog, fall back to a full scan.
+func (gw *GitWatcher) refsChanged(rw *repoWatch, reflog *reflogReader) {
+	entries, ok := reflog.readNew()
+	if !ok || len(entries) == 0 {
+		gw.poll(rw)
+		return
+	}
+	if gw.IsPaused() {
+		return
+	}
+
+	reader := rw.repo.Reader()
+	for _, entry := range entries {
+		if isZeroHash(entry.New) || gw.seen(entry.New) {
+			continue
+		}
+		commit, err := reader.Commit(entry.New)
+		if err != nil {
+			gw.sendError(err)
+			continue
+		}
+		// Checking out older commits moves HEAD too, they don't belong to the session,
+		// and neither do commits by others, e.g. fast-forwarded by a pull
+		if !rw.rules.Match(commit, gw.Task, gw.StartTime, rw.ref) {
+			gw.markSeen(commit.Hash)
+			continue
+		}
+		commit.Repo = rw.repo.Name
+		gw.emit(commit)
+	}
+}
+
+// poll looks for new commits in the repository since StartTime.
+func (gw *GitWatcher) poll(rw *repoWatch) {
+	if gw.IsPaused() {
+		return
+	}
+	commits, err := rw.rules.NewCommits(rw.repo, rw.ref, gw.Task, gw.StartTime, gw.seen)
+	if err != nil {
+		gw.sendError(err)
+		return
+	}
+	for _, commit := range commits {
+		gw.emit(commit)
+	}
+}
+
+// emit sends the commit, flagged against Scope, unless it was seen before. The commit
+// is sent without holding the lock, as the reader may pause the watcher meanwhile.
+func (gw *GitWatcher) emit(commit gitutil.Commit) {
+	gw.mu.Lock()
+	_, seen := gw.lastHashes[commit.Hash]
+	gw.lastHashes[commit.Hash] = struct{}{}
+	gw.mu.Unlock()
+	if seen {
+		return
+	}
+	commit.OutOfScope = gw.Scope.Outside(commit.Files)
+	select {
+	case gw.commitsCh <- commit:
+	case <-gw.stopCh:
+	}
+}
+
+func (gw *GitWatcher) seen(hash string) bool {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	_, seen := gw.lastHashes[hash]
+	return seen
+}
+
+func (gw *GitWatcher) markSeen(hash string) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	gw.lastHashes[hash] = struct{}{}
+}
+
+func (gw *GitWatcher) sendError(err error) {
+	select {
+	case gw.errorCh <- err:
+	default:
+		// drop the error if nobody is reading them
+	}
 }
 
-// Stop stops the polling goroutine.
+// Pause stops emitting commits until Resume is called.
+func (gw *GitWatcher) Pause() {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	if !gw.paused {
+		gw.paused = true
+		// Git dates commits in whole seconds, so those of this second count as paused
+		gw.pausedAt = time.Now().Truncate(time.Second)
+	}
+}
+
+// Resume continues emitting commits. Commits made while paused are marked as seen
+// without being emitted, so they are not credited to the session. Commits made before
+// the pause that weren't emitted yet are looked up again and emitted.
+func (gw *GitWatcher) Resume() error {
+	gw.mu.Lock()
+	watches := gw.repos
+	pausedAt := gw.pausedAt
+	gw.mu.Unlock()
+
+	var commits []gitutil.Commit
+	var errs []error
+	if !pausedAt.IsZero() {
+		now := time.Now()
+		for _, rw := range watches {
+			found, err := rw.rules.Commits(rw.repo, rw.ref, gw.Task, pausedAt, now)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			commits = append(commits, found...)
+		}
+	}
+
+	gw.mu.Lock()
+	gw.paused = false
+	gw.pausedAt = time.Time{}
+	for _, commit := range commits {
+		gw.lastHashes[commit.Hash] = struct{}{}
+	}
+	gw.mu.Unlock()
+
+	// The commits left from before the pause are sent by the watching goroutines, as
+	// sending blocks until they are read, which may be by the caller
+	for _, rw := range watches {
+		select {
+		case rw.resumed <- struct{}{}:
+		default:
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// IsPaused reports whether the watcher is paused.
+func (gw *GitWatcher) IsPaused() bool {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	return gw.paused
+}
+
+// Stop stops the watching goroutines and waits for a scan they are in to finish.
 func (gw *GitWatcher) Stop() {
 	close(gw.stopCh)
+	gw.running.Wait()
+}
+
+// Done returns a channel that is closed once the watcher is stopped.
+func (gw *GitWatcher) Done() <-chan struct{} {
+	return gw.stopCh
 }
 
-// Commits returns a channel of new commit messages.
-func (gw *GitWatcher) Commits() <-chan string {
+// Commits returns a channel of new commits.
+func (gw *GitWatcher) Commits() <-chan gitutil.Commit {
 	return gw.commitsCh
 }
 
-// Errors returns a channel of errors encountered during polling.
+// Errors returns a channel of errors encountered while looking for commits.
 func (gw *GitWatcher) Errors() <-chan error {
 	return gw.errorCh
 }
+
+// SessionRefs returns where HEAD was in each repository when the session with the
+// given state started, keyed by repository path.
+func SessionRefs(tbState *state.TimeBoxState) map[string]gitutil.SessionRef {
+	refs := make(map[string]gitutil.SessionRef, len(tbState.Refs))
+	for path, ref := range tbState.Refs {
+		refs[path] = gitutil.SessionRef{Branch: ref.Branch, Base: ref.Commit}
+	}
+	return refs
+}
+
+// CommitsDuring returns the commits in repos credited by rules to the session with the
+// given state: those made during its closed segments, and those tagged for its task
+// since it was first started, without duplicates. An empty repos means the current
+// repository.
+func CommitsDuring(rules gitutil.Attribution, repos []gitutil.Repo, tbState *state.TimeBoxState) ([]gitutil.Commit, error) {
+	if len(repos) == 0 {
+		repos = []gitutil.Repo{gitutil.CurrentRepo}
+	}
+	commits := []gitutil.Commit{}
+	seen := make(map[string]struct{})
+	add := func(found []gitutil.Commit) {
+		for _, c := range found {
+			if _, ok := seen[c.Hash]; !ok {
+				seen[c.Hash] = struct{}{}
+				commits = append(commits, c)
+			}
+		}
+	}
+
+	refs := SessionRefs(tbState)
+	task := tbState.Key()
+	var errs []error
+	for _, repo := range repos {
+		repoRules := rules.Resolve(repo)
+		ref := refs[repo.Path]
+		for _, seg := range tbState.Segments {
+			if seg.End == nil {
+				continue
+			}
+			found, err := repoRules.Commits(repo, ref, task, seg.Start, *seg.End)
+			if err != nil {
+				// One broken repository shouldn't hide the commits in the others
+				errs = append(errs, err)
+				break
+			}
+			add(found)
+		}
+		if len(tbState.Segments) > 0 {
+			if found, err := repoRules.Tagged(repo, task, tbState.CreatedAt()); err == nil {
+				add(found)
+			}
+		}
+	}
+	return commits, errors.Join(errs...)
+}
+
+// RecordRefs records where HEAD is in each of repos in the state of a task that is
+// started for the first time, and which files have uncommitted changes already.
+// Repositories that can't be read are left out.
+func RecordRefs(tbState *state.TimeBoxState, repos []gitutil.Repo) {
+	if len(tbState.Segments) > 0 || len(tbState.Refs) > 0 {
+		return
+	}
+	if len(repos) == 0 {
+		repos = []gitutil.Repo{gitutil.CurrentRepo}
+	}
+	for _, repo := range repos {
+		ref, err := gitutil.CurrentRef(repo)
+		if err != nil {
+			continue
+		}
+		if tbState.Refs == nil {
+			tbState.Refs = make(map[string]state.GitRef)
+		}
+		dirty, _ := gitutil.DirtyFiles(repo)
+		tbState.Refs[repo.Path] = state.GitRef{Branch: ref.Branch, Commit: ref.Base, Dirty: dirty}
+	}
+}
+
+// SessionBaseline returns what the uncommitted changes of the session with the given
+// state leave out: its task file, and the files that were changed before it started.
+func SessionBaseline(tbState *state.TimeBoxState) gitutil.Baseline {
+	base := gitutil.Baseline{TaskFile: tbState.File, Dirty: make(map[string]map[string]string)}
+	for path, ref := range tbState.Refs {
+		if len(ref.Dirty) > 0 {
+			base.Dirty[path] = ref.Dirty
+		}
+	}
+	return base
+}
@@ -0,0 +1,239 @@
+package gitwatcher
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gobox/internal/gitutil"
+)
+
+// gitRepo creates a repository with one commit in a temp dir and makes it the working directory.
+func gitRepo(t *testing.T) func(args ...string) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Chdir(dir)
+	return initRepo(t, dir)
+}
+
+// initRepo creates a repository with one commit in dir and returns a function running git in it.
+func initRepo(t *testing.T, dir string) func(args ...string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=Jane Doe", "GIT_AUTHOR_EMAIL=jane@example.com",
+			"GIT_COMMITTER_NAME=Jane Doe", "GIT_COMMITTER_EMAIL=jane@example.com",
+		)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, out)
+		}
+	}
+	git("init", "-q", "-b", "main")
+	// Only the user's own commits are credited to a session
+	git("config", "user.email", "jane@example.com")
+	git("commit", "-q", "--allow-empty", "-m", "Before the session")
+	return git
+}
+
+// sessionStart waits for the next whole second and returns it. Git stores commit times
+// in seconds, from a clock that may lag slightly behind time.Now.
+func sessionStart() time.Time {
+	start := time.Now().Truncate(time.Second).Add(time.Second)
+	time.Sleep(time.Until(start) + 50*time.Millisecond)
+	return start
+}
+
+func expectCommit(t *testing.T, gw *GitWatcher, subject string) {
+	t.Helper()
+	expectCommitIn(t, gw, gitutil.CurrentRepo.Name, subject)
+}
+
+func TestGitWatcherReportsCommitsWithoutPolling(t *testing.T) {
+	git := gitRepo(t)
+
+	start := sessionStart()
+
+	gw := NewGitWatcher(start, time.Hour)
+	gw.Start()
+	defer gw.Stop()
+
+	git("commit", "-q", "--allow-empty", "-m", "First")
+	expectCommit(t, gw, "First")
+
+	git("checkout", "-q", "-b", "feature/x")
+	git("commit", "-q", "--allow-empty", "-m", "On a branch")
+	expectCommit(t, gw, "On a branch")
+
+	// Checking out an older commit is not a new commit
+	git("checkout", "-q", "HEAD~2")
+	select {
+	case c := <-gw.Commits():
+		t.Errorf("unexpected commit %q", c.Subject)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+func TestGitWatcherPause(t *testing.T) {
+	git := gitRepo(t)
+	start := sessionStart()
+
+	gw := NewGitWatcher(start, time.Hour)
+	gw.Start()
+	defer gw.Stop()
+
+	gw.Pause()
+	git("commit", "-q", "--allow-empty", "-m", "While paused")
+	time.Sleep(300 * time.Millisecond)
+	if err := gw.Resume(); err != nil {
+		t.Fatalf("Resume failed: %v", err)
+	}
+
+	git("commit", "-q", "--allow-empty", "-m", "After resuming")
+	expectCommit(t, gw, "After resuming")
+}
+
+func TestGitWatcherResumeKeepsCommitsFromBeforeThePause(t *testing.T) {
+	git := gitRepo(t)
+	start := sessionStart()
+
+	gw := NewGitWatcher(start, time.Hour)
+	gw.Start()
+	defer gw.Stop()
+
+	// A commit from before the pause whose change is only noticed once paused
+	time.Sleep(time.Until(start.Add(time.Second)) + 50*time.Millisecond)
+	git("commit", "-q", "--allow-empty", "--date", start.Format(time.RFC3339), "-m", "Before pausing")
+	gw.Pause()
+	git("commit", "-q", "--allow-empty", "-m", "While paused")
+	time.Sleep(300 * time.Millisecond)
+
+	if err := gw.Resume(); err != nil {
+		t.Fatalf("Resume failed: %v", err)
+	}
+	expectCommit(t, gw, "Before pausing")
+	git("commit", "-q", "--allow-empty", "-m", "After resuming")
+	expectCommit(t, gw, "After resuming")
+}
+
+func TestGitWatcherPausesWhileCommitsAreWaiting(t *testing.T) {
+	git := gitRepo(t)
+	start := sessionStart()
+
+	gw := NewGitWatcher(start, time.Hour)
+	gw.Start()
+	defer gw.Stop()
+
+	// More commits than the channel holds, as a pull or rebase brings in, and nobody
+	// reading them yet
+	for i := 0; i < 15; i++ {
+		git("commit", "-q", "--allow-empty", "-m", fmt.Sprintf("Commit %d", i))
+	}
+	time.Sleep(300 * time.Millisecond)
+
+	paused := make(chan struct{})
+	go func() {
+		gw.Pause()
+		close(paused)
+	}()
+	select {
+	case <-paused:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Pause blocked while the watcher waited to send commits")
+	}
+}
+
+func TestGitWatcherWatchesSeveralRepos(t *testing.T) {
+	service := filepath.Join(t.TempDir(), "service")
+	lib := filepath.Join(t.TempDir(), "lib")
+	for _, dir := range []string{service, lib} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	gitService := initRepo(t, service)
+	gitLib := initRepo(t, lib)
+	start := sessionStart()
+
+	gw := NewGitWatcher(start, time.Hour)
+	gw.Repos = []gitutil.Repo{{Name: "service", Path: service}, {Name: "lib", Path: lib}}
+	gw.Start()
+	defer gw.Stop()
+
+	gitLib("commit", "-q", "--allow-empty", "-m", "In the library")
+	expectCommitIn(t, gw, "lib", "In the library")
+
+	gitService("commit", "-q", "--allow-empty", "-m", "In the service")
+	expectCommitIn(t, gw, "service", "In the service")
+
+	// Commits by others don't count
+	gitLib("commit", "-q", "--allow-empty", "--author", "Bob <bob@example.com>", "-m", "By a teammate")
+	gitLib("commit", "-q", "--allow-empty", "-m", "Mine again")
+	expectCommitIn(t, gw, "lib", "Mine again")
+}
+
+func expectCommitIn(t *testing.T, gw *GitWatcher, repo, subject string) {
+	t.Helper()
+	select {
+	case c := <-gw.Commits():
+		if c.Subject != subject || c.Repo != repo {
+			t.Errorf("expected commit %q in %s, got %q in %s", subject, repo, c.Subject, c.Repo)
+		}
+	case err := <-gw.Errors():
+		t.Fatalf("unexpected error: %v", err)
+	case <-time.After(3 * time.Second):
+		t.Fatalf("commit %q was not reported", subject)
+	}
+}
+
+func TestReflogReader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "HEAD")
+	old := "0000000000000000000000000000000000000000 1111111111111111111111111111111111111111 Jane <j@x> 1700000000 +0000\tcommit (initial): First\n"
+	if err := os.WriteFile(path, []byte(old), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := newReflogReader(path)
+	if entries, ok := r.readNew(); !ok || len(entries) != 0 {
+		t.Fatalf("expected no entries before anything was appended, got %v %v", entries, ok)
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// The second line is still being written
+	f.WriteString("1111111111111111111111111111111111111111 2222222222222222222222222222222222222222 Jane <j@x> 1700000060 +0000\tcommit: Second\n2222")
+
+	entries, ok := r.readNew()
+	if !ok || len(entries) != 1 {
+		t.Fatalf("expected 1 complete entry, got %v %v", entries, ok)
+	}
+	if entries[0].New != "2222222222222222222222222222222222222222" || entries[0].Message != "commit: Second" {
+		t.Errorf("unexpected entry %+v", entries[0])
+	}
+
+	f.WriteString("222222222222222222222222222222222222 3333333333333333333333333333333333333333 Jane <j@x> 1700000120 +0000\tcheckout: moving from main to x\n")
+	entries, ok = r.readNew()
+	if !ok || len(entries) != 1 || entries[0].Old != "2222222222222222222222222222222222222222" {
+		t.Fatalf("expected the rest of the partial line, got %v %v", entries, ok)
+	}
+
+	// A rewritten reflog can't be read incrementally
+	if err := os.WriteFile(path, []byte(old), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.readNew(); ok {
+		t.Error("expected a shrunk reflog to be reported")
+	}
+}
@@ -0,0 +1,89 @@
+package gitwatcher
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+)
+
+// reflogEntry is one line of a reflog: "<old> <new> <name> <email> <time> <tz>\t<message>".
+type reflogEntry struct {
+	Old     string
+	New     string
+	Message string
+}
+
+// reflogReader reads the entries appended to a reflog since the last read.
+type reflogReader struct {
+	path   string
+	offset int64
+}
+
+// newReflogReader returns a reader that starts at the current end of the reflog,
+// so only entries written from now on are read.
+func newReflogReader(path string) *reflogReader {
+	r := &reflogReader{path: path}
+	if info, err := os.Stat(path); err == nil {
+		r.offset = info.Size()
+	}
+	return r
+}
+
+// readNew returns the complete entries appended since the last call. ok is false if the
+// reflog is missing or was rewritten, e.g. by git reflog expire, in which case the caller
+// has to look for new commits some other way.
+func (r *reflogReader) readNew() (entries []reflogEntry, ok bool) {
+	f, err := os.Open(r.path)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, false
+	}
+	if info.Size() < r.offset {
+		r.offset = info.Size()
+		return nil, false
+	}
+	if info.Size() == r.offset {
+		return nil, true
+	}
+
+	data := make([]byte, info.Size()-r.offset)
+	n, err := f.ReadAt(data, r.offset)
+	if err != nil && err != io.EOF {
+		return nil, false
+	}
+	data = data[:n]
+
+	// Leave a partially written last line for the next read
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 {
+		return nil, true
+	}
+	r.offset += int64(end + 1)
+
+	for _, line := range strings.Split(string(data[:end]), "\n") {
+		if entry, ok := parseReflogLine(line); ok {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, true
+}
+
+func parseReflogLine(line string) (reflogEntry, bool) {
+	header, message, _ := strings.Cut(line, "\t")
+	fields := strings.Fields(header)
+	if len(fields) < 2 {
+		return reflogEntry{}, false
+	}
+	return reflogEntry{Old: fields[0], New: fields[1], Message: message}, true
+}
+
+// isZeroHash reports whether hash is the all-zero hash git uses for "no commit".
+func isZeroHash(hash string) bool {
+	return strings.Trim(hash, "0") == ""
+}
@@ -0,0 +1,201 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gobox/internal/gitutil"
+	"gobox/internal/parser"
+	"gobox/internal/state"
+	"gobox/pkg/task"
+)
+
+// Entry is a single completed session in the history log.
+type Entry struct {
+	TaskID      string              `json:"task_id"`      // Stable ID of the task, or its hash if it has none
+	Description string              `json:"description"`  // Task description at completion
+	File        string              `json:"file"`         // Absolute path of the markdown file the task lives in
+	TimeBox     string              `json:"timebox"`      // The raw timebox, e.g. "@1h" or "@[10:00-11:30]"
+	Planned     time.Duration       `json:"planned"`      // Planned duration derived from the timebox
+	Actual      time.Duration       `json:"actual"`       // Sum of all segments
+	Segments    []state.TimeSegment `json:"segments"`     // Work intervals of the session
+	Commits     []string            `json:"commits"`      // Commits made during the session, as oneline strings
+	Diff        gitutil.DiffSummary `json:"diff"`         // How much changed during the session
+	CompletedAt time.Time           `json:"completed_at"` // When the task was completed
+}
+
+// NewEntry builds a history entry for a task completed at completedAt.
+func NewEntry(file string, t task.Task, segments []state.TimeSegment, commits []gitutil.Commit, diff gitutil.DiffSummary, completedAt time.Time) Entry {
+	if abs, err := filepath.Abs(file); err == nil {
+		file = abs
+	}
+	var oneline []string
+	for _, c := range commits {
+		oneline = append(oneline, c.String())
+	}
+
+	return Entry{
+		TaskID:      t.Key(),
+		Description: t.Description,
+		File:        file,
+		TimeBox:     t.TimeBox,
+		Planned:     parser.TimeBoxLength(t.TimeBox),
+		Actual:      state.SumSegments(segments),
+		Segments:    segments,
+		Commits:     oneline,
+		Diff:        diff,
+		CompletedAt: completedAt,
+	}
+}
+
+// Log abstracts the append-only session history for testability.
+type Log interface {
+	Append(Entry) error
+	Entries() ([]Entry, error)
+}
+
+// FileLog implements Log as a JSONL file, one entry per line.
+type FileLog struct {
+	File string
+}
+
+func NewFileLog(file string) *FileLog {
+	return &FileLog{File: file}
+}
+
+// Append writes the entry as a new line at the end of the log file.
+func (fl *FileLog) Append(e Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(fl.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// Entries reads all entries in the order they were appended. A missing file is an empty log.
+func (fl *FileLog) Entries() ([]Entry, error) {
+	f, err := os.Open(fl.File)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			return nil, fmt.Errorf("invalid history entry at %s:%d: %w", fl.File, lineNo, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// InMemoryLog implements Log for testing (no disk I/O).
+type InMemoryLog struct {
+	mu      sync.Mutex
+	entries []Entry
+}
+
+func NewInMemoryLog() *InMemoryLog {
+	return &InMemoryLog{}
+}
+
+func (ml *InMemoryLog) Append(e Entry) error {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	ml.entries = append(ml.entries, e)
+	return nil
+}
+
+func (ml *InMemoryLog) Entries() ([]Entry, error) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	cpy := make([]Entry, len(ml.entries))
+	copy(cpy, ml.entries)
+	return cpy, nil
+}
+
+// Filter selects history entries. Zero fields match everything.
+type Filter struct {
+	Since time.Time // Only entries completed at or after Since
+	Until time.Time // Only entries completed before Until
+	File  string    // Only entries for this markdown file, matched by path or base name
+	Task  string    // Only entries whose task ID equals Task or whose description contains it (case-insensitive)
+}
+
+// Match reports whether the entry passes the filter.
+func (f Filter) Match(e Entry) bool {
+	if !f.Since.IsZero() && e.CompletedAt.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !e.CompletedAt.Before(f.Until) {
+		return false
+	}
+	if f.File != "" && !matchFile(f.File, e.File) {
+		return false
+	}
+	if f.Task != "" && e.TaskID != f.Task &&
+		!strings.Contains(strings.ToLower(e.Description), strings.ToLower(f.Task)) {
+		return false
+	}
+	return true
+}
+
+// Apply returns the entries that pass the filter, keeping their order.
+func (f Filter) Apply(entries []Entry) []Entry {
+	var matched []Entry
+	for _, e := range entries {
+		if f.Match(e) {
+			matched = append(matched, e)
+		}
+	}
+	return matched
+}
+
+// Querier is a Log that selects entries itself, such as a database with an index.
+type Querier interface {
+	Query(Filter) ([]Entry, error)
+}
+
+// Query returns the entries of log that pass the filter, in the order they were appended.
+func Query(log Log, f Filter) ([]Entry, error) {
+	if q, ok := log.(Querier); ok {
+		return q.Query(f)
+	}
+	entries, err := log.Entries()
+	if err != nil {
+		return nil, err
+	}
+	return f.Apply(entries), nil
+}
+
+func matchFile(want, file string) bool {
+	if abs, err := filepath.Abs(want); err == nil && abs == file {
+		return true
+	}
+	return filepath.Base(want) == want && filepath.Base(file) == want
+}
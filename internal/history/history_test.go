@@ -0,0 +1,99 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gobox/internal/gitutil"
+	"gobox/internal/state"
+	"gobox/pkg/task"
+)
+
+func TestFileLog_AppendAndEntries(t *testing.T) {
+	tmpDir := t.TempDir()
+	log := NewFileLog(filepath.Join(tmpDir, "history.jsonl"))
+
+	// A missing file is an empty log
+	entries, err := log.Entries()
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("expected empty log, got %v, %v", entries, err)
+	}
+
+	start := time.Now().UTC().Truncate(time.Second).Add(-time.Hour)
+	end := start.Add(40 * time.Minute)
+	tk := task.Task{ID: "abc123", Description: "Write docs", TimeBox: "@30m"}
+	first := NewEntry("tasks.md", tk, []state.TimeSegment{{Start: start, End: &end}}, []gitutil.Commit{{ShortHash: "1234567", Subject: "Add docs"}}, gitutil.DiffSummary{}, end)
+
+	if err := log.Append(first); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if err := log.Append(NewEntry("other.md", task.Task{Description: "Other"}, nil, nil, gitutil.DiffSummary{}, end)); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+
+	entries, err = log.Entries()
+	if err != nil {
+		t.Fatalf("Entries failed: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+
+	got := entries[0]
+	if got.TaskID != "abc123" || got.Description != "Write docs" || !filepath.IsAbs(got.File) {
+		t.Errorf("unexpected entry: %+v", got)
+	}
+	if got.Planned != 30*time.Minute || got.Actual != 40*time.Minute {
+		t.Errorf("planned/actual = %v/%v, want 30m/40m", got.Planned, got.Actual)
+	}
+	if len(got.Commits) != 1 || len(got.Segments) != 1 || !got.CompletedAt.Equal(end) {
+		t.Errorf("commits, segments or completion time not kept: %+v", got)
+	}
+
+	// The log is append-only: existing lines are left alone
+	data, err := os.ReadFile(log.File)
+	if err != nil {
+		t.Fatalf("failed to read log: %v", err)
+	}
+	if err := log.Append(first); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	after, _ := os.ReadFile(log.File)
+	if string(after[:len(data)]) != string(data) {
+		t.Errorf("existing history lines were modified")
+	}
+}
+
+func TestFilter_Match(t *testing.T) {
+	day := time.Date(2025, 6, 2, 15, 0, 0, 0, time.Local)
+	abs, _ := filepath.Abs("tasks.md")
+	entry := Entry{TaskID: "abc123", Description: "Write release notes", File: abs, CompletedAt: day}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   bool
+	}{
+		{name: "empty filter", filter: Filter{}, want: true},
+		{name: "since before", filter: Filter{Since: day.Add(-time.Hour)}, want: true},
+		{name: "since after", filter: Filter{Since: day.Add(time.Hour)}, want: false},
+		{name: "until after", filter: Filter{Until: day.Add(time.Hour)}, want: true},
+		{name: "until exclusive", filter: Filter{Until: day}, want: false},
+		{name: "file by path", filter: Filter{File: abs}, want: true},
+		{name: "file by base name", filter: Filter{File: "tasks.md"}, want: true},
+		{name: "other file", filter: Filter{File: "other.md"}, want: false},
+		{name: "task by id", filter: Filter{Task: "abc123"}, want: true},
+		{name: "task by text", filter: Filter{Task: "release"}, want: true},
+		{name: "other task", filter: Filter{Task: "deploy"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Match(entry); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
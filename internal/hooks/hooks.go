@@ -0,0 +1,174 @@
+// Package hooks installs the git hooks that tag commits with the task being worked on,
+// and implements what they do.
+package hooks
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"gobox/internal/gitutil"
+	"gobox/internal/state"
+)
+
+// Names are the hooks gobox installs.
+var Names = []string{"prepare-commit-msg", "post-commit"}
+
+// marker identifies hook scripts written by Install.
+const marker = "# Installed by gobox hooks install"
+
+// backupSuffix is appended to the name of a hook that was in place before Install. The
+// gobox hook runs it first, and Uninstall puts it back.
+const backupSuffix = ".pre-gobox"
+
+// Install writes the gobox hooks into the repository containing repoPath. command is the
+// shell command the hooks run, followed by the hook name and its arguments. Existing
+// hooks are kept and run before the gobox ones.
+func Install(repoPath, command string) (string, error) {
+	dir, err := gitutil.HooksDir(repoPath)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	for _, name := range Names {
+		path := filepath.Join(dir, name)
+		installed, err := isInstalled(path)
+		if err != nil {
+			return dir, err
+		}
+		if !installed {
+			if err := backUp(path); err != nil {
+				return dir, err
+			}
+		}
+		if err := os.WriteFile(path, []byte(script(name, command)), 0755); err != nil {
+			return dir, err
+		}
+	}
+	return dir, nil
+}
+
+// Uninstall removes the gobox hooks from the repository containing repoPath and puts back
+// the hooks they replaced. Hooks gobox didn't write are left alone.
+func Uninstall(repoPath string) (string, error) {
+	dir, err := gitutil.HooksDir(repoPath)
+	if err != nil {
+		return "", err
+	}
+
+	for _, name := range Names {
+		path := filepath.Join(dir, name)
+		installed, err := isInstalled(path)
+		if err != nil {
+			return dir, err
+		}
+		if !installed {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return dir, err
+		}
+		if err := os.Rename(path+backupSuffix, path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return dir, err
+		}
+	}
+	return dir, nil
+}
+
+// isInstalled reports whether the hook at path was written by Install.
+func isInstalled(path string) (bool, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return strings.Contains(string(content), marker), nil
+}
+
+// backUp moves an existing hook out of the way, refusing to overwrite an earlier backup.
+func backUp(path string) error {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if _, err := os.Stat(path + backupSuffix); err == nil {
+		return fmt.Errorf("%s and %s both exist, remove one of them first", path, path+backupSuffix)
+	}
+	return os.Rename(path, path+backupSuffix)
+}
+
+// script returns the hook script for the named hook. gobox failing must never stop a commit.
+func script(name, command string) string {
+	return fmt.Sprintf(`#!/bin/sh
+%s
+if [ -x "$0%s" ]; then
+	"$0%s" "$@" || exit $?
+fi
+%s %s "$@" || true
+`, marker, backupSuffix, backupSuffix, command, name)
+}
+
+// ShellQuote quotes s for use as a single word in a POSIX shell script.
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Active returns the state of the session that is running right now, nil if there is
+// none. Commits made while a session is paused are not tagged.
+func Active(states []state.TimeBoxState) *state.TimeBoxState {
+	var active *state.TimeBoxState
+	for i := range states {
+		s := &states[i]
+		if s.IsActive() && (active == nil || s.UpdatedAt().After(active.UpdatedAt())) {
+			active = s
+		}
+	}
+	return active
+}
+
+// tagMessage adds a trailer naming task to the commit message in msgFile. A message that
+// is already tagged, e.g. when amending a commit, keeps its task.
+func tagMessage(msgFile, task string) error {
+	cmd := exec.Command("git", "interpret-trailers", "--in-place", "--if-exists", "doNothing",
+		"--trailer", gitutil.TaskTrailer+": "+task, msgFile)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git interpret-trailers failed: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// PrepareCommitMsg implements the prepare-commit-msg hook: it tags the commit message
+// with the running session's task.
+func PrepareCommitMsg(states []state.TimeBoxState, msgFile string) error {
+	active := Active(states)
+	if active == nil {
+		return nil
+	}
+	return tagMessage(msgFile, active.Key())
+}
+
+// PostCommit implements the post-commit hook: it returns a note naming the task the new
+// commit was credited to, or "" if it isn't tagged.
+func PostCommit(states []state.TimeBoxState) (string, error) {
+	commit, err := gitutil.GetCommit("HEAD")
+	if err != nil {
+		return "", err
+	}
+	if commit.Task == "" {
+		return "", nil
+	}
+	name := commit.Task
+	for i := range states {
+		if states[i].Key() == commit.Task && states[i].Description != "" {
+			name = states[i].Description
+		}
+	}
+	return fmt.Sprintf("gobox: %s credited to %q", commit.ShortHash, name), nil
+}
@@ -0,0 +1,100 @@
+package hooks
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gobox/internal/state"
+)
+
+func gitRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	if out, err := exec.Command("git", "init", "-q", dir).CombinedOutput(); err != nil {
+		t.Fatalf("git init failed: %v\n%s", err, out)
+	}
+	return dir
+}
+
+func TestInstallKeepsExistingHooks(t *testing.T) {
+	repo := gitRepo(t)
+	hooksDir := filepath.Join(repo, ".git", "hooks")
+	if err := os.MkdirAll(hooksDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	existing := "#!/bin/sh\necho lint\n"
+	if err := os.WriteFile(filepath.Join(hooksDir, "post-commit"), []byte(existing), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Installing twice must not back up the gobox hook over the user's one
+	for i := 0; i < 2; i++ {
+		if _, err := Install(repo, "'/usr/bin/gobox' hooks run"); err != nil {
+			t.Fatalf("Install failed: %v", err)
+		}
+	}
+	for _, name := range Names {
+		content, err := os.ReadFile(filepath.Join(hooksDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(content), "'/usr/bin/gobox' hooks run "+name+` "$@"`) {
+			t.Errorf("unexpected %s hook:\n%s", name, content)
+		}
+	}
+	backup, err := os.ReadFile(filepath.Join(hooksDir, "post-commit"+backupSuffix))
+	if err != nil || string(backup) != existing {
+		t.Fatalf("expected the existing hook to be kept, got %q, %v", backup, err)
+	}
+
+	if _, err := Uninstall(repo); err != nil {
+		t.Fatalf("Uninstall failed: %v", err)
+	}
+	restored, err := os.ReadFile(filepath.Join(hooksDir, "post-commit"))
+	if err != nil || string(restored) != existing {
+		t.Errorf("expected the existing hook to be restored, got %q, %v", restored, err)
+	}
+	if _, err := os.Stat(filepath.Join(hooksDir, "prepare-commit-msg")); !os.IsNotExist(err) {
+		t.Errorf("expected prepare-commit-msg to be removed, got %v", err)
+	}
+}
+
+func TestPrepareCommitMsgTagsRunningSession(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	msgFile := filepath.Join(t.TempDir(), "COMMIT_EDITMSG")
+	message := "Fix the parser\n\n# Please enter the commit message for your changes.\n"
+	if err := os.WriteFile(msgFile, []byte(message), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	end := time.Now()
+	states := []state.TimeBoxState{
+		{TaskID: "paused", Segments: []state.TimeSegment{{Start: end.Add(-time.Hour), End: &end}}},
+		{TaskID: "running", Segments: []state.TimeSegment{{Start: end}}},
+	}
+	if err := PrepareCommitMsg(states, msgFile); err != nil {
+		t.Fatalf("PrepareCommitMsg failed: %v", err)
+	}
+	// Amending keeps the task the commit was made for
+	states[1].TaskID = "other"
+	if err := PrepareCommitMsg(states, msgFile); err != nil {
+		t.Fatalf("PrepareCommitMsg failed: %v", err)
+	}
+
+	content, err := os.ReadFile(msgFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(content), "Fix the parser\n\nGobox-Task: running\n") || strings.Contains(string(content), "other") {
+		t.Errorf("unexpected commit message:\n%s", content)
+	}
+}
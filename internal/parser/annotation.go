@@ -0,0 +1,183 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"gobox/internal/gitutil"
+	"gobox/internal/state"
+	"gobox/pkg/task"
+)
+
+// Built-in annotation formats, see AnnotationFormat.
+const (
+	FormatEmoji   = "emoji"   // ⏱️, 📊 and 📝 bullets, the default
+	FormatPlain   = "plain"   // the same without emoji
+	FormatClassic = "classic" // the "* Completed:" and "* Duration:" bullets of early gobox versions
+)
+
+var annotationFormats = map[string]string{
+	FormatEmoji: `
+{{- if .Actual}}* ⏱️ {{hms .Actual}}{{if isRange .Task.TimeBox}} ({{clock .Start}}-{{clock .End}}){{end}}
+{{end}}
+{{- if not .Diff.IsEmpty}}* 📊 {{.Diff}}
+{{end}}
+{{- if .Commits}}* 📝 Commits:
+{{template "commits" .}}{{end}}`,
+
+	FormatPlain: `
+{{- if .Actual}}* Time spent: {{hms .Actual}}{{if isRange .Task.TimeBox}} ({{clock .Start}}-{{clock .End}}){{end}}
+{{end}}
+{{- if not .Diff.IsEmpty}}* Changes: {{.Diff}}
+{{end}}
+{{- if .Commits}}* Commits:
+{{template "commits" .}}{{end}}`,
+
+	FormatClassic: `
+{{- "" -}}
+* Completed: {{.CompletedAt.Format "2006-01-02 15:04 MST"}}
+{{if .Actual}}* Duration: {{seconds .Actual}}
+{{end}}
+{{- if .Commits}}* Commits during task:
+{{range .Commits}}    - {{.ShortHash}} {{.Subject}}
+{{end}}{{end}}`,
+}
+
+// commitsTemplate lists the commits in backticks, grouped by repository if they come
+// from several.
+const commitsTemplate = `
+{{- if gt (len .Groups) 1}}
+{{- range .Groups}}  - {{or .Repo "(unknown repository)"}}
+{{range .Commits}}    - ` + "`{{.}}`" + `
+{{end}}{{end}}
+{{- else}}
+{{- range .Commits}}  - ` + "`{{.}}`" + `
+{{end}}{{end}}`
+
+// Annotation renders the lines added below a task when it is completed from a
+// text/template executed with AnnotationData. Each line of the output becomes a line
+// nested below the task; blank lines are dropped.
+type Annotation struct {
+	tmpl *template.Template
+}
+
+// AnnotationData is what annotation templates are executed with.
+type AnnotationData struct {
+	Task        task.Task
+	CompletedAt time.Time           // End of the last segment
+	Planned     time.Duration       // Length of the timebox
+	Actual      time.Duration       // Time spent in all segments
+	Start, End  time.Time           // First segment start and last segment end
+	Segments    []state.TimeSegment // Closed segments of the sessions spent on the task
+	Commits     []gitutil.Commit    // Commits credited to the task, with URLs if known
+	Groups      []CommitGroup       // Commits by repository, in order of appearance
+	Diff        gitutil.DiffSummary // Changes made by the commits and left uncommitted
+}
+
+// CommitGroup holds the commits made in one repository.
+type CommitGroup struct {
+	Repo    string
+	Commits []gitutil.Commit
+}
+
+// annotationFuncs are the functions available to annotation templates besides the
+// text/template builtins.
+var annotationFuncs = template.FuncMap{
+	// hms formats a duration as "1h 2m 3s"
+	"hms": func(d time.Duration) string {
+		return fmt.Sprintf("%dh %dm %ds", int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60)
+	},
+	// seconds drops the fractions of a second from a duration
+	"seconds": func(d time.Duration) time.Duration { return d.Truncate(time.Second) },
+	// clock formats a time as "15:04"
+	"clock":   func(t time.Time) string { return t.Format("15:04") },
+	"isRange": IsTimeRange,
+	"join":    strings.Join,
+}
+
+// DefaultAnnotation is the annotation in FormatEmoji.
+var DefaultAnnotation = mustAnnotation(annotationFormats[FormatEmoji])
+
+// NewAnnotation parses an annotation template. Besides the text/template builtins it can
+// use hms, seconds, clock, isRange and join, and {{template "commits" .}} for the default commit list.
+func NewAnnotation(text string) (*Annotation, error) {
+	tmpl, err := template.New("annotation").Funcs(annotationFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid annotation template: %w", err)
+	}
+	if _, err := tmpl.New("commits").Parse(commitsTemplate); err != nil {
+		return nil, err
+	}
+	return &Annotation{tmpl: tmpl}, nil
+}
+
+// AnnotationFormat returns the built-in annotation with the given name, see FormatEmoji,
+// FormatPlain and FormatClassic.
+func AnnotationFormat(name string) (*Annotation, error) {
+	text, ok := annotationFormats[name]
+	if !ok {
+		formats := make([]string, 0, len(annotationFormats))
+		for f := range annotationFormats {
+			formats = append(formats, f)
+		}
+		sort.Strings(formats)
+		return nil, fmt.Errorf("unknown annotation format %q, expected one of %s", name, strings.Join(formats, ", "))
+	}
+	return NewAnnotation(text)
+}
+
+func mustAnnotation(text string) *Annotation {
+	a, err := NewAnnotation(text)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// NewAnnotationData collects what an annotation template can show about a completed task.
+func NewAnnotationData(t task.Task, commits []gitutil.Commit, segments []state.TimeSegment, diff gitutil.DiffSummary) AnnotationData {
+	data := AnnotationData{
+		Task:     t,
+		Planned:  TimeBoxLength(t.TimeBox),
+		Actual:   state.SumSegments(segments),
+		Segments: segments,
+		Commits:  commits,
+		Diff:     diff,
+	}
+	if start, end, ok := actualRange(segments); ok {
+		data.Start, data.End = start, end
+		data.CompletedAt = end
+	} else {
+		data.CompletedAt = time.Now()
+	}
+
+	byRepo := make(map[string]int)
+	for _, c := range commits {
+		i, ok := byRepo[c.Repo]
+		if !ok {
+			i = len(data.Groups)
+			byRepo[c.Repo] = i
+			data.Groups = append(data.Groups, CommitGroup{Repo: c.Repo})
+		}
+		data.Groups[i].Commits = append(data.Groups[i].Commits, c)
+	}
+	return data
+}
+
+// Render executes the annotation template and returns its non-blank lines.
+func (a *Annotation) Render(data AnnotationData) ([]string, error) {
+	var sb strings.Builder
+	if err := a.tmpl.Execute(&sb, data); err != nil {
+		return nil, fmt.Errorf("failed to render annotation: %w", err)
+	}
+	var lines []string
+	for _, line := range strings.Split(sb.String(), "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, strings.TrimRight(line, " \t"))
+		}
+	}
+	return lines, nil
+}
@@ -0,0 +1,83 @@
+package parser_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"gobox/internal/gitutil"
+	"gobox/internal/parser"
+	"gobox/internal/state"
+	"gobox/pkg/task"
+)
+
+func TestAnnotationFormats(t *testing.T) {
+	start := time.Date(2025, 5, 25, 22, 25, 0, 0, time.UTC)
+	end := start.Add(4*time.Minute + 29*time.Second + 300*time.Millisecond)
+	segments := []state.TimeSegment{{Start: start, End: &end}}
+	commits := []gitutil.Commit{{Hash: "7605b74aa", ShortHash: "7605b74", Subject: "Use gomarkdown to parse markdown files"}}
+	data := parser.NewAnnotationData(task.Task{Description: "Parse markdown", TimeBox: "@1h"}, commits, segments, gitutil.DiffSummary{})
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{parser.FormatEmoji, "* ⏱️ 0h 4m 29s\n* 📝 Commits:\n  - `7605b74 Use gomarkdown to parse markdown files`"},
+		{parser.FormatPlain, "* Time spent: 0h 4m 29s\n* Commits:\n  - `7605b74 Use gomarkdown to parse markdown files`"},
+		{parser.FormatClassic, "* Completed: 2025-05-25 22:29 UTC\n* Duration: 4m29s\n* Commits during task:\n    - 7605b74 Use gomarkdown to parse markdown files"},
+	}
+	for _, tt := range tests {
+		annotation, err := parser.AnnotationFormat(tt.format)
+		if err != nil {
+			t.Fatalf("AnnotationFormat(%q) failed: %v", tt.format, err)
+		}
+		lines, err := annotation.Render(data)
+		if err != nil {
+			t.Fatalf("Render failed: %v", err)
+		}
+		if got := strings.Join(lines, "\n"); got != tt.want {
+			t.Errorf("%s format:\ngot  %q\nwant %q", tt.format, got, tt.want)
+		}
+	}
+
+	if _, err := parser.AnnotationFormat("fancy"); err == nil {
+		t.Errorf("expected an error for an unknown format")
+	}
+}
+
+func TestUpdateMarkdownWithTemplate(t *testing.T) {
+	tmpFile, err := createTempFileWithContent("- [ ] Fix parser bug @30m\n  - [ ] Nested @10m\n")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	annotation, err := parser.NewAnnotation(`- Planned {{.Planned}}, took {{.Actual}}
+{{range .Commits}}- [{{.ShortHash}}]({{.URL}}) {{.Subject}} by {{.Author}}
+{{end}}`)
+	if err != nil {
+		t.Fatalf("NewAnnotation failed: %v", err)
+	}
+
+	start := time.Now().Add(-20 * time.Minute)
+	end := start.Add(20 * time.Minute)
+	commits := []gitutil.Commit{{Hash: "abc1234def", ShortHash: "abc1234", Subject: "Fix the lexer", Author: "Jane Doe",
+		URL: "https://github.com/acme/gobox/commit/abc1234def"}}
+	nested := task.Task{Description: "Nested", TimeBox: "@10m", IsChecked: true}
+	if err := annotation.UpdateMarkdown(tmpFile.Name(), nil, nested, commits, []state.TimeSegment{{Start: start, End: &end}}, gitutil.DiffSummary{}); err != nil {
+		t.Fatalf("UpdateMarkdown failed: %v", err)
+	}
+
+	updatedContent, err := os.ReadFile(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("failed to read updated file: %v", err)
+	}
+	want := "  - [x] Nested @10m\n" +
+		"    <!-- gobox:annotation -->\n" +
+		"    - Planned 10m0s, took 20m0s\n" +
+		"    - [abc1234](https://github.com/acme/gobox/commit/abc1234def) Fix the lexer by Jane Doe\n"
+	if !strings.Contains(string(updatedContent), want) {
+		t.Errorf("unexpected content:\n%s", updatedContent)
+	}
+}
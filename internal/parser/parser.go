@@ -2,14 +2,20 @@ package parser
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
+	"gobox/internal/backup"
+	"gobox/internal/fileutil"
+	"gobox/internal/gitutil"
 	"gobox/internal/rewrite"
+	"gobox/internal/state"
 	"gobox/pkg/task"
 
 	"github.com/yuin/goldmark"
@@ -67,9 +73,10 @@ func extractTextSkippingNode(n ast.Node, skip ast.Node, content []byte, builder
 	}
 }
 
-func ExtractTask(node ast.Node, content []byte) (*task.Task, bool) {
-	re := regexp.MustCompile(`(@(?:\d+h\d+m|\d+h|\d+m))(?:\s|$)`)
+// timeBoxRe matches a trailing timebox: a duration such as `@1h30m` or a time range such as `@[10:00-11:30]`.
+var timeBoxRe = regexp.MustCompile(`(@(?:\[\d{1,2}:\d{2}\s*-\s*\d{1,2}:\d{2}\]|\d+h\d+m|\d+h|\d+m))(?:\s|$)`)
 
+func ExtractTask(node ast.Node, content []byte) (*task.Task, bool) {
 	if check, ok := node.(*east.TaskCheckBox); ok {
 		listItem := FindParentListItem(check)
 		if listItem == nil {
@@ -79,12 +86,17 @@ func ExtractTask(node ast.Node, content []byte) (*task.Task, bool) {
 		var descBuilder strings.Builder
 
 		// Extract text from all children of the list item, skipping the checkbox
+		// and any nested lists, which hold subtasks rather than description text
 		for c := listItem.FirstChild(); c != nil; c = c.NextSibling() {
+			if c.Kind() == ast.KindList {
+				continue
+			}
 			extractTextSkippingNode(c, check, content, &descBuilder)
 		}
 
-		descText := strings.TrimSpace(descBuilder.String())
-		matches := re.FindSubmatch([]byte(descText))
+		// The scope is read from the source, since "**" in it may be parsed as emphasis
+		descText := strings.TrimSpace(scopeRe.ReplaceAllString(descBuilder.String(), ""))
+		matches := timeBoxRe.FindSubmatch([]byte(descText))
 		timeBox := ""
 
 		if len(matches) > 1 {
@@ -95,9 +107,11 @@ func ExtractTask(node ast.Node, content []byte) (*task.Task, bool) {
 		itemText = strings.TrimSpace(itemText)
 
 		return &task.Task{
+			ID:          extractTaskID(listItem, content),
 			Description: itemText,
 			TimeBox:     timeBox,
 			IsChecked:   check.IsChecked,
+			Scope:       extractScope(listItem, content),
 			Position:    task.Position{},
 		}, true
 	}
@@ -105,7 +119,95 @@ func ExtractTask(node ast.Node, content []byte) (*task.Task, bool) {
 	return nil, false
 }
 
+// idMarkerRe matches the invisible marker that stores a task's stable ID, e.g. `<!-- gobox:id=1a2b3c4d -->`.
+var idMarkerRe = regexp.MustCompile(`<!--\s*gobox:id=([A-Za-z0-9_-]+)\s*-->`)
+
+// FormatIDMarker returns the marker that stores the given task ID in the markdown.
+func FormatIDMarker(id string) string {
+	return fmt.Sprintf("<!-- gobox:id=%s -->", id)
+}
+
+// extractTaskID returns the ID stored in an inline id marker of the list item, if any.
+func extractTaskID(listItem ast.Node, content []byte) string {
+	for c := listItem.FirstChild(); c != nil; c = c.NextSibling() {
+		if c.Kind() == ast.KindList {
+			continue
+		}
+		for n := c.FirstChild(); n != nil; n = n.NextSibling() {
+			raw, ok := n.(*ast.RawHTML)
+			if !ok {
+				continue
+			}
+			var html []byte
+			for i := 0; i < raw.Segments.Len(); i++ {
+				segment := raw.Segments.At(i)
+				html = append(html, segment.Value(content)...)
+			}
+			if m := idMarkerRe.FindSubmatch(html); m != nil {
+				return string(m[1])
+			}
+		}
+	}
+	return ""
+}
+
+// scopeRe matches a task's scope, the paths it is meant to change, e.g.
+// `{scope: internal/parser/**, docs/*.md}`.
+var scopeRe = regexp.MustCompile(`\{\s*scope:\s*([^}]*)\}`)
+
+// extractScope returns the glob patterns in the scope of the list item, nil if it has none.
+// Patterns are separated by commas or spaces.
+func extractScope(listItem ast.Node, content []byte) []string {
+	var scope []string
+	for c := listItem.FirstChild(); c != nil; c = c.NextSibling() {
+		if c.Kind() == ast.KindList {
+			continue
+		}
+		lines := c.Lines()
+		for i := 0; i < lines.Len(); i++ {
+			segment := lines.At(i)
+			for _, m := range scopeRe.FindAllSubmatch(segment.Value(content), -1) {
+				scope = append(scope, strings.FieldsFunc(string(m[1]), func(r rune) bool {
+					return r == ',' || r == ' ' || r == '\t'
+				})...)
+			}
+		}
+	}
+	return scope
+}
+
+// reposMarkerRe matches the marker listing the repositories whose commits count towards the
+// tasks of a file, e.g. `<!-- gobox:repos=../service, ../shared-lib -->`.
+var reposMarkerRe = regexp.MustCompile(`<!--\s*gobox:repos=(.*?)\s*-->`)
+
+// ParseRepos returns the repository paths listed in the file's repos markers, with relative
+// paths resolved against the file's directory. It returns nil if the file lists none.
+func ParseRepos(filename string) ([]string, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file %s: %w", filename, err)
+	}
+
+	var paths []string
+	for _, m := range reposMarkerRe.FindAllSubmatch(content, -1) {
+		for _, path := range strings.Split(string(m[1]), ",") {
+			path = strings.TrimSpace(path)
+			if path == "" {
+				continue
+			}
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(filepath.Dir(filename), path)
+			}
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
 // ParseMarkdownFile reads the markdown file and extracts tasks with time boxes.
+// Tasks are returned in document order; nested checklists are linked through
+// Parent and Children, and parents without an explicit timebox get the sum of their
+// children's timeboxes.
 func ParseMarkdownFile(filename string) ([]task.Task, error) {
 	content, err := os.ReadFile(filename)
 	if err != nil {
@@ -117,7 +219,27 @@ func ParseMarkdownFile(filename string) ([]task.Task, error) {
 	reader := text.NewReader(content)
 	rootNode := md.Parser().Parse(reader)
 
-	tasks := []task.Task{}
+	parsed := parseTaskTree(rootNode, content)
+
+	tasks := make([]task.Task, 0, len(parsed))
+	for _, tn := range parsed {
+		tasks = append(tasks, *tn.task)
+	}
+
+	return tasks, nil
+}
+
+// taskNode pairs a parsed task with the checkbox node it was extracted from.
+type taskNode struct {
+	node ast.Node
+	task *task.Task
+}
+
+// parseTaskTree extracts all tasks below rootNode in document order, links nested tasks
+// to their parents and rolls up timeboxes.
+func parseTaskTree(rootNode ast.Node, content []byte) []taskNode {
+	byListItem := make(map[ast.Node]*task.Task)
+	var parsed []taskNode
 
 	// Traverse the AST to find list items
 	ast.Walk(rootNode, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
@@ -125,14 +247,122 @@ func ParseMarkdownFile(filename string) ([]task.Task, error) {
 			return ast.WalkContinue, nil
 		}
 
-		if task, ok := ExtractTask(node, content); ok {
-			tasks = append(tasks, *task)
+		if t, ok := ExtractTask(node, content); ok {
+			listItem := FindParentListItem(node)
+			for p := FindParentListItem(listItem); p != nil; p = FindParentListItem(p) {
+				if parent, ok := byListItem[p]; ok {
+					t.Parent = parent
+					parent.Children = append(parent.Children, t)
+					break
+				}
+			}
+			byListItem[listItem] = t
+			parsed = append(parsed, taskNode{node: node, task: t})
 		}
 
 		return ast.WalkContinue, nil
 	})
 
-	return tasks, nil
+	for _, tn := range parsed {
+		if tn.task.Parent == nil {
+			rollUpTimeBox(tn.task)
+		}
+	}
+
+	return parsed
+}
+
+// findTaskNode returns the parsed task that target refers to, or nil.
+// Tasks are matched by ID, then by content hash. If neither matches, the unchecked task whose
+// description is most similar to target's is used, as long as it doesn't carry a different ID.
+// Two tasks that are equally similar match neither, rather than the wrong one.
+func findTaskNode(parsed []taskNode, target task.Task) *taskNode {
+	if target.ID != "" {
+		for i := range parsed {
+			if parsed[i].task.ID == target.ID {
+				return &parsed[i]
+			}
+		}
+	}
+
+	targetHash := target.Hash()
+	for i := range parsed {
+		if parsed[i].task.Hash() == targetHash {
+			return &parsed[i]
+		}
+	}
+
+	var best *taskNode
+	var bestScore float64
+	tied := false
+	for i := range parsed {
+		if parsed[i].task.IsChecked || (parsed[i].task.ID != "" && parsed[i].task.ID != target.ID) {
+			continue
+		}
+		score := task.Similarity(parsed[i].task.Description, target.Description)
+		switch {
+		case score < task.FuzzyMatchThreshold:
+		case best == nil || score > bestScore:
+			best, bestScore, tied = &parsed[i], score, false
+		case score == bestScore:
+			tied = true
+		}
+	}
+	if tied {
+		return nil
+	}
+	return best
+}
+
+// rollUpTimeBox sets the timebox of tasks without an explicit one to the sum of their
+// children's timeboxes, and returns the task's effective timebox duration.
+func rollUpTimeBox(t *task.Task) time.Duration {
+	var sum time.Duration
+	for _, child := range t.Children {
+		sum += rollUpTimeBox(child)
+	}
+
+	if t.TimeBox != "" {
+		return TimeBoxLength(t.TimeBox)
+	}
+
+	if sum > 0 {
+		t.TimeBox = FormatTimeBox(sum)
+		t.RolledUp = true
+	}
+	return sum
+}
+
+// TimeBoxLength returns the planned length of a timebox, or zero if it can't be parsed.
+// For time ranges this is the length of the range.
+func TimeBoxLength(timeBox string) time.Duration {
+	if IsTimeRange(timeBox) {
+		start, end, err := ParseTimeRange(timeBox)
+		if err != nil {
+			return 0
+		}
+		return end.Sub(start)
+	}
+	duration, _, err := ParseTimeBox(timeBox)
+	if err != nil {
+		return 0
+	}
+	return duration
+}
+
+// FormatTimeBox formats a duration as a timebox string such as `@1h30m`.
+func FormatTimeBox(d time.Duration) string {
+	hours := int(d.Hours())
+	minutes := int(d.Minutes()) % 60
+
+	switch {
+	case hours > 0 && minutes > 0:
+		return fmt.Sprintf("@%dh%dm", hours, minutes)
+	case hours > 0:
+		return fmt.Sprintf("@%dh", hours)
+	default:
+		return fmt.Sprintf("@%dm", minutes)
+	}
 }
 
 // ParseTimeBox parses the timebox string into a duration or an end time.
@@ -149,28 +379,10 @@ func ParseTimeBox(timeBox string) (time.Duration, time.Time, error) {
 
 	// Check for time range syntax: [HH:MM-HH:MM]
 	if strings.HasPrefix(timeBox, "[") && strings.HasSuffix(timeBox, "]") {
-		timeRangeStr := strings.Trim(timeBox, "[]")
-		parts := strings.Split(timeRangeStr, "-")
-		if len(parts) != 2 {
-			return 0, time.Time{}, fmt.Errorf("invalid time range format: %s. Expected [HH:MM-HH:MM]", timeBox)
-		}
-
-		endStr := strings.TrimSpace(parts[1])
-
-		now := time.Now()
-		endTime, err := time.Parse("15:04", endStr)
+		_, endTime, err := parseTimeRange(timeBox, time.Now())
 		if err != nil {
-			return 0, time.Time{}, fmt.Errorf("invalid end time format in %s: %w", timeBox, err)
-		}
-		// Set the year, month, day to today's date
-		endTime = time.Date(now.Year(), now.Month(), now.Day(), endTime.Hour(), endTime.Minute(), 0, 0, now.Location())
-
-		// If the end time is in the past (e.g., 10:00 AM when it's 2:00 PM),
-		// assume it's for the next day.
-		if endTime.Before(now) {
-			endTime = endTime.Add(24 * time.Hour)
+			return 0, time.Time{}, err
 		}
-
 		return 0, endTime, nil // Return 0 duration, valid end time
 	}
 
@@ -202,80 +414,482 @@ func ParseTimeBox(timeBox string) (time.Duration, time.Time, error) {
 	return 0, time.Time{}, fmt.Errorf("unsupported timebox format: %s. Expected @1h, @30m, @1h30m or @[HH:MM-HH:MM]", timeBox)
 }
 
-// UpdateMarkdown updates the task, adds commits, and records actual time spent in the markdown file.
-// totalDuration should be the sum of all time segments for the task.
+// ParseTimeRange parses a time range timebox such as `@[10:00-11:30]` into its start and end time.
+// Both times are placed on today's date. A range whose end is not after its start is assumed to run
+// past midnight, and a range that has already ended is assumed to be for the next day.
+func ParseTimeRange(timeBox string) (time.Time, time.Time, error) {
+	return parseTimeRange(strings.TrimPrefix(timeBox, "@"), time.Now())
+}
+
+// ParseTimeRangeAt parses a time range timebox like ParseTimeRange, as of at rather than now.
+// Given the start of a session, it returns the range the session was started for, even
+// once that has ended.
+func ParseTimeRangeAt(timeBox string, at time.Time) (time.Time, time.Time, error) {
+	return parseTimeRange(strings.TrimPrefix(timeBox, "@"), at)
+}
+
+func parseTimeRange(timeBox string, now time.Time) (time.Time, time.Time, error) {
+	if !strings.HasPrefix(timeBox, "[") || !strings.HasSuffix(timeBox, "]") {
+		return time.Time{}, time.Time{}, fmt.Errorf("not a time range: %s. Expected [HH:MM-HH:MM]", timeBox)
+	}
+
+	timeRangeStr := strings.Trim(timeBox, "[]")
+	parts := strings.Split(timeRangeStr, "-")
+	if len(parts) != 2 {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid time range format: %s. Expected [HH:MM-HH:MM]", timeBox)
+	}
+
+	startClock, err := time.Parse("15:04", strings.TrimSpace(parts[0]))
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid start time format in %s: %w", timeBox, err)
+	}
+	endClock, err := time.Parse("15:04", strings.TrimSpace(parts[1]))
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid end time format in %s: %w", timeBox, err)
+	}
+
+	// Set the year, month, day to today's date
+	startTime := time.Date(now.Year(), now.Month(), now.Day(), startClock.Hour(), startClock.Minute(), 0, 0, now.Location())
+	endTime := time.Date(now.Year(), now.Month(), now.Day(), endClock.Hour(), endClock.Minute(), 0, 0, now.Location())
+
+	// A range like [23:00-01:00] crosses midnight.
+	if !endTime.After(startTime) {
+		endTime = endTime.Add(24 * time.Hour)
+	}
+
+	// If the end time is in the past (e.g., 10:00 AM when it's 2:00 PM),
+	// assume it's for the next day.
+	if endTime.Before(now) {
+		startTime = startTime.Add(24 * time.Hour)
+		endTime = endTime.Add(24 * time.Hour)
+	}
+
+	return startTime, endTime, nil
+}
+
+// IsTimeRange reports whether the timebox uses the `@[HH:MM-HH:MM]` range syntax.
+func IsTimeRange(timeBox string) bool {
+	timeBox = strings.TrimPrefix(timeBox, "@")
+	return strings.HasPrefix(timeBox, "[") && strings.HasSuffix(timeBox, "]")
+}
+
+// UpdateMarkdown updates the task, adds commits, and records actual time spent in the markdown file
+// using the DefaultAnnotation.
+// The actual time spent is the sum of all closed segments; for time range tasks the actual range
+// worked (first segment start to last segment end) is written alongside it, followed by
+// a summary of the changes made unless diff is empty.
 func UpdateMarkdown(
 	filename string,
+	backups *backup.Store,
 	updatedTask task.Task,
-	commits []string,
-	totalDuration time.Duration,
+	commits []gitutil.Commit,
+	segments []state.TimeSegment,
+	diff gitutil.DiffSummary,
 ) error {
-	content, err := os.ReadFile(filename)
-	if err != nil {
-		return fmt.Errorf("failed to read file %s: %w", filename, err)
-	}
+	return DefaultAnnotation.UpdateMarkdown(filename, backups, updatedTask, commits, segments, diff)
+}
 
+// UpdateMarkdown checks off the task in the markdown file and writes the annotation below it.
+// The task is looked up by ID, hash or a fuzzy description match. Only its checkbox is
+// changed, and its ID marker added if it has none, so the item's text, bullet and
+// continuation lines stay as they are. The annotation is enclosed in markers and
+// replaces the one written when the task was completed before, including the unmarked
+// ones of earlier versions; any other content below the task is left alone.
+//
+// The file is replaced atomically and the change backed up in backups unless it is nil.
+// Nothing is kept from when the task was parsed at the start of the session: the file is
+// read when it is updated and the task looked up in it then, so edits made during the
+// session are kept and the lines they move are found where they are now. If it is edited
+// while being updated, it is read again and the task looked up anew.
+func (a *Annotation) UpdateMarkdown(
+	filename string,
+	backups *backup.Store,
+	updatedTask task.Task,
+	commits []gitutil.Commit,
+	segments []state.TimeSegment,
+	diff gitutil.DiffSummary,
+) error {
+	return updateFile(filename, backups, fmt.Sprintf("complete %q", updatedTask.Description), func(content []byte) ([]byte, error) {
+		return a.CompleteTask(content, updatedTask, commits, segments, diff)
+	})
+}
+
+// CompleteTask returns content, a markdown document, with the task completed as
+// described for UpdateMarkdown.
+func (a *Annotation) CompleteTask(
+	content []byte,
+	updatedTask task.Task,
+	commits []gitutil.Commit,
+	segments []state.TimeSegment,
+	diff gitutil.DiffSummary,
+) ([]byte, error) {
 	// Parse into AST
 	md := goldmark.New(goldmark.WithExtensions(extension.TaskList))
 	reader := text.NewReader(content)
 	rootNode := md.Parser().Parse(reader)
 
+	// Resolve tasks with their rolled-up timeboxes so parents match too
+	target := findTaskNode(parseTaskTree(rootNode, content), updatedTask)
+	if target == nil {
+		return nil, fmt.Errorf("task %q not found", updatedTask.Description)
+	}
+
+	current := *target.task
+	current.IsChecked = updatedTask.IsChecked
+	if current.ID == "" {
+		current.ID = updatedTask.ID
+	}
+	annotation, err := a.Render(NewAnnotationData(current, commits, segments, diff))
+	if err != nil {
+		return nil, err
+	}
+
 	// Create a new scanner rewriter to modify the content
-	rewriter := rewrite.NewScannerRewriter(
-		bytes.NewReader(content),
-		rewrite.BuildLineOffsets(content),
-	)
+	lineOffsets := rewrite.BuildLineOffsets(content)
+	rewriter := rewrite.NewScannerRewriter(bytes.NewReader(content), lineOffsets)
+
+	listItem := FindParentListItem(target.node)
+	textLines := listItem.FirstChild().Lines()
+	taskLine := rewriter.LineIndexOfByte(textLines.At(0).Start)
+	lastTextLine := rewriter.LineIndexOfByte(lastByte(textLines.At(textLines.Len() - 1)))
+
+	line := lineBytes(content, lineOffsets, taskLine)
+	if i := bytes.IndexByte(line, '['); i >= 0 && i+2 < len(line) && line[i+2] == ']' {
+		line[i+1] = ' '
+		if current.IsChecked {
+			line[i+1] = 'x'
+		}
+	}
+	if target.task.ID == "" && current.ID != "" {
+		line = append(bytes.TrimRight(line, " \t\r"), " "+FormatIDMarker(current.ID)...)
+	}
+	edits := []lineEdit{{start: taskLine, end: taskLine, lines: [][]byte{line}}}
 
-	err = ast.Walk(rootNode, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
-		if entering {
-			return ast.WalkContinue, nil
+	if len(annotation) > 0 {
+		// Annotation lines are nested like the item's text, below the checkbox
+		indent := lineIndent(content, textLines.At(0).Start)
+		if i := bytes.IndexByte(content[lineOffsets[taskLine]:], '['); i > len(indent) {
+			indent += strings.Repeat(" ", i-len(indent))
+		}
+
+		blocks := annotationBlocks(listItem, content, rewriter)
+		at := lineEdit{start: lastTextLine + 1, end: lastTextLine}
+		if len(blocks) > 0 {
+			at = blocks[0]
+			indent = lineIndent(content, lineOffsets[at.start])
+		}
+		at.lines = [][]byte{[]byte(indent + annotationStart)}
+		for _, l := range annotation {
+			at.lines = append(at.lines, []byte(indent+l))
+		}
+		at.lines = append(at.lines, []byte(indent+annotationEnd))
+
+		edits = append(edits, at)
+		if len(blocks) > 1 {
+			edits = append(edits, blocks[1:]...)
 		}
+	}
 
-		if parsedTask, ok := ExtractTask(n, content); ok {
-			if parsedTask.Hash() == updatedTask.Hash() {
-				p := FindParentListItem(n)
-				prev := p.FirstChild().Lines().At(0)
-				startIndex := rewriter.LineIndexOfByte(prev.Start)
-				endIndex := rewriter.LineIndexOfByte(prev.Stop)
+	for _, e := range edits {
+		if err := rewriter.ReplaceLines(e.start, e.end, e.lines); err != nil {
+			return nil, fmt.Errorf("failed to rewrite lines: %w", err)
+		}
+	}
+	if err := rewriter.CopyRemainingLines(); err != nil {
+		return nil, fmt.Errorf("failed to copy remaining lines: %w", err)
+	}
+	return checkCompletedParents(rewriter.Bytes(), taskLine), nil
+}
 
-				var taskText [][]byte
+// maxUpdateAttempts is how often a file that keeps changing while it is updated is read again.
+const maxUpdateAttempts = 3
 
-				taskText = append(taskText, []byte(updatedTask.String()))
+// updateFile replaces the content of the file with what update makes of it. If the file
+// changes between reading and writing it, it is read and updated again rather than
+// overwriting the changes. The change is backed up as operation in backups, unless it is
+// nil.
+func updateFile(filename string, backups *backup.Store, operation string, update func(content []byte) ([]byte, error)) error {
+	for attempt := 1; ; attempt++ {
+		content, version, err := fileutil.ReadFile(filename)
+		if err != nil {
+			return fmt.Errorf("failed to read file %s: %w", filename, err)
+		}
+		updated, err := update(content)
+		if err != nil {
+			return err
+		}
 
-				// Add actual duration if totalDuration is set
-				if totalDuration > 0 {
-					hours := int(totalDuration.Hours())
-					minutes := int(totalDuration.Minutes()) % 60
-					seconds := int(totalDuration.Seconds()) % 60
-					durationStr := fmt.Sprintf("  * ⏱️ %dh %dm %ds", hours, minutes, seconds)
-					taskText = append(taskText, []byte(durationStr))
-				}
+		var snap *backup.Snapshot
+		if backups != nil {
+			if snap, err = backups.Save(filename, operation, content, updated); err != nil {
+				return fmt.Errorf("failed to back up %s: %w", filename, err)
+			}
+		}
+		err = fileutil.WriteFileIfUnchanged(filename, updated, 0644, version)
+		if err != nil && snap != nil {
+			_ = backups.Remove(snap)
+		}
+		if errors.Is(err, fileutil.ErrChanged) && attempt < maxUpdateAttempts {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write file %s: %w", filename, err)
+		}
+		return nil
+	}
+}
 
-				if len(commits) > 0 {
-					taskText = append(taskText, []byte("  * 📝 Commits:"))
+// annotationStart and annotationEnd enclose the annotation written below a completed task.
+const (
+	annotationStart = "<!-- gobox:annotation -->"
+	annotationEnd   = "<!-- gobox:end -->"
+)
 
-					for _, commit := range commits {
-						commitText := fmt.Sprintf("    - `%s`", commit)
-						taskText = append(taskText, []byte(commitText))
-					}
-				}
+// Earlier versions didn't enclose their annotations in markers. Their bullets are only
+// taken for annotations if they have exactly the shape those versions wrote, so that notes
+// like "Duration: estimate was off" are left alone.
+var (
+	// legacyLineRe matches the single line bullets: the time spent, the diff summary, and
+	// when the task was completed and how long it took in the classic format.
+	legacyLineRe = regexp.MustCompile(`^(?:(?:⏱️ |Time spent: )\d+h \d+m \d+s(?: \(\d\d:\d\d-\d\d:\d\d\))?` +
+		`|(?:📊 |Changes: )\+\d+/-\d+ in \d+ files?(?:, \+\d+/-\d+ in \d+ files? uncommitted)?` +
+		`|Completed: \d{4}-\d\d-\d\d \d\d:\d\d \S+` +
+		`|Duration: (?:\d+h)?(?:\d+m)?\d+s)$`)
+	// legacyCommitsRe matches the bullet above the commit list, legacyCommitRe the commits
+	// in it, which may be grouped by repository.
+	legacyCommitsRe = regexp.MustCompile("^(?:📝 Commits:|Commits:)$")
+	legacyCommitRe  = regexp.MustCompile("^`[^`]+`$")
+	// legacyClassicCommitsRe matches the bullet above the commit list of the classic
+	// format, legacyClassicCommitRe the commits in it.
+	legacyClassicCommitsRe = regexp.MustCompile("^Commits during task:$")
+	legacyClassicCommitRe  = regexp.MustCompile("^[0-9a-f]{4,40}(?: .*)?$")
+)
 
-				rewriter.CopyLinesUntil(startIndex)
+// lineEdit replaces the lines from start through end with lines; end is start-1 to
+// insert lines before start.
+type lineEdit struct {
+	start, end int
+	lines      [][]byte
+}
 
-				// Replace the task item with the updated task
-				rewriter.ReplaceLines(startIndex, endIndex, taskText)
+// annotationBlocks returns the line ranges of the annotations below the task in listItem,
+// in document order: the blocks enclosed in markers, and the bullets of unmarked ones.
+func annotationBlocks(listItem ast.Node, content []byte, rewriter *rewrite.ScannerRewriter) []lineEdit {
+	var blocks []lineEdit
+	start := -1
+	for c := listItem.FirstChild().NextSibling(); c != nil; c = c.NextSibling() {
+		switch c.Kind() {
+		case ast.KindHTMLBlock:
+			html := nodeLines(c, content)
+			if strings.Contains(html, annotationStart) {
+				start = rewriter.LineIndexOfByte(c.Lines().At(0).Start)
+			}
+			if strings.Contains(html, annotationEnd) && start >= 0 {
+				end := rewriter.LineIndexOfByte(lastByte(c.Lines().At(c.Lines().Len() - 1)))
+				blocks = append(blocks, lineEdit{start: start, end: end})
+				start = -1
+			}
+		case ast.KindList:
+			if start >= 0 {
+				continue
+			}
+			for item := c.FirstChild(); item != nil; item = item.NextSibling() {
+				if !isLegacyAnnotation(item, content) {
+					continue
+				}
+				blocks = append(blocks, lineEdit{
+					start: rewriter.LineIndexOfByte(item.FirstChild().Lines().At(0).Start),
+					end:   rewriter.LineIndexOfByte(lastByteOf(item)),
+				})
 			}
 		}
+	}
+	return blocks
+}
+
+// isLegacyAnnotation reports whether the list item is a bullet of an unmarked annotation,
+// see legacyLineRe.
+func isLegacyAnnotation(item ast.Node, content []byte) bool {
+	line, rest, ok := itemLine(item, content)
+	if !ok {
+		return false
+	}
+	switch {
+	case legacyLineRe.MatchString(line):
+		return rest == nil
+	case legacyCommitsRe.MatchString(line):
+		return isLegacyCommitList(rest, content, legacyCommitRe, true)
+	case legacyClassicCommitsRe.MatchString(line):
+		return isLegacyCommitList(rest, content, legacyClassicCommitRe, false)
+	}
+	return false
+}
+
+// isLegacyCommitList reports whether list is a list of commits matching commitRe, or with
+// grouped, of repositories with such lists below them.
+func isLegacyCommitList(list ast.Node, content []byte, commitRe *regexp.Regexp, grouped bool) bool {
+	if list == nil || list.Kind() != ast.KindList || list.NextSibling() != nil {
+		return false
+	}
+	for item := list.FirstChild(); item != nil; item = item.NextSibling() {
+		line, rest, ok := itemLine(item, content)
+		switch {
+		case !ok:
+			return false
+		case rest == nil && commitRe.MatchString(line):
+		case rest != nil && grouped && isLegacyCommitList(rest, content, commitRe, false):
+		default:
+			return false
+		}
+	}
+	return true
+}
 
+// itemLine returns the text of a list item that is a single line, and the block below
+// it, if any.
+func itemLine(item ast.Node, content []byte) (string, ast.Node, bool) {
+	first := item.FirstChild()
+	if first == nil || first.Lines().Len() != 1 {
+		return "", nil, false
+	}
+	segment := first.Lines().At(0)
+	return strings.TrimSpace(string(segment.Value(content))), first.NextSibling(), true
+}
+
+// nodeLines returns the source lines of a block node.
+func nodeLines(n ast.Node, content []byte) string {
+	var sb strings.Builder
+	for i := 0; i < n.Lines().Len(); i++ {
+		segment := n.Lines().At(i)
+		sb.Write(segment.Value(content))
+	}
+	return sb.String()
+}
+
+// lastByte returns the offset of the last byte of a line segment, before any newline.
+func lastByte(segment text.Segment) int {
+	return max(segment.Start, segment.Stop-1)
+}
+
+// lastByteOf returns the offset of the last source byte of a block node and its children.
+func lastByteOf(n ast.Node) int {
+	last := 0
+	_ = ast.Walk(n, func(c ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering && c.Type() == ast.TypeBlock && c.Lines().Len() > 0 {
+			last = max(last, lastByte(c.Lines().At(c.Lines().Len()-1)))
+		}
 		return ast.WalkContinue, nil
 	})
+	return last
+}
 
-	if err := rewriter.CopyRemainingLines(); err != nil {
-		return fmt.Errorf("failed to copy remaining lines: %w", err)
+// lineBytes returns a copy of the line with the given index, without its newline.
+func lineBytes(content []byte, lineOffsets []int, index int) []byte {
+	end := len(content)
+	if index+1 < len(lineOffsets) {
+		end = lineOffsets[index+1]
+	}
+	line := bytes.TrimSuffix(content[lineOffsets[index]:end], []byte("\n"))
+	return append([]byte(nil), line...)
+}
+
+// checkCompletedParents checks the parents of the task on line taskLine whose subtasks
+// are all checked, going up the tree until one still has open subtasks. Other parents are
+// left alone: their subtasks may have been checked by hand, and aren't this task's.
+func checkCompletedParents(content []byte, taskLine int) []byte {
+	md := goldmark.New(goldmark.WithExtensions(extension.TaskList))
+	rootNode := md.Parser().Parse(text.NewReader(content))
+	lineStart := rewrite.BuildLineOffsets(content)[taskLine]
+
+	starts := make(map[*task.Task]int)
+	var completed *task.Task
+	for _, tn := range parseTaskTree(rootNode, content) {
+		line := FindParentListItem(tn.node).FirstChild().Lines().At(0)
+		starts[tn.task] = bytes.LastIndexByte(content[:line.Start], '\n') + 1
+		if starts[tn.task] == lineStart {
+			completed = tn.task
+		}
+	}
+	if completed == nil {
+		return content
+	}
+
+	for p := completed.Parent; p != nil && !p.IsChecked && allChecked(p.Children); p = p.Parent {
+		start := starts[p]
+		lineEnd := bytes.IndexByte(content[start:], '\n')
+		if lineEnd < 0 {
+			lineEnd = len(content) - start
+		}
+		i := bytes.Index(content[start:start+lineEnd], []byte("[ ]"))
+		if i < 0 {
+			break
+		}
+		content[start+i+1] = 'x'
+		p.IsChecked = true
+	}
+	return content
+}
+
+func allChecked(tasks []*task.Task) bool {
+	for _, t := range tasks {
+		if !t.IsChecked {
+			return false
+		}
+	}
+	return true
+}
+
+// lineIndent returns the leading whitespace of the line containing offset.
+func lineIndent(content []byte, offset int) string {
+	lineStart := bytes.LastIndexByte(content[:offset], '\n') + 1
+	end := lineStart
+	for end < len(content) && (content[end] == ' ' || content[end] == '\t') {
+		end++
 	}
+	return string(content[lineStart:end])
+}
 
-	// Finally, write out the buffer
-	return os.WriteFile(filename, rewriter.Bytes(), 0644)
+// AssignTaskID stores id as the stable ID of the task in the markdown file by appending an
+// invisible id marker to the task's line. The task is looked up the same way as in UpdateMarkdown,
+// and the change is backed up in backups unless it is nil.
+func AssignTaskID(filename string, backups *backup.Store, t task.Task, id string) error {
+	return updateFile(filename, backups, fmt.Sprintf("assign id to %q", t.Description), func(content []byte) ([]byte, error) {
+		md := goldmark.New(goldmark.WithExtensions(extension.TaskList))
+		rootNode := md.Parser().Parse(text.NewReader(content))
+
+		target := findTaskNode(parseTaskTree(rootNode, content), t)
+		if target == nil {
+			return nil, fmt.Errorf("task %q not found in %s", t.Description, filename)
+		}
+		if target.task.ID != "" {
+			return nil, fmt.Errorf("task %q already has id %s", t.Description, target.task.ID)
+		}
+
+		line := FindParentListItem(target.node).FirstChild().Lines().At(0)
+		lineEnd := len(content)
+		if i := bytes.IndexByte(content[line.Start:], '\n'); i >= 0 {
+			lineEnd = line.Start + i
+		}
+		lineEnd = line.Start + len(bytes.TrimRight(content[line.Start:lineEnd], " \t\r"))
+
+		var out bytes.Buffer
+		out.Write(content[:lineEnd])
+		out.WriteString(" " + FormatIDMarker(id))
+		out.Write(content[lineEnd:])
+		return out.Bytes(), nil
+	})
+}
+
+// actualRange returns the start of the first segment and the end of the last closed segment.
+func actualRange(segments []state.TimeSegment) (time.Time, time.Time, bool) {
+	if len(segments) == 0 {
+		return time.Time{}, time.Time{}, false
+	}
+	last := segments[len(segments)-1]
+	if last.End == nil {
+		return time.Time{}, time.Time{}, false
+	}
+	return segments[0].Start, *last.End, true
 }
 
 func FindParentListItem(n ast.Node) ast.Node {
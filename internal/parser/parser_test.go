@@ -2,12 +2,15 @@ package parser_test
 
 import (
 	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
 	"time"
 
+	"gobox/internal/gitutil"
 	"gobox/internal/parser"
+	"gobox/internal/state"
 	"gobox/pkg/task"
 
 	"github.com/yuin/goldmark"
@@ -81,6 +84,17 @@ func TestParseMarkdownFile(t *testing.T) {
 			want:     []task.Task{},
 			wantErr:  false,
 		},
+		{
+			name:     "time range task",
+			markdown: "- [ ] Planning block @[10:00-11:30]",
+			want: []task.Task{
+				{
+					Description: "Planning block",
+					TimeBox:     "@[10:00-11:30]",
+					IsChecked:   false,
+				},
+			},
+		},
 		{
 			name:     "inline code",
 			markdown: "- [ ] Task with `code` @1h",
@@ -173,7 +187,40 @@ func TestParseTimeBox(t *testing.T) {
 		wantEndTime  time.Time
 		wantErr      bool
 	}{
-		// Add test cases for ParseTimeBox here
+		{
+			name:         "hours",
+			timeBox:      "@2h",
+			wantDuration: 2 * time.Hour,
+		},
+		{
+			name:         "minutes",
+			timeBox:      "@30m",
+			wantDuration: 30 * time.Minute,
+		},
+		{
+			name:         "hours and minutes",
+			timeBox:      "@1h30m",
+			wantDuration: 90 * time.Minute,
+		},
+		{
+			name:    "time range",
+			timeBox: "@[10:00-11:30]",
+		},
+		{
+			name:    "invalid time range",
+			timeBox: "@[10:00]",
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			timeBox: "",
+			wantErr: true,
+		},
+		{
+			name:    "unsupported",
+			timeBox: "@soon",
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -196,6 +243,78 @@ func TestParseTimeBox(t *testing.T) {
 	}
 }
 
+func TestParseTimeRange(t *testing.T) {
+	start, end, err := parser.ParseTimeRange("@[10:00-11:30]")
+	if err != nil {
+		t.Fatalf("ParseTimeRange() error = %v", err)
+	}
+	if start.Hour() != 10 || start.Minute() != 0 {
+		t.Errorf("ParseTimeRange() start = %v, want 10:00", start)
+	}
+	if end.Hour() != 11 || end.Minute() != 30 {
+		t.Errorf("ParseTimeRange() end = %v, want 11:30", end)
+	}
+	if got := end.Sub(start); got != 90*time.Minute {
+		t.Errorf("ParseTimeRange() range length = %v, want 1h30m", got)
+	}
+	if end.Before(time.Now()) {
+		t.Errorf("ParseTimeRange() end %v should not be in the past", end)
+	}
+
+	start, end, err = parser.ParseTimeRange("@[23:00-01:00]")
+	if err != nil {
+		t.Fatalf("ParseTimeRange() error = %v", err)
+	}
+	if got := end.Sub(start); got != 2*time.Hour {
+		t.Errorf("ParseTimeRange() overnight range length = %v, want 2h", got)
+	}
+
+	if _, _, err := parser.ParseTimeRange("@1h"); err == nil {
+		t.Errorf("ParseTimeRange() expected error for duration timebox")
+	}
+}
+
+func TestUpdateMarkdownTimeRange(t *testing.T) {
+	tmpFile, err := createTempFileWithContent("- [ ] Planning block @[10:00-11:30]\n")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	tasks, err := parser.ParseMarkdownFile(tmpFile.Name())
+	if err != nil || len(tasks) != 1 {
+		t.Fatalf("ParseMarkdownFile failed: %v, %v", tasks, err)
+	}
+
+	updated := tasks[0]
+	updated.IsChecked = true
+
+	start := time.Date(2025, 6, 1, 10, 5, 0, 0, time.Local)
+	pause := start.Add(30 * time.Minute)
+	resume := pause.Add(10 * time.Minute)
+	end := resume.Add(45 * time.Minute)
+	segments := []state.TimeSegment{
+		{Start: start, End: &pause},
+		{Start: resume, End: &end},
+	}
+
+	if err := parser.UpdateMarkdown(tmpFile.Name(), nil, updated, nil, segments, gitutil.DiffSummary{}); err != nil {
+		t.Fatalf("UpdateMarkdown failed: %v", err)
+	}
+
+	updatedContent, err := os.ReadFile(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("failed to read updated file: %v", err)
+	}
+	updatedStr := string(updatedContent)
+	if !strings.Contains(updatedStr, "[x] Planning block @[10:00-11:30]") {
+		t.Errorf("updated task not found or incorrect: %q", updatedStr)
+	}
+	if !strings.Contains(updatedStr, "⏱️ 1h 15m 0s (10:05-11:30)") {
+		t.Errorf("actual range not found or incorrect: %q", updatedStr)
+	}
+}
+
 func TestUpdateMarkdown(t *testing.T) {
 	original := "- [ ] Task 1 @1h\n- [ ] Task 2 @2h\n"
 	tmpFile, err := createTempFileWithContent(original)
@@ -223,9 +342,9 @@ func TestUpdateMarkdown(t *testing.T) {
 	start := time.Now()
 	end := start.Add(1 * time.Hour)
 
-	// Use totalDuration instead of start/end
-	totalDuration := end.Sub(start)
-	err = parser.UpdateMarkdown(tmpFile.Name(), updated, nil, totalDuration)
+	segments := []state.TimeSegment{{Start: start, End: &end}}
+	diff := gitutil.Summarize([]gitutil.Commit{{Files: []string{"main.go", "internal/parser/parser.go"}, Insertions: 120, Deletions: 34}})
+	err = parser.UpdateMarkdown(tmpFile.Name(), nil, updated, nil, segments, diff)
 	if err != nil {
 		t.Fatalf("UpdateMarkdown failed: %v", err)
 	}
@@ -239,10 +358,421 @@ func TestUpdateMarkdown(t *testing.T) {
 	if !strings.Contains(updatedStr, "[x] Task 1 @1h") {
 		t.Errorf("updated task not found or incorrect: %q", updatedStr)
 	}
-	if !strings.Contains(updatedStr, "⏱️ 1h 0m 0s") {
-		t.Errorf("duration not found or incorrect: %q", updatedStr)
+	if !strings.Contains(updatedStr, "⏱️ 1h 0m 0s\n  * 📊 +120/-34 in 2 files\n") {
+		t.Errorf("duration or diff summary not found or incorrect: %q", updatedStr)
 	}
 	if !strings.Contains(updatedStr, "- [ ] Task 2 @2h") {
 		t.Errorf("other tasks should remain unchanged: %q", updatedStr)
 	}
 }
+
+func TestParseMarkdownFileNested(t *testing.T) {
+	markdown := "- [ ] Release\n  - [ ] Write changelog @30m\n  - [ ] Tag release @15m\n    - [x] Bump version @5m\n- [ ] Explicit parent @2h\n  - [ ] Child @1h\n"
+	tmpFile, err := createTempFileWithContent(markdown)
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	tasks, err := parser.ParseMarkdownFile(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("ParseMarkdownFile failed: %v", err)
+	}
+	if len(tasks) != 6 {
+		t.Fatalf("expected 6 tasks, got %d", len(tasks))
+	}
+
+	release := tasks[0]
+	if release.Description != "Release" {
+		t.Errorf("parent description should not include subtasks, got %q", release.Description)
+	}
+	if release.TimeBox != "@45m" || !release.RolledUp {
+		t.Errorf("expected rolled-up timebox @45m, got %q (rolled up: %v)", release.TimeBox, release.RolledUp)
+	}
+	if len(release.Children) != 2 || release.Children[1].Description != "Tag release" {
+		t.Errorf("unexpected children: %+v", release.Children)
+	}
+	if tasks[3].Depth() != 2 || tasks[3].Parent.Description != "Tag release" {
+		t.Errorf("expected grandchild to be linked to its parent, got depth %d", tasks[3].Depth())
+	}
+	if tasks[4].TimeBox != "@2h" || tasks[4].RolledUp {
+		t.Errorf("explicit parent timebox should be kept, got %q", tasks[4].TimeBox)
+	}
+	if release.String() != "- [ ] Release" {
+		t.Errorf("rolled-up timebox should not be written back, got %q", release.String())
+	}
+}
+
+func TestUpdateMarkdownNestedChecksParent(t *testing.T) {
+	// Docs had its subtask checked by hand, it isn't the completed task's parent
+	markdown := "- [ ] Ship\n  - [ ] Release\n    - [x] Write changelog @30m\n    - [ ] Tag release @15m\n- [ ] Docs\n  - [x] Update README @10m\n- [ ] Next @10m\n"
+	tmpFile, err := createTempFileWithContent(markdown)
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	tasks, err := parser.ParseMarkdownFile(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("ParseMarkdownFile failed: %v", err)
+	}
+
+	updated := tasks[3]
+	updated.IsChecked = true
+	start := time.Now().Add(-15 * time.Minute)
+	end := time.Now()
+	if err := parser.UpdateMarkdown(tmpFile.Name(), nil, updated, []gitutil.Commit{{ShortHash: "abc1234", Subject: "Tag v1"}}, []state.TimeSegment{{Start: start, End: &end}}, gitutil.DiffSummary{}); err != nil {
+		t.Fatalf("UpdateMarkdown failed: %v", err)
+	}
+
+	updatedContent, err := os.ReadFile(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("failed to read updated file: %v", err)
+	}
+	want := "- [x] Ship\n" +
+		"  - [x] Release\n" +
+		"    - [x] Write changelog @30m\n" +
+		"    - [x] Tag release @15m\n" +
+		"      <!-- gobox:annotation -->\n" +
+		"      * ⏱️ 0h 15m 0s\n" +
+		"      * 📝 Commits:\n" +
+		"        - `abc1234 Tag v1`\n" +
+		"      <!-- gobox:end -->\n" +
+		"- [ ] Docs\n" +
+		"  - [x] Update README @10m\n" +
+		"- [ ] Next @10m\n"
+	if string(updatedContent) != want {
+		t.Errorf("unexpected markdown:\n%s\nwant:\n%s", updatedContent, want)
+	}
+}
+
+func TestAssignTaskIDSurvivesEdits(t *testing.T) {
+	tmpFile, err := createTempFileWithContent("- [ ] Fix the tpyo @30m\n- [ ] Other @1h\n")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	tasks, err := parser.ParseMarkdownFile(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("ParseMarkdownFile failed: %v", err)
+	}
+	started := tasks[0]
+
+	if err := parser.AssignTaskID(tmpFile.Name(), nil, started, "abc123"); err != nil {
+		t.Fatalf("AssignTaskID failed: %v", err)
+	}
+	started.ID = "abc123"
+
+	tasks, err = parser.ParseMarkdownFile(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("ParseMarkdownFile failed: %v", err)
+	}
+	if tasks[0].ID != "abc123" || tasks[0].Description != "Fix the tpyo" || tasks[0].TimeBox != "@30m" {
+		t.Fatalf("unexpected task after assigning id: %+v", tasks[0])
+	}
+
+	// Fix the typo and change the timebox while the task is running
+	edited := "- [ ] Fix the typo @45m <!-- gobox:id=abc123 -->\n- [ ] Other @1h\n"
+	if err := os.WriteFile(tmpFile.Name(), []byte(edited), 0644); err != nil {
+		t.Fatalf("failed to edit file: %v", err)
+	}
+
+	started.IsChecked = true
+	if err := parser.UpdateMarkdown(tmpFile.Name(), nil, started, nil, nil, gitutil.DiffSummary{}); err != nil {
+		t.Fatalf("UpdateMarkdown failed: %v", err)
+	}
+
+	updatedContent, err := os.ReadFile(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("failed to read updated file: %v", err)
+	}
+	want := "- [x] Fix the typo @45m <!-- gobox:id=abc123 -->\n- [ ] Other @1h\n"
+	if string(updatedContent) != want {
+		t.Errorf("unexpected markdown:\n%s\nwant:\n%s", updatedContent, want)
+	}
+}
+
+func TestUpdateMarkdownFuzzyMatch(t *testing.T) {
+	tmpFile, err := createTempFileWithContent("- [ ] Write the release notes @30m\n- [ ] Other @1h\n")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	// The task was started before its description was edited and has no ID
+	started := task.Task{Description: "Write release notes", TimeBox: "@30m", IsChecked: true}
+	if err := parser.UpdateMarkdown(tmpFile.Name(), nil, started, nil, nil, gitutil.DiffSummary{}); err != nil {
+		t.Fatalf("UpdateMarkdown failed: %v", err)
+	}
+
+	updatedContent, err := os.ReadFile(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("failed to read updated file: %v", err)
+	}
+	if !strings.Contains(string(updatedContent), "- [x] Write the release notes @30m") {
+		t.Errorf("expected fuzzy matched task to be checked: %q", updatedContent)
+	}
+
+	unrelated := task.Task{Description: "Something else entirely", TimeBox: "@30m", IsChecked: true}
+	if err := parser.UpdateMarkdown(tmpFile.Name(), nil, unrelated, nil, nil, gitutil.DiffSummary{}); err == nil {
+		t.Errorf("expected an error for a task that isn't in the file")
+	}
+}
+
+func TestUpdateMarkdownFuzzyMatchIsUnambiguous(t *testing.T) {
+	tmpFile, err := createTempFileWithContent("- [x] Review part A @30m\n- [ ] Review part B @30m\n- [ ] Review part C @30m\n")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	// Equally similar to B and C, so it could be either
+	tied := task.Task{Description: "Review part D", TimeBox: "@30m", IsChecked: true}
+	if err := parser.UpdateMarkdown(tmpFile.Name(), nil, tied, nil, nil, gitutil.DiffSummary{}); err == nil {
+		t.Errorf("expected no match for a task as similar to two others")
+	}
+
+	// Closest to A, which is done already, so it is taken for B
+	edited := task.Task{Description: "Review part A.", TimeBox: "@30m", IsChecked: true}
+	if err := parser.UpdateMarkdown(tmpFile.Name(), nil, edited, nil, nil, gitutil.DiffSummary{}); err == nil {
+		t.Errorf("expected no match while B and C are equally similar")
+	}
+	if err := os.WriteFile(tmpFile.Name(), []byte("- [x] Review part A @30m\n- [ ] Review part B @30m\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := parser.UpdateMarkdown(tmpFile.Name(), nil, edited, nil, nil, gitutil.DiffSummary{}); err != nil {
+		t.Fatalf("UpdateMarkdown failed: %v", err)
+	}
+	content, err := os.ReadFile(tmpFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "- [x] Review part B @30m") {
+		t.Errorf("expected the unchecked task to be matched: %q", content)
+	}
+}
+
+func TestUpdateMarkdownGroupsCommitsByRepo(t *testing.T) {
+	tmpFile, err := createTempFileWithContent("- [ ] Cross-repo change @1h\n")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	tasks, err := parser.ParseMarkdownFile(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("ParseMarkdownFile failed: %v", err)
+	}
+	updated := tasks[0]
+	updated.IsChecked = true
+
+	commits := []gitutil.Commit{
+		{ShortHash: "aaaaaaa", Subject: "Add endpoint", Repo: "service"},
+		{ShortHash: "bbbbbbb", Subject: "Add client", Repo: "lib"},
+		{ShortHash: "ccccccc", Subject: "Use client", Repo: "service"},
+	}
+	if err := parser.UpdateMarkdown(tmpFile.Name(), nil, updated, commits, nil, gitutil.DiffSummary{}); err != nil {
+		t.Fatalf("UpdateMarkdown failed: %v", err)
+	}
+
+	content, err := os.ReadFile(tmpFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "  * 📝 Commits:\n" +
+		"    - service\n" +
+		"      - `aaaaaaa Add endpoint`\n" +
+		"      - `ccccccc Use client`\n" +
+		"    - lib\n" +
+		"      - `bbbbbbb Add client`\n"
+	if !strings.Contains(string(content), want) {
+		t.Errorf("expected commits grouped by repo, got:\n%s", content)
+	}
+}
+
+func TestParseRepos(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "tasks.md")
+	content := "# Release\n<!-- gobox:repos=../service, /src/shared-lib -->\n\n- [ ] Task @1h\n"
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	repos, err := parser.ParseRepos(file)
+	if err != nil {
+		t.Fatalf("ParseRepos failed: %v", err)
+	}
+	want := []string{filepath.Join(filepath.Dir(dir), "service"), "/src/shared-lib"}
+	if len(repos) != len(want) || repos[0] != want[0] || repos[1] != want[1] {
+		t.Errorf("got %q, want %q", repos, want)
+	}
+}
+
+func TestParseScope(t *testing.T) {
+	tmpFile, err := createTempFileWithContent("- [ ] Fix parser bug @30m {scope: internal/parser/**, docs/**}\n- [ ] Unscoped @1h\n")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	tasks, err := parser.ParseMarkdownFile(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("ParseMarkdownFile failed: %v", err)
+	}
+	if len(tasks) != 2 {
+		t.Fatalf("expected 2 tasks, got %d", len(tasks))
+	}
+	scoped := tasks[0]
+	if scoped.Description != "Fix parser bug" || scoped.TimeBox != "@30m" {
+		t.Errorf("unexpected task %q %q", scoped.Description, scoped.TimeBox)
+	}
+	if want := []string{"internal/parser/**", "docs/**"}; !reflect.DeepEqual(scoped.Scope, want) {
+		t.Errorf("got scope %q, want %q", scoped.Scope, want)
+	}
+	if tasks[1].Scope != nil {
+		t.Errorf("expected no scope, got %q", tasks[1].Scope)
+	}
+
+	// Completing the task keeps its scope
+	scoped.IsChecked = true
+	if err := parser.UpdateMarkdown(tmpFile.Name(), nil, scoped, nil, nil, gitutil.DiffSummary{}); err != nil {
+		t.Fatalf("UpdateMarkdown failed: %v", err)
+	}
+	updatedContent, err := os.ReadFile(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("failed to read updated file: %v", err)
+	}
+	if !strings.HasPrefix(string(updatedContent), "- [x] Fix parser bug @30m {scope: internal/parser/**, docs/**}\n") {
+		t.Errorf("expected the scope to be kept: %q", updatedContent)
+	}
+}
+
+func TestUpdateMarkdownKeepsSubContent(t *testing.T) {
+	markdown := "* [ ] Write the parser,\n  handling nested lists @1h\n  * see [the spec](https://spec.commonmark.org)\n  + keep this note\n+ [ ] Other @1h\n"
+	tmpFile, err := createTempFileWithContent(markdown)
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	tasks, err := parser.ParseMarkdownFile(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("ParseMarkdownFile failed: %v", err)
+	}
+	completed := tasks[0]
+	completed.IsChecked = true
+	completed.ID = "abc123"
+
+	start := time.Now().Add(-time.Hour)
+	end := start.Add(30 * time.Minute)
+	segments := []state.TimeSegment{{Start: start, End: &end}}
+	if err := parser.UpdateMarkdown(tmpFile.Name(), nil, completed, nil, segments, gitutil.DiffSummary{}); err != nil {
+		t.Fatalf("UpdateMarkdown failed: %v", err)
+	}
+
+	// Completing the task again updates its annotation instead of adding another one
+	later := end.Add(15 * time.Minute)
+	segments = append(segments, state.TimeSegment{Start: end, End: &later})
+	commits := []gitutil.Commit{{ShortHash: "abc1234", Subject: "Parse lists"}}
+	if err := parser.UpdateMarkdown(tmpFile.Name(), nil, completed, commits, segments, gitutil.DiffSummary{}); err != nil {
+		t.Fatalf("UpdateMarkdown failed: %v", err)
+	}
+
+	updatedContent, err := os.ReadFile(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("failed to read updated file: %v", err)
+	}
+	want := "* [x] Write the parser, <!-- gobox:id=abc123 -->\n" +
+		"  handling nested lists @1h\n" +
+		"  <!-- gobox:annotation -->\n" +
+		"  * ⏱️ 0h 45m 0s\n" +
+		"  * 📝 Commits:\n" +
+		"    - `abc1234 Parse lists`\n" +
+		"  <!-- gobox:end -->\n" +
+		"  * see [the spec](https://spec.commonmark.org)\n" +
+		"  + keep this note\n" +
+		"+ [ ] Other @1h\n"
+	if string(updatedContent) != want {
+		t.Errorf("unexpected markdown:\n%s\nwant:\n%s", updatedContent, want)
+	}
+}
+
+func TestUpdateMarkdownReplacesUnmarkedAnnotation(t *testing.T) {
+	markdown := "- [x] Parse markdown @1h\n" +
+		"    * Completed: 2025-05-25 22:42 CEST\n" +
+		"    * Duration: 4m29s\n" +
+		"    * Commits during task:\n" +
+		"        - 7605b74 Use gomarkdown to parse markdown files\n" +
+		"    * A note of my own\n" +
+		"- [ ] Next @10m\n"
+	tmpFile, err := createTempFileWithContent(markdown)
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	start := time.Now().Add(-time.Hour)
+	end := start.Add(time.Hour)
+	done := task.Task{Description: "Parse markdown", TimeBox: "@1h", IsChecked: true}
+	if err := parser.UpdateMarkdown(tmpFile.Name(), nil, done, nil, []state.TimeSegment{{Start: start, End: &end}}, gitutil.DiffSummary{}); err != nil {
+		t.Fatalf("UpdateMarkdown failed: %v", err)
+	}
+
+	updatedContent, err := os.ReadFile(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("failed to read updated file: %v", err)
+	}
+	want := "- [x] Parse markdown @1h\n" +
+		"    <!-- gobox:annotation -->\n" +
+		"    * ⏱️ 1h 0m 0s\n" +
+		"    <!-- gobox:end -->\n" +
+		"    * A note of my own\n" +
+		"- [ ] Next @10m\n"
+	if string(updatedContent) != want {
+		t.Errorf("unexpected markdown:\n%s\nwant:\n%s", updatedContent, want)
+	}
+}
+
+func TestUpdateMarkdownKeepsNotesShapedLikeAnnotations(t *testing.T) {
+	markdown := "- [x] Parse markdown @1h\n" +
+		"  * ⏱️ 0h 4m 29s\n" +
+		"  * 📝 Commits:\n" +
+		"    - `7605b74 Use gomarkdown to parse markdown files`\n" +
+		"  * Duration: estimate was off\n" +
+		"  * ⏱️ felt longer than that\n" +
+		"  * Commits:\n" +
+		"    - the parser ones, see the PR\n" +
+		"  * Completed: mostly\n" +
+		"- [ ] Next @10m\n"
+	tmpFile, err := createTempFileWithContent(markdown)
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	start := time.Now().Add(-time.Hour)
+	end := start.Add(time.Hour)
+	done := task.Task{Description: "Parse markdown", TimeBox: "@1h", IsChecked: true}
+	if err := parser.UpdateMarkdown(tmpFile.Name(), nil, done, nil, []state.TimeSegment{{Start: start, End: &end}}, gitutil.DiffSummary{}); err != nil {
+		t.Fatalf("UpdateMarkdown failed: %v", err)
+	}
+
+	updatedContent, err := os.ReadFile(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("failed to read updated file: %v", err)
+	}
+	want := "- [x] Parse markdown @1h\n" +
+		"  <!-- gobox:annotation -->\n" +
+		"  * ⏱️ 1h 0m 0s\n" +
+		"  <!-- gobox:end -->\n" +
+		"  * Duration: estimate was off\n" +
+		"  * ⏱️ felt longer than that\n" +
+		"  * Commits:\n" +
+		"    - the parser ones, see the PR\n" +
+		"  * Completed: mostly\n" +
+		"- [ ] Next @10m\n"
+	if string(updatedContent) != want {
+		t.Errorf("unexpected markdown:\n%s\nwant:\n%s", updatedContent, want)
+	}
+}
@@ -0,0 +1,110 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gobox/internal/backup"
+	"gobox/internal/gitutil"
+)
+
+func TestUpdateFileRetriesAfterConcurrentEdit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.md")
+	if err := os.WriteFile(path, []byte("- [ ] Task @1h\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := 0
+	err := updateFile(path, nil, "check the task", func(content []byte) ([]byte, error) {
+		calls++
+		if calls == 1 {
+			// Someone saves the file while it is being updated
+			if err := os.WriteFile(path, []byte("- [ ] Task @1h\n- [ ] Added meanwhile @1h\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return []byte(strings.Replace(string(content), "[ ]", "[x]", 1)), nil
+	})
+	if err != nil {
+		t.Fatalf("updateFile failed: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("update called %d times, want 2", calls)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "- [x] Task @1h\n- [ ] Added meanwhile @1h\n"
+	if string(content) != want {
+		t.Errorf("content = %q, want %q", content, want)
+	}
+}
+
+func TestUpdateMarkdownAfterEditDuringSession(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.md")
+	if err := os.WriteFile(path, []byte("- [ ] Write docs @1h\n- [ ] Fix the tpyo @30m\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tasks, err := ParseMarkdownFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := tasks[1]
+
+	// During the session a task is added above and the session's task reworded
+	edited := "- [ ] Triage @15m\n- [ ] Write docs @1h\n  Outline first\n- [ ] Fix the typo @30m\n"
+	if err := os.WriteFile(path, []byte(edited), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	started.IsChecked = true
+	if err := UpdateMarkdown(path, nil, started, nil, nil, gitutil.DiffSummary{}); err != nil {
+		t.Fatalf("UpdateMarkdown failed: %v", err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "- [ ] Triage @15m\n- [ ] Write docs @1h\n  Outline first\n- [x] Fix the typo @30m\n"
+	if string(content) != want {
+		t.Errorf("content = %q, want %q", content, want)
+	}
+
+	// A task removed during the session isn't completed in place of another one
+	if err := os.WriteFile(path, []byte("- [ ] Triage @15m\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateMarkdown(path, nil, tasks[0], nil, nil, gitutil.DiffSummary{}); err == nil {
+		t.Error("expected the removed task not to be found")
+	}
+	if content, _ := os.ReadFile(path); string(content) != "- [ ] Triage @15m\n" {
+		t.Errorf("the file was changed: %q", content)
+	}
+}
+
+func TestUpdateFileBacksUpTheChange(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tasks.md")
+	if err := os.WriteFile(path, []byte("- [ ] Task @1h\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	backups := backup.NewStore(filepath.Join(dir, "backups"))
+
+	err := updateFile(path, backups, "check the task", func(content []byte) ([]byte, error) {
+		return []byte("- [x] Task @1h\n"), nil
+	})
+	if err != nil {
+		t.Fatalf("updateFile failed: %v", err)
+	}
+	snap, err := backups.Latest(path)
+	if err != nil {
+		t.Fatalf("expected a backup: %v", err)
+	}
+	if snap.Operation != "check the task" || snap.Before != "- [ ] Task @1h\n" || snap.After != "- [x] Task @1h\n" {
+		t.Errorf("unexpected backup %+v", snap)
+	}
+}
@@ -0,0 +1,274 @@
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Output formats supported by Write.
+const (
+	FormatTable    = "table"
+	FormatMarkdown = "markdown"
+	FormatCSV      = "csv"
+	FormatJSON     = "json"
+)
+
+// Write renders the report in the given format.
+func Write(w io.Writer, r Report, format string) error {
+	switch format {
+	case FormatTable, "":
+		return WriteTable(w, r)
+	case FormatMarkdown, "md":
+		return WriteMarkdown(w, r)
+	case FormatCSV:
+		return WriteCSV(w, r)
+	case FormatJSON:
+		return WriteJSON(w, r)
+	default:
+		return fmt.Errorf("unsupported report format %q, expected table, markdown, csv or json", format)
+	}
+}
+
+// section is a titled list of summaries, in the order they are rendered.
+type section struct {
+	title     string
+	column    string // heading of the key column
+	group     string // group name in CSV output
+	summaries []Summary
+}
+
+func sections(r Report) []section {
+	return []section{
+		{title: "Per day", column: "Day", group: "day", summaries: r.ByDay},
+		{title: "Per week", column: "Week", group: "week", summaries: r.ByWeek},
+		{title: "Per file", column: "File", group: "file", summaries: r.ByFile},
+		{title: "Total", column: "", group: "total", summaries: []Summary{r.Total}},
+	}
+}
+
+var summaryHeader = []string{"Sessions", "Planned", "Actual", "Overrun", "Commits", "Commits/session"}
+
+func summaryRow(s Summary) []string {
+	return []string{
+		strconv.Itoa(s.Sessions),
+		formatDuration(s.Planned),
+		formatDuration(s.Actual),
+		formatRatio(s.OverrunRatio()),
+		strconv.Itoa(s.Commits),
+		strconv.FormatFloat(s.CommitsPerSession(), 'f', 1, 64),
+	}
+}
+
+var overrunHeader = []string{"Task", "File", "Completed", "Planned", "Actual", "Overrun"}
+
+func overrunRow(o Overrun) []string {
+	return []string{
+		o.Entry.Description,
+		o.Entry.File,
+		o.Entry.CompletedAt.Local().Format("2006-01-02 15:04"),
+		formatDuration(o.Entry.Planned),
+		formatDuration(o.Entry.Actual),
+		formatRatio(o.Ratio),
+	}
+}
+
+// WriteTable renders the report as aligned terminal tables.
+func WriteTable(w io.Writer, r Report) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, sec := range sections(r) {
+		fmt.Fprintf(tw, "%s\n", strings.ToUpper(sec.title))
+		fmt.Fprintf(tw, "%s\t%s\n", strings.ToUpper(sec.column), strings.ToUpper(strings.Join(summaryHeader, "\t")))
+		for _, s := range sec.summaries {
+			fmt.Fprintf(tw, "%s\t%s\n", s.Key, strings.Join(summaryRow(s), "\t"))
+		}
+		fmt.Fprintln(tw)
+	}
+
+	fmt.Fprintln(tw, "WORST OVERRUNS")
+	if len(r.WorstOverruns) == 0 {
+		fmt.Fprintln(tw, "None, all sessions finished within their timebox.")
+	} else {
+		fmt.Fprintln(tw, strings.ToUpper(strings.Join(overrunHeader, "\t")))
+		for _, o := range r.WorstOverruns {
+			fmt.Fprintln(tw, strings.Join(overrunRow(o), "\t"))
+		}
+	}
+	return tw.Flush()
+}
+
+// WriteMarkdown renders the report as markdown tables.
+func WriteMarkdown(w io.Writer, r Report) error {
+	for _, sec := range sections(r) {
+		fmt.Fprintf(w, "## %s\n\n", sec.title)
+		writeMarkdownRow(w, append([]string{sec.column}, summaryHeader...))
+		writeMarkdownSeparator(w, len(summaryHeader)+1)
+		for _, s := range sec.summaries {
+			writeMarkdownRow(w, append([]string{s.Key}, summaryRow(s)...))
+		}
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintf(w, "## Worst overruns\n\n")
+	if len(r.WorstOverruns) == 0 {
+		_, err := fmt.Fprintln(w, "None, all sessions finished within their timebox.")
+		return err
+	}
+	writeMarkdownRow(w, overrunHeader)
+	writeMarkdownSeparator(w, len(overrunHeader))
+	for _, o := range r.WorstOverruns {
+		writeMarkdownRow(w, overrunRow(o))
+	}
+	return nil
+}
+
+func writeMarkdownRow(w io.Writer, cells []string) {
+	escaped := make([]string, len(cells))
+	for i, c := range cells {
+		escaped[i] = strings.ReplaceAll(c, "|", `\|`)
+	}
+	fmt.Fprintf(w, "| %s |\n", strings.Join(escaped, " | "))
+}
+
+func writeMarkdownSeparator(w io.Writer, columns int) {
+	fmt.Fprintf(w, "|%s\n", strings.Repeat(" --- |", columns))
+}
+
+// WriteCSV renders all summaries and overruns as one CSV table. The group column tells
+// rows apart: day, week, file, total, or overrun for single sessions.
+func WriteCSV(w io.Writer, r Report) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"group", "key", "sessions", "planned_minutes", "actual_minutes", "overrun_ratio", "commits", "commits_per_session"})
+
+	for _, sec := range sections(r) {
+		for _, s := range sec.summaries {
+			_ = cw.Write([]string{
+				sec.group,
+				s.Key,
+				strconv.Itoa(s.Sessions),
+				formatMinutes(s.Planned),
+				formatMinutes(s.Actual),
+				strconv.FormatFloat(s.OverrunRatio(), 'f', 2, 64),
+				strconv.Itoa(s.Commits),
+				strconv.FormatFloat(s.CommitsPerSession(), 'f', 2, 64),
+			})
+		}
+	}
+	for _, o := range r.WorstOverruns {
+		_ = cw.Write([]string{
+			"overrun",
+			o.Entry.Description,
+			"1",
+			formatMinutes(o.Entry.Planned),
+			formatMinutes(o.Entry.Actual),
+			strconv.FormatFloat(o.Ratio, 'f', 2, 64),
+			strconv.Itoa(len(o.Entry.Commits)),
+			strconv.Itoa(len(o.Entry.Commits)),
+		})
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+type jsonSummary struct {
+	Key               string  `json:"key"`
+	Sessions          int     `json:"sessions"`
+	PlannedMinutes    float64 `json:"planned_minutes"`
+	ActualMinutes     float64 `json:"actual_minutes"`
+	OverrunRatio      float64 `json:"overrun_ratio"`
+	Commits           int     `json:"commits"`
+	CommitsPerSession float64 `json:"commits_per_session"`
+}
+
+type jsonOverrun struct {
+	TaskID         string    `json:"task_id"`
+	Description    string    `json:"description"`
+	File           string    `json:"file"`
+	CompletedAt    time.Time `json:"completed_at"`
+	PlannedMinutes float64   `json:"planned_minutes"`
+	ActualMinutes  float64   `json:"actual_minutes"`
+	OverrunRatio   float64   `json:"overrun_ratio"`
+}
+
+type jsonReport struct {
+	Total         jsonSummary   `json:"total"`
+	ByDay         []jsonSummary `json:"by_day"`
+	ByWeek        []jsonSummary `json:"by_week"`
+	ByFile        []jsonSummary `json:"by_file"`
+	WorstOverruns []jsonOverrun `json:"worst_overruns"`
+}
+
+func toJSONSummaries(summaries []Summary) []jsonSummary {
+	out := make([]jsonSummary, 0, len(summaries))
+	for _, s := range summaries {
+		out = append(out, toJSONSummary(s))
+	}
+	return out
+}
+
+func toJSONSummary(s Summary) jsonSummary {
+	return jsonSummary{
+		Key:               s.Key,
+		Sessions:          s.Sessions,
+		PlannedMinutes:    s.Planned.Minutes(),
+		ActualMinutes:     s.Actual.Minutes(),
+		OverrunRatio:      s.OverrunRatio(),
+		Commits:           s.Commits,
+		CommitsPerSession: s.CommitsPerSession(),
+	}
+}
+
+// WriteJSON renders the report as a JSON object.
+func WriteJSON(w io.Writer, r Report) error {
+	out := jsonReport{
+		Total:         toJSONSummary(r.Total),
+		ByDay:         toJSONSummaries(r.ByDay),
+		ByWeek:        toJSONSummaries(r.ByWeek),
+		ByFile:        toJSONSummaries(r.ByFile),
+		WorstOverruns: make([]jsonOverrun, 0, len(r.WorstOverruns)),
+	}
+	for _, o := range r.WorstOverruns {
+		out.WorstOverruns = append(out.WorstOverruns, jsonOverrun{
+			TaskID:         o.Entry.TaskID,
+			Description:    o.Entry.Description,
+			File:           o.Entry.File,
+			CompletedAt:    o.Entry.CompletedAt,
+			PlannedMinutes: o.Entry.Planned.Minutes(),
+			ActualMinutes:  o.Entry.Actual.Minutes(),
+			OverrunRatio:   o.Ratio,
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// formatDuration formats a duration as hours and minutes, e.g. "1h05m" or "45m".
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	hours := int(d.Hours())
+	minutes := int(d.Minutes()) % 60
+	if hours > 0 {
+		return fmt.Sprintf("%dh%02dm", hours, minutes)
+	}
+	return fmt.Sprintf("%dm", minutes)
+}
+
+func formatMinutes(d time.Duration) string {
+	return strconv.FormatFloat(d.Minutes(), 'f', 1, 64)
+}
+
+// formatRatio formats an overrun ratio such as 1.25 as "1.25x", or "-" if nothing was planned.
+func formatRatio(ratio float64) string {
+	if ratio == 0 {
+		return "-"
+	}
+	return strconv.FormatFloat(ratio, 'f', 2, 64) + "x"
+}
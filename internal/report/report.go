@@ -0,0 +1,145 @@
+package report
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"gobox/internal/history"
+)
+
+// Summary aggregates the sessions of one group, e.g. one day or one file.
+type Summary struct {
+	Key      string        // Group key, e.g. "2025-06-02", "2025-W23" or a file path
+	Sessions int           // Number of completed sessions
+	Planned  time.Duration // Sum of planned durations of sessions that had a timebox
+	Actual   time.Duration // Sum of actual time spent
+	Commits  int           // Number of commits made during the sessions
+
+	// plannedActual is the actual time of sessions that had a timebox, so the
+	// overrun ratio isn't skewed by sessions without a plan.
+	plannedActual time.Duration
+}
+
+// OverrunRatio returns actual / planned time for sessions that had a timebox.
+// 1 means the estimates were spot on, above 1 means they were too optimistic.
+// It returns 0 if nothing was planned.
+func (s Summary) OverrunRatio() float64 {
+	if s.Planned <= 0 {
+		return 0
+	}
+	return float64(s.plannedActual) / float64(s.Planned)
+}
+
+// CommitsPerSession returns the average number of commits per session.
+func (s Summary) CommitsPerSession() float64 {
+	if s.Sessions == 0 {
+		return 0
+	}
+	return float64(s.Commits) / float64(s.Sessions)
+}
+
+func (s *Summary) add(e history.Entry) {
+	s.Sessions++
+	s.Actual += e.Actual
+	s.Commits += len(e.Commits)
+	if e.Planned > 0 {
+		s.Planned += e.Planned
+		s.plannedActual += e.Actual
+	}
+}
+
+// Overrun is a single session measured against its estimate.
+type Overrun struct {
+	Entry history.Entry
+	Ratio float64 // actual / planned
+}
+
+// Report holds the summaries produced from a set of history entries.
+type Report struct {
+	Total         Summary
+	ByDay         []Summary
+	ByWeek        []Summary
+	ByFile        []Summary
+	WorstOverruns []Overrun
+}
+
+// GroupFunc returns the key of the group an entry belongs to.
+type GroupFunc func(history.Entry) string
+
+// ByDay groups entries by local completion date.
+func ByDay(e history.Entry) string {
+	return e.CompletedAt.Local().Format("2006-01-02")
+}
+
+// ByWeek groups entries by ISO week of the local completion date.
+func ByWeek(e history.Entry) string {
+	year, week := e.CompletedAt.Local().ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
+
+// ByFile groups entries by markdown file.
+func ByFile(e history.Entry) string {
+	return filepath.Clean(e.File)
+}
+
+// Build creates a report from history entries, listing at most top worst overruns.
+func Build(entries []history.Entry, top int) Report {
+	r := Report{
+		Total:         Summary{Key: "total"},
+		ByDay:         Group(entries, ByDay),
+		ByWeek:        Group(entries, ByWeek),
+		ByFile:        Group(entries, ByFile),
+		WorstOverruns: WorstOverruns(entries, top),
+	}
+	for _, e := range entries {
+		r.Total.add(e)
+	}
+	return r
+}
+
+// Group summarizes entries per group, sorted by key.
+func Group(entries []history.Entry, group GroupFunc) []Summary {
+	byKey := make(map[string]*Summary)
+	var keys []string
+	for _, e := range entries {
+		key := group(e)
+		s, ok := byKey[key]
+		if !ok {
+			s = &Summary{Key: key}
+			byKey[key] = s
+			keys = append(keys, key)
+		}
+		s.add(e)
+	}
+
+	sort.Strings(keys)
+	summaries := make([]Summary, 0, len(keys))
+	for _, key := range keys {
+		summaries = append(summaries, *byKey[key])
+	}
+	return summaries
+}
+
+// WorstOverruns returns up to n sessions that overran their timebox the most, worst first.
+// Sessions without a timebox or that finished within it are left out, and so are all of
+// them if n is not positive.
+func WorstOverruns(entries []history.Entry, n int) []Overrun {
+	n = max(n, 0)
+	var overruns []Overrun
+	for _, e := range entries {
+		if e.Planned <= 0 || e.Actual <= e.Planned {
+			continue
+		}
+		overruns = append(overruns, Overrun{Entry: e, Ratio: float64(e.Actual) / float64(e.Planned)})
+	}
+
+	sort.SliceStable(overruns, func(i, j int) bool {
+		return overruns[i].Ratio > overruns[j].Ratio
+	})
+	if len(overruns) > n {
+		overruns = overruns[:n]
+	}
+	return overruns
+}
@@ -0,0 +1,146 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"gobox/internal/history"
+)
+
+func entry(desc, file string, planned, actual time.Duration, commits int, completedAt time.Time) history.Entry {
+	e := history.Entry{
+		TaskID:      desc,
+		Description: desc,
+		File:        file,
+		Planned:     planned,
+		Actual:      actual,
+		CompletedAt: completedAt,
+	}
+	for i := 0; i < commits; i++ {
+		e.Commits = append(e.Commits, "abc123 commit")
+	}
+	return e
+}
+
+func testEntries() []history.Entry {
+	mon := time.Date(2025, 6, 2, 10, 0, 0, 0, time.Local)
+	return []history.Entry{
+		entry("Write parser", "/tmp/a.md", time.Hour, 90*time.Minute, 2, mon),
+		entry("Fix bug", "/tmp/a.md", 30*time.Minute, 20*time.Minute, 1, mon.Add(2*time.Hour)),
+		entry("Read docs", "/tmp/b.md", 0, 15*time.Minute, 0, mon.AddDate(0, 0, 1)),
+		entry("Refactor", "/tmp/b.md", 15*time.Minute, time.Hour, 3, mon.AddDate(0, 0, 7)),
+	}
+}
+
+func TestGroupByDay(t *testing.T) {
+	days := Group(testEntries(), ByDay)
+	if len(days) != 3 {
+		t.Fatalf("expected 3 days, got %d", len(days))
+	}
+
+	first := days[0]
+	if first.Key != "2025-06-02" || first.Sessions != 2 || first.Commits != 3 {
+		t.Errorf("unexpected first day summary: %+v", first)
+	}
+	if first.Planned != 90*time.Minute || first.Actual != 110*time.Minute {
+		t.Errorf("unexpected planned/actual: %v/%v", first.Planned, first.Actual)
+	}
+	if got := first.CommitsPerSession(); got != 1.5 {
+		t.Errorf("expected 1.5 commits per session, got %v", got)
+	}
+}
+
+func TestGroupByWeek(t *testing.T) {
+	weeks := Group(testEntries(), ByWeek)
+	if len(weeks) != 2 {
+		t.Fatalf("expected 2 weeks, got %d", len(weeks))
+	}
+	if weeks[0].Key != "2025-W23" || weeks[0].Sessions != 3 {
+		t.Errorf("unexpected first week: %+v", weeks[0])
+	}
+	if weeks[1].Key != "2025-W24" || weeks[1].Sessions != 1 {
+		t.Errorf("unexpected second week: %+v", weeks[1])
+	}
+}
+
+func TestOverrunRatioIgnoresUnplannedSessions(t *testing.T) {
+	files := Group(testEntries(), ByFile)
+	if len(files) != 2 {
+		t.Fatalf("expected 2 files, got %d", len(files))
+	}
+
+	// b.md: the unplanned 15m session must not count towards the ratio, 60m/15m = 4
+	b := files[1]
+	if b.Key != "/tmp/b.md" {
+		t.Fatalf("unexpected key %q", b.Key)
+	}
+	if got := b.OverrunRatio(); got != 4 {
+		t.Errorf("expected overrun ratio 4, got %v", got)
+	}
+	if got := (Summary{Actual: time.Hour}).OverrunRatio(); got != 0 {
+		t.Errorf("expected 0 without planned time, got %v", got)
+	}
+}
+
+func TestWorstOverruns(t *testing.T) {
+	overruns := WorstOverruns(testEntries(), 5)
+	if len(overruns) != 2 {
+		t.Fatalf("expected 2 overruns, got %d", len(overruns))
+	}
+	if overruns[0].Entry.Description != "Refactor" || overruns[0].Ratio != 4 {
+		t.Errorf("expected Refactor first with ratio 4, got %s %v", overruns[0].Entry.Description, overruns[0].Ratio)
+	}
+	if overruns[1].Entry.Description != "Write parser" {
+		t.Errorf("expected Write parser second, got %s", overruns[1].Entry.Description)
+	}
+
+	if got := WorstOverruns(testEntries(), -1); len(got) != 0 {
+		t.Errorf("expected no overruns for a negative count, got %d", len(got))
+	}
+	if got := WorstOverruns(testEntries(), 1); len(got) != 1 {
+		t.Errorf("expected top to limit overruns to 1, got %d", len(got))
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, Build(testEntries(), 5), FormatCSV); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	// header + 3 days + 2 weeks + 2 files + total + 2 overruns
+	if len(lines) != 11 {
+		t.Fatalf("expected 11 lines, got %d:\n%s", len(lines), buf.String())
+	}
+	if !strings.HasPrefix(lines[0], "group,key,sessions") {
+		t.Errorf("unexpected header: %s", lines[0])
+	}
+	if lines[8] != "total,total,4,105.0,185.0,1.62,6,1.50" {
+		t.Errorf("unexpected total row: %s", lines[8])
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, Build(testEntries(), 5), FormatJSON); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	var out jsonReport
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if out.Total.Sessions != 4 || len(out.ByDay) != 3 || len(out.WorstOverruns) != 2 {
+		t.Errorf("unexpected report: %+v", out)
+	}
+}
+
+func TestWriteUnknownFormat(t *testing.T) {
+	if err := Write(&bytes.Buffer{}, Report{}, "xml"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,114 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gobox/internal/gitutil"
+)
+
+// SuspendGap is how far the wall clock may move between two ticks before the machine is
+// taken to have been suspended in between. Tickers go by the monotonic clock, which
+// stops while the machine is suspended, the wall clock doesn't.
+const SuspendGap = 30 * time.Second
+
+// IdleCheckInterval is how often the idle sources are asked for the last activity.
+// Idle sessions are paused as of their last activity, so checking more often only
+// shows the pause sooner.
+const IdleCheckInterval = 10 * time.Second
+
+// idleCommandTimeout is how long an idle command may take to answer.
+const idleCommandTimeout = 5 * time.Second
+
+// IdlePause tells why and as of when a session was paused automatically.
+type IdlePause struct {
+	Since     time.Time // When the session was last seen active; its segment was closed there
+	Suspended bool      // Whether the machine was suspended, rather than the user idle
+}
+
+// IdleSource tells when the user was last active.
+type IdleSource interface {
+	// LastActive returns when activity was last seen, the zero time if none was.
+	LastActive(now time.Time) (time.Time, error)
+}
+
+// Activity is an IdleSource fed by the caller, e.g. with the keys typed in the TUI.
+type Activity struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+// Record records activity at t.
+func (a *Activity) Record(t time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if t.After(a.last) {
+		a.last = t
+	}
+}
+
+// LastActive returns when activity was last recorded.
+func (a *Activity) LastActive(time.Time) (time.Time, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.last, nil
+}
+
+// RepoActivity is an IdleSource that goes by the files changed in the working trees of
+// repositories, see gitutil.LastModified.
+type RepoActivity struct {
+	Repos []gitutil.Repo
+}
+
+// LastActive returns when a file in one of the repositories was last changed.
+func (r RepoActivity) LastActive(time.Time) (time.Time, error) {
+	var last time.Time
+	for _, repo := range r.Repos {
+		modified, err := gitutil.LastModified(repo)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if modified.After(last) {
+			last = modified
+		}
+	}
+	return last, nil
+}
+
+// IdleCommand is an IdleSource that asks a shell command how long the user has been
+// idle, e.g. xprintidle. The command prints a number of milliseconds or a duration like
+// "90s".
+type IdleCommand struct {
+	Command string
+}
+
+// LastActive runs the command and returns now less the idle time it printed.
+func (c IdleCommand) LastActive(now time.Time) (time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), idleCommandTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "sh", "-c", c.Command).Output()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("idle command %q failed: %w", c.Command, err)
+	}
+	idle, err := parseIdleTime(strings.TrimSpace(string(out)))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("idle command %q: %w", c.Command, err)
+	}
+	return now.Add(-idle), nil
+}
+
+// parseIdleTime parses the output of an idle command: milliseconds, or a duration.
+func parseIdleTime(s string) (time.Duration, error) {
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil && ms >= 0 {
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return d, nil
+	}
+	return 0, fmt.Errorf("%q is neither a number of milliseconds nor a duration", s)
+}
@@ -0,0 +1,121 @@
+package session
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gobox/internal/state"
+	"gobox/pkg/task"
+)
+
+// fakeSource is an IdleSource that saw activity at last, or fails with err.
+type fakeSource struct {
+	last time.Time
+	err  error
+}
+
+func (f fakeSource) LastActive(time.Time) (time.Time, error) {
+	return f.last, f.err
+}
+
+// runningSession returns a runner whose session has run since start and last ticked at
+// lastTick, without ticking on its own.
+func runningSession(start, lastTick time.Time) *SessionRunner {
+	tbState := &state.TimeBoxState{Segments: []state.TimeSegment{{Start: start}}}
+	runner := NewSessionRunner(task.Task{Description: "Idle task", TimeBox: "@2h"}, tbState, 2*time.Hour, time.Time{})
+	runner.lastTick = lastTick
+	runner.runningSince = start
+	return runner
+}
+
+func TestSuspendPausesAtLastTick(t *testing.T) {
+	start := time.Now().Add(-time.Hour).Round(0)
+	runner := runningSession(start, start)
+	if !runner.tick(start.Add(time.Second)) {
+		t.Fatal("a regular tick should keep the session going")
+	}
+
+	lastTick := start.Add(time.Second)
+	if runner.tick(time.Now()) {
+		t.Fatal("a tick an hour after the last one should pause the session")
+	}
+	idle := runner.Idle()
+	if idle == nil || !idle.Suspended || !idle.Since.Equal(lastTick) {
+		t.Fatalf("expected a suspend since %v, got %+v", lastTick, idle)
+	}
+	if runner.State.IsActive() || !runner.State.Segments[0].End.Equal(lastTick) {
+		t.Errorf("segment should end at the last tick: %+v", runner.State.Segments)
+	}
+	if ev := <-runner.Events(); ev != EventTick {
+		t.Errorf("expected EventTick for the regular tick, got %v", ev)
+	}
+	if ev := <-runner.Events(); ev != EventIdle {
+		t.Errorf("expected EventIdle, got %v", ev)
+	}
+
+	// Keeping the time opens the segment again
+	runner.KeepIdle()
+	defer runner.Stop()
+	if !runner.State.IsActive() || len(runner.State.Segments) != 1 || runner.Idle() != nil {
+		t.Errorf("keeping the idle time should reopen the segment: %+v", runner.State.Segments)
+	}
+}
+
+func TestIdleSourcesPauseAtLastActivity(t *testing.T) {
+	now := time.Now()
+	start := now.Add(-20 * time.Minute)
+	active := start.Add(5 * time.Minute)
+
+	runner := runningSession(start, now.Add(-time.Second))
+	runner.IdleAfter = 10 * time.Minute
+	runner.IdleSources = []IdleSource{fakeSource{last: start.Add(time.Minute)}, fakeSource{last: active}}
+	if runner.tick(now) {
+		t.Fatal("expected the idle session to be paused")
+	}
+	if idle := runner.Idle(); idle == nil || idle.Suspended || !idle.Since.Equal(active) {
+		t.Fatalf("expected to be idle since %v, got %+v", active, idle)
+	}
+
+	// Resuming leaves the idle time out
+	runner.Resume()
+	defer runner.Stop()
+	if len(runner.State.Segments) != 2 || !runner.State.Segments[0].End.Equal(active) || runner.Idle() != nil {
+		t.Errorf("resuming should start a new segment: %+v", runner.State.Segments)
+	}
+}
+
+func TestIdleSourcesKeepSessionGoing(t *testing.T) {
+	now := time.Now()
+	start := now.Add(-20 * time.Minute)
+
+	for name, source := range map[string]fakeSource{
+		"recent activity": {last: now.Add(-time.Minute)},
+		"failing source":  {err: errors.New("no display")},
+	} {
+		t.Run(name, func(t *testing.T) {
+			runner := runningSession(start, now.Add(-time.Second))
+			runner.IdleAfter = 10 * time.Minute
+			runner.IdleSources = []IdleSource{source}
+			if !runner.tick(now) || runner.Idle() != nil {
+				t.Error("expected the session to go on")
+			}
+		})
+	}
+}
+
+func TestParseIdleTime(t *testing.T) {
+	for input, want := range map[string]time.Duration{
+		"1500": 1500 * time.Millisecond,
+		"90s":  90 * time.Second,
+	} {
+		if got, err := parseIdleTime(input); err != nil || got != want {
+			t.Errorf("parseIdleTime(%q) = %v, %v, want %v", input, got, err, want)
+		}
+	}
+	for _, input := range []string{"", "soon", "-5"} {
+		if _, err := parseIdleTime(input); err == nil {
+			t.Errorf("expected an error for %q", input)
+		}
+	}
+}
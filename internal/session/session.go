@@ -17,6 +17,7 @@ const (
 	EventResumed
 	EventCompleted
 	EventStopped
+	EventIdle // paused automatically, see SessionRunner.Idle
 )
 
 // SessionRunner manages a timeboxed session for a task, including pause/resume and segment tracking.
@@ -34,6 +35,16 @@ type SessionRunner struct {
 	wg                       sync.WaitGroup
 	lastTick                 time.Time
 	previousSegmentsDuration time.Duration // cached sum of closed segments durations
+	pausedAt                 time.Time     // when the current pause started
+	pausedDuration           time.Duration // sum of finished pauses in this session
+
+	// Idle sessions are paused once no source saw activity for IdleAfter, zero to only
+	// pause them when the machine was suspended. Set before Start.
+	IdleAfter    time.Duration
+	IdleSources  []IdleSource
+	idle         *IdlePause // why the session is paused automatically, nil unless it is
+	idleChecked  time.Time  // when the idle sources were last asked
+	runningSince time.Time  // when the session last started or resumed, the earliest activity counted
 }
 
 // NewSessionRunner creates a new session runner for a task and its state.
@@ -67,40 +78,155 @@ func (sr *SessionRunner) Start() {
 			sr.previousSegmentsDuration += seg.End.Sub(seg.Start)
 		}
 	}
-	// Initialize lastTick to now
-	sr.lastTick = time.Now()
-	sr.ticker = time.NewTicker(1 * time.Second)
-	sr.wg.Add(1)
+	ticks := sr.startTicker(time.Now())
 	sr.Mutex.Unlock()
 
 	// Send initial tick immediately to update UI
 	sr.eventCh <- EventTick
 
-	go func() {
-		defer sr.wg.Done()
-		for {
-			select {
-			case tickTime := <-sr.ticker.C:
-				sr.Mutex.Lock()
-				// Update lastTick
-				sr.lastTick = tickTime
-				sr.Mutex.Unlock()
-
-				select {
-				case sr.eventCh <- EventTick:
-				default:
-					// drop tick event if channel is full
-				}
-
-				if sr.isTimeUp() {
-					sr.Complete()
-					return
-				}
-			case <-sr.stopCh:
+	go sr.run(ticks)
+}
+
+// startTicker starts ticking every second as of now, and returns the ticks for run.
+// The goroutine keeps its own reference, sr.ticker is reset when the session ends.
+// Called with the mutex held.
+func (sr *SessionRunner) startTicker(now time.Time) <-chan time.Time {
+	sr.lastTick = now
+	sr.runningSince = now
+	sr.ticker = time.NewTicker(1 * time.Second)
+	sr.wg.Add(1)
+	return sr.ticker.C
+}
+
+// run emits a tick event for each tick until the session ends or is paused automatically.
+func (sr *SessionRunner) run(ticks <-chan time.Time) {
+	defer sr.wg.Done()
+	for {
+		select {
+		case tickTime := <-ticks:
+			if !sr.tick(tickTime) {
 				return
 			}
+		case <-sr.stopCh:
+			return
 		}
-	}()
+	}
+}
+
+// tick handles a tick at tickTime and reports whether the session goes on. A session
+// is paused as of the previous tick if the machine was suspended in between, and as of
+// the last activity once it has been idle for IdleAfter.
+func (sr *SessionRunner) tick(tickTime time.Time) bool {
+	sr.Mutex.Lock()
+	lastTick := sr.lastTick
+	sr.lastTick = tickTime
+	sr.Mutex.Unlock()
+
+	if tickTime.Round(0).Sub(lastTick.Round(0)) > SuspendGap {
+		sr.pauseIdle(IdlePause{Since: lastTick, Suspended: true})
+		return false
+	}
+	if since, idle := sr.idleSince(tickTime); idle {
+		sr.pauseIdle(IdlePause{Since: since})
+		return false
+	}
+
+	select {
+	case sr.eventCh <- EventTick:
+	default:
+		// drop tick event if channel is full
+	}
+
+	if sr.isTimeUp() {
+		sr.Complete()
+		return false
+	}
+	return true
+}
+
+// idleSince asks the idle sources for the last activity, every IdleCheckInterval, and
+// reports whether there was none for IdleAfter. A source that fails can't tell the user
+// is idle, so the session goes on.
+func (sr *SessionRunner) idleSince(now time.Time) (time.Time, bool) {
+	sr.Mutex.Lock()
+	due := sr.IdleAfter > 0 && len(sr.IdleSources) > 0 && now.Sub(sr.idleChecked) >= IdleCheckInterval
+	if due {
+		sr.idleChecked = now
+	}
+	after, sources, active := sr.IdleAfter, sr.IdleSources, sr.runningSince
+	sr.Mutex.Unlock()
+	if !due {
+		return time.Time{}, false
+	}
+
+	for _, source := range sources {
+		last, err := source.LastActive(now)
+		if err != nil {
+			return time.Time{}, false
+		}
+		if last.After(active) {
+			active = last
+		}
+	}
+	return active, now.Sub(active) >= after
+}
+
+// pauseIdle pauses the session as of p.Since, leaving out the time since from the
+// closed segment, and emits EventIdle. KeepIdle counts the time after all.
+func (sr *SessionRunner) pauseIdle(p IdlePause) {
+	sr.Mutex.Lock()
+	defer sr.Mutex.Unlock()
+	if sr.Paused || sr.Completed || !sr.State.IsActive() {
+		return
+	}
+	p.Since = p.Since.Round(0)
+	sr.State.CloseAt(p.Since)
+	p.Since = *sr.State.PausedAt
+	sr.Paused = true
+	sr.pausedAt = p.Since
+	sr.idle = &p
+	if sr.ticker != nil {
+		sr.ticker.Stop()
+	}
+	select {
+	case sr.eventCh <- EventIdle:
+	default:
+		// drop event if channel is full
+	}
+}
+
+// Idle returns why and as of when the session was paused automatically, nil unless it
+// is paused for that.
+func (sr *SessionRunner) Idle() *IdlePause {
+	sr.Mutex.Lock()
+	defer sr.Mutex.Unlock()
+	if sr.idle == nil {
+		return nil
+	}
+	p := *sr.idle
+	return &p
+}
+
+// KeepIdle resumes a session that was paused automatically, counting the time it was
+// paused as worked: the segment closed by the pause is opened again. Resume leaves the
+// time out instead.
+func (sr *SessionRunner) KeepIdle() {
+	sr.Mutex.Lock()
+	defer sr.Mutex.Unlock()
+	if sr.idle == nil || !sr.Paused || sr.Completed {
+		return
+	}
+	sr.State.Segments[len(sr.State.Segments)-1].End = nil
+	sr.State.PausedAt = nil
+	sr.Paused = false
+	sr.pausedAt = time.Time{}
+	sr.idle = nil
+	go sr.run(sr.startTicker(time.Now()))
+	select {
+	case sr.eventCh <- EventResumed:
+	default:
+		// drop event if channel is full
+	}
 }
 
 // Pause pauses the session and closes the current segment.
@@ -118,13 +244,20 @@ func (sr *SessionRunner) Pause() {
 		}
 	}
 	sr.Paused = true
+	sr.pausedAt = now
+	sr.State.PausedAt = &now
 	if sr.ticker != nil {
 		sr.ticker.Stop()
 	}
-	sr.eventCh <- EventPaused
+	select {
+	case sr.eventCh <- EventPaused:
+	default:
+		// drop event if channel is full
+	}
 }
 
-// Resume resumes the session and starts a new segment.
+// Resume resumes the session and starts a new segment. The time a session paused
+// automatically was idle is left out, see KeepIdle.
 func (sr *SessionRunner) Resume() {
 	sr.Mutex.Lock()
 	defer sr.Mutex.Unlock()
@@ -132,6 +265,9 @@ func (sr *SessionRunner) Resume() {
 		return
 	}
 	now := time.Now()
+	sr.pausedDuration += now.Sub(sr.pausedAt)
+	sr.pausedAt = time.Time{}
+	sr.State.PausedAt = nil
 	sr.State.Segments = append(sr.State.Segments, state.TimeSegment{Start: now, End: nil})
 	// Calculate and cache previous segments duration on resume
 	sr.previousSegmentsDuration = 0
@@ -141,34 +277,13 @@ func (sr *SessionRunner) Resume() {
 		}
 	}
 	sr.Paused = false
-	sr.ticker = time.NewTicker(1 * time.Second)
-	sr.wg.Add(1)
-	go func() {
-		defer sr.wg.Done()
-		for {
-			select {
-			case tickTime := <-sr.ticker.C:
-				sr.Mutex.Lock()
-				// Update lastTick
-				sr.lastTick = tickTime
-				sr.Mutex.Unlock()
-
-				select {
-				case sr.eventCh <- EventTick:
-				default:
-					// drop tick event if channel is full
-				}
-
-				if sr.isTimeUp() {
-					sr.Complete()
-					return
-				}
-			case <-sr.stopCh:
-				return
-			}
-		}
-	}()
-	sr.eventCh <- EventResumed
+	sr.idle = nil
+	go sr.run(sr.startTicker(now))
+	select {
+	case sr.eventCh <- EventResumed:
+	default:
+		// drop event if channel is full
+	}
 }
 
 // Complete ends the session, closes the current segment, and emits EventCompleted.
@@ -186,6 +301,7 @@ func (sr *SessionRunner) Complete() {
 		}
 	}
 	sr.Completed = true
+	sr.State.PausedAt = nil
 	if sr.ticker != nil {
 		sr.ticker.Stop()
 		sr.ticker = nil
@@ -214,7 +330,7 @@ func (sr *SessionRunner) Complete() {
 	}
 }
 
-// Stop ends the session without marking it as completed.
+// Stop ends the session without marking it as completed, closing its running segment.
 func (sr *SessionRunner) Stop() {
 	sr.Mutex.Lock()
 	defer sr.Mutex.Unlock()
@@ -223,7 +339,17 @@ func (sr *SessionRunner) Stop() {
 	if sr.Completed {
 		return
 	}
-	
+
+	// Close the running segment, so the stopped session doesn't look active
+	now := time.Now()
+	if len(sr.State.Segments) > 0 {
+		last := &sr.State.Segments[len(sr.State.Segments)-1]
+		if last.End == nil {
+			last.End = &now
+		}
+	}
+	sr.State.PausedAt = nil
+
 	if sr.ticker != nil {
 		sr.ticker.Stop()
 	}
@@ -298,6 +424,17 @@ func (sr *SessionRunner) TotalElapsed() time.Duration {
 	return sr.previousSegmentsDuration + ongoingDuration
 }
 
+// PausedDuration returns the total time the session has spent paused, including the current pause.
+func (sr *SessionRunner) PausedDuration() time.Duration {
+	sr.Mutex.Lock()
+	defer sr.Mutex.Unlock()
+
+	if sr.Paused {
+		return sr.pausedDuration + time.Since(sr.pausedAt)
+	}
+	return sr.pausedDuration
+}
+
 // Remaining returns the time remaining in the session.
 // For duration-based sessions, it returns the duration minus elapsed time.
 // For end-time-based sessions, it returns the time until the end time.
@@ -198,3 +198,45 @@ func TestSessionRunner_EndTime(t *testing.T) {
 	}
 	runner.Wait()
 }
+
+func TestSessionRunner_PausedDuration(t *testing.T) {
+	tbTask := task.Task{
+		Description: "Paused Task",
+		TimeBox:     "@10m",
+		IsChecked:   false,
+	}
+	tbState := &state.TimeBoxState{
+		TaskHash: tbTask.Hash(),
+		Segments: []state.TimeSegment{},
+	}
+
+	runner := NewSessionRunner(tbTask, tbState, 10*time.Minute, time.Time{})
+	runner.Start()
+	defer runner.Stop()
+
+	if got := runner.PausedDuration(); got != 0 {
+		t.Errorf("PausedDuration() before pausing = %v, want 0", got)
+	}
+
+	runner.Pause()
+	time.Sleep(50 * time.Millisecond)
+	paused := runner.PausedDuration()
+	if paused < 50*time.Millisecond {
+		t.Errorf("PausedDuration() while paused = %v, want >= 50ms", paused)
+	}
+	frozen := runner.TotalElapsed()
+	time.Sleep(20 * time.Millisecond)
+	if got := runner.TotalElapsed(); got != frozen {
+		t.Errorf("TotalElapsed() should not advance while paused: %v != %v", got, frozen)
+	}
+
+	runner.Resume()
+	resumed := runner.PausedDuration()
+	if resumed < paused {
+		t.Errorf("PausedDuration() after resume = %v, want >= %v", resumed, paused)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if got := runner.PausedDuration(); got != resumed {
+		t.Errorf("PausedDuration() should not advance after resume: %v != %v", got, resumed)
+	}
+}
@@ -0,0 +1,18 @@
+package sqlstore
+
+import (
+	"net/url"
+
+	_ "modernc.org/sqlite" // pure Go SQLite, no cgo
+)
+
+const driverName = "sqlite"
+
+// dsn returns the data source name for the database at path. Write-ahead logging lets
+// readers go on while another process writes, writers wait for each other instead of
+// failing, and transactions take the write lock up front so that they never need to
+// upgrade a read lock, which can deadlock.
+func dsn(path string) string {
+	return "file:" + (&url.URL{Path: path}).EscapedPath() +
+		"?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)&_txlock=immediate"
+}
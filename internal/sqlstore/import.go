@@ -0,0 +1,109 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gobox/internal/core"
+	"gobox/internal/history"
+	"gobox/internal/state"
+)
+
+// Import copies the sessions in the JSON state files and the entries of the JSONL history
+// logs into the store. Each file is imported once: files imported before, as well as
+// missing files, are skipped, and the files themselves are left as they are. States of
+// tasks the store already tracks are kept over the imported ones. It returns the files
+// it imported.
+func (s *Store) Import(stateFiles, historyFiles []string) ([]string, error) {
+	var imported []string
+	err := s.inTx(func(ctx context.Context, tx *sql.Tx) error {
+		states, err := loadStates(ctx, tx)
+		if err != nil {
+			return err
+		}
+		statesChanged := false
+		for _, file := range stateFiles {
+			path, ok, err := importable(ctx, tx, file)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			fileStates, err := core.NewFileStateStore(path).Load()
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", path, err)
+			}
+			for _, st := range fileStates {
+				if state.FindState(states, st.File, st.TaskID, st.TaskHash, "") < 0 {
+					states = append(states, st)
+					statesChanged = true
+				}
+			}
+			if err := markImported(ctx, tx, path); err != nil {
+				return err
+			}
+			imported = append(imported, path)
+		}
+		if statesChanged {
+			if err := saveStates(ctx, tx, states); err != nil {
+				return err
+			}
+		}
+
+		for _, file := range historyFiles {
+			path, ok, err := importable(ctx, tx, file)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			entries, err := history.NewFileLog(path).Entries()
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				if err := appendEntry(ctx, tx, e); err != nil {
+					return err
+				}
+			}
+			if err := markImported(ctx, tx, path); err != nil {
+				return err
+			}
+			imported = append(imported, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return imported, nil
+}
+
+// importable returns the absolute path of file and whether it exists and hasn't been
+// imported yet.
+func importable(ctx context.Context, tx *sql.Tx, file string) (string, bool, error) {
+	path, err := filepath.Abs(file)
+	if err != nil {
+		return "", false, err
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return path, false, nil
+	}
+	var n int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM imports WHERE path = ?`, path).Scan(&n); err != nil {
+		return "", false, err
+	}
+	return path, n == 0, nil
+}
+
+func markImported(ctx context.Context, tx *sql.Tx, path string) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO imports (path, imported_at) VALUES (?, ?)`, path, toTime(time.Now()))
+	return err
+}
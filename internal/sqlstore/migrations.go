@@ -0,0 +1,102 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// migrations are the schema changes, one list of statements per version. The version of
+// a database is the number of migrations applied to it, kept in PRAGMA user_version.
+// Append new migrations; never change one that has been released.
+var migrations = [][]string{
+	// 1: state of unfinished tasks, completed sessions and the files they belong to
+	{
+		`CREATE TABLE task_files (
+			id   INTEGER PRIMARY KEY,
+			path TEXT NOT NULL UNIQUE
+		)`,
+		`CREATE TABLE tasks (
+			id          INTEGER PRIMARY KEY,
+			position    INTEGER NOT NULL,
+			task_id     TEXT NOT NULL DEFAULT '',
+			task_hash   TEXT NOT NULL,
+			file_id     INTEGER REFERENCES task_files (id),
+			description TEXT NOT NULL DEFAULT '',
+			timebox     TEXT NOT NULL DEFAULT '',
+			completed   INTEGER NOT NULL DEFAULT 0,
+			paused_at   INTEGER,
+			refs        TEXT
+		)`,
+		`CREATE TABLE sessions (
+			id           INTEGER PRIMARY KEY,
+			task_key     TEXT NOT NULL,
+			file_id      INTEGER REFERENCES task_files (id),
+			description  TEXT NOT NULL DEFAULT '',
+			timebox      TEXT NOT NULL DEFAULT '',
+			planned      INTEGER NOT NULL,
+			actual       INTEGER NOT NULL,
+			diff         TEXT,
+			completed_at INTEGER NOT NULL
+		)`,
+		`CREATE INDEX sessions_completed_at ON sessions (completed_at)`,
+		`CREATE INDEX sessions_task_key ON sessions (task_key)`,
+		`CREATE TABLE segments (
+			id         INTEGER PRIMARY KEY,
+			task_row   INTEGER REFERENCES tasks (id) ON DELETE CASCADE,
+			session_id INTEGER REFERENCES sessions (id) ON DELETE CASCADE,
+			started_at INTEGER NOT NULL,
+			ended_at   INTEGER,
+			CHECK ((task_row IS NULL) != (session_id IS NULL))
+		)`,
+		`CREATE INDEX segments_task_row ON segments (task_row)`,
+		`CREATE INDEX segments_session_id ON segments (session_id)`,
+		`CREATE TABLE commits (
+			id         INTEGER PRIMARY KEY,
+			session_id INTEGER NOT NULL REFERENCES sessions (id) ON DELETE CASCADE,
+			line       TEXT NOT NULL
+		)`,
+		`CREATE INDEX commits_session_id ON commits (session_id)`,
+		`CREATE TABLE imports (
+			path        TEXT PRIMARY KEY,
+			imported_at INTEGER NOT NULL
+		)`,
+	},
+	// 2: heartbeats of running sessions, to tell those left over by a killed gobox
+	{
+		`ALTER TABLE tasks ADD COLUMN heartbeat INTEGER`,
+	},
+}
+
+// SchemaVersion is the version of the schema this package reads and writes.
+var SchemaVersion = len(migrations)
+
+// migrate brings the schema of db up to SchemaVersion. It runs in a transaction, so
+// processes opening the database at the same time apply each migration once.
+func migrate(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var version int
+	if err := tx.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if version > SchemaVersion {
+		return fmt.Errorf("database schema version %d is newer than this gobox supports (%d)", version, SchemaVersion)
+	}
+	for ; version < SchemaVersion; version++ {
+		for _, stmt := range migrations[version] {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("migration %d failed: %w", version+1, err)
+			}
+		}
+	}
+	// PRAGMA doesn't take parameters
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`PRAGMA user_version = %d`, version)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,417 @@
+// Package sqlstore keeps session state and history in an SQLite database, an alternative
+// to the JSON state file and the JSONL history log that scales to a long history and to
+// several gobox processes sharing it.
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gobox/internal/gitutil"
+	"gobox/internal/history"
+	"gobox/internal/state"
+)
+
+// Store keeps the state of unfinished tasks and the history of completed sessions in an
+// SQLite database. It implements core.StateStore and history.Log. Every read and write
+// is a transaction of its own, so the database can be shared by several gobox processes
+// and goroutines.
+type Store struct {
+	db *sql.DB
+}
+
+// Open opens the database at path, creating it if needed, and migrates its schema.
+func Open(path string) (*Store, error) {
+	db, err := sql.Open(driverName, dsn(path))
+	if err != nil {
+		return nil, err
+	}
+	// SQLite serializes writers anyway; a single connection per process keeps writes
+	// from waiting on reads of the same process.
+	db.SetMaxOpenConns(1)
+	s, err := New(db)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// New returns a store for db, migrating its schema to SchemaVersion.
+func New(db *sql.DB) (*Store, error) {
+	if err := migrate(context.Background(), db); err != nil {
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// inTx runs fn in a transaction, committing it if fn succeeds.
+func (s *Store) inTx(fn func(ctx context.Context, tx *sql.Tx) error) error {
+	ctx := context.Background()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(ctx, tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Load returns the states of all unfinished tasks, in the order they were saved.
+func (s *Store) Load() ([]state.TimeBoxState, error) {
+	var states []state.TimeBoxState
+	err := s.inTx(func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		states, err = loadStates(ctx, tx)
+		return err
+	})
+	return states, err
+}
+
+// Save replaces the states of all tasks with states.
+func (s *Store) Save(states []state.TimeBoxState) error {
+	return s.inTx(func(ctx context.Context, tx *sql.Tx) error {
+		return saveStates(ctx, tx, states)
+	})
+}
+
+// Update implements core.StateStore. Transactions take the database's write lock as
+// they begin, so no other process writes between loading and saving the states.
+func (s *Store) Update(update func([]state.TimeBoxState) ([]state.TimeBoxState, error)) ([]state.TimeBoxState, error) {
+	var states []state.TimeBoxState
+	err := s.inTx(func(ctx context.Context, tx *sql.Tx) error {
+		loaded, err := loadStates(ctx, tx)
+		if err != nil {
+			return err
+		}
+		if states, err = update(loaded); err != nil {
+			return err
+		}
+		return saveStates(ctx, tx, states)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return states, nil
+}
+
+// RemoveTaskState returns states without the state tracked under taskKey.
+func (s *Store) RemoveTaskState(states []state.TimeBoxState, taskKey string) []state.TimeBoxState {
+	var newStates []state.TimeBoxState
+	for _, st := range states {
+		if st.Key() != taskKey {
+			newStates = append(newStates, st)
+		}
+	}
+	return newStates
+}
+
+func loadStates(ctx context.Context, tx *sql.Tx) ([]state.TimeBoxState, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT t.id, t.task_id, t.task_hash, COALESCE(f.path, ''), t.description, t.timebox,
+			t.completed, t.paused_at, t.heartbeat, t.refs
+		FROM tasks t LEFT JOIN task_files f ON f.id = t.file_id
+		ORDER BY t.position`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var states []state.TimeBoxState
+	index := make(map[int64]int) // task row to index in states
+	for rows.Next() {
+		var (
+			id        int64
+			st        state.TimeBoxState
+			pausedAt  sql.NullInt64
+			heartbeat sql.NullInt64
+			refs      sql.NullString
+		)
+		if err := rows.Scan(&id, &st.TaskID, &st.TaskHash, &st.File, &st.Description, &st.TimeBox,
+			&st.Completed, &pausedAt, &heartbeat, &refs); err != nil {
+			return nil, err
+		}
+		st.PausedAt = fromNullTime(pausedAt)
+		st.Heartbeat = fromNullTime(heartbeat)
+		if refs.Valid {
+			if err := json.Unmarshal([]byte(refs.String), &st.Refs); err != nil {
+				return nil, fmt.Errorf("invalid refs of task %s: %w", st.Key(), err)
+			}
+		}
+		index[id] = len(states)
+		states = append(states, st)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	segments, err := tx.QueryContext(ctx, `
+		SELECT task_row, started_at, ended_at FROM segments
+		WHERE task_row IS NOT NULL ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer segments.Close()
+	for segments.Next() {
+		var (
+			row     int64
+			start   int64
+			endedAt sql.NullInt64
+		)
+		if err := segments.Scan(&row, &start, &endedAt); err != nil {
+			return nil, err
+		}
+		if i, ok := index[row]; ok {
+			states[i].Segments = append(states[i].Segments, state.TimeSegment{Start: fromTime(start), End: fromNullTime(endedAt)})
+		}
+	}
+	return states, segments.Err()
+}
+
+func saveStates(ctx context.Context, tx *sql.Tx, states []state.TimeBoxState) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM segments WHERE task_row IS NOT NULL`); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM tasks`); err != nil {
+		return err
+	}
+	for i, st := range states {
+		fileID, err := taskFileID(ctx, tx, st.File)
+		if err != nil {
+			return err
+		}
+		var refs sql.NullString
+		if len(st.Refs) > 0 {
+			data, err := json.Marshal(st.Refs)
+			if err != nil {
+				return err
+			}
+			refs = sql.NullString{String: string(data), Valid: true}
+		}
+		res, err := tx.ExecContext(ctx, `
+			INSERT INTO tasks (position, task_id, task_hash, file_id, description, timebox, completed, paused_at, heartbeat, refs)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			i, st.TaskID, st.TaskHash, fileID, st.Description, st.TimeBox, st.Completed, toNullTime(st.PausedAt),
+			toNullTime(st.Heartbeat), refs)
+		if err != nil {
+			return fmt.Errorf("failed to save task %s: %w", st.Key(), err)
+		}
+		row, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		for _, seg := range st.Segments {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO segments (task_row, started_at, ended_at) VALUES (?, ?, ?)`,
+				row, toTime(seg.Start), toNullTime(seg.End)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// taskFileID returns the row of the task file at path, adding it if needed. An empty
+// path has no row.
+func taskFileID(ctx context.Context, tx *sql.Tx, path string) (sql.NullInt64, error) {
+	if path == "" {
+		return sql.NullInt64{}, nil
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO task_files (path) VALUES (?) ON CONFLICT (path) DO NOTHING`, path); err != nil {
+		return sql.NullInt64{}, err
+	}
+	var id int64
+	if err := tx.QueryRowContext(ctx, `SELECT id FROM task_files WHERE path = ?`, path).Scan(&id); err != nil {
+		return sql.NullInt64{}, err
+	}
+	return sql.NullInt64{Int64: id, Valid: true}, nil
+}
+
+// Append adds a completed session to the history.
+func (s *Store) Append(e history.Entry) error {
+	return s.inTx(func(ctx context.Context, tx *sql.Tx) error {
+		return appendEntry(ctx, tx, e)
+	})
+}
+
+func appendEntry(ctx context.Context, tx *sql.Tx, e history.Entry) error {
+	fileID, err := taskFileID(ctx, tx, e.File)
+	if err != nil {
+		return err
+	}
+	diff, err := json.Marshal(e.Diff)
+	if err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO sessions (task_key, file_id, description, timebox, planned, actual, diff, completed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.TaskID, fileID, e.Description, e.TimeBox, int64(e.Planned), int64(e.Actual), string(diff), toTime(e.CompletedAt))
+	if err != nil {
+		return fmt.Errorf("failed to add session of task %s: %w", e.TaskID, err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	for _, seg := range e.Segments {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO segments (session_id, started_at, ended_at) VALUES (?, ?, ?)`,
+			id, toTime(seg.Start), toNullTime(seg.End)); err != nil {
+			return err
+		}
+	}
+	for _, line := range e.Commits {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO commits (session_id, line) VALUES (?, ?)`, id, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Entries returns all sessions in the order they were appended.
+func (s *Store) Entries() ([]history.Entry, error) {
+	return s.Query(history.Filter{})
+}
+
+// Query returns the sessions that pass the filter, in the order they were appended. The
+// time range is looked up in the index, so reports over recent weeks stay fast however
+// long the history grows.
+func (s *Store) Query(f history.Filter) ([]history.Entry, error) {
+	where, args := `1`, []any{}
+	if !f.Since.IsZero() {
+		where += ` AND completed_at >= ?`
+		args = append(args, toTime(f.Since))
+	}
+	if !f.Until.IsZero() {
+		where += ` AND completed_at < ?`
+		args = append(args, toTime(f.Until))
+	}
+
+	var entries []history.Entry
+	err := s.inTx(func(ctx context.Context, tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, `
+			SELECT s.id, s.task_key, COALESCE(f.path, ''), s.description, s.timebox, s.planned, s.actual,
+				s.diff, s.completed_at
+			FROM sessions s LEFT JOIN task_files f ON f.id = s.file_id
+			WHERE `+where+` ORDER BY s.id`, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		index := make(map[int64]int) // session id to index in entries
+		for rows.Next() {
+			var (
+				id                  int64
+				e                   history.Entry
+				planned, actual, at int64
+				diff                sql.NullString
+			)
+			if err := rows.Scan(&id, &e.TaskID, &e.File, &e.Description, &e.TimeBox, &planned, &actual, &diff, &at); err != nil {
+				return err
+			}
+			e.Planned, e.Actual, e.CompletedAt = time.Duration(planned), time.Duration(actual), fromTime(at)
+			if diff.Valid {
+				var d gitutil.DiffSummary
+				if err := json.Unmarshal([]byte(diff.String), &d); err != nil {
+					return fmt.Errorf("invalid diff of session %d: %w", id, err)
+				}
+				e.Diff = d
+			}
+			index[id] = len(entries)
+			entries = append(entries, e)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+		return loadSessionDetails(ctx, tx, where, args, entries, index)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return f.Apply(entries), nil
+}
+
+// loadSessionDetails fills in the segments and commits of the sessions selected by where.
+func loadSessionDetails(ctx context.Context, tx *sql.Tx, where string, args []any, entries []history.Entry, index map[int64]int) error {
+	segments, err := tx.QueryContext(ctx, `
+		SELECT session_id, started_at, ended_at FROM segments
+		WHERE session_id IN (SELECT id FROM sessions WHERE `+where+`) ORDER BY id`, args...)
+	if err != nil {
+		return err
+	}
+	defer segments.Close()
+	for segments.Next() {
+		var (
+			id, start int64
+			endedAt   sql.NullInt64
+		)
+		if err := segments.Scan(&id, &start, &endedAt); err != nil {
+			return err
+		}
+		if i, ok := index[id]; ok {
+			entries[i].Segments = append(entries[i].Segments, state.TimeSegment{Start: fromTime(start), End: fromNullTime(endedAt)})
+		}
+	}
+	if err := segments.Err(); err != nil {
+		return err
+	}
+
+	commits, err := tx.QueryContext(ctx, `
+		SELECT session_id, line FROM commits
+		WHERE session_id IN (SELECT id FROM sessions WHERE `+where+`) ORDER BY id`, args...)
+	if err != nil {
+		return err
+	}
+	defer commits.Close()
+	for commits.Next() {
+		var (
+			id   int64
+			line string
+		)
+		if err := commits.Scan(&id, &line); err != nil {
+			return err
+		}
+		if i, ok := index[id]; ok {
+			entries[i].Commits = append(entries[i].Commits, line)
+		}
+	}
+	return commits.Err()
+}
+
+// Times are stored as nanoseconds since the epoch, which sort and compare as numbers.
+
+func toTime(t time.Time) int64 {
+	return t.UnixNano()
+}
+
+func fromTime(n int64) time.Time {
+	return time.Unix(0, n)
+}
+
+func toNullTime(t *time.Time) sql.NullInt64 {
+	if t == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: toTime(*t), Valid: true}
+}
+
+func fromNullTime(n sql.NullInt64) *time.Time {
+	if !n.Valid {
+		return nil
+	}
+	t := fromTime(n.Int64)
+	return &t
+}
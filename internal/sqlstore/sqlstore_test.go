@@ -0,0 +1,181 @@
+package sqlstore_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"gobox/internal/core"
+	"gobox/internal/history"
+	"gobox/internal/sqlstore"
+	"gobox/internal/state"
+)
+
+func openStore(t *testing.T, path string) *sqlstore.Store {
+	t.Helper()
+	store, err := sqlstore.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func sampleStates() []state.TimeBoxState {
+	now := time.Now().Truncate(time.Second)
+	later := now.Add(time.Hour)
+	return []state.TimeBoxState{
+		{
+			TaskHash: "hash1",
+			File:     "/notes/tasks.md",
+			Segments: []state.TimeSegment{{Start: now, End: &later}},
+			PausedAt: &later,
+			Refs:     map[string]state.GitRef{"/src/app": {Branch: "main", Commit: "abc"}},
+		},
+		{
+			TaskID:      "id2",
+			TaskHash:    "hash2",
+			Description: "Write docs",
+			TimeBox:     "@1h",
+			Segments:    []state.TimeSegment{{Start: now}},
+			Heartbeat:   &later,
+		},
+	}
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	store := openStore(t, filepath.Join(t.TempDir(), "gobox.db"))
+	states := sampleStates()
+	if err := store.Save(states); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if !reflect.DeepEqual(loaded, states) {
+		t.Errorf("Load() =\n%+v\nwant\n%+v", loaded, states)
+	}
+
+	if err := store.Save(store.RemoveTaskState(loaded, "id2")); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if loaded, _ = store.Load(); len(loaded) != 1 || loaded[0].TaskHash != "hash1" {
+		t.Errorf("expected only the first state after removal, got %+v", loaded)
+	}
+}
+
+func TestHistoryQuery(t *testing.T) {
+	store := openStore(t, filepath.Join(t.TempDir(), "gobox.db"))
+	day := time.Date(2025, 6, 2, 10, 0, 0, 0, time.Local)
+	for i, desc := range []string{"Write docs", "Fix bug", "Write tests"} {
+		end := day.AddDate(0, 0, i).Add(time.Hour)
+		e := history.Entry{
+			TaskID: desc, Description: desc, File: "/notes/tasks.md", TimeBox: "@1h",
+			Planned: time.Hour, Actual: time.Hour,
+			Segments:    []state.TimeSegment{{Start: end.Add(-time.Hour), End: &end}},
+			Commits:     []string{"abc1234 " + desc},
+			CompletedAt: end,
+		}
+		if err := store.Append(e); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	all, err := store.Entries()
+	if err != nil || len(all) != 3 || all[1].Description != "Fix bug" || all[1].Commits[0] != "abc1234 Fix bug" {
+		t.Fatalf("Entries() = %+v, %v", all, err)
+	}
+	got, err := store.Query(history.Filter{Since: day.AddDate(0, 0, 1), Task: "write"})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(got) != 1 || got[0].Description != "Write tests" || len(got[0].Segments) != 1 {
+		t.Errorf("Query() = %+v, want the last session", got)
+	}
+}
+
+func TestImportRunsOnce(t *testing.T) {
+	dir := t.TempDir()
+	stateFile := filepath.Join(dir, ".gobox_state.json")
+	historyFile := filepath.Join(dir, ".gobox_history.jsonl")
+	if err := core.NewFileStateStore(stateFile).Save(sampleStates()); err != nil {
+		t.Fatal(err)
+	}
+	if err := history.NewFileLog(historyFile).Append(history.Entry{TaskID: "id0", Description: "Old", CompletedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	store := openStore(t, filepath.Join(dir, "gobox.db"))
+	for i := 0; i < 2; i++ {
+		imported, err := store.Import([]string{stateFile, filepath.Join(dir, "missing.json")}, []string{historyFile})
+		if err != nil {
+			t.Fatalf("Import failed: %v", err)
+		}
+		if want := map[int]int{0: 2, 1: 0}[i]; len(imported) != want {
+			t.Errorf("import %d imported %v, want %d files", i+1, imported, want)
+		}
+	}
+	states, _ := store.Load()
+	entries, _ := store.Entries()
+	if len(states) != 2 || len(entries) != 1 {
+		t.Errorf("expected 2 states and 1 entry, got %d and %d", len(states), len(entries))
+	}
+}
+
+func TestConcurrentProcesses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gobox.db")
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		// A store per goroutine, as separate processes would open it
+		store := openStore(t, path)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if err := store.Append(history.Entry{TaskID: "t", CompletedAt: time.Now()}); err != nil {
+					t.Errorf("Append failed: %v", err)
+				}
+				if err := store.Save(sampleStates()); err != nil {
+					t.Errorf("Save failed: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	entries, err := openStore(t, path).Entries()
+	if err != nil || len(entries) != 40 {
+		t.Errorf("expected 40 entries, got %d, %v", len(entries), err)
+	}
+}
+
+func TestUpdateKeepsConcurrentChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gobox.db")
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		store := openStore(t, path)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				key := fmt.Sprintf("task-%d-%d", i, j)
+				_, err := store.Update(func(states []state.TimeBoxState) ([]state.TimeBoxState, error) {
+					return append(states, state.TimeBoxState{TaskID: key, TaskHash: key}), nil
+				})
+				if err != nil {
+					t.Errorf("Update failed: %v", err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	states, err := openStore(t, path).Load()
+	if err != nil || len(states) != 20 {
+		t.Errorf("expected 20 states, got %d, %v", len(states), err)
+	}
+}
@@ -4,15 +4,44 @@ import (
 	"encoding/json"
 	"os"
 	"time"
+
+	"gobox/internal/fileutil"
+	"gobox/pkg/task"
 )
 
+// HeartbeatInterval is how often the process running a session records a heartbeat.
+const HeartbeatInterval = 30 * time.Second
+
+// StaleAfter is how long a running session may go without a heartbeat before it is
+// taken to be left over by a process that was killed or a machine that went to sleep.
+const StaleAfter = 4 * HeartbeatInterval
+
 // TimeBoxState represents the state of a timeboxed task, including its unique identifier
 // and the list of time segments (work intervals) associated with it.
 // This struct is designed to be serializable for persistence between sessions.
 type TimeBoxState struct {
-	TaskHash  string        `json:"task_hash"`  // Unique hash of the task
-	Segments  []TimeSegment `json:"segments"`   // List of time segments
-	Completed bool          `json:"completed"`  // Whether the task is completed
+	TaskID    string        `json:"task_id,omitempty"`   // Stable ID of the task, see task.Task.ID
+	TaskHash  string        `json:"task_hash"`           // Content hash of the task, used to match states saved before IDs existed
+	Segments  []TimeSegment `json:"segments"`            // List of time segments
+	Completed bool          `json:"completed"`           // Whether the task is completed
+	PausedAt  *time.Time    `json:"paused_at,omitempty"` // When the session was paused, nil unless it is paused
+	Heartbeat *time.Time    `json:"heartbeat,omitempty"` // When the process running the session last recorded it was alive
+
+	// Task details as of the last start, so the session can be shown without parsing the markdown file
+	File        string `json:"file,omitempty"`        // Absolute path of the markdown file
+	Description string `json:"description,omitempty"` // Task description
+	TimeBox     string `json:"timebox,omitempty"`     // The raw timebox, e.g. "@1h"
+
+	// Where HEAD was in each repository when the task was first started, keyed by the
+	// repository path, to tell which commits belong to it
+	Refs map[string]GitRef `json:"refs,omitempty"`
+}
+
+// GitRef records where HEAD was in a repository, and what was left uncommitted.
+type GitRef struct {
+	Branch string            `json:"branch,omitempty"` // Checked out branch, empty if HEAD was detached
+	Commit string            `json:"commit,omitempty"` // Commit HEAD pointed to, empty if there were no commits yet
+	Dirty  map[string]string `json:"dirty,omitempty"`  // Files with uncommitted changes, see gitutil.DirtyFiles
 }
 
 // TimeSegment represents a single uninterrupted interval of work within a timebox.
@@ -22,6 +51,162 @@ type TimeSegment struct {
 	End   *time.Time `json:"end"`
 }
 
+// Key returns the identifier the state is tracked by: the task ID if set, otherwise the task hash.
+func (t *TimeBoxState) Key() string {
+	if t.TaskID != "" {
+		return t.TaskID
+	}
+	return t.TaskHash
+}
+
+// FindState returns the index of the state belonging to the task with the given ID and hash
+// in the task file file, or -1 if there is none. States are matched by ID first, wherever
+// the task lives now; states without an ID are matched by hash so that state saved before
+// the task had an ID is picked up. As the same task text may appear in several files, a
+// hash only matches states of the same file, or states that don't know their file.
+//
+// If the task was edited since such a state was saved, its hash no longer matches. Then
+// the state without an ID whose description is most similar to description is used, like
+// tasks are found in the task file, unless another one is just as similar. An empty
+// description only matches exactly.
+func FindState(states []TimeBoxState, file, taskID, taskHash, description string) int {
+	if taskID != "" {
+		for i := range states {
+			if states[i].TaskID == taskID {
+				return i
+			}
+		}
+	}
+	for i := range states {
+		if states[i].TaskID == "" && states[i].TaskHash == taskHash && states[i].InFile(file) {
+			return i
+		}
+	}
+	if description == "" {
+		return -1
+	}
+
+	best, bestScore, tied := -1, 0.0, false
+	for i := range states {
+		s := &states[i]
+		if s.TaskID != "" || s.Completed || s.Description == "" || !s.InFile(file) {
+			continue
+		}
+		score := task.Similarity(s.Description, description)
+		switch {
+		case score < task.FuzzyMatchThreshold:
+		case best < 0 || score > bestScore:
+			best, bestScore, tied = i, score, false
+		case score == bestScore:
+			tied = true
+		}
+	}
+	if tied {
+		return -1
+	}
+	return best
+}
+
+// InFile reports whether the state may belong to a task in file, an absolute path. An
+// empty file, or a state that doesn't record its file, matches any file.
+func (t *TimeBoxState) InFile(file string) bool {
+	return file == "" || t.File == "" || t.File == file
+}
+
+// Put returns states with s in place of the state tracked under the same key, or with s
+// added if there is none.
+func Put(states []TimeBoxState, s TimeBoxState) []TimeBoxState {
+	for i := range states {
+		if states[i].Key() == s.Key() {
+			states[i] = s
+			return states
+		}
+	}
+	return append(states, s)
+}
+
+// Active returns the indexes of the states with an open segment, leaving out the state
+// tracked under the key except.
+func Active(states []TimeBoxState, except string) []int {
+	var active []int
+	for i := range states {
+		if states[i].IsActive() && states[i].Key() != except {
+			active = append(active, i)
+		}
+	}
+	return active
+}
+
+// Pause closes the open segment at now and marks the session paused. A session that
+// isn't active is left alone.
+func (t *TimeBoxState) Pause(now time.Time) {
+	if !t.IsActive() {
+		return
+	}
+	t.Segments[len(t.Segments)-1].End = &now
+	t.PausedAt = &now
+}
+
+// Beat records that the process running the session is alive at now.
+func (t *TimeBoxState) Beat(now time.Time) {
+	t.Heartbeat = &now
+}
+
+// LastSeen returns when the session was last known to run: its last heartbeat, or the
+// start of its last segment if it has no heartbeat since.
+func (t *TimeBoxState) LastSeen() time.Time {
+	seen := t.UpdatedAt()
+	if t.Heartbeat != nil && t.Heartbeat.After(seen) {
+		seen = *t.Heartbeat
+	}
+	return seen
+}
+
+// IsStale reports whether the session has an open segment but no heartbeat for longer
+// than StaleAfter, so no process is running it anymore.
+func (t *TimeBoxState) IsStale(now time.Time) bool {
+	return t.IsActive() && now.Sub(t.LastSeen()) > StaleAfter
+}
+
+// Stale returns the indexes of the stale states, see IsStale.
+func Stale(states []TimeBoxState, now time.Time) []int {
+	var stale []int
+	for i := range states {
+		if states[i].IsStale(now) {
+			stale = append(stale, i)
+		}
+	}
+	return stale
+}
+
+// CloseAt closes the open segment at end and marks the session paused, so it can be
+// resumed later. An end before the segment's start closes it empty.
+func (t *TimeBoxState) CloseAt(end time.Time) {
+	if !t.IsActive() {
+		return
+	}
+	if start := t.Segments[len(t.Segments)-1].Start; end.Before(start) {
+		end = start
+	}
+	t.Pause(end)
+}
+
+// PauseRunning pauses a session run by another process: at now, or at its last
+// heartbeat if it is stale, so that the time after the process stopped isn't counted.
+func (t *TimeBoxState) PauseRunning(now time.Time) {
+	if t.IsStale(now) {
+		t.CloseAt(t.LastSeen())
+		return
+	}
+	t.Pause(now)
+}
+
+// PausedElsewhere reports whether saved, the state store's copy of the running session
+// t, was paused by another process: saved closed the segment t still has open.
+func (t *TimeBoxState) PausedElsewhere(saved TimeBoxState) bool {
+	return t.IsActive() && saved.IsPaused() && len(saved.Segments) == len(t.Segments)
+}
+
 // IsActive reports whether the timebox is currently active.
 // A timebox is considered active if its last segment has no End time (i.e., work is ongoing).
 func (t *TimeBoxState) IsActive() bool {
@@ -32,6 +217,11 @@ func (t *TimeBoxState) IsActive() bool {
 	return last.End == nil
 }
 
+// IsPaused reports whether the session was paused and has not been resumed or stopped since.
+func (t *TimeBoxState) IsPaused() bool {
+	return t.PausedAt != nil && !t.IsActive()
+}
+
 // CreatedAt returns the start time of the first segment, representing when the timebox was started.
 // If there are no segments, it returns the zero value of time.Time.
 func (t *TimeBoxState) CreatedAt() time.Time {
@@ -55,16 +245,33 @@ func (t *TimeBoxState) UpdatedAt() time.Time {
 	return last.Start
 }
 
-// SaveToFile serializes the TimeBoxState to a file as JSON.
+// SumSegments returns the total duration of all closed segments.
+func SumSegments(segments []TimeSegment) time.Duration {
+	var total time.Duration
+	for _, seg := range segments {
+		if seg.End != nil {
+			total += seg.End.Sub(seg.Start)
+		}
+	}
+	return total
+}
+
+// Elapsed returns the total duration of all segments, counting an open segment up to now.
+func Elapsed(segments []TimeSegment, now time.Time) time.Duration {
+	total := SumSegments(segments)
+	if len(segments) > 0 && segments[len(segments)-1].End == nil {
+		total += now.Sub(segments[len(segments)-1].Start)
+	}
+	return total
+}
+
+// SaveToFile serializes the TimeBoxState to a file as JSON, replacing the file atomically.
 func (t *TimeBoxState) SaveToFile(path string) error {
-	f, err := os.Create(path)
+	data, err := json.MarshalIndent(t, "", "  ")
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	enc := json.NewEncoder(f)
-	enc.SetIndent("", "  ")
-	return enc.Encode(t)
+	return fileutil.WriteFile(path, append(data, '\n'), 0644)
 }
 
 // LoadFromFile deserializes a TimeBoxState from a JSON file.
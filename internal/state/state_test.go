@@ -203,3 +203,126 @@ func TestTimeBoxState_UpdatedAt(t *testing.T) {
 		})
 	}
 }
+
+func TestFindState(t *testing.T) {
+	states := []TimeBoxState{
+		{TaskHash: "legacy"},
+		{TaskID: "id1", TaskHash: "hash1"},
+		{TaskID: "id2", TaskHash: "legacy"},
+		{TaskHash: "shared", File: "/notes/a.md"},
+		{TaskHash: "shared", File: "/notes/b.md"},
+		{TaskHash: "old", File: "/notes/a.md", Description: "Write the release notes"},
+		{TaskHash: "old1", File: "/notes/b.md", Description: "Review part A"},
+		{TaskHash: "old2", File: "/notes/b.md", Description: "Review part B"},
+		{TaskHash: "done", File: "/notes/c.md", Description: "Plan the sprint", Completed: true},
+	}
+
+	tests := []struct {
+		name        string
+		file        string
+		taskID      string
+		taskHash    string
+		description string
+		want        int
+	}{
+		{name: "by id", taskID: "id2", taskHash: "changed", want: 2},
+		{name: "by hash without id", taskID: "", taskHash: "legacy", want: 0},
+		{name: "legacy state for task with new id", taskID: "id3", taskHash: "legacy", want: 0},
+		{name: "hash of state with other id", taskID: "", taskHash: "hash1", want: -1},
+		{name: "missing", taskID: "nope", taskHash: "nope", want: -1},
+		{name: "hash in the same file", file: "/notes/b.md", taskHash: "shared", want: 4},
+		{name: "hash in another file", file: "/notes/c.md", taskHash: "shared", want: -1},
+		{name: "state without file", file: "/notes/c.md", taskHash: "legacy", want: 0},
+		{name: "id in another file", file: "/notes/c.md", taskID: "id1", taskHash: "moved", want: 1},
+		{name: "edited description", file: "/notes/a.md", taskID: "id4", taskHash: "edited", description: "Write the release note", want: 5},
+		{name: "edited description in another file", file: "/notes/b.md", taskHash: "edited", description: "Write the release note", want: -1},
+		{name: "description without fuzzy step", file: "/notes/a.md", taskHash: "edited", want: -1},
+		{name: "most similar description", file: "/notes/b.md", taskHash: "edited", description: "Review part B.", want: 7},
+		{name: "tied descriptions", file: "/notes/b.md", taskHash: "edited", description: "Review part C", want: -1},
+		{name: "completed state", file: "/notes/c.md", taskHash: "edited", description: "Plan the sprints", want: -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FindState(states, tt.file, tt.taskID, tt.taskHash, tt.description); got != tt.want {
+				t.Errorf("FindState() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestActiveAndPause(t *testing.T) {
+	now := time.Now()
+	earlier := now.Add(-time.Hour)
+	states := []TimeBoxState{
+		{TaskID: "open", Segments: []TimeSegment{{Start: earlier}}},
+		{TaskID: "closed", Segments: []TimeSegment{{Start: earlier, End: &now}}},
+		{TaskID: "mine", Segments: []TimeSegment{{Start: earlier}}},
+	}
+
+	active := Active(states, "mine")
+	if len(active) != 1 || active[0] != 0 {
+		t.Fatalf("Active = %v, want [0]", active)
+	}
+
+	running := states[0]
+	running.Segments = append([]TimeSegment(nil), running.Segments...)
+	states[active[0]].Pause(now)
+	if states[0].IsActive() || !states[0].IsPaused() || !states[0].PausedAt.Equal(now) {
+		t.Errorf("expected the session to be paused at %v, got %+v", now, states[0])
+	}
+	if !running.PausedElsewhere(states[0]) {
+		t.Errorf("the running copy should see it was paused elsewhere")
+	}
+
+	// Resuming adds a segment, which the saved copy doesn't know about yet
+	running.Segments = append(states[0].Segments, TimeSegment{Start: now})
+	if running.PausedElsewhere(states[0]) {
+		t.Errorf("a session resumed since it was saved was not paused elsewhere")
+	}
+
+	states = Put(states, TimeBoxState{TaskID: "closed"})
+	if len(states) != 3 || len(states[1].Segments) != 0 {
+		t.Errorf("Put should replace the state with the same key, got %+v", states)
+	}
+	if states = Put(states, TimeBoxState{TaskID: "new"}); len(states) != 4 {
+		t.Errorf("Put should add a new state, got %+v", states)
+	}
+}
+
+func TestStaleSessions(t *testing.T) {
+	now := time.Now()
+	heartbeat := now.Add(-time.Hour)
+	recent := now.Add(-HeartbeatInterval)
+	states := []TimeBoxState{
+		{TaskID: "killed", Segments: []TimeSegment{{Start: now.Add(-2 * time.Hour)}}, Heartbeat: &heartbeat},
+		{TaskID: "running", Segments: []TimeSegment{{Start: now.Add(-2 * time.Hour)}}, Heartbeat: &recent},
+		{TaskID: "just started", Segments: []TimeSegment{{Start: now.Add(-time.Minute)}}},
+		{TaskID: "before heartbeats", Segments: []TimeSegment{{Start: now.Add(-time.Hour)}}},
+		{TaskID: "paused", Segments: []TimeSegment{{Start: now.Add(-2 * time.Hour), End: &heartbeat}}, PausedAt: &heartbeat},
+	}
+
+	stale := Stale(states, now)
+	if len(stale) != 2 || stale[0] != 0 || stale[1] != 3 {
+		t.Fatalf("Stale = %v, want [0 3]", stale)
+	}
+	if !states[0].LastSeen().Equal(heartbeat) || !states[3].LastSeen().Equal(states[3].Segments[0].Start) {
+		t.Errorf("unexpected LastSeen: %v, %v", states[0].LastSeen(), states[3].LastSeen())
+	}
+
+	// A stale session paused by another process ends at its last heartbeat
+	states[0].PauseRunning(now)
+	if end := states[0].Segments[0].End; end == nil || !end.Equal(heartbeat) || !states[0].IsPaused() {
+		t.Errorf("expected the stale session to end at its heartbeat, got %+v", states[0])
+	}
+	states[1].PauseRunning(now)
+	if end := states[1].Segments[0].End; end == nil || !end.Equal(now) {
+		t.Errorf("expected the running session to end now, got %+v", states[1])
+	}
+
+	// Closing before the segment's start leaves it empty
+	states[2].CloseAt(now.Add(-time.Hour))
+	if seg := states[2].Segments[0]; seg.End == nil || !seg.End.Equal(seg.Start) {
+		t.Errorf("expected an empty segment, got %+v", seg)
+	}
+}
@@ -0,0 +1,187 @@
+// Package statefile locates the file gobox keeps the sessions of a repository in, and
+// moves sessions out of the files earlier versions left in every working directory.
+package statefile
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gobox/internal/core"
+	"gobox/internal/gitutil"
+	"gobox/internal/state"
+)
+
+// EnvVar names the environment variable that overrides the state file.
+const EnvVar = "GOBOX_STATE"
+
+// LegacyName is the state file earlier versions kept in the working directory.
+const LegacyName = ".gobox_state.json"
+
+// Name is the name of the state file in its directory.
+const Name = "state.json"
+
+// Path returns the state file for the sessions of the tasks in taskFile, a task file or
+// a directory: override if it is set, else $GOBOX_STATE if that is set, else state.json in
+// $XDG_STATE_HOME/gobox/<repo-id>/ for the repository taskFile is in. Outside of a
+// repository the directory of taskFile takes its place. An empty taskFile stands for
+// the working directory.
+func Path(override, taskFile string) (string, error) {
+	if override == "" {
+		override = os.Getenv(EnvVar)
+	}
+	if override != "" {
+		return filepath.Abs(override)
+	}
+
+	root, err := Root(taskFile)
+	if err != nil {
+		return "", err
+	}
+	dir, err := stateHome()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gobox", RepoID(root), Name), nil
+}
+
+// Root returns the directory whose sessions share a state file with those of the tasks
+// in taskFile: the top of its repository, or its own directory outside of one.
+func Root(taskFile string) (string, error) {
+	dir, err := filepath.Abs(taskFile)
+	if err != nil {
+		return "", err
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		dir = filepath.Dir(dir)
+	}
+	if root := gitutil.FindRoot(dir); root != "" {
+		return root, nil
+	}
+	return dir, nil
+}
+
+// RootFile returns the file name in the root of the tasks in taskFile, see Root. The
+// history and the config are kept there, wherever in the repository gobox runs.
+func RootFile(taskFile, name string) (string, error) {
+	root, err := Root(taskFile)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, name), nil
+}
+
+// RepoID names the state directory of the repository at root after the repository,
+// made unique by a hash of its path.
+func RepoID(root string) string {
+	sum := sha256.Sum256([]byte(root))
+	return filepath.Base(root) + "-" + hex.EncodeToString(sum[:6])
+}
+
+// LoadAll returns the sessions in the state files of all repositories, see Path. It is
+// for gobox status, which runs wherever the shell prompt or status bar does rather than
+// in the repository of the session.
+func LoadAll() ([]state.TimeBoxState, error) {
+	dir, err := stateHome()
+	if err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "gobox", "*", Name))
+	if err != nil {
+		return nil, err
+	}
+	var states []state.TimeBoxState
+	for _, path := range paths {
+		loaded, err := core.NewFileStateStore(path).Load()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		states = append(states, loaded...)
+	}
+	return states, nil
+}
+
+// stateHome returns $XDG_STATE_HOME, or its default ~/.local/state.
+func stateHome() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); filepath.IsAbs(dir) {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("can't find the state directory, set --state or %s: %w", EnvVar, err)
+	}
+	return filepath.Join(home, ".local", "state"), nil
+}
+
+// LegacyPaths returns where earlier versions may have left the state file for the tasks
+// in taskFile: the repository root and the task file's directory. Files elsewhere, e.g.
+// in the working directory, may hold the sessions of another repository and are left.
+func LegacyPaths(taskFile string) []string {
+	root, err := Root(taskFile)
+	if err != nil {
+		return nil
+	}
+	dirs := []string{root}
+	if taskFile != "" {
+		if abs, err := filepath.Abs(taskFile); err == nil {
+			if info, err := os.Stat(abs); err != nil || !info.IsDir() {
+				abs = filepath.Dir(abs)
+			}
+			dirs = append(dirs, abs)
+		}
+	}
+
+	var paths []string
+	seen := make(map[string]bool)
+	for _, dir := range dirs {
+		path := filepath.Join(dir, LegacyName)
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// Migrate moves the sessions in the legacy state files into the state file at path,
+// creating its directory, and removes the legacy files. Sessions already in path win
+// over those of the same task in a legacy file. Each file is merged while path is locked,
+// so sessions other gobox processes save meanwhile are kept, and only removed once its
+// sessions are saved. It returns the files it migrated.
+func Migrate(path string, legacy ...string) ([]string, error) {
+	store := core.NewFileStateStore(path)
+
+	var migrated []string
+	for _, old := range legacy {
+		if old == path {
+			continue
+		}
+		if _, err := os.Stat(old); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		oldStates, err := core.NewFileStateStore(old).Load()
+		if err != nil {
+			return migrated, fmt.Errorf("failed to read %s: %w", old, err)
+		}
+
+		_, err = store.Update(func(states []state.TimeBoxState) ([]state.TimeBoxState, error) {
+			for _, s := range oldStates {
+				if state.FindState(states, s.File, s.TaskID, s.TaskHash, "") < 0 {
+					states = append(states, s)
+				}
+			}
+			return states, nil
+		})
+		if err != nil {
+			return migrated, err
+		}
+		if err := os.Remove(old); err != nil {
+			return migrated, err
+		}
+		migrated = append(migrated, old)
+	}
+	return migrated, nil
+}
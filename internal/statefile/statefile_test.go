@@ -0,0 +1,189 @@
+package statefile_test
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"gobox/internal/core"
+	"gobox/internal/state"
+	"gobox/internal/statefile"
+	"gobox/internal/statusline"
+)
+
+func TestPath(t *testing.T) {
+	dir := t.TempDir()
+	repo := filepath.Join(dir, "project")
+	if err := os.MkdirAll(filepath.Join(repo, ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(repo, "notes"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	stateHome := filepath.Join(dir, "state")
+	t.Setenv("XDG_STATE_HOME", stateHome)
+	t.Setenv(statefile.EnvVar, "")
+
+	// Task files anywhere in the repository share the state file of its root
+	want := filepath.Join(stateHome, "gobox", statefile.RepoID(repo), statefile.Name)
+	for _, taskFile := range []string{filepath.Join(repo, "tasks.md"), filepath.Join(repo, "notes", "todo.md"), filepath.Join(repo, "notes")} {
+		got, err := statefile.Path("", taskFile)
+		if err != nil {
+			t.Fatalf("Path(%q) failed: %v", taskFile, err)
+		}
+		if got != want {
+			t.Errorf("Path(%q) = %q, want %q", taskFile, got, want)
+		}
+	}
+
+	// Outside of a repository the task file's directory takes its place
+	other := filepath.Join(dir, "elsewhere", "tasks.md")
+	if got, _ := statefile.Path("", other); got != filepath.Join(stateHome, "gobox", statefile.RepoID(filepath.Dir(other)), statefile.Name) {
+		t.Errorf("Path(%q) = %q outside of a repository", other, got)
+	}
+
+	// $GOBOX_STATE and --state override the location, in that order
+	t.Setenv(statefile.EnvVar, filepath.Join(dir, "env.json"))
+	if got, _ := statefile.Path("", other); got != filepath.Join(dir, "env.json") {
+		t.Errorf("Path() = %q, want the file from the environment", got)
+	}
+	if got, _ := statefile.Path(filepath.Join(dir, "flag.json"), other); got != filepath.Join(dir, "flag.json") {
+		t.Errorf("Path() = %q, want the file from the flag", got)
+	}
+}
+
+func TestLoadAllFromOutsideTheRepositories(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", filepath.Join(dir, "state"))
+	t.Setenv(statefile.EnvVar, "")
+
+	now := time.Now()
+	pausedAt := now.Add(-30 * time.Minute)
+	sessions := map[string]state.TimeBoxState{
+		"service": {TaskID: "paused", Description: "Review PR", TimeBox: "@1h",
+			Segments: []state.TimeSegment{{Start: now.Add(-time.Hour), End: &pausedAt}}},
+		"lib": {TaskID: "running", Description: "Fix bug", TimeBox: "@1h",
+			Segments: []state.TimeSegment{{Start: now.Add(-10 * time.Minute)}}},
+	}
+	for name, session := range sessions {
+		repo := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Join(repo, ".git"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		path, err := statefile.Path("", filepath.Join(repo, "tasks.md"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := core.NewFileStateStore(path).Save([]state.TimeBoxState{session}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Status bars run in the home directory, say, not in the repository
+	t.Chdir(t.TempDir())
+
+	states, err := statefile.LoadAll()
+	if err != nil {
+		t.Fatalf("LoadAll failed: %v", err)
+	}
+	if len(states) != 2 {
+		t.Fatalf("expected the sessions of both repositories, got %+v", states)
+	}
+	if info := statusline.Current(states, now); info.State != statusline.StateRunning || info.Task != "Fix bug" {
+		t.Errorf("expected the running session, got %+v", info)
+	}
+}
+
+func TestRepoIDTellsApartRepositoriesOfTheSameName(t *testing.T) {
+	a := statefile.RepoID("/home/me/work/gobox")
+	b := statefile.RepoID("/home/me/forks/gobox")
+	if a == b {
+		t.Errorf("RepoID() = %q for both repositories", a)
+	}
+	if a != statefile.RepoID("/home/me/work/gobox") {
+		t.Errorf("RepoID() is not stable")
+	}
+}
+
+func TestLegacyPathsStayInTheRepository(t *testing.T) {
+	dir := t.TempDir()
+	repo := filepath.Join(dir, "project")
+	if err := os.MkdirAll(filepath.Join(repo, ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(repo, "notes"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// The state file in the working directory belongs to another repository
+	t.Chdir(dir)
+
+	got := statefile.LegacyPaths(filepath.Join(repo, "notes", "todo.md"))
+	want := []string{filepath.Join(repo, statefile.LegacyName), filepath.Join(repo, "notes", statefile.LegacyName)}
+	if !slices.Equal(got, want) {
+		t.Errorf("LegacyPaths() = %v, want %v", got, want)
+	}
+	if got := statefile.LegacyPaths(repo); !slices.Equal(got, want[:1]) {
+		t.Errorf("LegacyPaths(%q) = %v, want %v", repo, got, want[:1])
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state", "gobox", "project", statefile.Name)
+	legacy := filepath.Join(dir, statefile.LegacyName)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	if err := core.NewFileStateStore(legacy).Save([]state.TimeBoxState{
+		{TaskID: "kept", TaskHash: "h1", Segments: []state.TimeSegment{{Start: now}}},
+		{TaskID: "moved", TaskHash: "h2", Segments: []state.TimeSegment{{Start: now}}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.NewFileStateStore(path).Save([]state.TimeBoxState{{TaskID: "kept", TaskHash: "h1"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	migrated, err := statefile.Migrate(path, legacy, filepath.Join(dir, "missing", statefile.LegacyName))
+	if err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	if len(migrated) != 1 || migrated[0] != legacy {
+		t.Errorf("Migrate() = %v, want %v", migrated, []string{legacy})
+	}
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Errorf("legacy state file should be removed, got %v", err)
+	}
+
+	states, err := core.NewFileStateStore(path).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 2 || states[0].TaskID != "kept" || len(states[0].Segments) != 0 || states[1].TaskID != "moved" {
+		t.Errorf("unexpected migrated states %+v", states)
+	}
+
+	// Nothing left to migrate
+	if migrated, err := statefile.Migrate(path, legacy); err != nil || len(migrated) != 0 {
+		t.Errorf("second Migrate() = %v, %v", migrated, err)
+	}
+	// A legacy file is kept until its sessions are saved
+	if err := core.NewFileStateStore(legacy).Save([]state.TimeBoxState{{TaskID: "late", TaskHash: "h3"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("{not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if migrated, err := statefile.Migrate(path, legacy); err == nil || len(migrated) != 0 {
+		t.Errorf("Migrate() into a corrupt state file = %v, %v", migrated, err)
+	}
+	if _, err := os.Stat(legacy); err != nil {
+		t.Errorf("legacy state file should be kept when the merge fails, got %v", err)
+	}
+}
@@ -0,0 +1,231 @@
+package statusline
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+
+	"gobox/internal/parser"
+	"gobox/internal/state"
+)
+
+// Output formats supported by Render besides Go templates.
+const (
+	FormatCompact  = "compact"  // one line for PS1 or tmux, e.g. "▶ Fix bug 12:34"
+	FormatWaybar   = "waybar"   // Waybar custom module JSON
+	FormatI3blocks = "i3blocks" // i3blocks JSON (format=json)
+)
+
+// Session states reported in Info.State.
+const (
+	StateIdle    = "idle"
+	StateRunning = "running"
+	StatePaused  = "paused"
+)
+
+// Info describes the current session as read from the persisted state.
+type Info struct {
+	State     string        // idle, running or paused
+	Task      string        // task description
+	TimeBox   string        // raw timebox, e.g. "@1h"
+	File      string        // markdown file the task lives in
+	Elapsed   time.Duration // time worked on the task so far
+	Remaining time.Duration // time left in the timebox, negative once it is overdue
+	Percent   int           // share of the timebox used up, 0-100
+}
+
+// Overdue reports whether the timebox has run out.
+func (i Info) Overdue() bool {
+	return i.State != StateIdle && i.Remaining < 0
+}
+
+// Current returns the running session, or the most recently paused one if none is
+// running. Info.State is idle if there is neither.
+func Current(states []state.TimeBoxState, now time.Time) Info {
+	var current *state.TimeBoxState
+	for i := range states {
+		s := &states[i]
+		if !s.IsActive() && !s.IsPaused() {
+			continue
+		}
+		if current == nil || rank(s) > rank(current) ||
+			(rank(s) == rank(current) && s.UpdatedAt().After(current.UpdatedAt())) {
+			current = s
+		}
+	}
+	if current == nil {
+		return Info{State: StateIdle}
+	}
+
+	info := Info{
+		State:   StateRunning,
+		Task:    current.Description,
+		TimeBox: current.TimeBox,
+		File:    current.File,
+		Elapsed: state.Elapsed(current.Segments, now),
+	}
+	if current.IsPaused() {
+		info.State = StatePaused
+	}
+	if info.Task == "" {
+		// State saved by an older gobox only knows the task by its key
+		info.Task = current.Key()
+	}
+
+	if duration, endTime, err := parser.ParseTimeBox(current.TimeBox); err == nil {
+		// A range belongs to the day the session started on, not to the next one
+		// ParseTimeBox moves it to once it has ended
+		if len(current.Segments) > 0 && parser.IsTimeRange(current.TimeBox) {
+			_, endTime, _ = parser.ParseTimeRangeAt(current.TimeBox, current.Segments[0].Start)
+		}
+		var total time.Duration
+		if duration > 0 {
+			total = duration
+			info.Remaining = duration - info.Elapsed
+		} else if !endTime.IsZero() {
+			info.Remaining = endTime.Sub(now)
+			total = info.Elapsed + info.Remaining
+		}
+		if total > 0 {
+			info.Percent = min(100, max(0, int(100*info.Elapsed/total)))
+		}
+	}
+	return info
+}
+
+// rank prefers running sessions over paused ones.
+func rank(s *state.TimeBoxState) int {
+	if s.IsActive() {
+		return 1
+	}
+	return 0
+}
+
+// Render writes info in the given format: compact, waybar, i3blocks, or a Go template
+// such as "{{.Task}} {{clock .Remaining}}" executed with Info.
+func Render(w io.Writer, info Info, format string) error {
+	switch format {
+	case FormatCompact, "":
+		if info.State == StateIdle {
+			return nil
+		}
+		_, err := fmt.Fprintln(w, Compact(info))
+		return err
+	case FormatWaybar:
+		return writeJSON(w, waybar(info))
+	case FormatI3blocks:
+		return writeJSON(w, i3blocks(info))
+	}
+
+	if !strings.Contains(format, "{{") {
+		return fmt.Errorf("unsupported status format %q, expected compact, waybar, i3blocks or a Go template", format)
+	}
+	tmpl, err := template.New("status").Funcs(template.FuncMap{
+		"clock":    Clock,
+		"truncate": truncate,
+	}).Parse(format)
+	if err != nil {
+		return fmt.Errorf("invalid status template: %w", err)
+	}
+	if err := tmpl.Execute(w, info); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w)
+	return err
+}
+
+// Compact returns a short one-line summary, e.g. "▶ Fix bug 12:34", "⏸ Fix bug 12:34"
+// or "▶ Fix bug +3:10" once the timebox is overdue.
+func Compact(info Info) string {
+	if info.State == StateIdle {
+		return ""
+	}
+	icon := "▶"
+	if info.State == StatePaused {
+		icon = "⏸"
+	}
+	return fmt.Sprintf("%s %s %s", icon, truncate(30, info.Task), Clock(info.Remaining))
+}
+
+// Clock formats a remaining duration as a countdown, e.g. "1:02:03" or "12:34".
+// Negative durations are shown as overtime, e.g. "+3:10".
+func Clock(d time.Duration) string {
+	sign := ""
+	if d < 0 {
+		sign = "+"
+		d = -d
+	}
+	d = d.Round(time.Second)
+	h := int(d.Hours())
+	m := int(d.Minutes()) % 60
+	s := int(d.Seconds()) % 60
+	if h > 0 {
+		return fmt.Sprintf("%s%d:%02d:%02d", sign, h, m, s)
+	}
+	return fmt.Sprintf("%s%d:%02d", sign, m, s)
+}
+
+// truncate shortens s to at most n runes, ending in an ellipsis if it was cut.
+func truncate(n int, s string) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+// class returns the CSS class / state name used by status bars.
+func class(info Info) string {
+	if info.Overdue() {
+		return "overdue"
+	}
+	return info.State
+}
+
+func tooltip(info Info) string {
+	if info.State == StateIdle {
+		return "No active session"
+	}
+	return fmt.Sprintf("%s %s (%s)\nElapsed: %s\nFile: %s",
+		info.Task, info.TimeBox, info.State, info.Elapsed.Round(time.Second), info.File)
+}
+
+func waybar(info Info) any {
+	return struct {
+		Text       string `json:"text"`
+		Alt        string `json:"alt"`
+		Tooltip    string `json:"tooltip"`
+		Class      string `json:"class"`
+		Percentage int    `json:"percentage"`
+	}{Compact(info), info.State, tooltip(info), class(info), info.Percent}
+}
+
+func i3blocks(info Info) any {
+	out := struct {
+		FullText  string `json:"full_text"`
+		ShortText string `json:"short_text"`
+		Color     string `json:"color,omitempty"`
+	}{FullText: Compact(info)}
+	if info.State != StateIdle {
+		out.ShortText = Clock(info.Remaining)
+	}
+	switch {
+	case info.Overdue():
+		out.Color = "#FF5555"
+	case info.State == StatePaused:
+		out.Color = "#FFD700"
+	}
+	return out
+}
+
+func writeJSON(w io.Writer, v any) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", line)
+	return err
+}
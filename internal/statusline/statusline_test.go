@@ -0,0 +1,155 @@
+package statusline
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"gobox/internal/state"
+)
+
+func TestCurrent(t *testing.T) {
+	now := time.Date(2025, 6, 2, 10, 0, 0, 0, time.Local)
+	ended := now.Add(-30 * time.Minute)
+	pausedAt := now.Add(-5 * time.Minute)
+	pausedEnd := pausedAt
+
+	states := []state.TimeBoxState{
+		{
+			// Stopped without being paused, not shown
+			TaskID:      "old",
+			Description: "Old task",
+			TimeBox:     "@1h",
+			Segments:    []state.TimeSegment{{Start: now.Add(-time.Hour), End: &ended}},
+		},
+		{
+			TaskID:      "paused",
+			Description: "Paused task",
+			TimeBox:     "@30m",
+			PausedAt:    &pausedAt,
+			Segments:    []state.TimeSegment{{Start: now.Add(-15 * time.Minute), End: &pausedEnd}},
+		},
+	}
+
+	info := Current(states, now)
+	if info.State != StatePaused || info.Task != "Paused task" {
+		t.Fatalf("expected the paused task, got %+v", info)
+	}
+	if info.Elapsed != 10*time.Minute || info.Remaining != 20*time.Minute || info.Percent != 33 {
+		t.Errorf("unexpected times: %+v", info)
+	}
+
+	// A running session wins over a paused one
+	states = append(states, state.TimeBoxState{
+		TaskID:      "running",
+		Description: "Running task",
+		TimeBox:     "@10m",
+		Segments:    []state.TimeSegment{{Start: now.Add(-12 * time.Minute)}},
+	})
+	info = Current(states, now)
+	if info.State != StateRunning || info.Task != "Running task" {
+		t.Fatalf("expected the running task, got %+v", info)
+	}
+	if !info.Overdue() || info.Remaining != -2*time.Minute || info.Percent != 100 {
+		t.Errorf("expected an overdue session, got %+v", info)
+	}
+
+	if info := Current(states[:1], now); info.State != StateIdle {
+		t.Errorf("expected idle, got %+v", info)
+	}
+}
+
+func TestCurrentRangeThatHasEnded(t *testing.T) {
+	now := time.Date(2025, 6, 2, 10, 15, 0, 0, time.Local)
+	states := []state.TimeBoxState{{
+		TaskID:      "range",
+		Description: "Standup",
+		TimeBox:     "@[09:00-10:00]",
+		Segments:    []state.TimeSegment{{Start: time.Date(2025, 6, 2, 9, 0, 0, 0, time.Local)}},
+	}}
+
+	// The range ended a quarter of an hour ago rather than starting tomorrow
+	info := Current(states, now)
+	if !info.Overdue() || info.Remaining != -15*time.Minute || info.Percent != 100 {
+		t.Errorf("expected the session to be overdue, got %+v", info)
+	}
+
+	// A session started early runs until the end of the same range
+	states[0].Segments[0].Start = time.Date(2025, 6, 2, 8, 30, 0, 0, time.Local)
+	if info := Current(states, time.Date(2025, 6, 2, 9, 30, 0, 0, time.Local)); info.Remaining != 30*time.Minute {
+		t.Errorf("Remaining = %v, want 30m", info.Remaining)
+	}
+}
+
+func TestClock(t *testing.T) {
+	tests := map[time.Duration]string{
+		12*time.Minute + 34*time.Second:           "12:34",
+		time.Hour + 2*time.Minute + 3*time.Second: "1:02:03",
+		-(3*time.Minute + 10*time.Second):         "+3:10",
+		0:                                         "0:00",
+	}
+	for d, want := range tests {
+		if got := Clock(d); got != want {
+			t.Errorf("Clock(%v) = %q, want %q", d, got, want)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	info := Info{
+		State:     StateRunning,
+		Task:      "Fix the flaky integration test in the parser package",
+		TimeBox:   "@1h",
+		Elapsed:   45 * time.Minute,
+		Remaining: 15 * time.Minute,
+		Percent:   75,
+	}
+
+	var buf bytes.Buffer
+	if err := Render(&buf, info, FormatCompact); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "▶ Fix the flaky integration tes… 15:00\n" {
+		t.Errorf("unexpected compact output %q", got)
+	}
+
+	buf.Reset()
+	if err := Render(&buf, info, "{{.Task | truncate 7}} {{clock .Remaining}} {{.Percent}}%"); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "Fix th… 15:00 75%\n" {
+		t.Errorf("unexpected template output %q", got)
+	}
+
+	buf.Reset()
+	if err := Render(&buf, info, FormatWaybar); err != nil {
+		t.Fatal(err)
+	}
+	var wb map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &wb); err != nil {
+		t.Fatalf("invalid waybar JSON: %v", err)
+	}
+	if wb["class"] != "running" || wb["percentage"] != float64(75) {
+		t.Errorf("unexpected waybar output %v", wb)
+	}
+
+	buf.Reset()
+	info.State = StatePaused
+	if err := Render(&buf, info, FormatI3blocks); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"short_text":"15:00"`) || !strings.Contains(buf.String(), `"color":"#FFD700"`) {
+		t.Errorf("unexpected i3blocks output %s", buf.String())
+	}
+
+	buf.Reset()
+	if err := Render(&buf, Info{State: StateIdle}, FormatCompact); err != nil || buf.Len() != 0 {
+		t.Errorf("expected no output when idle, got %q (%v)", buf.String(), err)
+	}
+
+	if err := Render(&buf, info, "bogus"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
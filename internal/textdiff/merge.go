@@ -0,0 +1,112 @@
+package textdiff
+
+import (
+	"sort"
+	"strings"
+)
+
+// change replaces the base lines [start, end) with lines.
+type change struct {
+	start, end int
+	lines      []string
+	theirs     bool // made on the theirs side
+}
+
+// changes returns the changes an edit script makes to the old text.
+func changes(ops []op, theirs bool) []change {
+	var result []change
+	line := 0
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			line++
+			i++
+			continue
+		}
+		c := change{start: line, end: line, theirs: theirs}
+		for ; i < len(ops) && ops[i].kind != ' '; i++ {
+			if ops[i].kind == '-' {
+				c.end++
+			} else {
+				c.lines = append(c.lines, ops[i].line)
+			}
+		}
+		line = c.end
+		result = append(result, c)
+	}
+	return result
+}
+
+// Merge merges the changes made from base to ours and from base to theirs. Changes to
+// different lines are both kept; where both sides changed the same lines differently,
+// the result has both versions between conflict markers labeled oursLabel and
+// theirsLabel. It returns the merged text and the number of conflicts.
+func Merge(base, ours, theirs []byte, oursLabel, theirsLabel string) ([]byte, int) {
+	baseLines := splitLines(string(base))
+	all := append(changes(diffLines(baseLines, splitLines(string(ours))), false),
+		changes(diffLines(baseLines, splitLines(string(theirs))), true)...)
+	sort.SliceStable(all, func(i, j int) bool { return all[i].start < all[j].start })
+
+	var b strings.Builder
+	conflicts := 0
+	line := 0
+	for i := 0; i < len(all); {
+		// Group the changes to overlapping base lines, and insertions at the same line
+		start, end := all[i].start, all[i].end
+		j := i + 1
+		for ; j < len(all) && (all[j].start < end || all[j].start == start && start == end); j++ {
+			end = max(end, all[j].end)
+		}
+		group := all[i:j]
+		i = j
+
+		writeLines(&b, baseLines[line:start])
+		line = end
+
+		oursLines, oursChanged := apply(baseLines, start, end, group, false)
+		theirsLines, theirsChanged := apply(baseLines, start, end, group, true)
+		switch {
+		case !theirsChanged:
+			writeLines(&b, oursLines)
+		case !oursChanged || strings.Join(oursLines, "") == strings.Join(theirsLines, ""):
+			writeLines(&b, theirsLines)
+		default:
+			conflicts++
+			writeLines(&b, []string{"<<<<<<< " + oursLabel + "\n"})
+			writeLines(&b, oursLines)
+			writeLines(&b, []string{"=======\n"})
+			writeLines(&b, theirsLines)
+			writeLines(&b, []string{">>>>>>> " + theirsLabel + "\n"})
+		}
+	}
+	writeLines(&b, baseLines[line:])
+	return []byte(b.String()), conflicts
+}
+
+// apply returns the base lines [start, end) with the changes of one side in group
+// applied, and whether that side changed anything.
+func apply(base []string, start, end int, group []change, theirs bool) ([]string, bool) {
+	var lines []string
+	changed := false
+	line := start
+	for _, c := range group {
+		if c.theirs != theirs {
+			continue
+		}
+		lines = append(lines, base[line:c.start]...)
+		lines = append(lines, c.lines...)
+		line = c.end
+		changed = true
+	}
+	return append(lines, base[line:end]...), changed
+}
+
+// writeLines writes lines to b. Only the last line of the text may lack a newline, so a
+// line without one gets it when more lines follow.
+func writeLines(b *strings.Builder, lines []string) {
+	for _, line := range lines {
+		if b.Len() > 0 && !strings.HasSuffix(b.String(), "\n") {
+			b.WriteByte('\n')
+		}
+		b.WriteString(line)
+	}
+}
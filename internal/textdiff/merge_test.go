@@ -0,0 +1,54 @@
+package textdiff_test
+
+import (
+	"testing"
+
+	"gobox/internal/textdiff"
+)
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name              string
+		base, ours, their string
+		want              string
+		conflicts         int
+	}{
+		{
+			name:  "separate changes",
+			base:  "- [x] Task @1h\n  * ⏱️ 1h\n- [ ] Other @1h\n",
+			ours:  "- [x] Task @1h\n  * ⏱️ 1h\n- [ ] Other @2h\n- [ ] New @1h\n",
+			their: "- [ ] Task @1h\n- [ ] Other @1h\n",
+			want:  "- [ ] Task @1h\n- [ ] Other @2h\n- [ ] New @1h\n",
+		},
+		{
+			name:  "same change",
+			base:  "a\nb\n",
+			ours:  "a\nc\n",
+			their: "a\nc\n",
+			want:  "a\nc\n",
+		},
+		{
+			name:      "conflict",
+			base:      "a\nb\nc\n",
+			ours:      "a\nours\nc\n",
+			their:     "a\ntheirs\nc\n",
+			want:      "a\n<<<<<<< current\nours\n=======\ntheirs\n>>>>>>> undo\nc\n",
+			conflicts: 1,
+		},
+		{
+			name:  "missing newline",
+			base:  "a\nb",
+			ours:  "A\nb",
+			their: "a\nb\nc",
+			want:  "A\nb\nc",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, conflicts := textdiff.Merge([]byte(tt.base), []byte(tt.ours), []byte(tt.their), "current", "undo")
+			if string(got) != tt.want || conflicts != tt.conflicts {
+				t.Errorf("Merge() = %q, %d conflicts, want %q, %d", got, conflicts, tt.want, tt.conflicts)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+// Package textdiff renders the difference between two versions of a text file as a
+// unified diff.
+package textdiff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// contextLines is how many unchanged lines are shown around each change.
+const contextLines = 3
+
+// noNewline marks a last line without a trailing newline.
+const noNewline = "\\ No newline at end of file\n"
+
+// op is one line of the edit script turning the old text into the new one.
+type op struct {
+	kind byte   // ' ' for a kept line, '-' for a removed one, '+' for an added one
+	line string // the line, including its newline if it has one
+}
+
+// Unified returns the unified diff of oldText and newText, labeled oldName and newName,
+// or "" if they are equal.
+func Unified(oldName, newName string, oldText, newText []byte) string {
+	ops := diffLines(splitLines(string(oldText)), splitLines(string(newText)))
+
+	var b strings.Builder
+	for _, h := range hunks(ops) {
+		if b.Len() == 0 {
+			fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+		}
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(h.oldStart, h.oldLines), hunkRange(h.newStart, h.newLines))
+		for _, o := range h.ops {
+			b.WriteByte(o.kind)
+			b.WriteString(o.line)
+			if !strings.HasSuffix(o.line, "\n") {
+				b.WriteString("\n" + noNewline)
+			}
+		}
+	}
+	return b.String()
+}
+
+// splitLines splits text into lines that keep their newline.
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines returns a shortest edit script from a to b. Common leading and trailing lines
+// are split off first, so the quadratic search only covers the changed region.
+func diffLines(a, b []string) []op {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]op, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		ops = append(ops, op{' ', line})
+	}
+	ops = append(ops, diffMiddle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, op{' ', line})
+	}
+	return ops
+}
+
+// diffMiddle diffs a and b by their longest common subsequence.
+func diffMiddle(a, b []string) []op {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var ops []op
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, op{' ', a[i]})
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, op{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, op{'+', b[j]})
+			j++
+		}
+	}
+	return ops
+}
+
+// hunk is a group of nearby changes with their context.
+type hunk struct {
+	oldStart, oldLines int // first line (1-based) and number of lines in the old text
+	newStart, newLines int // same for the new text
+	ops                []op
+}
+
+// hunks groups the changes in ops into hunks, merging changes whose context overlaps.
+func hunks(ops []op) []hunk {
+	var result []hunk
+	oldLine, newLine := 1, 1 // line numbers of ops[i] in both texts
+	prevEnd := 0             // end of the previous hunk
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			oldLine++
+			newLine++
+			i++
+			continue
+		}
+
+		// Start the hunk up to contextLines before the change
+		start := max(i-contextLines, prevEnd)
+		h := hunk{oldStart: oldLine - (i - start), newStart: newLine - (i - start)}
+
+		// Extend it while the next change is close enough for the context to overlap
+		end := i
+		for k := i; k < len(ops); k++ {
+			if ops[k].kind != ' ' {
+				end = k + 1
+			} else if k-end >= 2*contextLines {
+				break
+			}
+		}
+		end = min(end+contextLines, len(ops))
+
+		h.ops = ops[start:end]
+		for _, o := range h.ops {
+			if o.kind != '+' {
+				h.oldLines++
+			}
+			if o.kind != '-' {
+				h.newLines++
+			}
+		}
+		for _, o := range ops[i:end] {
+			if o.kind != '+' {
+				oldLine++
+			}
+			if o.kind != '-' {
+				newLine++
+			}
+		}
+		result = append(result, h)
+		i, prevEnd = end, end
+	}
+	return result
+}
+
+// hunkRange formats the range of a hunk header. An empty range starts at the line
+// before it, as in diff(1).
+func hunkRange(start, lines int) string {
+	switch lines {
+	case 0:
+		return fmt.Sprintf("%d,0", start-1)
+	case 1:
+		return fmt.Sprintf("%d", start)
+	default:
+		return fmt.Sprintf("%d,%d", start, lines)
+	}
+}
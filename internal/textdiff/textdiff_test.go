@@ -0,0 +1,80 @@
+package textdiff_test
+
+import (
+	"strings"
+	"testing"
+
+	"gobox/internal/textdiff"
+)
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		want     string
+	}{
+		{
+			name: "equal",
+			old:  "a\nb\n",
+			new:  "a\nb\n",
+			want: "",
+		},
+		{
+			name: "change with context",
+			old:  "1\n2\n3\n4\n5\n6\n7\n8\n",
+			new:  "1\n2\n3\n4\nfive\n6\n7\n8\n",
+			want: "--- a\n+++ b\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name: "insertion",
+			old:  "- [ ] Task @1h\n- [ ] Other @1h\n",
+			new:  "- [x] Task @1h\n  * ⏱️ 1h 0m 0s\n- [ ] Other @1h\n",
+			want: "--- a\n+++ b\n@@ -1,2 +1,3 @@\n-- [ ] Task @1h\n+- [x] Task @1h\n+  * ⏱️ 1h 0m 0s\n - [ ] Other @1h\n",
+		},
+		{
+			name: "separate hunks",
+			old:  "a\n1\n2\n3\n4\n5\n6\n7\nb\n",
+			new:  "A\n1\n2\n3\n4\n5\n6\n7\nB\n",
+			want: "--- a\n+++ b\n@@ -1,4 +1,4 @@\n-a\n+A\n 1\n 2\n 3\n@@ -6,4 +6,4 @@\n 5\n 6\n 7\n-b\n+B\n",
+		},
+		{
+			name: "merged hunks",
+			old:  "a\n1\n2\n3\n4\n5\n6\nb\n",
+			new:  "A\n1\n2\n3\n4\n5\n6\nB\n",
+			want: "--- a\n+++ b\n@@ -1,8 +1,8 @@\n-a\n+A\n 1\n 2\n 3\n 4\n 5\n 6\n-b\n+B\n",
+		},
+		{
+			name: "missing newline",
+			old:  "a",
+			new:  "a\n",
+			want: "--- a\n+++ b\n@@ -1 +1 @@\n-a\n\\ No newline at end of file\n+a\n",
+		},
+		{
+			name: "new file",
+			old:  "",
+			new:  "a\n",
+			want: "--- a\n+++ b\n@@ -0,0 +1 @@\n+a\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := textdiff.Unified("a", "b", []byte(tt.old), []byte(tt.new))
+			if got != tt.want {
+				t.Errorf("Unified() =\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnifiedLargeFile(t *testing.T) {
+	var old strings.Builder
+	for i := 0; i < 5000; i++ {
+		old.WriteString("- [ ] task\n")
+	}
+	changed := strings.Replace(old.String(), "[ ]", "[x]", 1)
+	got := textdiff.Unified("a", "b", []byte(old.String()), []byte(changed))
+	want := "--- a\n+++ b\n@@ -1,4 +1,4 @@\n-- [ ] task\n+- [x] task\n - [ ] task\n - [ ] task\n - [ ] task\n"
+	if got != want {
+		t.Errorf("Unified() =\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -2,7 +2,13 @@ package tui
 
 import (
 	"fmt"
+	"gobox/internal/backup"
+	"gobox/internal/config"
 	"gobox/internal/core"
+	"gobox/internal/gitutil"
+	"gobox/internal/history"
+	"gobox/internal/parser"
+	"gobox/internal/session"
 	"gobox/internal/state"
 	"gobox/pkg/task"
 	"io"
@@ -32,6 +38,11 @@ func (t TaskItem) Title() string {
 	return t.RawLine
 }
 
+// treeIndent returns the indentation used to render the task as part of the task tree.
+func (t TaskItem) treeIndent() string {
+	return strings.Repeat("  ", t.Task.Depth())
+}
+
 func (t TaskItem) Description() string { return "" }
 func (t TaskItem) FilterValue() string {
 	if t.Width > 0 {
@@ -48,6 +59,12 @@ const (
 	ViewTimerActive
 	ViewTimerDone
 	ViewQuitting
+	ViewConfirmEarlyStart
+	ViewPaused
+	ViewSelectCommits
+	ViewConfirmSessionConflict
+	ViewRecoverSessions
+	ViewIdle
 )
 
 // multilineDelegate wraps a list.DefaultDelegate and overrides Render to support multiline wrapped titles.
@@ -67,11 +84,24 @@ func (d *multilineDelegate) Render(w io.Writer, m list.Model, index int, item li
 		return
 	}
 
+	// Nested tasks are indented below their parent and wrapped within the remaining width
+	indent := ti.treeIndent()
+	if ti.Width > 0 && indent != "" {
+		ti.Width = max(ti.Width-len(indent)-2, 10)
+	}
+
 	title := ti.Title()
 	lines := strings.Split(title, "\n")
 	isSelected := index == m.Index()
 
 	for i, line := range lines {
+		if indent != "" {
+			if i == 0 {
+				line = indent + "└ " + line
+			} else {
+				line = indent + "  " + line
+			}
+		}
 		if isSelected {
 			fmt.Fprint(w, d.titleStyle.Render(line))
 		} else {
@@ -98,11 +128,28 @@ type model struct {
 	ActiveView    ViewState
 	timer         time.Duration
 	timerTotal    time.Duration
+	pausedTime    time.Duration // total time the running session has been paused
 	TimerTask     TaskItem
-	sessionRunner interface{} // session.SessionRunner, but avoid import cycle
+	pendingTask   TaskItem         // task awaiting confirmation to start early or despite other sessions
+	conflict      *sessionConflict // sessions running elsewhere when starting pendingTask
+	recovery      *staleRecovery   // sessions left running by gobox processes that are gone
+	sessionRunner interface{}      // session.SessionRunner, but avoid import cycle
 	SessionState  *state.TimeBoxState
 	gitWatcher    interface{} // gitwatcher.GitWatcher, but avoid import cycle
-	commits       []string
+	commits       []gitutil.Commit
+	gitErr        error // last error from the git watcher, shown below the commits
+	commitRules   gitutil.Attribution
+	repos         []gitutil.Repo       // repositories whose commits count towards the tasks
+	uncommitted   *gitutil.DiffSummary // changes not committed yet, nil if the working trees are clean
+	scopeChecks   config.ScopeChecks   // what is checked against the task's scope besides commits
+	annotation    *parser.Annotation   // what is written below completed tasks
+	completion    *pendingCompletion   // changes to the task file awaiting confirmation
+	dryRun        bool                 // preview changes to the task file without writing them
+	listErr       error                // why the last completed session wasn't fully recorded, shown below the task list
+	idle          config.IdleConfig    // when a running session is paused for being idle
+	keys          *session.Activity    // keys typed, as activity for the idle check
+	idlePause     *session.IdlePause   // why the running session was paused automatically
+	sessionGen    int                  // counts started sessions, to drop updates meant for earlier ones
 	commitTable   table.Model
 	height        int // Track terminal height for dynamic resizing
 	width         int // Track terminal width for dynamic resizing
@@ -111,6 +158,12 @@ type model struct {
 	stateMgr core.StateStore
 	States   []state.TimeBoxState
 
+	// historyLog records completed sessions, nil to disable
+	historyLog history.Log
+
+	// backups keeps the task file from before each change, nil to disable
+	backups *backup.Store
+
 	// Time when the last tickMsg was handled, for debounce
 	lastTickTime time.Time
 }
@@ -118,11 +171,8 @@ type model struct {
 
 func InitialModel(tasks []TaskItem, markdownFile string, height int, stateMgr core.StateStore, states []state.TimeBoxState) model {
 	l := initList(tasks, markdownFile, height)
-	columns := []table.Column{
-		{Title: "Commit", Width: 80 - 4},
-	}
 	t := table.New(
-		table.WithColumns(columns),
+		table.WithColumns(commitColumns(80, false)),
 		table.WithRows([]table.Row{}),
 		table.WithFocused(false),
 		table.WithHeight(10),
@@ -134,12 +184,51 @@ func InitialModel(tasks []TaskItem, markdownFile string, height int, stateMgr co
 		stateMgr:    stateMgr,
 		States:      states,
 		commitTable: t,
-		commits:     []string{},
+		commits:     []gitutil.Commit{},
+		annotation:  parser.DefaultAnnotation,
+		keys:        &session.Activity{},
 		ActiveView:  ViewTaskList,
 	}
 	return m
 }
 
+// repoColumnWidth is the width of the repository column in the commit table.
+const repoColumnWidth = 16
+
+// commitColumns returns the commit table columns for the given terminal width, with a
+// repository column if commits come from several repositories.
+func commitColumns(width int, withRepo bool) []table.Column {
+	if !withRepo {
+		return []table.Column{{Title: "Commit", Width: width - 4}}
+	}
+	return []table.Column{
+		{Title: "Repo", Width: repoColumnWidth},
+		{Title: "Commit", Width: max(width-4-repoColumnWidth, 10)},
+	}
+}
+
+// outOfScopeMarker starts the commit table rows of commits that changed files outside
+// the task's scope.
+const outOfScopeMarker = "⚠ "
+
+// commitRows returns the commit table rows for the session's commits.
+func (m model) commitRows() []table.Row {
+	withRepo := len(m.commitTable.Columns()) > 1
+	rows := make([]table.Row, len(m.commits))
+	for i, c := range m.commits {
+		commit := c.String()
+		if len(c.OutOfScope) > 0 {
+			commit = outOfScopeMarker + commit
+		}
+		if withRepo {
+			rows[i] = table.Row{c.Repo, commit}
+		} else {
+			rows[i] = table.Row{commit}
+		}
+	}
+	return rows
+}
+
 func max(a, b int) int {
 	if a > b {
 		return a
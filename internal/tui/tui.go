@@ -3,8 +3,12 @@ package tui
 import (
 	"fmt"
 	"strings"
+	"time"
 
+	"gobox/internal/backup"
+	"gobox/internal/config"
 	"gobox/internal/core"
+	"gobox/internal/history"
 	"gobox/internal/parser"
 	"gobox/internal/state"
 
@@ -68,11 +72,19 @@ func (m model) Init() tea.Cmd {
 }
 
 // Run launches the GoBox TUI for the given markdown file, state manager, and state.
-func Run(markdownFile string, stateMgr core.StateStore, states []state.TimeBoxState) error {
+// Completed sessions are appended to historyLog and changes to the task file backed up
+// in backups, unless they are nil; cfg decides which
+// repositories are watched and which commits are credited to a session. With dryRun,
+// the changes completing a task would make to the markdown file are only shown.
+func Run(markdownFile string, stateMgr core.StateStore, states []state.TimeBoxState, historyLog history.Log, backups *backup.Store, cfg config.Config, dryRun bool) error {
 	parsedTasks, err := parser.ParseMarkdownFile(markdownFile)
 	if err != nil {
 		return fmt.Errorf("Error loading tasks from markdown: %w", err)
 	}
+	repos, err := cfg.SessionRepos(markdownFile)
+	if err != nil {
+		return fmt.Errorf("Error finding repositories: %w", err)
+	}
 
 	tasks := make([]TaskItem, 0, len(parsedTasks))
 	for _, t := range parsedTasks {
@@ -86,6 +98,18 @@ func Run(markdownFile string, stateMgr core.StateStore, states []state.TimeBoxSt
 	}
 
 	m := InitialModel(tasks, markdownFile, 24, stateMgr, states)
+	m.historyLog = historyLog
+	m.backups = backups
+	m.commitRules = cfg.Commits
+	m.repos = repos
+	m.scopeChecks = cfg.Scope
+	m.idle = cfg.Idle
+	m.dryRun = dryRun
+	if m.annotation, err = cfg.LoadAnnotation(); err != nil {
+		return err
+	}
+	m.commitTable.SetColumns(commitColumns(m.width, len(repos) > 1))
+	m = recoverStaleSessions(m, time.Now())
 	p := tea.NewProgram(&teaModelAdapter{m})
 
 	_, err = p.Run()
@@ -2,11 +2,13 @@ package tui
 
 import (
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
 	"gobox/internal/core"
+	"gobox/internal/gitutil"
 	"gobox/internal/state"
 	"gobox/pkg/task"
 
@@ -63,9 +65,18 @@ func TestCompletionScreenConfirmTriggersMarkdownUpdate(t *testing.T) {
 	model.SessionState = &sessState
 	model.States = append(model.States, sessState)
 
-	// Simulate pressing Enter key in ViewTimerDone, which should trigger markdown update
+	// Simulate pressing Enter key in ViewTimerDone, which should preview the changes first
 	keyEnter := simulateKeyMsg("enter")
 
+	model, _ = HandleKeyMsg(model, keyEnter)
+	if model.completion == nil || !strings.Contains(model.completion.preview, "+- [x] Sample Test Task for some work @1m") {
+		t.Fatalf("expected a preview of the changes, got %+v", model.completion)
+	}
+	if content, _ := os.ReadFile(tmpFile.Name()); string(content) != markdownContent {
+		t.Fatalf("markdown changed before confirmation:\n%s", content)
+	}
+
+	// Confirming the preview triggers the markdown update
 	model, _ = HandleKeyMsg(model, keyEnter)
 
 	// Read back updated markdown file contents
@@ -83,6 +94,99 @@ func TestCompletionScreenConfirmTriggersMarkdownUpdate(t *testing.T) {
 	}
 }
 
+func TestCompletionPreviewSelectCommitsAndDryRun(t *testing.T) {
+	markdownContent := "- [ ] Write docs @1m\n"
+	path := filepath.Join(t.TempDir(), "tasks.md")
+	if err := os.WriteFile(path, []byte(markdownContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stateMgr := core.NewInMemoryStateStore()
+	item := TaskItem{RawLine: "Write docs @1m", Task: task.Task{Description: "Write docs", TimeBox: "@1m"}}
+	m := InitialModel([]TaskItem{item}, path, 40, stateMgr, nil)
+	m.dryRun = true
+	m.ActiveView = ViewTimerDone
+	m.TimerTask = item
+
+	now := time.Now()
+	m.States = []state.TimeBoxState{{
+		TaskHash: item.Task.Hash(),
+		Segments: []state.TimeSegment{{Start: now.Add(-time.Minute), End: &now}},
+	}}
+	m.SessionState = &m.States[0]
+	m.completion = &pendingCompletion{
+		commits: []gitutil.Commit{
+			{Hash: "abc1234", ShortHash: "abc1234", Subject: "Write the docs"},
+			{Hash: "def5678", ShortHash: "def5678", Subject: "Unrelated fix"},
+		},
+		excluded: make(map[string]bool),
+	}
+	m = previewCompletion(m)
+	if !strings.Contains(m.completion.preview, "def5678 Unrelated fix") {
+		t.Fatalf("expected both commits in the preview:\n%s", m.completion.preview)
+	}
+
+	// Deselect the second commit
+	m, _ = HandleKeyMsg(m, simulateKeyMsg("e"))
+	if m.ActiveView != ViewSelectCommits {
+		t.Fatalf("expected commit selection, got view %v", m.ActiveView)
+	}
+	m, _ = HandleKeyMsg(m, simulateKeyMsg("j"))
+	m, _ = HandleKeyMsg(m, simulateKeyMsg(" "))
+	m, _ = HandleKeyMsg(m, tea.KeyMsg{Type: tea.KeyEnter})
+	if m.ActiveView != ViewTimerDone {
+		t.Fatalf("expected to return to the preview, got view %v", m.ActiveView)
+	}
+	if strings.Contains(m.completion.preview, "Unrelated fix") || !strings.Contains(m.completion.preview, "abc1234 Write the docs") {
+		t.Errorf("expected only the selected commit in the preview:\n%s", m.completion.preview)
+	}
+	if view := completionView(m); !strings.Contains(view, "Dry run") {
+		t.Errorf("expected the dry run to be announced:\n%s", view)
+	}
+
+	// A dry run leaves the task file and the session's state alone
+	m, _ = HandleKeyMsg(m, simulateKeyMsg("y"))
+	if m.ActiveView != ViewTaskList {
+		t.Errorf("expected the task list, got view %v", m.ActiveView)
+	}
+	if content, _ := os.ReadFile(path); string(content) != markdownContent {
+		t.Errorf("dry run changed the markdown:\n%s", content)
+	}
+	if len(m.States) != 1 {
+		t.Errorf("dry run should keep the session's state, got %+v", m.States)
+	}
+}
+
+func TestCancelCompletionKeepsState(t *testing.T) {
+	markdownContent := "- [ ] Write docs @1m\n"
+	path := filepath.Join(t.TempDir(), "tasks.md")
+	if err := os.WriteFile(path, []byte(markdownContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stateMgr := core.NewInMemoryStateStore()
+	item := TaskItem{RawLine: "Write docs @1m", Task: task.Task{Description: "Write docs", TimeBox: "@1m"}}
+	m := InitialModel([]TaskItem{item}, path, 40, stateMgr, nil)
+	m.ActiveView = ViewTimerDone
+	m.TimerTask = item
+	m.States = []state.TimeBoxState{{
+		TaskHash: item.Task.Hash(),
+		Segments: []state.TimeSegment{{Start: time.Now().Add(-time.Minute)}},
+	}}
+	m.SessionState = &m.States[0]
+
+	m, _ = HandleKeyMsg(m, tea.KeyMsg{Type: tea.KeyEnter})
+	m, _ = HandleKeyMsg(m, tea.KeyMsg{Type: tea.KeyEsc})
+	if m.ActiveView != ViewTaskList || m.SessionState != nil {
+		t.Fatalf("expected to return to the task list, got view %v", m.ActiveView)
+	}
+	if content, _ := os.ReadFile(path); string(content) != markdownContent {
+		t.Errorf("cancelling changed the markdown:\n%s", content)
+	}
+	saved, _ := stateMgr.Load()
+	if len(saved) != 1 || saved[0].IsActive() {
+		t.Errorf("expected the closed session to be saved, got %+v", saved)
+	}
+}
+
 // simulateKeyMsg creates a tea.KeyMsg for a given string key
 func simulateKeyMsg(key string) tea.KeyMsg {
 	return tea.KeyMsg{
@@ -1,28 +1,58 @@
 package tui
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
+	"gobox/internal/core"
 	"gobox/internal/gitutil"
 	"gobox/internal/gitwatcher"
+	"gobox/internal/history"
 	"gobox/internal/parser"
 	"gobox/internal/session"
 	"gobox/internal/state"
+	"gobox/internal/textdiff"
+	"gobox/pkg/task"
 
 	"slices"
 
 	"github.com/charmbracelet/bubbles/list"
 	"github.com/charmbracelet/bubbles/table"
+	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 )
 
 // Message types for Bubbletea update loop
 type tickMsg struct{}
 type sessionCompletedMsg struct{}
-type commitMsg string
 type reloadListMsg struct{}
 
+// commitMsg carries a commit found by the git watcher of the session started as number
+// gen.
+type commitMsg struct {
+	gen    int
+	commit gitutil.Commit
+}
+
+// gitErrorMsg carries an error of the git watcher of the session started as number gen.
+type gitErrorMsg struct {
+	gen int
+	err error
+}
+
+// uncommittedMsg carries the uncommitted changes of the session started as number gen.
+type uncommittedMsg struct {
+	gen  int
+	diff *gitutil.DiffSummary
+}
+
+// uncommittedInterval is how often the working trees are checked for uncommitted changes.
+const uncommittedInterval = 10 * time.Second
+
 // sessionTickCmd returns a Bubbletea command that listens for session runner events.
 func sessionTickCmd(runner *session.SessionRunner) tea.Cmd {
 	return func() tea.Msg {
@@ -38,18 +68,29 @@ func sessionTickCmd(runner *session.SessionRunner) tea.Cmd {
 	}
 }
 
-// watchCommitsCmd returns a Bubbletea command that listens for new git commits or errors.
-func watchCommitsCmd(gw *gitwatcher.GitWatcher) tea.Cmd {
+// watchCommitsCmd returns a Bubbletea command that listens for new git commits or errors
+// of the session started as number gen, until its watcher is stopped.
+func watchCommitsCmd(gen int, gw *gitwatcher.GitWatcher) tea.Cmd {
 	return func() tea.Msg {
 		select {
 		case commit := <-gw.Commits():
-			return commitMsg(commit)
+			return commitMsg{gen: gen, commit: commit}
 		case err := <-gw.Errors():
-			return commitMsg(fmt.Sprintf("Git error: %v", err))
+			return gitErrorMsg{gen: gen, err: err}
+		case <-gw.Done():
+			return nil
 		}
 	}
 }
 
+// uncommittedCmd returns a Bubbletea command that looks up the uncommitted changes in
+// repos after delay, except for those base leaves out, flagging files outside scope.
+func uncommittedCmd(gen int, repos []gitutil.Repo, scope gitutil.Scope, base gitutil.Baseline, delay time.Duration) tea.Cmd {
+	return tea.Tick(delay, func(time.Time) tea.Msg {
+		return uncommittedMsg{gen: gen, diff: gitutil.SessionDiff(nil, repos, scope, base).Uncommitted}
+	})
+}
+
 // Update handles all Bubbletea update logic for the TUI model.
 func Update(m model, msg tea.Msg) (model, tea.Cmd) {
 	switch msg := msg.(type) {
@@ -63,6 +104,12 @@ func Update(m model, msg tea.Msg) (model, tea.Cmd) {
 		return handleSessionCompletedMsg(m, msg)
 	case commitMsg:
 		return handleCommitMsg(m, msg)
+	case gitErrorMsg:
+		return handleGitErrorMsg(m, msg)
+	case uncommittedMsg:
+		return handleUncommittedMsg(m, msg)
+	case stateCheckMsg:
+		return handleStateCheckMsg(m, msg)
 	case tea.WindowSizeMsg:
 		return handleWindowResize(m, msg)
 	default:
@@ -77,6 +124,9 @@ func Update(m model, msg tea.Msg) (model, tea.Cmd) {
 
 func HandleKeyMsg(m model, msg tea.KeyMsg) (model, tea.Cmd) {
 	k := msg.String()
+	if m.keys != nil {
+		m.keys.Record(time.Now())
+	}
 
 	switch m.ActiveView {
 	case ViewQuitting:
@@ -90,7 +140,8 @@ func HandleKeyMsg(m model, msg tea.KeyMsg) (model, tea.Cmd) {
 			if runner, ok := m.sessionRunner.(*session.SessionRunner); ok && runner != nil {
 				runner.Stop()
 			}
-			_ = m.stateMgr.Save(m.States)
+			m = stopWatcher(m)
+			m = saveSession(m)
 			return m, tea.Quit
 
 		case "enter":
@@ -98,200 +149,174 @@ func HandleKeyMsg(m model, msg tea.KeyMsg) (model, tea.Cmd) {
 				runner.Complete()
 			}
 			m.ActiveView = ViewTimerDone
+			if m.SessionState != nil {
+				m = prepareCompletion(m)
+			}
 			return m, nil
+
+		case "p", " ":
+			return pauseSession(m)
 		}
 
-	case ViewTimerDone:
+	case ViewPaused:
 		switch k {
-		case "enter", " ":
-			if m.SessionState != nil && m.list.Title != "" {
-				now := time.Now()
-
-				// Calculate total duration
-				var totalDuration time.Duration
-				for _, seg := range m.SessionState.Segments {
-					if seg.End != nil {
-						totalDuration += seg.End.Sub(seg.Start)
-					} else {
-						totalDuration += now.Sub(seg.Start)
-					}
-				}
+		case "ctrl+c", "q":
+			m.ActiveView = ViewQuitting
+			if runner, ok := m.sessionRunner.(*session.SessionRunner); ok && runner != nil {
+				runner.Stop()
+			}
+			m = stopWatcher(m)
+			m = saveSession(m)
+			return m, tea.Quit
 
-				// Get commits for the task duration
-				commitsDuringTask, _ := func() ([]string, error) {
-					var allCommits []string
-					commitSet := make(map[string]struct{})
-
-					for _, seg := range m.SessionState.Segments {
-						if seg.End == nil {
-							continue
-						}
-						commits, err := gitutil.GetCommitsBetweenTimeRange(seg.Start, *seg.End)
-						if err != nil {
-							return nil, err
-						}
-						for _, c := range commits {
-							if _, exists := commitSet[c]; !exists {
-								commitSet[c] = struct{}{}
-								allCommits = append(allCommits, c)
-							}
-						}
-					}
-					return allCommits, nil
-				}()
+		case "enter":
+			if runner, ok := m.sessionRunner.(*session.SessionRunner); ok && runner != nil {
+				runner.Complete()
+			}
+			m.ActiveView = ViewTimerDone
+			if m.SessionState != nil {
+				m = prepareCompletion(m)
+			}
+			return m, nil
 
-				// Update the markdown file
-				updatedTask := m.TimerTask.Task
-				updatedTask.IsChecked = true
+		case "p", " ":
+			return resumeSession(m)
+		}
+
+	case ViewIdle:
+		switch k {
+		case "k":
+			return keepIdleTime(m)
 
-				markdownFile := m.list.Title
+		case "d", "enter":
+			return resumeSession(m)
 
-				if err := parser.UpdateMarkdown(markdownFile, updatedTask, commitsDuringTask, totalDuration); err != nil {
-					fmt.Printf("Failed to update markdown file %s, quitting\n", markdownFile)
-					return m, tea.Quit
-				}
+		case "p", "esc":
+			m.ActiveView = ViewPaused
+			return m, nil
 
-				// Remove completed task state and save
-				m.States = m.stateMgr.RemoveTaskState(m.States, m.SessionState.TaskHash)
-				_ = m.stateMgr.Save(m.States)
-				m.SessionState = nil
+		case "ctrl+c", "q":
+			m.ActiveView = ViewQuitting
+			if runner, ok := m.sessionRunner.(*session.SessionRunner); ok && runner != nil {
+				runner.Stop()
 			}
+			m = stopWatcher(m)
+			m = saveSession(m)
+			return m, tea.Quit
+		}
 
-			m.ActiveView = ViewTaskList
-			return m, func() tea.Msg { return reloadListMsg{} }
+	case ViewTimerDone:
+		// The changes to the task file are previewed before they are written
+		if m.completion == nil && m.SessionState != nil {
+			return prepareCompletion(m), nil
+		}
+		switch k {
+		case "enter", " ", "y":
+			return completeTask(m)
+
+		case "e":
+			if m.completion != nil && len(m.completion.commits) > 0 {
+				m.ActiveView = ViewSelectCommits
+			}
+			return m, nil
+
+		case "esc", "n":
+			return cancelCompletion(m)
 
 		default:
 			return m, nil
 		}
 
+	case ViewSelectCommits:
+		c := m.completion
+		switch k {
+		case "up", "k":
+			if c.cursor > 0 {
+				c.cursor--
+			}
+		case "down", "j":
+			if c.cursor < len(c.commits)-1 {
+				c.cursor++
+			}
+		case " ", "x":
+			hash := c.commits[c.cursor].Hash
+			c.excluded[hash] = !c.excluded[hash]
+		case "enter", "esc":
+			m = previewCompletion(m)
+			m.ActiveView = ViewTimerDone
+		}
+		return m, nil
+
+	case ViewConfirmEarlyStart:
+		switch k {
+		case "y", "enter":
+			item := m.pendingTask
+			m.pendingTask = TaskItem{}
+			return startTask(m, item)
+
+		case "n", "esc":
+			m.pendingTask = TaskItem{}
+			m.ActiveView = ViewTaskList
+			return m, nil
+
+		case "ctrl+c", "q":
+			m.ActiveView = ViewQuitting
+			return m, tea.Quit
+		}
+
+	case ViewRecoverSessions:
+		return handleRecoveryKey(m, msg)
+
+	case ViewConfirmSessionConflict:
+		switch k {
+		case "p":
+			item := m.pendingTask
+			m.pendingTask, m.conflict = TaskItem{}, nil
+			return beginSession(m, item, true)
+
+		case "j":
+			if m.conflict.join == nil {
+				return m, nil
+			}
+			item := *m.conflict.join
+			m.pendingTask, m.conflict = TaskItem{}, nil
+			return beginSession(m, item, false)
+
+		case "a", "n", "esc":
+			m.pendingTask, m.conflict = TaskItem{}, nil
+			m.ActiveView = ViewTaskList
+			return m, nil
+
+		case "ctrl+c", "q":
+			m.ActiveView = ViewQuitting
+			return m, tea.Quit
+		}
+
 	case ViewTaskList:
 		switch k {
 		case "ctrl+c", "q":
 			now := time.Now()
-			if m.SessionState != nil {
-				taskHash := m.SessionState.TaskHash
-				for i := range m.States {
-					if m.States[i].TaskHash == taskHash {
-						m.SessionState = &m.States[i]
-						break
-					}
-				}
-			}
 			if m.SessionState != nil && len(m.SessionState.Segments) > 0 {
 				lastSeg := &m.SessionState.Segments[len(m.SessionState.Segments)-1]
 				if lastSeg.End == nil {
 					lastSeg.End = &now
 				}
 			}
-			_ = m.stateMgr.Save(m.States)
+			m = saveSession(m)
 			m.ActiveView = ViewQuitting
 			return m, tea.Quit
 
 		case "enter":
 			if item, ok := m.list.SelectedItem().(TaskItem); ok {
-				duration, endTime, err := parser.ParseTimeBox(item.Task.TimeBox)
-				if err == nil && (duration > 0 || !endTime.IsZero()) {
-					now := time.Now()
-					taskHash := item.Task.Hash()
-					found := false
-					var idx int
-
-					// Find existing task state or create new one
-					for i := range m.States {
-						if m.States[i].TaskHash == taskHash {
-							idx = i
-							found = true
-							break
-						}
-					}
-					if !found {
-						cleanStates := m.stateMgr.RemoveTaskState(m.States, taskHash)
-						newState := state.TimeBoxState{
-							TaskHash: taskHash,
-							Segments: []state.TimeSegment{{Start: now}},
-						}
-						m.States = append(cleanStates, newState)
-						idx = len(m.States) - 1
-						_ = m.stateMgr.Save(m.States)
-					} else {
-						segment := state.TimeSegment{Start: now}
-						m.States[idx].Segments = append(m.States[idx].Segments, segment)
-					}
-
-					m.SessionState = &m.States[idx]
-
-					// Set up timer state
-					m.TimerTask = item
-					m.ActiveView = ViewTimerActive
-
-					runner := session.NewSessionRunner(item.Task, m.SessionState, duration, endTime)
-					m.sessionRunner = runner
-					m.timerTotal = duration
-					m.timer = duration
-					m.TimerTask = item
-
-					runner.Start()
-
-					// Setup git watcher if needed
-					if m.gitWatcher == nil {
-						var startTime time.Time
-						if len(m.SessionState.Segments) > 0 {
-							startTime = m.SessionState.Segments[0].Start
-						} else {
-							startTime = now
-						}
-						watcher := gitwatcher.NewGitWatcher(startTime, 5*time.Second)
-						m.gitWatcher = watcher
-
-						if len(m.SessionState.Segments) > 1 {
-							commitSet := make(map[string]struct{})
-							for _, seg := range m.SessionState.Segments {
-								if seg.End == nil {
-									continue
-								}
-								commits, err := gitutil.GetCommitsBetweenTimeRange(seg.Start, *seg.End)
-								if err != nil {
-									continue
-								}
-								for _, commit := range commits {
-									if _, exists := commitSet[commit]; !exists {
-										commitSet[commit] = struct{}{}
-										m.commits = append(m.commits, commit)
-									}
-								}
-							}
-							if len(m.commits) > 0 {
-								rows := make([]table.Row, len(m.commits))
-								for i, c := range m.commits {
-									rows[i] = table.Row{c}
-								}
-								if len(m.commitTable.Columns()) > 0 {
-									m.commitTable.SetRows(rows)
-								}
-							}
-						}
-
-						watcher.Start()
-
-						if len(m.commitTable.Columns()) == 0 {
-							columns := []table.Column{
-								{Title: "Commit", Width: m.width - 4},
-							}
-							m.commitTable = table.New(
-								table.WithColumns(columns),
-								table.WithRows([]table.Row{}),
-								table.WithFocused(false),
-								table.WithHeight(10),
-							)
-						}
-					}
-
-					cmds := []tea.Cmd{sessionTickCmd(runner)}
-					if watcher, ok := m.gitWatcher.(*gitwatcher.GitWatcher); ok && watcher != nil {
-						cmds = append(cmds, watchCommitsCmd(watcher))
+				if parser.IsTimeRange(item.Task.TimeBox) {
+					startTime, _, err := parser.ParseTimeRange(item.Task.TimeBox)
+					if err == nil && time.Now().Before(startTime) {
+						m.pendingTask = item
+						m.ActiveView = ViewConfirmEarlyStart
+						return m, nil
 					}
-					return m, tea.Batch(cmds...)
 				}
+				return startTask(m, item)
 			}
 		default:
 			// Forward other keys to the list's update for navigation, selection, etc.
@@ -303,6 +328,579 @@ func HandleKeyMsg(m model, msg tea.KeyMsg) (model, tea.Cmd) {
 	return m, nil
 }
 
+// pauseSession pauses the running session, closing its current segment, and stops
+// crediting new commits to the task until the session is resumed.
+func pauseSession(m model) (model, tea.Cmd) {
+	if runner, ok := m.sessionRunner.(*session.SessionRunner); ok && runner != nil {
+		runner.Pause()
+		m.pausedTime = runner.PausedDuration()
+	}
+	if watcher, ok := m.gitWatcher.(*gitwatcher.GitWatcher); ok && watcher != nil {
+		watcher.Pause()
+	}
+	m = saveSession(m)
+	m.ActiveView = ViewPaused
+	return m, nil
+}
+
+// resumeSession resumes a paused session, opening a new segment.
+func resumeSession(m model) (model, tea.Cmd) {
+	if runner, ok := m.sessionRunner.(*session.SessionRunner); ok && runner != nil {
+		runner.Resume()
+		m.pausedTime = runner.PausedDuration()
+	}
+	if watcher, ok := m.gitWatcher.(*gitwatcher.GitWatcher); ok && watcher != nil {
+		_ = watcher.Resume()
+	}
+	m = saveSession(m)
+	m.ActiveView = ViewTimerActive
+	return m, nil
+}
+
+// idlePaused shows that the running session was paused for being idle, or because the
+// machine was suspended, and asks whether to keep the time since. Meanwhile new commits
+// aren't credited to the task, as while paused.
+func idlePaused(m model) model {
+	if runner, ok := m.sessionRunner.(*session.SessionRunner); ok && runner != nil {
+		m.idlePause = runner.Idle()
+	}
+	if watcher, ok := m.gitWatcher.(*gitwatcher.GitWatcher); ok && watcher != nil {
+		watcher.Pause()
+	}
+	m = saveSession(m)
+	m.ActiveView = ViewIdle
+	return m
+}
+
+// keepIdleTime resumes a session paused for being idle, counting the idle time as worked.
+func keepIdleTime(m model) (model, tea.Cmd) {
+	if runner, ok := m.sessionRunner.(*session.SessionRunner); ok && runner != nil {
+		runner.KeepIdle()
+		m.pausedTime = runner.PausedDuration()
+	}
+	if watcher, ok := m.gitWatcher.(*gitwatcher.GitWatcher); ok && watcher != nil {
+		_ = watcher.Resume()
+	}
+	m = saveSession(m)
+	m.ActiveView = ViewTimerActive
+	return m, nil
+}
+
+// saveSession saves the running session's state, keeping the states other gobox
+// processes saved meanwhile. Failures are ignored: the session goes on, and its time
+// is saved with the next change.
+func saveSession(m model) model {
+	if m.SessionState == nil {
+		return m
+	}
+	if states, err := core.SaveState(m.stateMgr, *m.SessionState); err == nil {
+		m.States = states
+	}
+	return m
+}
+
+// stateCheckInterval is how often the state store is checked for the running session
+// being paused by another gobox process, and how often it is considered for a heartbeat.
+const stateCheckInterval = 5 * time.Second
+
+// stateCheckMsg carries the saved states for the session started as number gen.
+type stateCheckMsg struct {
+	gen    int
+	states []state.TimeBoxState
+}
+
+// stateCheckCmd returns a Bubbletea command that loads the saved states after delay.
+func stateCheckCmd(gen int, stateMgr core.StateStore, delay time.Duration) tea.Cmd {
+	return tea.Tick(delay, func(time.Time) tea.Msg {
+		states, _ := stateMgr.Load()
+		return stateCheckMsg{gen: gen, states: states}
+	})
+}
+
+// handleStateCheckMsg pauses the running session if another gobox process paused it,
+// as the pause offered when starting another session does. The time since then
+// doesn't count. Otherwise it records a heartbeat for the session every
+// state.HeartbeatInterval, so other processes can tell it is still running.
+func handleStateCheckMsg(m model, msg stateCheckMsg) (model, tea.Cmd) {
+	if msg.gen != m.sessionGen || m.SessionState == nil {
+		return m, nil
+	}
+	next := stateCheckCmd(m.sessionGen, m.stateMgr, stateCheckInterval)
+	if m.ActiveView != ViewTimerActive {
+		return m, next
+	}
+	i := state.FindState(msg.states, m.SessionState.File, m.SessionState.TaskID, m.SessionState.TaskHash, "")
+	if i < 0 || !m.SessionState.PausedElsewhere(msg.states[i]) {
+		return beat(m, time.Now()), next
+	}
+
+	saved := msg.states[i]
+	m, _ = pauseSession(m)
+	if runner, ok := m.sessionRunner.(*session.SessionRunner); ok && runner != nil {
+		runner.Mutex.Lock()
+		m.SessionState.Segments[len(m.SessionState.Segments)-1].End = saved.Segments[len(saved.Segments)-1].End
+		m.SessionState.PausedAt = saved.PausedAt
+		runner.Mutex.Unlock()
+		m = saveSession(m)
+	}
+	return m, next
+}
+
+// beat saves a heartbeat for the running session if the last one is due for renewal.
+// Only the saved state's heartbeat is updated, see core.BeatState, so that a pause made
+// elsewhere since the last check isn't undone.
+func beat(m model, now time.Time) model {
+	runner, ok := m.sessionRunner.(*session.SessionRunner)
+	if !ok || runner == nil {
+		return m
+	}
+	runner.Mutex.Lock()
+	due := m.SessionState.IsActive() && now.Sub(m.SessionState.LastSeen()) >= state.HeartbeatInterval
+	if due {
+		m.SessionState.Beat(now)
+	}
+	key := m.SessionState.Key()
+	runner.Mutex.Unlock()
+	if !due {
+		return m
+	}
+	if states, err := core.BeatState(m.stateMgr, key, now); err == nil {
+		m.States = states
+	}
+	return m
+}
+
+// sessionConflict are the sessions running elsewhere when starting another one.
+type sessionConflict struct {
+	others []state.TimeBoxState
+	join   *TaskItem // the task of the running session, if it can be followed here
+}
+
+// askAboutRunningSessions asks what to do with the sessions running elsewhere before
+// starting item: pause them, join the running one or not start.
+func askAboutRunningSessions(m model, item TaskItem, others []state.TimeBoxState) model {
+	m.pendingTask = item
+	m.conflict = &sessionConflict{others: others}
+	if len(others) == 1 && !others[0].IsStale(time.Now()) {
+		if running, ok := findTaskItem(m, others[0]); ok {
+			m.conflict.join = &running
+		}
+	}
+	m.ActiveView = ViewConfirmSessionConflict
+	return m
+}
+
+// findTaskItem returns the listed task s is the state of.
+func findTaskItem(m model, s state.TimeBoxState) (TaskItem, bool) {
+	file := absFile(m.list.Title)
+	for _, listItem := range m.list.Items() {
+		item, ok := listItem.(TaskItem)
+		if !ok {
+			continue
+		}
+		if state.FindState([]state.TimeBoxState{s}, file, item.Task.ID, item.Task.Hash(), "") == 0 {
+			return item, true
+		}
+	}
+	return TaskItem{}, false
+}
+
+// staleRecovery goes through the sessions whose gobox was killed, or whose machine went
+// to sleep or lost power, while they were running. Their open segments would otherwise
+// count the time since as worked.
+type staleRecovery struct {
+	sessions []state.TimeBoxState // stale sessions left to deal with, the first is shown
+	trimming bool                 // whether the end of the first session is being entered
+	input    textinput.Model      // when the first session ended
+	err      error                // why the entered end or closing the session failed
+}
+
+// recoverStaleSessions asks what to do with the stale sessions among the saved states
+// before showing the task list, if there are any.
+func recoverStaleSessions(m model, now time.Time) model {
+	var stale []state.TimeBoxState
+	for _, i := range state.Stale(m.States, now) {
+		stale = append(stale, m.States[i])
+	}
+	if len(stale) == 0 {
+		return m
+	}
+	input := textinput.New()
+	input.Placeholder = "17:30 or 45m"
+	input.Prompt = "Stopped at: "
+	input.CharLimit = 16
+	m.recovery = &staleRecovery{sessions: stale, input: input}
+	m.ActiveView = ViewRecoverSessions
+	return m
+}
+
+// handleRecoveryKey closes the shown stale session at its last heartbeat (h), keeps all
+// its time by closing it now (k) or closes it at an entered time (t).
+func handleRecoveryKey(m model, msg tea.KeyMsg) (model, tea.Cmd) {
+	r := m.recovery
+	s := r.sessions[0]
+	k := msg.String()
+	if k == "ctrl+c" {
+		m.ActiveView = ViewQuitting
+		return m, tea.Quit
+	}
+
+	if r.trimming {
+		switch k {
+		case "enter":
+			end, err := core.ParseEnd(strings.TrimSpace(r.input.Value()), s, time.Now())
+			if err != nil {
+				r.err = err
+				return m, nil
+			}
+			return closeStaleSession(m, end), nil
+		case "esc":
+			r.trimming, r.err = false, nil
+			r.input.Blur()
+			return m, nil
+		}
+		var cmd tea.Cmd
+		r.input, cmd = r.input.Update(msg)
+		return m, cmd
+	}
+
+	switch k {
+	case "h":
+		return closeStaleSession(m, s.LastSeen()), nil
+	case "k":
+		return closeStaleSession(m, time.Now()), nil
+	case "t":
+		r.trimming, r.err = true, nil
+		r.input.SetValue("")
+		return m, r.input.Focus()
+	case "q":
+		m.ActiveView = ViewQuitting
+		return m, tea.Quit
+	}
+	return m, nil
+}
+
+// closeStaleSession closes the shown stale session at end and moves on to the next one,
+// or to the task list after the last. A session another gobox dealt with meanwhile is
+// left as it is.
+func closeStaleSession(m model, end time.Time) model {
+	r := m.recovery
+	if err := core.CloseStale(m.stateMgr, r.sessions[0], end); err != nil && !errors.Is(err, core.ErrNotStale) {
+		r.err = err
+		return m
+	}
+	if states, err := m.stateMgr.Load(); err == nil {
+		m.States = states
+	}
+
+	r.sessions = r.sessions[1:]
+	r.trimming, r.err = false, nil
+	r.input.Blur()
+	if len(r.sessions) == 0 {
+		m.recovery = nil
+		m.ActiveView = ViewTaskList
+	}
+	return m
+}
+
+// pendingCompletion is what completing the session's task will write to the task file,
+// shown for confirmation before it is written.
+type pendingCompletion struct {
+	commits  []gitutil.Commit    // commits made during the session
+	excluded map[string]bool     // hashes of the commits the user deselected
+	cursor   int                 // highlighted commit while selecting commits
+	diff     gitutil.DiffSummary // changes made by the selected commits and left uncommitted
+	preview  string              // unified diff of the task file
+	err      error               // why the task file can't be updated, if it can't
+}
+
+// selected returns the commits that will be listed below the task.
+func (c *pendingCompletion) selected() []gitutil.Commit {
+	var commits []gitutil.Commit
+	for _, commit := range c.commits {
+		if !c.excluded[commit.Hash] {
+			commits = append(commits, commit)
+		}
+	}
+	return commits
+}
+
+// selectedCommits returns the commits that will be listed below the completed task:
+// those of the session the user didn't deselect.
+func (m model) selectedCommits() []gitutil.Commit {
+	if m.completion == nil {
+		return m.commits
+	}
+	return m.completion.selected()
+}
+
+// completedTask returns the session's task marked as done.
+func (m model) completedTask() task.Task {
+	t := m.TimerTask.Task
+	t.IsChecked = true
+	return t
+}
+
+// prepareCompletion closes the session's last segment, looks up the commits made during
+// it and previews the changes completing the task makes to the task file.
+func prepareCompletion(m model) model {
+	if len(m.SessionState.Segments) > 0 && m.SessionState.Segments[len(m.SessionState.Segments)-1].End == nil {
+		now := time.Now()
+		m.SessionState.Segments[len(m.SessionState.Segments)-1].End = &now
+		m = saveSession(m)
+	}
+
+	commits, _ := gitwatcher.CommitsDuring(m.commitRules, m.repos, m.SessionState)
+	gitutil.FlagScope(commits, m.TimerTask.Task.Scope)
+	gitutil.LinkCommits(commits, m.repos)
+	m.completion = &pendingCompletion{commits: commits, excluded: make(map[string]bool)}
+	return previewCompletion(m)
+}
+
+// previewCompletion renders the diff of the task file for the selected commits.
+func previewCompletion(m model) model {
+	c := m.completion
+	selected := c.selected()
+	c.diff = gitutil.SessionDiff(selected, m.repos, m.scopeChecks.UncommittedScope(m.TimerTask.Task.Scope), gitwatcher.SessionBaseline(m.SessionState))
+
+	markdownFile := m.list.Title
+	content, err := os.ReadFile(markdownFile)
+	if err != nil {
+		c.preview, c.err = "", err
+		return m
+	}
+	updated, err := m.annotation.CompleteTask(content, m.completedTask(), selected, m.SessionState.Segments, c.diff)
+	if err != nil {
+		c.preview, c.err = "", err
+		return m
+	}
+	c.preview = textdiff.Unified(markdownFile, markdownFile, content, updated)
+	c.err = nil
+	return m
+}
+
+// completeTask writes the previewed changes to the task file, records the session in the
+// history and forgets its state. In a dry run nothing is written and the state is kept,
+// so the task can still be completed for real.
+func completeTask(m model) (model, tea.Cmd) {
+	if m.SessionState != nil && m.completion != nil && m.list.Title != "" && !m.dryRun {
+		now := time.Now()
+		updatedTask := m.completedTask()
+		commits := m.completion.selected()
+		markdownFile := m.list.Title
+
+		if err := m.annotation.UpdateMarkdown(markdownFile, m.backups, updatedTask, commits, m.SessionState.Segments, m.completion.diff); err != nil {
+			fmt.Printf("Failed to update markdown file %s, quitting\n", markdownFile)
+			return m, tea.Quit
+		}
+
+		// Keep a permanent record of the session before its state is removed. If it
+		// can't be recorded, the state is kept as the only record of the session.
+		m.listErr = nil
+		if m.historyLog != nil {
+			entry := history.NewEntry(markdownFile, updatedTask, m.SessionState.Segments, commits, m.completion.diff, now)
+			if err := m.historyLog.Append(entry); err != nil {
+				m.listErr = fmt.Errorf("couldn't record the session in the history, its state is kept: %w", err)
+				m = saveSession(m)
+			}
+		}
+
+		// Remove completed task state and save
+		if m.listErr == nil {
+			if states, err := core.RemoveState(m.stateMgr, m.SessionState.Key()); err == nil {
+				m.States = states
+			}
+		}
+	}
+
+	m = stopWatcher(m)
+	m.SessionState = nil
+	m.completion = nil
+	m.ActiveView = ViewTaskList
+	return m, func() tea.Msg { return reloadListMsg{} }
+}
+
+// cancelCompletion returns to the task list without completing the task. The session's
+// time is kept in its state, so starting the task again continues it.
+func cancelCompletion(m model) (model, tea.Cmd) {
+	m = saveSession(m)
+	m = stopWatcher(m)
+	m.SessionState = nil
+	m.completion = nil
+	m.ActiveView = ViewTaskList
+	return m, func() tea.Msg { return reloadListMsg{} }
+}
+
+// stopWatcher stops watching for the commits of the session, which has ended or makes way
+// for another one.
+func stopWatcher(m model) model {
+	if watcher, ok := m.gitWatcher.(*gitwatcher.GitWatcher); ok && watcher != nil {
+		watcher.Stop()
+	}
+	m.gitWatcher = nil
+	return m
+}
+
+// startTask begins (or resumes) a timeboxed session for the given task item. If other
+// sessions are running, it asks what to do with them first.
+func startTask(m model, item TaskItem) (model, tea.Cmd) {
+	return beginSession(m, item, false)
+}
+
+// beginSession starts a session for item, pausing the sessions running elsewhere if
+// pauseOthers is set. Otherwise it asks what to do with them. A session of item that is
+// already running, in another gobox process, is joined rather than started again.
+func beginSession(m model, item TaskItem, pauseOthers bool) (model, tea.Cmd) {
+	duration, endTime, err := parser.ParseTimeBox(item.Task.TimeBox)
+	if err == nil && (duration > 0 || !endTime.IsZero()) {
+		now := time.Now()
+		taskHash := item.Task.Hash()
+
+		// Give the task a stable ID on first start, so its state survives edits to the task.
+		// A dry run leaves the task file alone.
+		if item.Task.ID == "" && !m.dryRun {
+			id := task.NewID()
+			if err := parser.AssignTaskID(m.list.Title, m.backups, item.Task, id); err == nil {
+				item.Task.ID = id
+			}
+		}
+
+		// Find existing task state or create new one, in the states as saved right now so
+		// that sessions started elsewhere are seen
+		var started state.TimeBoxState
+		open := func(states []state.TimeBoxState) ([]state.TimeBoxState, error) {
+			idx := state.FindState(states, absFile(m.list.Title), item.Task.ID, taskHash, item.Task.Description)
+			if idx < 0 {
+				newState := state.TimeBoxState{
+					TaskID:   item.Task.ID,
+					TaskHash: taskHash,
+				}
+				gitwatcher.RecordRefs(&newState, m.repos)
+				states = append(states, newState)
+				idx = len(states) - 1
+			}
+			states[idx].TaskID = item.Task.ID
+			states[idx].TaskHash = taskHash
+			if !states[idx].IsActive() {
+				states[idx].Segments = append(states[idx].Segments, state.TimeSegment{Start: now})
+				states[idx].PausedAt = nil
+			}
+			setTaskDetails(&states[idx], m.list.Title, item.Task)
+
+			active := state.Active(states, states[idx].Key())
+			if len(active) > 0 && !pauseOthers {
+				others := &core.ActiveSessionsError{}
+				for _, i := range active {
+					others.States = append(others.States, states[i])
+				}
+				return nil, others
+			}
+			for _, i := range active {
+				states[i].PauseRunning(now)
+			}
+			started = states[idx]
+			return states, nil
+		}
+		states, err := m.stateMgr.Update(open)
+		var others *core.ActiveSessionsError
+		if errors.As(err, &others) {
+			return askAboutRunningSessions(m, item, others.States), nil
+		}
+		if err != nil {
+			// The session runs without being saved rather than not at all
+			if states, err = open(m.States); err != nil {
+				return m, nil
+			}
+		}
+		m.States = states
+		m.SessionState = &started
+		m.listErr = nil
+
+		// Set up timer state
+		m.TimerTask = item
+		m.ActiveView = ViewTimerActive
+
+		runner := session.NewSessionRunner(item.Task, m.SessionState, duration, endTime)
+		runner.IdleAfter = m.idle.Threshold()
+		runner.IdleSources = m.idle.NewSources(m.repos, m.keys)
+		m.sessionRunner = runner
+		m.timerTotal = duration
+		m.timer = duration
+		m.TimerTask = item
+
+		// Time range tasks count down to the end of the range; the progress bar spans
+		// the scheduled range, or the remainder of it when started early.
+		if duration == 0 {
+			rangeStart := now
+			if startTime, _, err := parser.ParseTimeRange(item.Task.TimeBox); err == nil && startTime.Before(now) {
+				rangeStart = startTime
+			}
+			m.timerTotal = endTime.Sub(rangeStart)
+			m.timer = endTime.Sub(now)
+		}
+
+		runner.Start()
+
+		// Each session watches for commits with its own task, scope and baseline
+		m = stopWatcher(m)
+		startTime := now
+		if len(m.SessionState.Segments) > 0 {
+			startTime = m.SessionState.Segments[0].Start
+		}
+		watcher := gitwatcher.NewGitWatcher(startTime, 5*time.Second)
+		watcher.Rules = m.commitRules
+		watcher.Repos = m.repos
+		watcher.Refs = gitwatcher.SessionRefs(m.SessionState)
+		watcher.Task = m.SessionState.Key()
+		watcher.Scope = item.Task.Scope
+		m.gitWatcher = watcher
+
+		if len(m.commitTable.Columns()) == 0 {
+			m.commitTable = table.New(
+				table.WithColumns(commitColumns(m.width, len(m.repos) > 1)),
+				table.WithRows([]table.Row{}),
+				table.WithFocused(false),
+				table.WithHeight(10),
+			)
+		}
+		m.commits = nil
+		if len(m.SessionState.Segments) > 1 {
+			m.commits, _ = gitwatcher.CommitsDuring(m.commitRules, m.repos, m.SessionState)
+			gitutil.FlagScope(m.commits, item.Task.Scope)
+		}
+		m.commitTable.SetRows(m.commitRows())
+		m.gitErr = nil
+
+		watcher.Start()
+
+		m.uncommitted = nil
+		m.sessionGen++
+		cmds := []tea.Cmd{
+			sessionTickCmd(runner),
+			uncommittedCmd(m.sessionGen, m.repos, m.scopeChecks.UncommittedScope(item.Task.Scope), gitwatcher.SessionBaseline(m.SessionState), 0),
+			stateCheckCmd(m.sessionGen, m.stateMgr, stateCheckInterval),
+		}
+		cmds = append(cmds, watchCommitsCmd(m.sessionGen, watcher))
+		return m, tea.Batch(cmds...)
+	}
+	return m, nil
+}
+
+// setTaskDetails records what the session is about in its state, for gobox status.
+func setTaskDetails(tbState *state.TimeBoxState, file string, t task.Task) {
+	tbState.File = absFile(file)
+	tbState.Description = t.Description
+	tbState.TimeBox = t.TimeBox
+}
+
+// absFile returns the absolute path of file, or file itself if it can't be made absolute.
+func absFile(file string) string {
+	if abs, err := filepath.Abs(file); err == nil {
+		return abs
+	}
+	return file
+}
+
 func handleReloadListMsg(m model, _ reloadListMsg) (model, tea.Cmd) {
 	tasks, err := parser.ParseMarkdownFile(m.list.Title)
 	if err == nil {
@@ -323,8 +921,13 @@ func handleReloadListMsg(m model, _ reloadListMsg) (model, tea.Cmd) {
 
 func handleTickMsg(m model, _ tickMsg) (model, tea.Cmd) {
 	if runner, ok := m.sessionRunner.(*session.SessionRunner); ok && runner != nil {
-		if m.ActiveView != ViewTimerDone {
-			if m.timerTotal > 0 {
+		if m.ActiveView == ViewTimerActive && runner.Idle() != nil {
+			m = idlePaused(m)
+		}
+		m.pausedTime = runner.PausedDuration()
+		// The countdown stays frozen while paused
+		if m.ActiveView != ViewTimerDone && m.ActiveView != ViewPaused && m.ActiveView != ViewIdle {
+			if runner.Duration > 0 {
 				elapsed := runner.TotalElapsed()
 				m.timer = m.timerTotal - elapsed
 				if m.timer < 0 {
@@ -345,6 +948,10 @@ func handleTickMsg(m model, _ tickMsg) (model, tea.Cmd) {
 }
 
 func handleSessionCompletedMsg(m model, _ sessionCompletedMsg) (model, tea.Cmd) {
+	// Completed early or cancelled, the session has been dealt with already
+	if m.completion != nil || (m.SessionState == nil && m.ActiveView == ViewTaskList) {
+		return m, nil
+	}
 	m.ActiveView = ViewTimerDone
 
 	if m.SessionState != nil {
@@ -365,30 +972,7 @@ func handleSessionCompletedMsg(m model, _ sessionCompletedMsg) (model, tea.Cmd)
 			}
 		}
 
-		// Attempt to get commits, ignore errors
-		_, _ = func() ([]string, error) {
-			var allCommits []string
-			commitSet := make(map[string]struct{})
-
-			for _, seg := range m.SessionState.Segments {
-				if seg.End == nil {
-					continue
-				}
-				commits, err := gitutil.GetCommitsBetweenTimeRange(seg.Start, *seg.End)
-				if err != nil {
-					return nil, err
-				}
-				for _, c := range commits {
-					if _, exists := commitSet[c]; !exists {
-						commitSet[c] = struct{}{}
-						allCommits = append(allCommits, c)
-					}
-				}
-			}
-			return allCommits, nil
-		}()
-
-		_ = m.stateMgr.Save(m.States)
+		m = saveSession(m)
 		tasks, err := parser.ParseMarkdownFile(m.list.Title)
 		if err == nil {
 			var items []list.Item
@@ -403,27 +987,52 @@ func handleSessionCompletedMsg(m model, _ sessionCompletedMsg) (model, tea.Cmd)
 			}
 			m.list.SetItems(items)
 		}
+		m = prepareCompletion(m)
 	}
 	return m, nil
 }
 
+// handleUncommittedMsg shows the uncommitted changes and schedules the next check while
+// the session lasts.
+func handleUncommittedMsg(m model, msg uncommittedMsg) (model, tea.Cmd) {
+	if msg.gen != m.sessionGen || m.SessionState == nil {
+		return m, nil
+	}
+	m.uncommitted = msg.diff
+	return m, uncommittedCmd(m.sessionGen, m.repos, m.scopeChecks.UncommittedScope(m.TimerTask.Task.Scope), gitwatcher.SessionBaseline(m.SessionState), uncommittedInterval)
+}
+
 func handleCommitMsg(m model, msg commitMsg) (model, tea.Cmd) {
-	newCommit := string(msg)
-	isDuplicate := slices.Contains(m.commits, newCommit)
+	// Commits of an earlier session's watcher belong to its task
+	if msg.gen != m.sessionGen {
+		return m, nil
+	}
+	newCommit := msg.commit
+	isDuplicate := slices.ContainsFunc(m.commits, func(c gitutil.Commit) bool {
+		return c.Hash == newCommit.Hash
+	})
 
 	if !isDuplicate {
 		m.commits = append(m.commits, newCommit)
-		rows := make([]table.Row, len(m.commits))
-		for i, c := range m.commits {
-			rows[i] = table.Row{c}
-		}
 		if len(m.commitTable.Columns()) > 0 {
-			m.commitTable.SetRows(rows)
+			m.commitTable.SetRows(m.commitRows())
 		}
 	}
 
 	if watcher, ok := m.gitWatcher.(*gitwatcher.GitWatcher); ok && watcher != nil {
-		return m, watchCommitsCmd(watcher)
+		return m, watchCommitsCmd(m.sessionGen, watcher)
+	}
+	return m, nil
+}
+
+// handleGitErrorMsg shows the latest git error below the commit table and keeps watching.
+func handleGitErrorMsg(m model, msg gitErrorMsg) (model, tea.Cmd) {
+	if msg.gen != m.sessionGen {
+		return m, nil
+	}
+	m.gitErr = msg.err
+	if watcher, ok := m.gitWatcher.(*gitwatcher.GitWatcher); ok && watcher != nil {
+		return m, watchCommitsCmd(m.sessionGen, watcher)
 	}
 	return m, nil
 }
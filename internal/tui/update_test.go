@@ -1,14 +1,25 @@
 package tui
 
 import (
+	"errors"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
 	"time"
 
+	"gobox/internal/config"
+	"gobox/internal/core"
+	"gobox/internal/gitutil"
+	"gobox/internal/gitwatcher"
+	"gobox/internal/history"
 	"gobox/internal/parser"
+	"gobox/internal/session"
 	"gobox/internal/state"
+	"gobox/pkg/task"
+
+	tea "github.com/charmbracelet/bubbletea"
 )
 
 type dummyStateMgr struct{}
@@ -17,9 +28,10 @@ func (d *dummyStateMgr) Load() ([]state.TimeBoxState, error) {
 	return nil, nil
 }
 
-func (d *dummyStateMgr) Save(states []state.TimeBoxState) error {
-	return nil
+func (d *dummyStateMgr) Update(update func([]state.TimeBoxState) ([]state.TimeBoxState, error)) ([]state.TimeBoxState, error) {
+	return update(nil)
 }
+
 func (d *dummyStateMgr) RemoveTaskState(states []state.TimeBoxState, taskHash string) []state.TimeBoxState {
 	var newStates []state.TimeBoxState
 	for _, s := range states {
@@ -107,3 +119,378 @@ func TestHandleSessionCompletedMsg_ReloadsTasks(t *testing.T) {
 		t.Errorf("reloaded tasks do not match expected tasks.\nGot: %v\nExpected: %v", reloadedTasks, expectedTasks)
 	}
 }
+
+func TestEnterOnFutureTimeRangeAsksToStartEarly(t *testing.T) {
+	start := time.Now().Add(2 * time.Hour)
+	end := start.Add(time.Hour)
+	timeBox := "@[" + start.Format("15:04") + "-" + end.Format("15:04") + "]"
+
+	items := []TaskItem{{
+		RawLine: "Planning block " + timeBox,
+		Task:    task.Task{Description: "Planning block", TimeBox: timeBox},
+	}}
+	m := InitialModel(items, "tasks.md", 24, &dummyStateMgr{}, nil)
+
+	m, _ = HandleKeyMsg(m, tea.KeyMsg{Type: tea.KeyEnter})
+	if m.ActiveView != ViewConfirmEarlyStart {
+		t.Fatalf("expected early start confirmation, got view %v", m.ActiveView)
+	}
+	if m.SessionState != nil {
+		t.Errorf("session should not start before confirmation")
+	}
+
+	m, _ = HandleKeyMsg(m, simulateKeyMsg("n"))
+	if m.ActiveView != ViewTaskList {
+		t.Errorf("expected to return to task list, got view %v", m.ActiveView)
+	}
+	if m.SessionState != nil {
+		t.Errorf("session should not start after declining")
+	}
+}
+
+func TestPauseAndResumeKeysCloseAndReopenSegments(t *testing.T) {
+	items := []TaskItem{{
+		RawLine: "Pausable task @10m",
+		Task:    task.Task{Description: "Pausable task", TimeBox: "@10m"},
+	}}
+	stateMgr := core.NewInMemoryStateStore()
+	m := InitialModel(items, "tasks.md", 24, stateMgr, nil)
+
+	m, _ = HandleKeyMsg(m, tea.KeyMsg{Type: tea.KeyEnter})
+	if m.ActiveView != ViewTimerActive {
+		t.Fatalf("expected timer view, got %v", m.ActiveView)
+	}
+	runner := m.sessionRunner.(*session.SessionRunner)
+	defer runner.Stop()
+	defer m.gitWatcher.(*gitwatcher.GitWatcher).Stop()
+
+	m, _ = HandleKeyMsg(m, simulateKeyMsg("p"))
+	if m.ActiveView != ViewPaused {
+		t.Fatalf("expected paused view, got %v", m.ActiveView)
+	}
+	if m.SessionState.IsActive() {
+		t.Errorf("segment should be closed while paused")
+	}
+	saved, _ := stateMgr.Load()
+	if len(saved) != 1 || saved[0].IsActive() {
+		t.Errorf("closed segment should be persisted on pause: %+v", saved)
+	}
+
+	m, _ = HandleKeyMsg(m, simulateKeyMsg("p"))
+	if m.ActiveView != ViewTimerActive {
+		t.Fatalf("expected timer view after resume, got %v", m.ActiveView)
+	}
+	if len(m.SessionState.Segments) != 2 || !m.SessionState.IsActive() {
+		t.Errorf("resume should open a new segment: %+v", m.SessionState.Segments)
+	}
+	if m.pausedTime <= 0 {
+		t.Errorf("expected paused time to be tracked, got %v", m.pausedTime)
+	}
+}
+
+func TestOutOfScopeCommitsAreFlagged(t *testing.T) {
+	m := InitialModel(nil, "tasks.md", 40, &dummyStateMgr{}, nil)
+	m.ActiveView = ViewTimerDone
+	m.TimerTask = TaskItem{Task: task.Task{Description: "Fix parser bug", Scope: []string{"internal/parser/**"}}}
+
+	m, _ = handleCommitMsg(m, commitMsg{commit: gitutil.Commit{Hash: "abc1234", ShortHash: "abc1234", Subject: "Fix the lexer",
+		Files: []string{"internal/parser/lexer.go"}}})
+	m, _ = handleCommitMsg(m, commitMsg{commit: gitutil.Commit{Hash: "def5678", ShortHash: "def5678", Subject: "Tweak the view",
+		Files: []string{"internal/tui/view.go"}, OutOfScope: []string{"internal/tui/view.go"}}})
+
+	rows := m.commitTable.Rows()
+	if len(rows) != 2 || strings.HasPrefix(rows[0][0], outOfScopeMarker) || !strings.HasPrefix(rows[1][0], outOfScopeMarker) {
+		t.Fatalf("expected only the second row to be flagged, got %q", rows)
+	}
+	if view := completionView(m); !strings.Contains(view, "def5678 Tweak the view: internal/tui/view.go") {
+		t.Errorf("expected a scope warning on completion:\n%s", view)
+	}
+}
+
+func TestEachSessionWatchesCommitsForItsOwnTask(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.md")
+	if err := os.WriteFile(path, []byte("- [ ] Fix parser @10m\n- [ ] Tweak view @10m\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	parserItem := TaskItem{RawLine: "Fix parser @10m", Task: task.Task{Description: "Fix parser", TimeBox: "@10m", Scope: []string{"internal/parser/**"}}}
+	viewItem := TaskItem{RawLine: "Tweak view @10m", Task: task.Task{Description: "Tweak view", TimeBox: "@10m", Scope: []string{"internal/tui/**"}}}
+	m := InitialModel([]TaskItem{parserItem, viewItem}, path, 40, core.NewInMemoryStateStore(), nil)
+
+	m, _ = startTask(m, parserItem)
+	defer m.sessionRunner.(*session.SessionRunner).Stop()
+	first := m.gitWatcher.(*gitwatcher.GitWatcher)
+	firstGen := m.sessionGen
+	m, _ = handleCommitMsg(m, commitMsg{gen: firstGen, commit: gitutil.Commit{Hash: "abc1234", ShortHash: "abc1234", Subject: "Fix the lexer"}})
+	if len(m.commits) != 1 {
+		t.Fatalf("expected the commit to be listed, got %+v", m.commits)
+	}
+
+	// Leaving the session without completing the task stops its watcher
+	m, _ = HandleKeyMsg(m, tea.KeyMsg{Type: tea.KeyEnter})
+	m, _ = HandleKeyMsg(m, tea.KeyMsg{Type: tea.KeyEsc})
+	select {
+	case <-first.Done():
+	default:
+		t.Fatal("the watcher of the ended session is still running")
+	}
+
+	m, _ = startTask(m, viewItem)
+	defer m.sessionRunner.(*session.SessionRunner).Stop()
+	second := m.gitWatcher.(*gitwatcher.GitWatcher)
+	defer second.Stop()
+	if second == first || second.Task != m.SessionState.Key() || !reflect.DeepEqual(second.Scope, gitutil.Scope(viewItem.Task.Scope)) {
+		t.Fatalf("expected a watcher for the second task, got task %q scope %v", second.Task, second.Scope)
+	}
+	if len(m.commits) != 0 || len(m.commitTable.Rows()) != 0 {
+		t.Errorf("the first session's commits were carried over: %+v", m.commits)
+	}
+
+	// A commit the first watcher found before it stopped belongs to the first task
+	m, _ = handleCommitMsg(m, commitMsg{gen: firstGen, commit: gitutil.Commit{Hash: "def5678", ShortHash: "def5678", Subject: "Late commit"}})
+	if len(m.commits) != 0 {
+		t.Errorf("a commit of the first session was listed: %+v", m.commits)
+	}
+}
+
+// runningElsewhere returns a store with a session of item running in another gobox.
+func runningElsewhere(item TaskItem, since time.Time) *core.InMemoryStateStore {
+	stateMgr := core.NewInMemoryStateStore()
+	heartbeat := time.Now()
+	stateMgr.Save([]state.TimeBoxState{{
+		TaskHash:    item.Task.Hash(),
+		Description: item.Task.Description,
+		TimeBox:     item.Task.TimeBox,
+		Segments:    []state.TimeSegment{{Start: since}},
+		Heartbeat:   &heartbeat,
+	}})
+	return stateMgr
+}
+
+func TestStartingWhileAnotherSessionRuns(t *testing.T) {
+	items := []TaskItem{
+		{RawLine: "Write docs @10m", Task: task.Task{Description: "Write docs", TimeBox: "@10m"}},
+		{RawLine: "Fix bug @10m", Task: task.Task{Description: "Fix bug", TimeBox: "@10m"}},
+	}
+	stateMgr := runningElsewhere(items[1], time.Now().Add(-5*time.Minute))
+	m := InitialModel(items, "tasks.md", 24, stateMgr, nil)
+	m.dryRun = true
+
+	m, _ = HandleKeyMsg(m, tea.KeyMsg{Type: tea.KeyEnter})
+	if m.ActiveView != ViewConfirmSessionConflict || m.SessionState != nil {
+		t.Fatalf("expected to be asked about the running session, got view %v", m.ActiveView)
+	}
+	if m.conflict.join == nil || m.conflict.join.Task.Description != "Fix bug" {
+		t.Errorf("expected to be offered to join Fix bug, got %+v", m.conflict.join)
+	}
+	if view := sessionConflictView(m); !strings.Contains(view, "Fix bug (5m0s so far)") || !strings.Contains(view, "j to join") {
+		t.Errorf("expected the running session and the choices to be shown:\n%s", view)
+	}
+
+	// Going back starts nothing
+	m, _ = HandleKeyMsg(m, simulateKeyMsg("a"))
+	if m.ActiveView != ViewTaskList {
+		t.Fatalf("expected the task list, got view %v", m.ActiveView)
+	}
+	if saved, _ := stateMgr.Load(); len(saved) != 1 || !saved[0].IsActive() {
+		t.Errorf("going back should leave the running session alone, got %+v", saved)
+	}
+
+	// Pausing the running session starts the new one
+	m, _ = HandleKeyMsg(m, tea.KeyMsg{Type: tea.KeyEnter})
+	m, _ = HandleKeyMsg(m, simulateKeyMsg("p"))
+	if m.ActiveView != ViewTimerActive || m.TimerTask.Task.Description != "Write docs" {
+		t.Fatalf("expected Write docs to start, got view %v for %q", m.ActiveView, m.TimerTask.Task.Description)
+	}
+	defer m.sessionRunner.(*session.SessionRunner).Stop()
+	defer m.gitWatcher.(*gitwatcher.GitWatcher).Stop()
+
+	saved, _ := stateMgr.Load()
+	if len(saved) != 2 || !saved[0].IsPaused() || !saved[1].IsActive() {
+		t.Errorf("expected Fix bug paused and Write docs running, got %+v", saved)
+	}
+	if active := state.Active(saved, ""); len(active) != 1 {
+		t.Errorf("expected a single running session, got %d", len(active))
+	}
+}
+
+func TestJoinRunningSessionAndNoticePauseElsewhere(t *testing.T) {
+	items := []TaskItem{
+		{RawLine: "Write docs @10m", Task: task.Task{Description: "Write docs", TimeBox: "@10m"}},
+		{RawLine: "Fix bug @10m", Task: task.Task{Description: "Fix bug", TimeBox: "@10m"}},
+	}
+	stateMgr := runningElsewhere(items[1], time.Now().Add(-5*time.Minute))
+	m := InitialModel(items, "tasks.md", 24, stateMgr, nil)
+	m.dryRun = true
+
+	m, _ = HandleKeyMsg(m, tea.KeyMsg{Type: tea.KeyEnter})
+	m, _ = HandleKeyMsg(m, simulateKeyMsg("j"))
+	if m.ActiveView != ViewTimerActive || m.TimerTask.Task.Description != "Fix bug" {
+		t.Fatalf("expected to join Fix bug, got view %v for %q", m.ActiveView, m.TimerTask.Task.Description)
+	}
+	runner := m.sessionRunner.(*session.SessionRunner)
+	defer runner.Stop()
+	defer m.gitWatcher.(*gitwatcher.GitWatcher).Stop()
+	if len(m.SessionState.Segments) != 1 {
+		t.Errorf("joining should continue the running segment, got %+v", m.SessionState.Segments)
+	}
+	if elapsed := runner.TotalElapsed(); elapsed < 5*time.Minute {
+		t.Errorf("expected the time spent elsewhere to count, got %v", elapsed)
+	}
+
+	// Heartbeats show that the session is still running
+	later := time.Now().Add(state.HeartbeatInterval)
+	m = beat(m, later)
+	saved, _ := stateMgr.Load()
+	if saved[0].Heartbeat == nil || !saved[0].Heartbeat.Equal(later) {
+		t.Errorf("expected a heartbeat at %v, got %v", later, saved[0].Heartbeat)
+	}
+
+	// The other gobox pauses the session
+	pausedAt := time.Now().Add(-time.Minute)
+	saved[0].Segments = []state.TimeSegment{{Start: saved[0].Segments[0].Start, End: &pausedAt}}
+	saved[0].PausedAt = &pausedAt
+	if _, err := core.SaveState(stateMgr, saved[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	// A heartbeat due before the pause is noticed leaves it alone
+	m = beat(m, later.Add(state.HeartbeatInterval))
+	if saved, _ := stateMgr.Load(); saved[0].IsActive() || !saved[0].Heartbeat.Equal(later) {
+		t.Fatalf("the heartbeat should not undo the pause: %+v", saved[0])
+	}
+
+	m, _ = handleStateCheckMsg(m, stateCheckMsg{gen: m.sessionGen, states: saved})
+	if m.ActiveView != ViewPaused {
+		t.Fatalf("expected the session to be paused here too, got view %v", m.ActiveView)
+	}
+	if end := m.SessionState.Segments[0].End; end == nil || !end.Equal(pausedAt) {
+		t.Errorf("expected the segment to end when it was paused elsewhere, got %v", end)
+	}
+}
+
+func TestRecoverStaleSessionsOnStart(t *testing.T) {
+	now := time.Now()
+	heartbeat := now.Add(-2 * time.Hour)
+	start := now.Add(-3 * time.Hour)
+	stateMgr := core.NewInMemoryStateStore()
+	stateMgr.Save([]state.TimeBoxState{
+		{TaskID: "a", Description: "Write docs", Segments: []state.TimeSegment{{Start: start}}, Heartbeat: &heartbeat},
+		{TaskID: "b", Description: "Fix bug", Segments: []state.TimeSegment{{Start: start}}, Heartbeat: &heartbeat},
+	})
+	states, _ := stateMgr.Load()
+	m := recoverStaleSessions(InitialModel(nil, "tasks.md", 24, stateMgr, states), now)
+	if m.ActiveView != ViewRecoverSessions || len(m.recovery.sessions) != 2 {
+		t.Fatalf("expected to be asked about both stale sessions, got view %v", m.ActiveView)
+	}
+	if view := recoveryView(m); !strings.Contains(view, "Write docs") || !strings.Contains(view, "(1 more after this one)") {
+		t.Errorf("expected the first stale session to be shown:\n%s", view)
+	}
+
+	// End the first at its last heartbeat
+	m, _ = HandleKeyMsg(m, simulateKeyMsg("h"))
+	saved, _ := stateMgr.Load()
+	if end := saved[0].Segments[0].End; end == nil || !end.Equal(heartbeat) {
+		t.Errorf("expected Write docs to end at its heartbeat, got %+v", saved[0].Segments)
+	}
+
+	// Trim the second to 30 minutes, after a typo
+	m, _ = HandleKeyMsg(m, simulateKeyMsg("t"))
+	m, _ = HandleKeyMsg(m, simulateKeyMsg("later"))
+	m, _ = HandleKeyMsg(m, tea.KeyMsg{Type: tea.KeyEnter})
+	if m.recovery == nil || m.recovery.err == nil {
+		t.Fatalf("expected an error for an invalid end")
+	}
+	m.recovery.input.SetValue("30m")
+	m, _ = HandleKeyMsg(m, tea.KeyMsg{Type: tea.KeyEnter})
+	if m.ActiveView != ViewTaskList || m.recovery != nil {
+		t.Fatalf("expected the task list once all sessions are dealt with, got view %v", m.ActiveView)
+	}
+	saved, _ = stateMgr.Load()
+	if end := saved[1].Segments[0].End; end == nil || !end.Equal(start.Add(30*time.Minute)) || !saved[1].IsPaused() {
+		t.Errorf("expected Fix bug to end after 30 minutes, got %+v", saved[1])
+	}
+	if len(state.Stale(m.States, now)) != 0 {
+		t.Errorf("the model should have the recovered states, got %+v", m.States)
+	}
+}
+
+func TestIdleSessionIsPausedAndKeepsTheTimeOnRequest(t *testing.T) {
+	items := []TaskItem{{
+		RawLine: "Idle task @10m",
+		Task:    task.Task{Description: "Idle task", TimeBox: "@10m"},
+	}}
+	stateMgr := core.NewInMemoryStateStore()
+	m := InitialModel(items, "tasks.md", 24, stateMgr, nil)
+	m.idle = config.IdleConfig{After: "1ns", Sources: []config.IdleSource{config.IdleKeys}}
+
+	m, _ = HandleKeyMsg(m, tea.KeyMsg{Type: tea.KeyEnter})
+	runner := m.sessionRunner.(*session.SessionRunner)
+	defer runner.Stop()
+	defer m.gitWatcher.(*gitwatcher.GitWatcher).Stop()
+
+	// No key is typed after the start, so the session is idle from the first check
+	deadline := time.Now().Add(3 * time.Second)
+	for runner.Idle() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("the session was not paused for being idle")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	m, _ = handleTickMsg(m, tickMsg{})
+	if m.ActiveView != ViewIdle {
+		t.Fatalf("expected the idle view, got %v", m.ActiveView)
+	}
+	if !strings.Contains(ModelView(m), "No activity since") {
+		t.Errorf("expected the idle view to say since when, got:\n%s", ModelView(m))
+	}
+	saved, _ := stateMgr.Load()
+	if len(saved) != 1 || !saved[0].IsPaused() {
+		t.Errorf("the idle session should be saved paused: %+v", saved)
+	}
+
+	m, _ = HandleKeyMsg(m, simulateKeyMsg("k"))
+	if m.ActiveView != ViewTimerActive {
+		t.Fatalf("expected timer view after keeping the time, got %v", m.ActiveView)
+	}
+	if len(m.SessionState.Segments) != 1 || !m.SessionState.IsActive() {
+		t.Errorf("keeping the idle time should reopen the segment: %+v", m.SessionState.Segments)
+	}
+}
+
+// failingLog is a history log that can't be written.
+type failingLog struct{}
+
+func (failingLog) Append(history.Entry) error        { return errors.New("disk full") }
+func (failingLog) Entries() ([]history.Entry, error) { return nil, nil }
+
+func TestCompleteTaskKeepsStateWhenHistoryFails(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "tasks.md")
+	if err := os.WriteFile(file, []byte("- [ ] Task @10m\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tasks, err := parser.ParseMarkdownFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().Add(-10 * time.Minute)
+	end := time.Now()
+	stateMgr := core.NewInMemoryStateStore()
+	tbState := state.TimeBoxState{TaskHash: tasks[0].Hash(), Segments: []state.TimeSegment{{Start: start, End: &end}}}
+	if _, err := core.SaveState(stateMgr, tbState); err != nil {
+		t.Fatal(err)
+	}
+
+	m := InitialModel(nil, file, 24, stateMgr, nil)
+	m.historyLog = failingLog{}
+	m.TimerTask = TaskItem{Task: tasks[0]}
+	m.SessionState = &tbState
+	m.completion = &pendingCompletion{excluded: map[string]bool{}}
+
+	m, _ = completeTask(m)
+	if m.listErr == nil || !strings.Contains(ModelView(m), "disk full") {
+		t.Errorf("expected the history error below the task list, got:\n%s", ModelView(m))
+	}
+	if saved, _ := stateMgr.Load(); len(saved) != 1 {
+		t.Errorf("expected the session's state to be kept, got %+v", saved)
+	}
+}
@@ -3,6 +3,11 @@ package tui
 import (
 	"fmt"
 	"strings"
+	"time"
+
+	"gobox/internal/gitutil"
+	"gobox/internal/parser"
+	"gobox/internal/state"
 
 	"github.com/charmbracelet/bubbles/progress"
 	"github.com/charmbracelet/lipgloss"
@@ -13,10 +18,18 @@ func ModelView(m model) string {
 	switch m.ActiveView {
 	case ViewQuitting:
 		return quittingView()
-	case ViewTimerActive:
+	case ViewTimerActive, ViewPaused, ViewIdle:
 		return timerView(m)
 	case ViewTimerDone:
-		return completionView()
+		return completionView(m)
+	case ViewSelectCommits:
+		return selectCommitsView(m)
+	case ViewConfirmEarlyStart:
+		return earlyStartView(m)
+	case ViewConfirmSessionConflict:
+		return sessionConflictView(m)
+	case ViewRecoverSessions:
+		return recoveryView(m)
 	case ViewTaskList:
 		return taskListView(m)
 	default:
@@ -53,20 +66,60 @@ func timerView(m model) string {
 	pb := progress.New(progress.WithDefaultGradient(), progress.WithWidth(40))
 	progressBar := pb.ViewAs(progressPercent)
 
+	status := headerStyle.Render("Time remaining: ") + timerStyle.Render(timeStr)
+	instructions := "Press Enter to complete early, p/Space to pause or q/Ctrl+C to quit."
+	if m.ActiveView == ViewPaused {
+		pausedStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#FFFF00"))
+		status += "  " + pausedStyle.Render("⏸ Paused")
+		instructions = "Press p/Space to resume, Enter to complete or q/Ctrl+C to quit."
+	}
+	if m.ActiveView == ViewIdle && m.idlePause != nil {
+		idleStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#FFFF00"))
+		since := m.idlePause.Since
+		reason := "No activity since " + since.Format("15:04")
+		if m.idlePause.Suspended {
+			reason = "The computer was asleep since " + since.Format("15:04")
+		}
+		status += "  " + idleStyle.Render("⏸ Paused") + "\n" + idleStyle.Render(reason)
+		instructions = fmt.Sprintf("Press k to keep the %s since as worked, d/Enter to resume without it, p/Esc to stay paused or q/Ctrl+C to quit.",
+			time.Since(since).Round(time.Second))
+	}
+	if m.pausedTime > 0 {
+		status += "\n" + headerStyle.Render("Paused for: ") + m.pausedTime.Round(time.Second).String()
+	}
+
+	workingOn := headerStyle.Render("Working on: ") + m.TimerTask.Title()
+	if scope := m.TimerTask.Task.Scope; len(scope) > 0 {
+		workingOn += "\n" + headerStyle.Render("Scope: ") + strings.Join(scope, ", ")
+	}
+
 	timerBlock := lipgloss.NewStyle().Padding(1).BorderStyle(lipgloss.RoundedBorder()).Render(
 		fmt.Sprintf(
-			"%s\n%s\n%s\n\nPress Enter to complete early or q/Ctrl+C to quit.",
-			headerStyle.Render("Working on: ")+m.TimerTask.Title(),
-			headerStyle.Render("Time remaining: ")+timerStyle.Render(timeStr),
+			"%s\n%s\n%s\n\n%s",
+			workingOn,
+			status,
 			progressBar,
+			instructions,
 		),
 	)
-	commitsBlock := lipgloss.NewStyle().Padding(1).Render(headerStyle.Render("Commits during session:"))
+	commitsHeader := headerStyle.Render("Commits during session:")
+	diff := gitutil.Summarize(m.commits)
+	diff.Uncommitted = m.uncommitted
+	if !diff.IsEmpty() {
+		commitsHeader += "\n📊 " + diff.String()
+		if dirs := diff.DirNames(); len(dirs) > 0 {
+			commitsHeader += " (" + strings.Join(dirs, ", ") + ")"
+		}
+	}
+	commitsBlock := lipgloss.NewStyle().Padding(1).Render(commitsHeader)
 
 	// Only render commit table if it has columns and rows
 	commitTableBlock := ""
 	if len(m.commitTable.Columns()) > 0 {
-		commitTableBlock = m.commitTable.View()
+		commitTableBlock = highlightOutOfScope(m.commitTable.View())
+	}
+	if m.gitErr != nil {
+		commitTableBlock = lipgloss.JoinVertical(lipgloss.Left, commitTableBlock, fmt.Sprintf("Git error: %v", m.gitErr))
 	}
 
 	content := lipgloss.JoinVertical(lipgloss.Left, timerBlock, commitsBlock, commitTableBlock)
@@ -77,14 +130,225 @@ func timerView(m model) string {
 	return content
 }
 
-func completionView() string {
-	// Show completion message and return to list after a keypress
+// highlightOutOfScope colors the rows of the rendered commit table whose commits changed
+// files outside the task's scope.
+func highlightOutOfScope(table string) string {
+	if !strings.Contains(table, outOfScopeMarker) {
+		return table
+	}
+	lines := strings.Split(table, "\n")
+	for i, line := range lines {
+		if strings.Contains(line, outOfScopeMarker) {
+			lines[i] = scopeWarningStyle.Render(line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+var scopeWarningStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#FF8800"))
+
+func completionView(m model) string {
+	// Show completion message and the changes to the task file for confirmation
 	successStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#00FF00"))
 	instructionStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#FFFF00"))
+
+	message := successStyle.Render("✅ Task completed successfully!")
+	if warnings := gitutil.ScopeWarnings(m.selectedCommits(), m.uncommitted); len(warnings) > 0 {
+		summary := scopeWarningStyle.Bold(true).Render(fmt.Sprintf("⚠️  Changes outside the scope (%s):", strings.Join(m.TimerTask.Task.Scope, ", ")))
+		for _, w := range warnings {
+			summary += "\n" + scopeWarningStyle.Render("  "+w)
+		}
+		message += "\n\n" + summary
+	}
+
+	instructions := "Press Enter or Space to mark as complete and return to the list."
+	if c := m.completion; c != nil {
+		switch {
+		case c.err != nil:
+			message += "\n\n" + errorStyle.Render(fmt.Sprintf("Can't update %s: %v", m.list.Title, c.err))
+		case c.preview != "":
+			message += "\n\n" + renderDiff(c.preview, max(m.height-14, 10))
+		}
+		if m.dryRun {
+			message += "\n\n" + dryRunStyle.Render("Dry run: the task file will not be changed.")
+		}
+		instructions = "Press Enter/y to apply, "
+		if len(c.commits) > 0 {
+			instructions += "e to select the commits, "
+		}
+		instructions += "Esc/n to return to the list without completing."
+	}
+
 	return lipgloss.NewStyle().Padding(1).BorderStyle(lipgloss.DoubleBorder()).Render(
 		fmt.Sprintf("%s\n\n%s",
-			successStyle.Render("✅ Task completed successfully!"),
-			instructionStyle.Render("Press Enter or Space to mark as complete and return to the list.")),
+			message,
+			instructionStyle.Render(instructions)),
+	)
+}
+
+var (
+	errorStyle      = lipgloss.NewStyle().Foreground(lipgloss.Color("#FF0000"))
+	dryRunStyle     = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#FFFF00"))
+	diffHeaderStyle = lipgloss.NewStyle().Bold(true)
+	diffHunkStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("#00FFFF"))
+	diffAddedStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("#00FF00"))
+	diffRemoveStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#FF0000"))
+)
+
+// renderDiff colors a unified diff, showing at most maxLines lines of it.
+func renderDiff(diff string, maxLines int) string {
+	lines := strings.Split(strings.TrimSuffix(diff, "\n"), "\n")
+	more := 0
+	if len(lines) > maxLines {
+		more = len(lines) - maxLines
+		lines = lines[:maxLines]
+	}
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "---"), strings.HasPrefix(line, "+++"):
+			lines[i] = diffHeaderStyle.Render(line)
+		case strings.HasPrefix(line, "@@"):
+			lines[i] = diffHunkStyle.Render(line)
+		case strings.HasPrefix(line, "+"):
+			lines[i] = diffAddedStyle.Render(line)
+		case strings.HasPrefix(line, "-"):
+			lines[i] = diffRemoveStyle.Render(line)
+		}
+	}
+	if more > 0 {
+		lines = append(lines, fmt.Sprintf("… %d more lines", more))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// selectCommitsView lists the session's commits for choosing those listed below the task.
+func selectCommitsView(m model) string {
+	headerStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#00FFFF"))
+	cursorStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#00FFFF"))
+	instructionStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#FFFF00"))
+
+	c := m.completion
+	rows := make([]string, len(c.commits))
+	for i, commit := range c.commits {
+		check := "[x]"
+		if c.excluded[commit.Hash] {
+			check = "[ ]"
+		}
+		row := check + " " + commit.String()
+		if commit.Repo != "" && len(m.repos) > 1 {
+			row = check + " " + commit.Repo + ": " + commit.String()
+		}
+		switch {
+		case i == c.cursor:
+			row = cursorStyle.Render("> " + row)
+		case len(commit.OutOfScope) > 0:
+			row = scopeWarningStyle.Render("  " + row)
+		default:
+			row = "  " + row
+		}
+		rows[i] = row
+	}
+
+	return lipgloss.NewStyle().Padding(1).BorderStyle(lipgloss.DoubleBorder()).Render(
+		fmt.Sprintf("%s\n\n%s\n\n%s",
+			headerStyle.Render("Commits listed below the task:"),
+			strings.Join(rows, "\n"),
+			instructionStyle.Render("Press Space to select or deselect a commit and Enter when done.")),
+	)
+}
+
+func earlyStartView(m model) string {
+	headerStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#00FFFF"))
+	instructionStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#FFFF00"))
+
+	scheduled := m.pendingTask.Task.TimeBox
+	if startTime, _, err := parser.ParseTimeRange(m.pendingTask.Task.TimeBox); err == nil {
+		scheduled = fmt.Sprintf("%s (starts in %s)", startTime.Format("15:04"), time.Until(startTime).Round(time.Minute))
+	}
+
+	return lipgloss.NewStyle().Padding(1).BorderStyle(lipgloss.RoundedBorder()).Render(
+		fmt.Sprintf("%s\n%s\n\n%s",
+			headerStyle.Render("Not started yet: ")+m.pendingTask.Title(),
+			headerStyle.Render("Scheduled for: ")+scheduled,
+			instructionStyle.Render("Press y/Enter to start early or n/Esc to go back.")),
+	)
+}
+
+// sessionConflictView asks what to do with the sessions running elsewhere before
+// starting another one.
+func sessionConflictView(m model) string {
+	headerStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#00FFFF"))
+	instructionStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#FFFF00"))
+
+	now := time.Now()
+	running := make([]string, len(m.conflict.others))
+	for i, other := range m.conflict.others {
+		description := other.Description
+		if description == "" {
+			description = other.Key()
+		}
+		running[i] = fmt.Sprintf("  %s (%s so far)", description, state.Elapsed(other.Segments, now).Round(time.Second))
+		if other.IsStale(now) {
+			running[i] = fmt.Sprintf("  %s (stopped responding at %s)", description, other.LastSeen().Format("15:04"))
+		}
+		if other.File != "" && other.File != absFile(m.list.Title) {
+			running[i] += " in " + other.File
+		}
+	}
+
+	instructions := "Press p to pause it and start, "
+	if len(m.conflict.others) > 1 {
+		instructions = "Press p to pause them and start, "
+	}
+	if m.conflict.join != nil {
+		instructions += "j to join the running session, "
+	}
+	instructions += "a/Esc to go back without starting."
+
+	return lipgloss.NewStyle().Padding(1).BorderStyle(lipgloss.RoundedBorder()).Render(
+		fmt.Sprintf("%s\n%s\n%s\n\n%s",
+			headerStyle.Render("Starting: ")+m.pendingTask.Title(),
+			headerStyle.Render("Running in another gobox:"),
+			strings.Join(running, "\n"),
+			instructionStyle.Render(instructions)),
+	)
+}
+
+// recoveryView asks what to do with a session left running by a gobox that is gone.
+func recoveryView(m model) string {
+	headerStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#00FFFF"))
+	instructionStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#FFFF00"))
+
+	r := m.recovery
+	s := r.sessions[0]
+	now := time.Now()
+	description := s.Description
+	if description == "" {
+		description = s.Key()
+	}
+	lastSeen := s.LastSeen()
+	start := s.Segments[len(s.Segments)-1].Start
+
+	message := scopeWarningStyle.Bold(true).Render("⚠️  This session was still running when gobox stopped:") + "\n" +
+		headerStyle.Render("Task: ") + description + "\n" +
+		headerStyle.Render("Running since: ") + start.Format("Mon Jan 2 15:04") + "\n" +
+		headerStyle.Render("Last heartbeat: ") + fmt.Sprintf("%s (%s ago)", lastSeen.Format("Mon Jan 2 15:04"), now.Sub(lastSeen).Round(time.Minute))
+	if len(r.sessions) > 1 {
+		message += fmt.Sprintf("\n(%d more after this one)", len(r.sessions)-1)
+	}
+
+	instructions := fmt.Sprintf("Press h to end it at the last heartbeat (%s in total), k to keep the time until now (%s), t to enter when you stopped or q to quit.",
+		state.Elapsed(s.Segments, lastSeen).Round(time.Minute), state.Elapsed(s.Segments, now).Round(time.Minute))
+	if r.trimming {
+		message += "\n\n" + r.input.View()
+		instructions = "Enter when you stopped, like 17:30, or how long it ran, like 45m, and press Enter. Esc goes back."
+	}
+	if r.err != nil {
+		message += "\n" + errorStyle.Render(r.err.Error())
+	}
+
+	return lipgloss.NewStyle().Padding(1).BorderStyle(lipgloss.RoundedBorder()).Render(
+		fmt.Sprintf("%s\n\n%s", message, instructionStyle.Render(instructions)),
 	)
 }
 
@@ -95,6 +359,9 @@ func taskListView(m model) string {
 		Padding(1).
 		Render(m.list.View())
 
+	if m.listErr != nil {
+		return lipgloss.JoinVertical(lipgloss.Left, taskList, errorStyle.Render(m.listErr.Error()))
+	}
 	return lipgloss.JoinVertical(lipgloss.Left,
 		taskList,
 	)
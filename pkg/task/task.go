@@ -1,9 +1,11 @@
 package task
 
 import (
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"strings"
 )
 
 // Position represents a range within the task or markdown document, identified by start and end indexes.
@@ -14,10 +16,15 @@ type Position struct {
 
 // Task represents a task parsed from the Markdown file.
 type Task struct {
-	Description string // The text of the task description
-	TimeBox     string // The raw timebox string, e.g., "@1h", "@[10:00-13:00]"
-	IsChecked   bool   // True if the task is already checked
+	ID          string   // Stable identifier stored in the markdown, empty until the task is first started
+	Description string   // The text of the task description
+	TimeBox     string   // The raw timebox string, e.g., "@1h", "@[10:00-13:00]"
+	IsChecked   bool     // True if the task is already checked
+	Scope       []string // Glob patterns of the paths the task is meant to change, e.g. "internal/parser/**"
 	Position    Position
+	RolledUp    bool    // True if TimeBox is the sum of the children's timeboxes rather than set explicitly
+	Parent      *Task   // The enclosing task of a nested checklist item, nil for top-level tasks
+	Children    []*Task // Nested subtasks in document order
 }
 
 // Hash generates a unique hash for the task based on its Description and TimeBox.
@@ -27,7 +34,35 @@ func (t *Task) Hash() string {
 	return hex.EncodeToString(hash[:])
 }
 
+// Key returns the identifier used to track the task's state: its stable ID if it has one,
+// otherwise its content hash.
+func (t *Task) Key() string {
+	if t.ID != "" {
+		return t.ID
+	}
+	return t.Hash()
+}
+
+// NewID generates a short random task ID.
+func NewID() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("failed to generate task id: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// Depth returns how deeply the task is nested, 0 for top-level tasks.
+func (t *Task) Depth() int {
+	depth := 0
+	for p := t.Parent; p != nil; p = p.Parent {
+		depth++
+	}
+	return depth
+}
+
 // String returns a markdown task list item representation of the task with its description, time box, and checked status.
+// A rolled-up time box is left out, since it isn't part of the markdown source.
 func (t *Task) String() string {
 	checkMark := " "
 
@@ -35,5 +70,46 @@ func (t *Task) String() string {
 		checkMark = "x"
 	}
 
-	return fmt.Sprintf("- [%s] %s %s", checkMark, t.Description, t.TimeBox)
+	scope := ""
+	if len(t.Scope) > 0 {
+		scope = fmt.Sprintf(" {scope: %s}", strings.Join(t.Scope, ", "))
+	}
+
+	if t.RolledUp {
+		return fmt.Sprintf("- [%s] %s%s", checkMark, t.Description, scope)
+	}
+
+	return fmt.Sprintf("- [%s] %s %s%s", checkMark, t.Description, t.TimeBox, scope)
+}
+
+// FuzzyMatchThreshold is the minimum description similarity for a fuzzy task match.
+const FuzzyMatchThreshold = 0.8
+
+// Similarity returns how similar two strings are, from 0 (nothing in common) to 1 (equal),
+// based on their Levenshtein distance.
+func Similarity(a, b string) float64 {
+	ra, rb := []rune(strings.ToLower(a)), []rune(strings.ToLower(b))
+	longest := max(len(ra), len(rb))
+	if longest == 0 {
+		return 1
+	}
+
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return 1 - float64(prev[len(rb)])/float64(longest)
 }